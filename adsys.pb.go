@@ -21,6 +21,64 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type ApplyResult_Status int32
+
+const (
+	ApplyResult_STATUS_UNSPECIFIED ApplyResult_Status = 0
+	ApplyResult_CHANGED            ApplyResult_Status = 1
+	ApplyResult_UNCHANGED          ApplyResult_Status = 2
+	ApplyResult_CHANGES_UNKNOWN    ApplyResult_Status = 3 // Rules were applied by a manager which can't tell if the system changed
+	ApplyResult_SKIPPED_NON_PRO    ApplyResult_Status = 4 // Rules were filtered out as the machine is not subscribed to Ubuntu Pro
+	ApplyResult_FAILED             ApplyResult_Status = 5
+)
+
+// Enum value maps for ApplyResult_Status.
+var (
+	ApplyResult_Status_name = map[int32]string{
+		0: "STATUS_UNSPECIFIED",
+		1: "CHANGED",
+		2: "UNCHANGED",
+		3: "CHANGES_UNKNOWN",
+		4: "SKIPPED_NON_PRO",
+		5: "FAILED",
+	}
+	ApplyResult_Status_value = map[string]int32{
+		"STATUS_UNSPECIFIED": 0,
+		"CHANGED":            1,
+		"UNCHANGED":          2,
+		"CHANGES_UNKNOWN":    3,
+		"SKIPPED_NON_PRO":    4,
+		"FAILED":             5,
+	}
+)
+
+func (x ApplyResult_Status) Enum() *ApplyResult_Status {
+	p := new(ApplyResult_Status)
+	*p = x
+	return p
+}
+
+func (x ApplyResult_Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ApplyResult_Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_adsys_proto_enumTypes[0].Descriptor()
+}
+
+func (ApplyResult_Status) Type() protoreflect.EnumType {
+	return &file_adsys_proto_enumTypes[0]
+}
+
+func (x ApplyResult_Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ApplyResult_Status.Descriptor instead.
+func (ApplyResult_Status) EnumDescriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{5, 0}
+}
+
 type Empty struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -265,19 +323,164 @@ func (x *UpdatePolicyRequest) GetPurge() bool {
 	return false
 }
 
+type ApplyResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Manager       string                 `protobuf:"bytes,1,opt,name=manager,proto3" json:"manager,omitempty"`
+	Object        string                 `protobuf:"bytes,2,opt,name=object,proto3" json:"object,omitempty"`
+	Status        ApplyResult_Status     `protobuf:"varint,3,opt,name=status,proto3,enum=ApplyResult_Status" json:"status,omitempty"`
+	Files         []string               `protobuf:"bytes,4,rep,name=files,proto3" json:"files,omitempty"` // Files created, modified or removed by the manager
+	DurationMs    int64                  `protobuf:"varint,5,opt,name=durationMs,proto3" json:"durationMs,omitempty"`
+	Error         string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApplyResult) Reset() {
+	*x = ApplyResult{}
+	mi := &file_adsys_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApplyResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApplyResult) ProtoMessage() {}
+
+func (x *ApplyResult) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApplyResult.ProtoReflect.Descriptor instead.
+func (*ApplyResult) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ApplyResult) GetManager() string {
+	if x != nil {
+		return x.Manager
+	}
+	return ""
+}
+
+func (x *ApplyResult) GetObject() string {
+	if x != nil {
+		return x.Object
+	}
+	return ""
+}
+
+func (x *ApplyResult) GetStatus() ApplyResult_Status {
+	if x != nil {
+		return x.Status
+	}
+	return ApplyResult_STATUS_UNSPECIFIED
+}
+
+func (x *ApplyResult) GetFiles() []string {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *ApplyResult) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *ApplyResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type PlanPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IsComputer    bool                   `protobuf:"varint,1,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Krb5Cc        string                 `protobuf:"bytes,3,opt,name=krb5cc,proto3" json:"krb5cc,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PlanPolicyRequest) Reset() {
+	*x = PlanPolicyRequest{}
+	mi := &file_adsys_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PlanPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlanPolicyRequest) ProtoMessage() {}
+
+func (x *PlanPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlanPolicyRequest.ProtoReflect.Descriptor instead.
+func (*PlanPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PlanPolicyRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *PlanPolicyRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *PlanPolicyRequest) GetKrb5Cc() string {
+	if x != nil {
+		return x.Krb5Cc
+	}
+	return ""
+}
+
 type DumpPoliciesRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
 	IsComputer    bool                   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
 	Details       bool                   `protobuf:"varint,3,opt,name=details,proto3" json:"details,omitempty"` // Show rules in addition to GPO
 	All           bool                   `protobuf:"varint,4,opt,name=all,proto3" json:"all,omitempty"`         // Show overridden rules
+	Format        string                 `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`    // text (default), json or yaml
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *DumpPoliciesRequest) Reset() {
 	*x = DumpPoliciesRequest{}
-	mi := &file_adsys_proto_msgTypes[5]
+	mi := &file_adsys_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -289,7 +492,7 @@ func (x *DumpPoliciesRequest) String() string {
 func (*DumpPoliciesRequest) ProtoMessage() {}
 
 func (x *DumpPoliciesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[5]
+	mi := &file_adsys_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -302,7 +505,7 @@ func (x *DumpPoliciesRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DumpPoliciesRequest.ProtoReflect.Descriptor instead.
 func (*DumpPoliciesRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{5}
+	return file_adsys_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *DumpPoliciesRequest) GetTarget() string {
@@ -333,6 +536,261 @@ func (x *DumpPoliciesRequest) GetAll() bool {
 	return false
 }
 
+func (x *DumpPoliciesRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type ExplainPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer    bool                   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"` // Rule type, like dconf
+	Key           string                 `protobuf:"bytes,4,opt,name=key,proto3" json:"key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExplainPolicyRequest) Reset() {
+	*x = ExplainPolicyRequest{}
+	mi := &file_adsys_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExplainPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExplainPolicyRequest) ProtoMessage() {}
+
+func (x *ExplainPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExplainPolicyRequest.ProtoReflect.Descriptor instead.
+func (*ExplainPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExplainPolicyRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *ExplainPolicyRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *ExplainPolicyRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ExplainPolicyRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+type PolicyHistoryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer    bool                   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicyHistoryRequest) Reset() {
+	*x = PolicyHistoryRequest{}
+	mi := &file_adsys_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicyHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyHistoryRequest) ProtoMessage() {}
+
+func (x *PolicyHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyHistoryRequest.ProtoReflect.Descriptor instead.
+func (*PolicyHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *PolicyHistoryRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *PolicyHistoryRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+type RollbackPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Target        string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer    bool                   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	To            uint32                 `protobuf:"varint,3,opt,name=to,proto3" json:"to,omitempty"` // History entry to roll back to, 0 being the currently applied policies
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RollbackPolicyRequest) Reset() {
+	*x = RollbackPolicyRequest{}
+	mi := &file_adsys_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RollbackPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RollbackPolicyRequest) ProtoMessage() {}
+
+func (x *RollbackPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RollbackPolicyRequest.ProtoReflect.Descriptor instead.
+func (*RollbackPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RollbackPolicyRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *RollbackPolicyRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *RollbackPolicyRequest) GetTo() uint32 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+type VerifyPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IsComputer    bool                   `protobuf:"varint,1,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	All           bool                   `protobuf:"varint,2,opt,name=all,proto3" json:"all,omitempty"` // Verify policies of the machine and all the users
+	Target        string                 `protobuf:"bytes,3,opt,name=target,proto3" json:"target,omitempty"`
+	Repair        bool                   `protobuf:"varint,4,opt,name=repair,proto3" json:"repair,omitempty"` // Apply again cached policies when the system drifted
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyPolicyRequest) Reset() {
+	*x = VerifyPolicyRequest{}
+	mi := &file_adsys_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyPolicyRequest) ProtoMessage() {}
+
+func (x *VerifyPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyPolicyRequest.ProtoReflect.Descriptor instead.
+func (*VerifyPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *VerifyPolicyRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *VerifyPolicyRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+func (x *VerifyPolicyRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *VerifyPolicyRequest) GetRepair() bool {
+	if x != nil {
+		return x.Repair
+	}
+	return false
+}
+
 type DumpPolicyDefinitionsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Format        string                 `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
@@ -343,7 +801,7 @@ type DumpPolicyDefinitionsRequest struct {
 
 func (x *DumpPolicyDefinitionsRequest) Reset() {
 	*x = DumpPolicyDefinitionsRequest{}
-	mi := &file_adsys_proto_msgTypes[6]
+	mi := &file_adsys_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -355,7 +813,7 @@ func (x *DumpPolicyDefinitionsRequest) String() string {
 func (*DumpPolicyDefinitionsRequest) ProtoMessage() {}
 
 func (x *DumpPolicyDefinitionsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[6]
+	mi := &file_adsys_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -368,7 +826,7 @@ func (x *DumpPolicyDefinitionsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DumpPolicyDefinitionsRequest.ProtoReflect.Descriptor instead.
 func (*DumpPolicyDefinitionsRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{6}
+	return file_adsys_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *DumpPolicyDefinitionsRequest) GetFormat() string {
@@ -395,7 +853,7 @@ type DumpPolicyDefinitionsResponse struct {
 
 func (x *DumpPolicyDefinitionsResponse) Reset() {
 	*x = DumpPolicyDefinitionsResponse{}
-	mi := &file_adsys_proto_msgTypes[7]
+	mi := &file_adsys_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -407,7 +865,7 @@ func (x *DumpPolicyDefinitionsResponse) String() string {
 func (*DumpPolicyDefinitionsResponse) ProtoMessage() {}
 
 func (x *DumpPolicyDefinitionsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[7]
+	mi := &file_adsys_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -420,7 +878,7 @@ func (x *DumpPolicyDefinitionsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DumpPolicyDefinitionsResponse.ProtoReflect.Descriptor instead.
 func (*DumpPolicyDefinitionsResponse) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{7}
+	return file_adsys_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *DumpPolicyDefinitionsResponse) GetAdmx() string {
@@ -446,7 +904,7 @@ type GetDocRequest struct {
 
 func (x *GetDocRequest) Reset() {
 	*x = GetDocRequest{}
-	mi := &file_adsys_proto_msgTypes[8]
+	mi := &file_adsys_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -458,7 +916,7 @@ func (x *GetDocRequest) String() string {
 func (*GetDocRequest) ProtoMessage() {}
 
 func (x *GetDocRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[8]
+	mi := &file_adsys_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -471,7 +929,7 @@ func (x *GetDocRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetDocRequest.ProtoReflect.Descriptor instead.
 func (*GetDocRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{8}
+	return file_adsys_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *GetDocRequest) GetChapter() string {
@@ -490,7 +948,7 @@ type ListDocReponse struct {
 
 func (x *ListDocReponse) Reset() {
 	*x = ListDocReponse{}
-	mi := &file_adsys_proto_msgTypes[9]
+	mi := &file_adsys_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -502,7 +960,7 @@ func (x *ListDocReponse) String() string {
 func (*ListDocReponse) ProtoMessage() {}
 
 func (x *ListDocReponse) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[9]
+	mi := &file_adsys_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -515,7 +973,7 @@ func (x *ListDocReponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListDocReponse.ProtoReflect.Descriptor instead.
 func (*ListDocReponse) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{9}
+	return file_adsys_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *ListDocReponse) GetChapters() []string {
@@ -544,14 +1002,63 @@ const file_adsys_proto_rawDesc = "" +
 	"\x03all\x18\x02 \x01(\bR\x03all\x12\x16\n" +
 	"\x06target\x18\x03 \x01(\tR\x06target\x12\x16\n" +
 	"\x06krb5cc\x18\x04 \x01(\tR\x06krb5cc\x12\x14\n" +
-	"\x05purge\x18\x05 \x01(\bR\x05purge\"y\n" +
+	"\x05purge\x18\x05 \x01(\bR\x05purge\"\xac\x02\n" +
+	"\vApplyResult\x12\x18\n" +
+	"\amanager\x18\x01 \x01(\tR\amanager\x12\x16\n" +
+	"\x06object\x18\x02 \x01(\tR\x06object\x12+\n" +
+	"\x06status\x18\x03 \x01(\x0e2\x13.ApplyResult.StatusR\x06status\x12\x14\n" +
+	"\x05files\x18\x04 \x03(\tR\x05files\x12\x1e\n" +
+	"\n" +
+	"durationMs\x18\x05 \x01(\x03R\n" +
+	"durationMs\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\"r\n" +
+	"\x06Status\x12\x16\n" +
+	"\x12STATUS_UNSPECIFIED\x10\x00\x12\v\n" +
+	"\aCHANGED\x10\x01\x12\r\n" +
+	"\tUNCHANGED\x10\x02\x12\x13\n" +
+	"\x0fCHANGES_UNKNOWN\x10\x03\x12\x13\n" +
+	"\x0fSKIPPED_NON_PRO\x10\x04\x12\n" +
+	"\n" +
+	"\x06FAILED\x10\x05\"c\n" +
+	"\x11PlanPolicyRequest\x12\x1e\n" +
+	"\n" +
+	"isComputer\x18\x01 \x01(\bR\n" +
+	"isComputer\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12\x16\n" +
+	"\x06krb5cc\x18\x03 \x01(\tR\x06krb5cc\"\x91\x01\n" +
 	"\x13DumpPoliciesRequest\x12\x16\n" +
 	"\x06target\x18\x01 \x01(\tR\x06target\x12\x1e\n" +
 	"\n" +
 	"isComputer\x18\x02 \x01(\bR\n" +
 	"isComputer\x12\x18\n" +
 	"\adetails\x18\x03 \x01(\bR\adetails\x12\x10\n" +
-	"\x03all\x18\x04 \x01(\bR\x03all\"R\n" +
+	"\x03all\x18\x04 \x01(\bR\x03all\x12\x16\n" +
+	"\x06format\x18\x05 \x01(\tR\x06format\"t\n" +
+	"\x14ExplainPolicyRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x1e\n" +
+	"\n" +
+	"isComputer\x18\x02 \x01(\bR\n" +
+	"isComputer\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x10\n" +
+	"\x03key\x18\x04 \x01(\tR\x03key\"N\n" +
+	"\x14PolicyHistoryRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x1e\n" +
+	"\n" +
+	"isComputer\x18\x02 \x01(\bR\n" +
+	"isComputer\"_\n" +
+	"\x15RollbackPolicyRequest\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x1e\n" +
+	"\n" +
+	"isComputer\x18\x02 \x01(\bR\n" +
+	"isComputer\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\rR\x02to\"w\n" +
+	"\x13VerifyPolicyRequest\x12\x1e\n" +
+	"\n" +
+	"isComputer\x18\x01 \x01(\bR\n" +
+	"isComputer\x12\x10\n" +
+	"\x03all\x18\x02 \x01(\bR\x03all\x12\x16\n" +
+	"\x06target\x18\x03 \x01(\tR\x06target\x12\x16\n" +
+	"\x06repair\x18\x04 \x01(\bR\x06repair\"R\n" +
 	"\x1cDumpPolicyDefinitionsRequest\x12\x16\n" +
 	"\x06format\x18\x01 \x01(\tR\x06format\x12\x1a\n" +
 	"\bdistroID\x18\x02 \x01(\tR\bdistroID\"G\n" +
@@ -561,14 +1068,22 @@ const file_adsys_proto_rawDesc = "" +
 	"\rGetDocRequest\x12\x18\n" +
 	"\achapter\x18\x01 \x01(\tR\achapter\",\n" +
 	"\x0eListDocReponse\x12\x1a\n" +
-	"\bchapters\x18\x01 \x03(\tR\bchapters2\xc0\x04\n" +
+	"\bchapters\x18\x01 \x03(\tR\bchapters2\xcc\a\n" +
 	"\aservice\x12 \n" +
 	"\x03Cat\x12\x06.Empty\x1a\x0f.StringResponse0\x01\x12$\n" +
 	"\aVersion\x12\x06.Empty\x1a\x0f.StringResponse0\x01\x12#\n" +
 	"\x06Status\x12\x06.Empty\x1a\x0f.StringResponse0\x01\x12\x1e\n" +
-	"\x04Stop\x12\f.StopRequest\x1a\x06.Empty0\x01\x12.\n" +
-	"\fUpdatePolicy\x12\x14.UpdatePolicyRequest\x1a\x06.Empty0\x01\x127\n" +
-	"\fDumpPolicies\x12\x14.DumpPoliciesRequest\x1a\x0f.StringResponse0\x01\x12Z\n" +
+	"\x04Stop\x12\f.StopRequest\x1a\x06.Empty0\x01\x12+\n" +
+	"\x0eGarbageCollect\x12\x06.Empty\x1a\x0f.StringResponse0\x01\x12.\n" +
+	"\fUpdatePolicy\x12\x14.UpdatePolicyRequest\x1a\x06.Empty0\x01\x12?\n" +
+	"\x17UpdatePolicyWithResults\x12\x14.UpdatePolicyRequest\x1a\f.ApplyResult0\x01\x123\n" +
+	"\n" +
+	"PlanPolicy\x12\x12.PlanPolicyRequest\x1a\x0f.StringResponse0\x01\x127\n" +
+	"\fDumpPolicies\x12\x14.DumpPoliciesRequest\x1a\x0f.StringResponse0\x01\x129\n" +
+	"\rExplainPolicy\x12\x15.ExplainPolicyRequest\x1a\x0f.StringResponse0\x01\x129\n" +
+	"\rPolicyHistory\x12\x15.PolicyHistoryRequest\x1a\x0f.StringResponse0\x01\x128\n" +
+	"\x0eRollbackPolicy\x12\x16.RollbackPolicyRequest\x1a\f.ApplyResult0\x01\x127\n" +
+	"\fVerifyPolicy\x12\x14.VerifyPolicyRequest\x1a\x0f.StringResponse0\x01\x12Z\n" +
 	"\x17DumpPoliciesDefinitions\x12\x1d.DumpPolicyDefinitionsRequest\x1a\x1e.DumpPolicyDefinitionsResponse0\x01\x12+\n" +
 	"\x06GetDoc\x12\x0e.GetDocRequest\x1a\x0f.StringResponse0\x01\x12$\n" +
 	"\aListDoc\x12\x06.Empty\x1a\x0f.ListDocReponse0\x01\x121\n" +
@@ -588,49 +1103,72 @@ func file_adsys_proto_rawDescGZIP() []byte {
 	return file_adsys_proto_rawDescData
 }
 
-var file_adsys_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_adsys_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_adsys_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_adsys_proto_goTypes = []any{
-	(*Empty)(nil),                         // 0: Empty
-	(*ListUsersRequest)(nil),              // 1: ListUsersRequest
-	(*StopRequest)(nil),                   // 2: StopRequest
-	(*StringResponse)(nil),                // 3: StringResponse
-	(*UpdatePolicyRequest)(nil),           // 4: UpdatePolicyRequest
-	(*DumpPoliciesRequest)(nil),           // 5: DumpPoliciesRequest
-	(*DumpPolicyDefinitionsRequest)(nil),  // 6: DumpPolicyDefinitionsRequest
-	(*DumpPolicyDefinitionsResponse)(nil), // 7: DumpPolicyDefinitionsResponse
-	(*GetDocRequest)(nil),                 // 8: GetDocRequest
-	(*ListDocReponse)(nil),                // 9: ListDocReponse
+	(ApplyResult_Status)(0),               // 0: ApplyResult.Status
+	(*Empty)(nil),                         // 1: Empty
+	(*ListUsersRequest)(nil),              // 2: ListUsersRequest
+	(*StopRequest)(nil),                   // 3: StopRequest
+	(*StringResponse)(nil),                // 4: StringResponse
+	(*UpdatePolicyRequest)(nil),           // 5: UpdatePolicyRequest
+	(*ApplyResult)(nil),                   // 6: ApplyResult
+	(*PlanPolicyRequest)(nil),             // 7: PlanPolicyRequest
+	(*DumpPoliciesRequest)(nil),           // 8: DumpPoliciesRequest
+	(*ExplainPolicyRequest)(nil),          // 9: ExplainPolicyRequest
+	(*PolicyHistoryRequest)(nil),          // 10: PolicyHistoryRequest
+	(*RollbackPolicyRequest)(nil),         // 11: RollbackPolicyRequest
+	(*VerifyPolicyRequest)(nil),           // 12: VerifyPolicyRequest
+	(*DumpPolicyDefinitionsRequest)(nil),  // 13: DumpPolicyDefinitionsRequest
+	(*DumpPolicyDefinitionsResponse)(nil), // 14: DumpPolicyDefinitionsResponse
+	(*GetDocRequest)(nil),                 // 15: GetDocRequest
+	(*ListDocReponse)(nil),                // 16: ListDocReponse
 }
 var file_adsys_proto_depIdxs = []int32{
-	0,  // 0: service.Cat:input_type -> Empty
-	0,  // 1: service.Version:input_type -> Empty
-	0,  // 2: service.Status:input_type -> Empty
-	2,  // 3: service.Stop:input_type -> StopRequest
-	4,  // 4: service.UpdatePolicy:input_type -> UpdatePolicyRequest
-	5,  // 5: service.DumpPolicies:input_type -> DumpPoliciesRequest
-	6,  // 6: service.DumpPoliciesDefinitions:input_type -> DumpPolicyDefinitionsRequest
-	8,  // 7: service.GetDoc:input_type -> GetDocRequest
-	0,  // 8: service.ListDoc:input_type -> Empty
-	1,  // 9: service.ListUsers:input_type -> ListUsersRequest
-	0,  // 10: service.GPOListScript:input_type -> Empty
-	0,  // 11: service.CertAutoEnrollScript:input_type -> Empty
-	3,  // 12: service.Cat:output_type -> StringResponse
-	3,  // 13: service.Version:output_type -> StringResponse
-	3,  // 14: service.Status:output_type -> StringResponse
-	0,  // 15: service.Stop:output_type -> Empty
-	0,  // 16: service.UpdatePolicy:output_type -> Empty
-	3,  // 17: service.DumpPolicies:output_type -> StringResponse
-	7,  // 18: service.DumpPoliciesDefinitions:output_type -> DumpPolicyDefinitionsResponse
-	3,  // 19: service.GetDoc:output_type -> StringResponse
-	9,  // 20: service.ListDoc:output_type -> ListDocReponse
-	3,  // 21: service.ListUsers:output_type -> StringResponse
-	3,  // 22: service.GPOListScript:output_type -> StringResponse
-	3,  // 23: service.CertAutoEnrollScript:output_type -> StringResponse
-	12, // [12:24] is the sub-list for method output_type
-	0,  // [0:12] is the sub-list for method input_type
-	0,  // [0:0] is the sub-list for extension type_name
-	0,  // [0:0] is the sub-list for extension extendee
-	0,  // [0:0] is the sub-list for field type_name
+	0,  // 0: ApplyResult.status:type_name -> ApplyResult.Status
+	1,  // 1: service.Cat:input_type -> Empty
+	1,  // 2: service.Version:input_type -> Empty
+	1,  // 3: service.Status:input_type -> Empty
+	3,  // 4: service.Stop:input_type -> StopRequest
+	1,  // 5: service.GarbageCollect:input_type -> Empty
+	5,  // 6: service.UpdatePolicy:input_type -> UpdatePolicyRequest
+	5,  // 7: service.UpdatePolicyWithResults:input_type -> UpdatePolicyRequest
+	7,  // 8: service.PlanPolicy:input_type -> PlanPolicyRequest
+	8,  // 9: service.DumpPolicies:input_type -> DumpPoliciesRequest
+	9,  // 10: service.ExplainPolicy:input_type -> ExplainPolicyRequest
+	10, // 11: service.PolicyHistory:input_type -> PolicyHistoryRequest
+	11, // 12: service.RollbackPolicy:input_type -> RollbackPolicyRequest
+	12, // 13: service.VerifyPolicy:input_type -> VerifyPolicyRequest
+	13, // 14: service.DumpPoliciesDefinitions:input_type -> DumpPolicyDefinitionsRequest
+	15, // 15: service.GetDoc:input_type -> GetDocRequest
+	1,  // 16: service.ListDoc:input_type -> Empty
+	2,  // 17: service.ListUsers:input_type -> ListUsersRequest
+	1,  // 18: service.GPOListScript:input_type -> Empty
+	1,  // 19: service.CertAutoEnrollScript:input_type -> Empty
+	4,  // 20: service.Cat:output_type -> StringResponse
+	4,  // 21: service.Version:output_type -> StringResponse
+	4,  // 22: service.Status:output_type -> StringResponse
+	1,  // 23: service.Stop:output_type -> Empty
+	4,  // 24: service.GarbageCollect:output_type -> StringResponse
+	1,  // 25: service.UpdatePolicy:output_type -> Empty
+	6,  // 26: service.UpdatePolicyWithResults:output_type -> ApplyResult
+	4,  // 27: service.PlanPolicy:output_type -> StringResponse
+	4,  // 28: service.DumpPolicies:output_type -> StringResponse
+	4,  // 29: service.ExplainPolicy:output_type -> StringResponse
+	4,  // 30: service.PolicyHistory:output_type -> StringResponse
+	6,  // 31: service.RollbackPolicy:output_type -> ApplyResult
+	4,  // 32: service.VerifyPolicy:output_type -> StringResponse
+	14, // 33: service.DumpPoliciesDefinitions:output_type -> DumpPolicyDefinitionsResponse
+	4,  // 34: service.GetDoc:output_type -> StringResponse
+	16, // 35: service.ListDoc:output_type -> ListDocReponse
+	4,  // 36: service.ListUsers:output_type -> StringResponse
+	4,  // 37: service.GPOListScript:output_type -> StringResponse
+	4,  // 38: service.CertAutoEnrollScript:output_type -> StringResponse
+	20, // [20:39] is the sub-list for method output_type
+	1,  // [1:20] is the sub-list for method input_type
+	1,  // [1:1] is the sub-list for extension type_name
+	1,  // [1:1] is the sub-list for extension extendee
+	0,  // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_adsys_proto_init() }
@@ -643,13 +1181,14 @@ func file_adsys_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_adsys_proto_rawDesc), len(file_adsys_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   10,
+			NumEnums:      1,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_adsys_proto_goTypes,
 		DependencyIndexes: file_adsys_proto_depIdxs,
+		EnumInfos:         file_adsys_proto_enumTypes,
 		MessageInfos:      file_adsys_proto_msgTypes,
 	}.Build()
 	File_adsys_proto = out.File
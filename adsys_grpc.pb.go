@@ -23,8 +23,15 @@ const (
 	Service_Version_FullMethodName                 = "/service/Version"
 	Service_Status_FullMethodName                  = "/service/Status"
 	Service_Stop_FullMethodName                    = "/service/Stop"
+	Service_GarbageCollect_FullMethodName          = "/service/GarbageCollect"
 	Service_UpdatePolicy_FullMethodName            = "/service/UpdatePolicy"
+	Service_UpdatePolicyWithResults_FullMethodName = "/service/UpdatePolicyWithResults"
+	Service_PlanPolicy_FullMethodName              = "/service/PlanPolicy"
 	Service_DumpPolicies_FullMethodName            = "/service/DumpPolicies"
+	Service_ExplainPolicy_FullMethodName           = "/service/ExplainPolicy"
+	Service_PolicyHistory_FullMethodName           = "/service/PolicyHistory"
+	Service_RollbackPolicy_FullMethodName          = "/service/RollbackPolicy"
+	Service_VerifyPolicy_FullMethodName            = "/service/VerifyPolicy"
 	Service_DumpPoliciesDefinitions_FullMethodName = "/service/DumpPoliciesDefinitions"
 	Service_GetDoc_FullMethodName                  = "/service/GetDoc"
 	Service_ListDoc_FullMethodName                 = "/service/ListDoc"
@@ -41,8 +48,15 @@ type ServiceClient interface {
 	Version(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	Status(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Empty], error)
+	GarbageCollect(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	UpdatePolicy(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Empty], error)
+	UpdatePolicyWithResults(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyResult], error)
+	PlanPolicy(ctx context.Context, in *PlanPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	DumpPolicies(ctx context.Context, in *DumpPoliciesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
+	ExplainPolicy(ctx context.Context, in *ExplainPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
+	PolicyHistory(ctx context.Context, in *PolicyHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
+	RollbackPolicy(ctx context.Context, in *RollbackPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyResult], error)
+	VerifyPolicy(ctx context.Context, in *VerifyPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DumpPolicyDefinitionsResponse], error)
 	GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error)
 	ListDoc(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ListDocReponse], error)
@@ -135,9 +149,28 @@ func (c *serviceClient) Stop(ctx context.Context, in *StopRequest, opts ...grpc.
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_StopClient = grpc.ServerStreamingClient[Empty]
 
+func (c *serviceClient) GarbageCollect(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[4], Service_GarbageCollect_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Empty, StringResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_GarbageCollectClient = grpc.ServerStreamingClient[StringResponse]
+
 func (c *serviceClient) UpdatePolicy(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Empty], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[4], Service_UpdatePolicy_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[5], Service_UpdatePolicy_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -154,9 +187,47 @@ func (c *serviceClient) UpdatePolicy(ctx context.Context, in *UpdatePolicyReques
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_UpdatePolicyClient = grpc.ServerStreamingClient[Empty]
 
+func (c *serviceClient) UpdatePolicyWithResults(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], Service_UpdatePolicyWithResults_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UpdatePolicyRequest, ApplyResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_UpdatePolicyWithResultsClient = grpc.ServerStreamingClient[ApplyResult]
+
+func (c *serviceClient) PlanPolicy(ctx context.Context, in *PlanPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], Service_PlanPolicy_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PlanPolicyRequest, StringResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_PlanPolicyClient = grpc.ServerStreamingClient[StringResponse]
+
 func (c *serviceClient) DumpPolicies(ctx context.Context, in *DumpPoliciesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[5], Service_DumpPolicies_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], Service_DumpPolicies_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -173,9 +244,85 @@ func (c *serviceClient) DumpPolicies(ctx context.Context, in *DumpPoliciesReques
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_DumpPoliciesClient = grpc.ServerStreamingClient[StringResponse]
 
+func (c *serviceClient) ExplainPolicy(ctx context.Context, in *ExplainPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], Service_ExplainPolicy_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExplainPolicyRequest, StringResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_ExplainPolicyClient = grpc.ServerStreamingClient[StringResponse]
+
+func (c *serviceClient) PolicyHistory(ctx context.Context, in *PolicyHistoryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], Service_PolicyHistory_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PolicyHistoryRequest, StringResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_PolicyHistoryClient = grpc.ServerStreamingClient[StringResponse]
+
+func (c *serviceClient) RollbackPolicy(ctx context.Context, in *RollbackPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[11], Service_RollbackPolicy_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RollbackPolicyRequest, ApplyResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_RollbackPolicyClient = grpc.ServerStreamingClient[ApplyResult]
+
+func (c *serviceClient) VerifyPolicy(ctx context.Context, in *VerifyPolicyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[12], Service_VerifyPolicy_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VerifyPolicyRequest, StringResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_VerifyPolicyClient = grpc.ServerStreamingClient[StringResponse]
+
 func (c *serviceClient) DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DumpPolicyDefinitionsResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], Service_DumpPoliciesDefinitions_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[13], Service_DumpPoliciesDefinitions_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +341,7 @@ type Service_DumpPoliciesDefinitionsClient = grpc.ServerStreamingClient[DumpPoli
 
 func (c *serviceClient) GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], Service_GetDoc_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[14], Service_GetDoc_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +360,7 @@ type Service_GetDocClient = grpc.ServerStreamingClient[StringResponse]
 
 func (c *serviceClient) ListDoc(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ListDocReponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], Service_ListDoc_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[15], Service_ListDoc_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +379,7 @@ type Service_ListDocClient = grpc.ServerStreamingClient[ListDocReponse]
 
 func (c *serviceClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], Service_ListUsers_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[16], Service_ListUsers_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -251,7 +398,7 @@ type Service_ListUsersClient = grpc.ServerStreamingClient[StringResponse]
 
 func (c *serviceClient) GPOListScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], Service_GPOListScript_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[17], Service_GPOListScript_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -270,7 +417,7 @@ type Service_GPOListScriptClient = grpc.ServerStreamingClient[StringResponse]
 
 func (c *serviceClient) CertAutoEnrollScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StringResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[11], Service_CertAutoEnrollScript_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[18], Service_CertAutoEnrollScript_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -295,8 +442,15 @@ type ServiceServer interface {
 	Version(*Empty, grpc.ServerStreamingServer[StringResponse]) error
 	Status(*Empty, grpc.ServerStreamingServer[StringResponse]) error
 	Stop(*StopRequest, grpc.ServerStreamingServer[Empty]) error
+	GarbageCollect(*Empty, grpc.ServerStreamingServer[StringResponse]) error
 	UpdatePolicy(*UpdatePolicyRequest, grpc.ServerStreamingServer[Empty]) error
+	UpdatePolicyWithResults(*UpdatePolicyRequest, grpc.ServerStreamingServer[ApplyResult]) error
+	PlanPolicy(*PlanPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error
 	DumpPolicies(*DumpPoliciesRequest, grpc.ServerStreamingServer[StringResponse]) error
+	ExplainPolicy(*ExplainPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error
+	PolicyHistory(*PolicyHistoryRequest, grpc.ServerStreamingServer[StringResponse]) error
+	RollbackPolicy(*RollbackPolicyRequest, grpc.ServerStreamingServer[ApplyResult]) error
+	VerifyPolicy(*VerifyPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error
 	DumpPoliciesDefinitions(*DumpPolicyDefinitionsRequest, grpc.ServerStreamingServer[DumpPolicyDefinitionsResponse]) error
 	GetDoc(*GetDocRequest, grpc.ServerStreamingServer[StringResponse]) error
 	ListDoc(*Empty, grpc.ServerStreamingServer[ListDocReponse]) error
@@ -325,12 +479,33 @@ func (UnimplementedServiceServer) Status(*Empty, grpc.ServerStreamingServer[Stri
 func (UnimplementedServiceServer) Stop(*StopRequest, grpc.ServerStreamingServer[Empty]) error {
 	return status.Error(codes.Unimplemented, "method Stop not implemented")
 }
+func (UnimplementedServiceServer) GarbageCollect(*Empty, grpc.ServerStreamingServer[StringResponse]) error {
+	return status.Error(codes.Unimplemented, "method GarbageCollect not implemented")
+}
 func (UnimplementedServiceServer) UpdatePolicy(*UpdatePolicyRequest, grpc.ServerStreamingServer[Empty]) error {
 	return status.Error(codes.Unimplemented, "method UpdatePolicy not implemented")
 }
+func (UnimplementedServiceServer) UpdatePolicyWithResults(*UpdatePolicyRequest, grpc.ServerStreamingServer[ApplyResult]) error {
+	return status.Error(codes.Unimplemented, "method UpdatePolicyWithResults not implemented")
+}
+func (UnimplementedServiceServer) PlanPolicy(*PlanPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error {
+	return status.Error(codes.Unimplemented, "method PlanPolicy not implemented")
+}
 func (UnimplementedServiceServer) DumpPolicies(*DumpPoliciesRequest, grpc.ServerStreamingServer[StringResponse]) error {
 	return status.Error(codes.Unimplemented, "method DumpPolicies not implemented")
 }
+func (UnimplementedServiceServer) ExplainPolicy(*ExplainPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error {
+	return status.Error(codes.Unimplemented, "method ExplainPolicy not implemented")
+}
+func (UnimplementedServiceServer) PolicyHistory(*PolicyHistoryRequest, grpc.ServerStreamingServer[StringResponse]) error {
+	return status.Error(codes.Unimplemented, "method PolicyHistory not implemented")
+}
+func (UnimplementedServiceServer) RollbackPolicy(*RollbackPolicyRequest, grpc.ServerStreamingServer[ApplyResult]) error {
+	return status.Error(codes.Unimplemented, "method RollbackPolicy not implemented")
+}
+func (UnimplementedServiceServer) VerifyPolicy(*VerifyPolicyRequest, grpc.ServerStreamingServer[StringResponse]) error {
+	return status.Error(codes.Unimplemented, "method VerifyPolicy not implemented")
+}
 func (UnimplementedServiceServer) DumpPoliciesDefinitions(*DumpPolicyDefinitionsRequest, grpc.ServerStreamingServer[DumpPolicyDefinitionsResponse]) error {
 	return status.Error(codes.Unimplemented, "method DumpPoliciesDefinitions not implemented")
 }
@@ -414,6 +589,17 @@ func _Service_Stop_Handler(srv interface{}, stream grpc.ServerStream) error {
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_StopServer = grpc.ServerStreamingServer[Empty]
 
+func _Service_GarbageCollect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).GarbageCollect(m, &grpc.GenericServerStream[Empty, StringResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_GarbageCollectServer = grpc.ServerStreamingServer[StringResponse]
+
 func _Service_UpdatePolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(UpdatePolicyRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -425,6 +611,28 @@ func _Service_UpdatePolicy_Handler(srv interface{}, stream grpc.ServerStream) er
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_UpdatePolicyServer = grpc.ServerStreamingServer[Empty]
 
+func _Service_UpdatePolicyWithResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UpdatePolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).UpdatePolicyWithResults(m, &grpc.GenericServerStream[UpdatePolicyRequest, ApplyResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_UpdatePolicyWithResultsServer = grpc.ServerStreamingServer[ApplyResult]
+
+func _Service_PlanPolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PlanPolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PlanPolicy(m, &grpc.GenericServerStream[PlanPolicyRequest, StringResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_PlanPolicyServer = grpc.ServerStreamingServer[StringResponse]
+
 func _Service_DumpPolicies_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(DumpPoliciesRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -436,6 +644,50 @@ func _Service_DumpPolicies_Handler(srv interface{}, stream grpc.ServerStream) er
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Service_DumpPoliciesServer = grpc.ServerStreamingServer[StringResponse]
 
+func _Service_ExplainPolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExplainPolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ExplainPolicy(m, &grpc.GenericServerStream[ExplainPolicyRequest, StringResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_ExplainPolicyServer = grpc.ServerStreamingServer[StringResponse]
+
+func _Service_PolicyHistory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PolicyHistoryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).PolicyHistory(m, &grpc.GenericServerStream[PolicyHistoryRequest, StringResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_PolicyHistoryServer = grpc.ServerStreamingServer[StringResponse]
+
+func _Service_RollbackPolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RollbackPolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).RollbackPolicy(m, &grpc.GenericServerStream[RollbackPolicyRequest, ApplyResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_RollbackPolicyServer = grpc.ServerStreamingServer[ApplyResult]
+
+func _Service_VerifyPolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VerifyPolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).VerifyPolicy(m, &grpc.GenericServerStream[VerifyPolicyRequest, StringResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Service_VerifyPolicyServer = grpc.ServerStreamingServer[StringResponse]
+
 func _Service_DumpPoliciesDefinitions_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(DumpPolicyDefinitionsRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -530,16 +782,51 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Service_Stop_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GarbageCollect",
+			Handler:       _Service_GarbageCollect_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "UpdatePolicy",
 			Handler:       _Service_UpdatePolicy_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "UpdatePolicyWithResults",
+			Handler:       _Service_UpdatePolicyWithResults_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PlanPolicy",
+			Handler:       _Service_PlanPolicy_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "DumpPolicies",
 			Handler:       _Service_DumpPolicies_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ExplainPolicy",
+			Handler:       _Service_ExplainPolicy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PolicyHistory",
+			Handler:       _Service_PolicyHistory_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "RollbackPolicy",
+			Handler:       _Service_RollbackPolicy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "VerifyPolicy",
+			Handler:       _Service_VerifyPolicy_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "DumpPoliciesDefinitions",
 			Handler:       _Service_DumpPoliciesDefinitions_Handler,
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/leonelquinteros/gotext"
@@ -21,6 +24,7 @@ import (
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/decorate"
 	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
 )
 
 func (a *App) installPolicy() {
@@ -48,6 +52,7 @@ func (a *App) installPolicy() {
 	policyCmd.AddCommand(mainCmd)
 
 	var details, all, nocolor, isMachine *bool
+	var appliedFormat *string
 	appliedCmd := &cobra.Command{
 		Use:   "applied [USER_NAME]",
 		Short: gotext.Get("Print last applied GPOs for current or given user/machine"),
@@ -64,13 +69,14 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				target = args[0]
 			}
-			return a.dumpPolicies(target, *details, *all, *nocolor, *isMachine)
+			return a.dumpPolicies(target, *details, *all, *nocolor, *isMachine, *appliedFormat)
 		},
 	}
 	details = appliedCmd.Flags().BoolP("details", "", false, gotext.Get("show applied rules in addition to GPOs."))
 	all = appliedCmd.Flags().BoolP("all", "a", false, gotext.Get("show overridden rules in each GPOs."))
 	nocolor = appliedCmd.Flags().BoolP("no-color", "", false, gotext.Get("don't display colorized version."))
 	isMachine = appliedCmd.Flags().BoolP("machine", "m", false, gotext.Get("show applied rules to the machine."))
+	appliedFormat = appliedCmd.Flags().StringP("format", "", "text", gotext.Get("print the applied GPOs and rules in the given format: text, json or yaml."))
 	policyCmd.AddCommand(appliedCmd)
 	cmdhandler.RegisterAlias(appliedCmd, &a.rootCmd)
 
@@ -116,6 +122,7 @@ The command is a no-op if the ticket is not present on disk or the detect_cached
 	debugCmd.AddCommand(ticketPathCmd)
 
 	var updateMachine, updateAll *bool
+	var updateFormat *string
 	updateCmd := &cobra.Command{
 		Use:   "update [USER_NAME KERBEROS_TICKET_PATH]",
 		Short: gotext.Get("Updates/Create a policy for current user or given user with its kerberos ticket"),
@@ -142,14 +149,149 @@ The command is a no-op if the ticket is not present on disk or the detect_cached
 			if len(args) > 0 {
 				user, krb5cc = args[0], args[1]
 			}
-			return a.update(*updateMachine, *updateAll, user, krb5cc)
+			return a.update(*updateMachine, *updateAll, user, krb5cc, *updateFormat)
 		},
 	}
 	updateMachine = updateCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine updates the policy of the computer."))
 	updateAll = updateCmd.Flags().BoolP("all", "a", false, gotext.Get("all updates the policy of the computer and all the logged in users. -m or USER_NAME/TICKET cannot be used with this option."))
+	updateFormat = updateCmd.Flags().StringP("format", "", "", gotext.Get("print the outcome of each policy manager in the given format: table or json."))
 	policyCmd.AddCommand(updateCmd)
 	cmdhandler.RegisterAlias(updateCmd, &a.rootCmd)
 
+	var planMachine *bool
+	planCmd := &cobra.Command{
+		Use:   "plan [USER_NAME]",
+		Short: gotext.Get("Show the changes updating the policy for current user or given user would do, without applying them"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// Machine option doesn’t take arguments
+			if *planMachine || len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			// Get all connected users
+			return a.users(true), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			var user string
+			if len(args) > 0 {
+				user = args[0]
+			}
+			return a.plan(*planMachine, user)
+		},
+	}
+	planMachine = planCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine shows the changes to the policy of the computer."))
+	policyCmd.AddCommand(planCmd)
+
+	var explainMachine *bool
+	explainCmd := &cobra.Command{
+		Use:   "explain TYPE KEY [USER_NAME]",
+		Short: gotext.Get("Explain how a rule applied to current or given user/machine is set by the GPOs defining it"),
+		Long: gotext.Get(`Explain how a rule applied to current or given user/machine is set by the GPOs defining it.
+It lists every GPO defining the rule of type TYPE (dconf, privilege…) and KEY, from the closest to the furthest,
+shows which one won and if values were merged, and prints the effective value after dynamic values expansion.`),
+		Args: cobra.RangeArgs(2, 3),
+		ValidArgsFunction: func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// Machine option doesn’t take user argument
+			if *explainMachine || len(args) != 2 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			// Get all users with cached policies
+			return a.users(false), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 2 {
+				target = args[2]
+			}
+			return a.explain(*explainMachine, target, args[0], args[1])
+		},
+	}
+	explainMachine = explainCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine explains a rule applied to the computer."))
+	policyCmd.AddCommand(explainCmd)
+
+	var historyMachine *bool
+	historyCmd := &cobra.Command{
+		Use:   "history [USER_NAME]",
+		Short: gotext.Get("List policies previously applied to current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// Machine option doesn’t take arguments
+			if *historyMachine || len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			// Get all users with cached policies
+			return a.users(false), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.history(*historyMachine, target)
+		},
+	}
+	historyMachine = historyCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine lists the policies previously applied to the computer."))
+	policyCmd.AddCommand(historyCmd)
+
+	var rollbackMachine *bool
+	var rollbackTo *uint32
+	var rollbackFormat *string
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback [USER_NAME]",
+		Short: gotext.Get("Apply again policies previously applied to current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// Machine option doesn’t take arguments
+			if *rollbackMachine || len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			// Get all users with cached policies
+			return a.users(false), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.rollback(*rollbackMachine, target, *rollbackTo, *rollbackFormat)
+		},
+	}
+	rollbackMachine = rollbackCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine rolls back the policy of the computer."))
+	rollbackTo = rollbackCmd.Flags().Uint32P("to", "", 1, gotext.Get("number of the history entry, as listed by the history command, to roll back to."))
+	rollbackFormat = rollbackCmd.Flags().StringP("format", "", "", gotext.Get("print the outcome of each policy manager in the given format: table or json."))
+	policyCmd.AddCommand(rollbackCmd)
+
+	var verifyMachine, verifyAll, verifyRepair *bool
+	verifyCmd := &cobra.Command{
+		Use:   "verify [USER_NAME]",
+		Short: gotext.Get("Check that the system is still in the state set up by the policies applied to current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// All and machine options don’t take arguments
+			if *verifyAll || *verifyMachine || len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			// Get all users with cached policies
+			return a.users(false), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(_ *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.verify(*verifyMachine, *verifyAll, target, *verifyRepair)
+		},
+	}
+	verifyMachine = verifyCmd.Flags().BoolP("machine", "m", false, gotext.Get("machine verifies the policy of the computer."))
+	verifyAll = verifyCmd.Flags().BoolP("all", "a", false, gotext.Get("all verifies the policy of the computer and all the users with cached policies. -m or USER_NAME cannot be used with this option."))
+	verifyRepair = verifyCmd.Flags().BoolP("repair", "", false, gotext.Get("apply again the cached policies when the system drifted from them."))
+	policyCmd.AddCommand(verifyCmd)
+
 	var purgeMachine, purgeAll *bool
 	purgeCmd := &cobra.Command{
 		Use:   "purge [USER_NAME]",
@@ -223,11 +365,16 @@ func (a App) getPolicyDefinitions(format, distroID string) (err error) {
 	return nil
 }
 
-func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor, isMachine bool) error {
+func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor, isMachine bool, format string) error {
 	// incompatible options
 	if showOverridden && !showDetails {
 		showDetails = true
 	}
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return errors.New(gotext.Get("unsupported format %q: expected text, json or yaml", format))
+	}
 
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -257,6 +404,7 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor,
 		IsComputer: isMachine,
 		Details:    showDetails,
 		All:        showOverridden,
+		Format:     format,
 	})
 	if err != nil {
 		return err
@@ -267,6 +415,12 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor,
 		return err
 	}
 
+	// Machine readable formats are printed as is.
+	if format != "text" {
+		fmt.Print(policies)
+		return nil
+	}
+
 	if nocolor {
 		color.NoColor = true
 	}
@@ -376,6 +530,7 @@ func colorizePolicies(policies string) (string, error) {
 	var out stringsBuilderWithError
 
 	bold := color.New(color.Bold)
+	filteredSuffix := " " + gotext.Get("(filtered by targeting)")
 	var currentPoliciesType string
 	for _, l := range strings.Split(strings.TrimSpace(policies), "\n") {
 		//nolint:whitespace
@@ -402,6 +557,9 @@ func colorizePolicies(policies string) (string, error) {
 				}
 			}
 
+			// Keep the targeting mark at the end of the entry, after the disabled state.
+			e, filtered := strings.CutSuffix(e, filteredSuffix)
+
 			indent := "        - "
 			if disabledKey {
 				if currentPoliciesType == "dconf" {
@@ -410,7 +568,10 @@ func colorizePolicies(policies string) (string, error) {
 					e = gotext.Get("%s: Disabled", e)
 				}
 			}
-			if overridden {
+			if filtered {
+				e += filteredSuffix
+			}
+			if overridden || filtered {
 				e = color.HiBlackString("%s%s", indent, e)
 			} else {
 				e = fmt.Sprintf("%s%s", indent, e)
@@ -459,7 +620,7 @@ func (s *stringsBuilderWithError) Println(l string) {
 	_, s.err = s.WriteString(l)
 }
 
-func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
+func (a *App) update(isComputer, updateAll bool, target, krb5cc, format string) error {
 	// incompatible options
 	if updateAll && (isComputer || target != "" || krb5cc != "") {
 		return errors.New(gotext.Get("machine or user arguments cannot be used with update all"))
@@ -467,6 +628,9 @@ func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
 	if isComputer && (target != "" || krb5cc != "") {
 		return errors.New(gotext.Get("user arguments cannot be used with machine update"))
 	}
+	if err := checkApplyResultsFormat(format); err != nil {
+		return err
+	}
 
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -502,22 +666,260 @@ func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
 		}
 	}
 
-	stream, err := client.UpdatePolicy(a.ctx, &adsys.UpdatePolicyRequest{
+	req := &adsys.UpdatePolicyRequest{
 		IsComputer: isComputer,
 		All:        updateAll,
 		Target:     target,
-		Krb5Cc:     krb5cc})
+		Krb5Cc:     krb5cc}
+
+	// Only ask for the outcome of each policy manager when it is printed, so that older daemons are still supported.
+	if format == "" {
+		stream, err := client.UpdatePolicy(a.ctx, req)
+		if err != nil {
+			return err
+		}
+		if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	}
+
+	stream, err := client.UpdatePolicyWithResults(a.ctx, req)
 	if err != nil {
 		return err
 	}
 
-	if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+	return printApplyResults(stream, format)
+}
+
+func (a *App) plan(isComputer bool, target string) error {
+	// incompatible options
+	if isComputer && target != "" {
+		return errors.New(gotext.Get("user arguments cannot be used with machine plan"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var krb5cc string
+	if isComputer {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		// for malconfigured machines where /proc/sys/kernel/hostname returns the fqdn and not only the machine name, strip it
+		target, _, _ = strings.Cut(hostname, ".")
+	}
+
+	// Plan for current user
+	if target == "" {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve current user: %w", err)
+		}
+		target = u.Username
+		krb5cc = strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
+		if krb5cc == "" && a.config.DetectCachedTicket {
+			krb5cc, err = ad.TicketPath()
+			// Don't return an error as we might still have a cached ticket
+			// under /run/adsys/krb5cc
+			if err != nil {
+				log.Warningf(a.ctx, "Failed to get ticket path: %v", err)
+			}
+		}
+	}
+
+	stream, err := client.PlanPolicy(a.ctx, &adsys.PlanPolicyRequest{
+		IsComputer: isComputer,
+		Target:     target,
+		Krb5Cc:     krb5cc,
+	})
+	if err != nil {
+		return err
+	}
+
+	plan, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(plan)
+
+	return nil
+}
+
+func (a *App) explain(isComputer bool, target, ruleType, key string) error {
+	// incompatible options
+	if isComputer && target != "" {
+		return errors.New(gotext.Get("user arguments cannot be used with machine explain"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target, err = historyTarget(isComputer, target)
+	if err != nil {
+		return err
+	}
+
+	stream, err := client.ExplainPolicy(a.ctx, &adsys.ExplainPolicyRequest{
+		Target:     target,
+		IsComputer: isComputer,
+		Type:       ruleType,
+		Key:        key,
+	})
+	if err != nil {
+		return err
+	}
+
+	explanation, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(explanation)
+
+	return nil
+}
+
+func (a *App) history(isComputer bool, target string) error {
+	// incompatible options
+	if isComputer && target != "" {
+		return errors.New(gotext.Get("user arguments cannot be used with machine history"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target, err = historyTarget(isComputer, target)
+	if err != nil {
+		return err
+	}
+
+	stream, err := client.PolicyHistory(a.ctx, &adsys.PolicyHistoryRequest{
+		Target:     target,
+		IsComputer: isComputer,
+	})
+	if err != nil {
+		return err
+	}
+
+	history, err := singleMsg(stream)
+	if err != nil {
 		return err
 	}
+	fmt.Print(history)
 
 	return nil
 }
 
+func (a *App) rollback(isComputer bool, target string, to uint32, format string) error {
+	// incompatible options
+	if isComputer && target != "" {
+		return errors.New(gotext.Get("user arguments cannot be used with machine rollback"))
+	}
+	if err := checkApplyResultsFormat(format); err != nil {
+		return err
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target, err = historyTarget(isComputer, target)
+	if err != nil {
+		return err
+	}
+
+	stream, err := client.RollbackPolicy(a.ctx, &adsys.RollbackPolicyRequest{
+		Target:     target,
+		IsComputer: isComputer,
+		To:         to,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printApplyResults(stream, format)
+}
+
+func (a *App) verify(isComputer, verifyAll bool, target string, repair bool) error {
+	// incompatible options
+	if verifyAll && (isComputer || target != "") {
+		return errors.New(gotext.Get("machine or user arguments cannot be used with verify all"))
+	}
+	if isComputer && target != "" {
+		return errors.New(gotext.Get("user arguments cannot be used with machine verify"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !verifyAll {
+		target, err = historyTarget(isComputer, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	stream, err := client.VerifyPolicy(a.ctx, &adsys.VerifyPolicyRequest{
+		IsComputer: isComputer,
+		All:        verifyAll,
+		Target:     target,
+		Repair:     repair,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drifts of each object are streamed as soon as they are verified.
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Print(r.GetMsg())
+	}
+}
+
+// historyTarget returns the machine name or the current user if target is not set.
+func historyTarget(isComputer bool, target string) (string, error) {
+	if target != "" {
+		return target, nil
+	}
+
+	if isComputer {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		// for malconfigured machines where /proc/sys/kernel/hostname returns the fqdn and not only the machine name, strip it
+		target, _, _ = strings.Cut(hostname, ".")
+		return target, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve current user: %w", err)
+	}
+	return u.Username, nil
+}
+
 func (a *App) purge(isComputer, purgeAll bool, target string) error {
 	// incompatible options
 	if purgeAll && target != "" {
@@ -569,6 +971,118 @@ func (a *App) purge(isComputer, purgeAll bool, target string) error {
 	return nil
 }
 
+// checkApplyResultsFormat returns an error if format is not a supported output format for the outcome of policy
+// managers. An empty format prints nothing.
+func checkApplyResultsFormat(format string) error {
+	switch format {
+	case "", "table", "json":
+		return nil
+	}
+	return errors.New(gotext.Get("unsupported format %q: expected table or json", format))
+}
+
+// printApplyResults waits for the daemon to apply the policies and prints the outcome of each policy manager
+// in format. The outcome is printed even if applying the policies failed.
+func printApplyResults(stream grpc.ServerStreamingClient[adsys.ApplyResult], format string) error {
+	var results []*adsys.ApplyResult
+	var errApply error
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			errApply = err
+			break
+		}
+		results = append(results, r)
+	}
+
+	if format != "" {
+		out, err := formatApplyResults(results, format)
+		if err != nil {
+			return errors.Join(errApply, err)
+		}
+		fmt.Print(out)
+	}
+
+	return errApply
+}
+
+// applyStatus returns a human readable version of a policy manager outcome.
+func applyStatus(s adsys.ApplyResult_Status) string {
+	switch s {
+	case adsys.ApplyResult_CHANGED:
+		return gotext.Get("changed")
+	case adsys.ApplyResult_UNCHANGED:
+		return gotext.Get("unchanged")
+	case adsys.ApplyResult_CHANGES_UNKNOWN:
+		return gotext.Get("applied, changes unknown")
+	case adsys.ApplyResult_SKIPPED_NON_PRO:
+		return gotext.Get("skipped (not Ubuntu Pro)")
+	case adsys.ApplyResult_FAILED:
+		return gotext.Get("failed")
+	}
+	return gotext.Get("unknown")
+}
+
+// formatApplyResults returns the outcome of each policy manager as a table or as json.
+func formatApplyResults(results []*adsys.ApplyResult, format string) (string, error) {
+	if format == "json" {
+		type jsonResult struct {
+			Object     string   `json:"object"`
+			Manager    string   `json:"manager"`
+			Status     string   `json:"status"`
+			Files      []string `json:"files"`
+			DurationMs int64    `json:"durationMs"`
+			Error      string   `json:"error,omitempty"`
+		}
+		out := make([]jsonResult, 0, len(results))
+		for _, r := range results {
+			files := r.GetFiles()
+			if files == nil {
+				files = []string{}
+			}
+			out = append(out, jsonResult{
+				Object:     r.GetObject(),
+				Manager:    r.GetManager(),
+				Status:     strings.ToLower(r.GetStatus().String()),
+				Files:      files,
+				DurationMs: r.GetDurationMs(),
+				Error:      r.GetError(),
+			})
+		}
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	}
+
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, gotext.Get("OBJECT\tMANAGER\tSTATUS\tDURATION\tFILES"))
+	for _, r := range results {
+		files := "-"
+		if len(r.GetFiles()) > 0 {
+			files = strings.Join(r.GetFiles(), ", ")
+		}
+		duration := time.Duration(r.GetDurationMs()) * time.Millisecond
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.GetObject(), r.GetManager(), applyStatus(r.GetStatus()), duration, files)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	for _, r := range results {
+		if r.GetError() == "" {
+			continue
+		}
+		fmt.Fprintf(&out, "\n%s\n", gotext.Get("%s policy for %s failed: %s", r.GetManager(), r.GetObject(), r.GetError()))
+	}
+
+	return out.String(), nil
+}
+
 // users returns the list of connected users according to their cached policy information.
 // If active is true, the list of users is retrieved from the cached Kerberos ticket information.
 func (a App) users(active bool) []string {
@@ -6,6 +6,7 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -51,3 +52,36 @@ Policies from user configuration:
 	want := testutils.LoadWithUpdateFromGolden(t, got)
 	require.Equal(t, want, got, "colorizePolicies returned expected formatted output")
 }
+
+func TestFormatApplyResults(t *testing.T) {
+	t.Parallel()
+
+	results := []*adsys.ApplyResult{
+		{Manager: "dconf", Object: "hostname", Status: adsys.ApplyResult_CHANGED, Files: []string{"/etc/dconf/db/machine.d/adsys", "/etc/dconf/db/machine.d/locks/adsys"}, DurationMs: 12},
+		{Manager: "privilege", Object: "hostname", Status: adsys.ApplyResult_SKIPPED_NON_PRO, DurationMs: 1},
+		{Manager: "scripts", Object: "hostname", Status: adsys.ApplyResult_UNCHANGED},
+		{Manager: "proxy", Object: "hostname", Status: adsys.ApplyResult_CHANGES_UNKNOWN, DurationMs: 3},
+		{Manager: "mount", Object: "hostname", Status: adsys.ApplyResult_FAILED, Files: []string{"/etc/systemd/system/adsys-mnt.mount"}, DurationMs: 1500, Error: "can't apply mount policy"},
+	}
+
+	tests := map[string]struct {
+		format  string
+		results []*adsys.ApplyResult
+	}{
+		"Table":               {format: "table", results: results},
+		"JSON":                {format: "json", results: results},
+		"Table without entry": {format: "table"},
+		"JSON without entry":  {format: "json"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := formatApplyResults(tc.results, tc.format)
+			require.NoError(t, err, "formatApplyResults should not return an error")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "formatApplyResults returned unexpected output")
+		})
+	}
+}
@@ -51,6 +51,15 @@ func (a *App) installService() {
 	}
 	stopForce = cmd.Flags().BoolP("force", "f", false, gotext.Get("force will shut it down immediately and drop existing connections."))
 	mainCmd.AddCommand(cmd)
+
+	cmd = &cobra.Command{
+		Use:               "gc",
+		Short:             gotext.Get("Remove from cache the GPOs no longer referenced by any cached policies"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(_ *cobra.Command, _ []string) error { return a.serviceGC() },
+	}
+	mainCmd.AddCommand(cmd)
 }
 
 func (a *App) serviceCat() error {
@@ -103,6 +112,28 @@ func (a App) getStatus() (err error) {
 	return nil
 }
 
+// serviceGC removes the unreferenced GPOs from the daemon cache.
+func (a *App) serviceGC() error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.GarbageCollect(a.ctx, &adsys.Empty{})
+	if err != nil {
+		return err
+	}
+
+	msg, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Println(msg)
+
+	return nil
+}
+
 func (a *App) serviceStop(force bool) error {
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -4,6 +4,7 @@ package daemon
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"runtime"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/daemon"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/files"
 	"github.com/ubuntu/decorate"
 )
 
@@ -50,11 +52,36 @@ type daemonConfig struct {
 	ApparmorFsDir  string `mapstructure:"apparmorfs_dir"`
 	SystemUnitDir  string `mapstructure:"systemunit_dir"`
 	GlobalTrustDir string `mapstructure:"global_trust_dir"`
+	PluginsDir     string `mapstructure:"plugins_dir"`
 
 	AdBackend      string         `mapstructure:"ad_backend"`
 	SSSdConfig     sss.Config     `mapstructure:"sssd"`
 	WinbindConfig  winbind.Config `mapstructure:"winbind"`
 	GpoListTimeout int            `mapstructure:"gpo_list_timeout"`
+	PluginsTimeout int            `mapstructure:"plugins_timeout"`
+	HistorySize    int            `mapstructure:"history_size"`
+	HistoryMaxAge  int            `mapstructure:"history_max_age"`
+
+	WMIUnknownFilters string `mapstructure:"wmi_unknown_filters"`
+
+	FilesAllowedPrefixes []string `mapstructure:"files_allowed_prefixes"`
+
+	OfflineMaxAge      int            `mapstructure:"offline_max_age"`
+	OfflineMaxAgeTypes map[string]int `mapstructure:"offline_max_age_types"`
+	OfflineStaleAction string         `mapstructure:"offline_stale_action"`
+	OfflineFallbackDir string         `mapstructure:"offline_fallback_dir"`
+
+	LocalSource string `mapstructure:"local_source"`
+
+	ADSite          string `mapstructure:"ad_site"`
+	DCRetries       int    `mapstructure:"dc_retries"`
+	DCRetryBackoff  int    `mapstructure:"dc_retry_backoff"`
+	DownloadTimeout int    `mapstructure:"download_timeout"`
+
+	GCGracePeriod int `mapstructure:"gc_grace_period"`
+
+	DriftCheckInterval int  `mapstructure:"drift_check_interval"`
+	DriftRepair        bool `mapstructure:"drift_repair"`
 
 	ServiceTimeout int `mapstructure:"service_timeout"`
 }
@@ -84,7 +111,7 @@ func New() *App {
 				// Config reload
 
 				// No change in config file: skip.
-				if a.config == newConfig {
+				if reflect.DeepEqual(a.config, newConfig) {
 					return nil
 				}
 
@@ -123,10 +150,26 @@ func New() *App {
 				adsysservice.WithApparmorFsDir(a.config.ApparmorFsDir),
 				adsysservice.WithSystemUnitDir(a.config.SystemUnitDir),
 				adsysservice.WithGlobalTrustDir(a.config.GlobalTrustDir),
+				adsysservice.WithPluginsDir(a.config.PluginsDir),
 				adsysservice.WithADBackend(a.config.AdBackend),
 				adsysservice.WithSSSConfig(a.config.SSSdConfig),
 				adsysservice.WithWinbindConfig(a.config.WinbindConfig),
 				adsysservice.WithGpoListTimeout(time.Second*time.Duration(a.config.GpoListTimeout)),
+				adsysservice.WithWMIUnknownFilters(a.config.WMIUnknownFilters),
+				adsysservice.WithOfflineMaxAge(time.Hour*time.Duration(a.config.OfflineMaxAge), hoursByType(a.config.OfflineMaxAgeTypes)),
+				adsysservice.WithOfflineStaleAction(a.config.OfflineStaleAction),
+				adsysservice.WithOfflineFallbackDir(a.config.OfflineFallbackDir),
+				adsysservice.WithLocalSource(a.config.LocalSource),
+				adsysservice.WithSite(a.config.ADSite),
+				adsysservice.WithDCRetries(a.config.DCRetries, time.Second*time.Duration(a.config.DCRetryBackoff)),
+				adsysservice.WithDownloadTimeout(time.Second*time.Duration(a.config.DownloadTimeout)),
+				adsysservice.WithPluginsTimeout(time.Second*time.Duration(a.config.PluginsTimeout)),
+				adsysservice.WithFilesAllowedPrefixes(a.config.FilesAllowedPrefixes),
+				adsysservice.WithHistorySize(a.config.HistorySize),
+				adsysservice.WithHistoryMaxAge(24*time.Hour*time.Duration(a.config.HistoryMaxAge)),
+				adsysservice.WithGCGracePeriod(time.Hour*time.Duration(a.config.GCGracePeriod)),
+				adsysservice.WithDriftCheckInterval(time.Minute*time.Duration(a.config.DriftCheckInterval)),
+				adsysservice.WithDriftRepair(a.config.DriftRepair),
 			)
 			if err != nil {
 				close(a.ready)
@@ -169,6 +212,60 @@ func New() *App {
 	err = a.viper.BindPFlag("gpo_list_timeout", a.rootCmd.PersistentFlags().Lookup("gpo-list-timeout"))
 	decorate.LogOnError(&err)
 
+	a.rootCmd.PersistentFlags().IntP("dc-retries", "", consts.DefaultDCRetries, gotext.Get("number of times all domain controllers are tried again once none of them can be reached."))
+	err = a.viper.BindPFlag("dc_retries", a.rootCmd.PersistentFlags().Lookup("dc-retries"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().IntP("dc-retry-backoff", "", consts.DefaultDCRetryBackoff, gotext.Get("time in seconds before trying all domain controllers again, doubled on each retry."))
+	err = a.viper.BindPFlag("dc_retry_backoff", a.rootCmd.PersistentFlags().Lookup("dc-retry-backoff"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().IntP("download-timeout", "", consts.DefaultDownloadTimeout, gotext.Get("time in seconds for the GPOs and assets downloads from a domain controller. 0 for no timeout."))
+	err = a.viper.BindPFlag("download_timeout", a.rootCmd.PersistentFlags().Lookup("download-timeout"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().StringP("wmi-unknown-filters", "", consts.DefaultWMIUnknownFilters, gotext.Get("apply (pass) or skip (fail) GPOs whose WMI filter can't be evaluated."))
+	err = a.viper.BindPFlag("wmi_unknown_filters", a.rootCmd.PersistentFlags().Lookup("wmi-unknown-filters"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().IntP("offline-max-age", "", 0, gotext.Get("time in hours since their last online refresh for cached policies to be applied while offline. 0 for no limit."))
+	err = a.viper.BindPFlag("offline_max_age", a.rootCmd.PersistentFlags().Lookup("offline-max-age"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().StringP("offline-stale-action", "", consts.DefaultOfflineStaleAction, gotext.Get("apply with a warning (warn), refuse (refuse) or replace with the fallback policies (fallback) stale cached policies while offline."))
+	err = a.viper.BindPFlag("offline_stale_action", a.rootCmd.PersistentFlags().Lookup("offline-stale-action"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().StringP("offline-fallback-dir", "", consts.DefaultOfflineFallbackDir, gotext.Get("directory of the fallback policies applied instead of stale cached ones."))
+	err = a.viper.BindPFlag("offline_fallback_dir", a.rootCmd.PersistentFlags().Lookup("offline-fallback-dir"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().StringP("local-source", "", "", gotext.Get("directory or archive of an export of the sysvol share to read policies from instead of the directory service."))
+	err = a.viper.BindPFlag("local_source", a.rootCmd.PersistentFlags().Lookup("local-source"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().IntP("plugins-timeout", "", consts.DefaultPluginsTimeout, gotext.Get("time in seconds for a policy manager plugin to finish. 0 for no timeout."))
+	err = a.viper.BindPFlag("plugins_timeout", a.rootCmd.PersistentFlags().Lookup("plugins-timeout"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().StringSliceP("files-allowed-prefixes", "", files.DefaultAllowedPrefixes, gotext.Get("directories the machine files and folders can be deployed to."))
+	err = a.viper.BindPFlag("files_allowed_prefixes", a.rootCmd.PersistentFlags().Lookup("files-allowed-prefixes"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().IntP("history-size", "", consts.DefaultHistorySize, gotext.Get("number of applied policies kept in history for each user and the machine. 0 to disable history."))
+	err = a.viper.BindPFlag("history_size", a.rootCmd.PersistentFlags().Lookup("history-size"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().IntP("history-max-age", "", consts.DefaultHistoryMaxAge, gotext.Get("time in days after which applied policies are removed from history. 0 to keep them regardless of their age."))
+	err = a.viper.BindPFlag("history_max_age", a.rootCmd.PersistentFlags().Lookup("history-max-age"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().IntP("gc-grace-period", "", consts.DefaultGCGracePeriod, gotext.Get("time in hours GPOs no longer referenced by any cached policies are kept before being garbage collected."))
+	err = a.viper.BindPFlag("gc_grace_period", a.rootCmd.PersistentFlags().Lookup("gc-grace-period"))
+	decorate.LogOnError(&err)
+
+	a.rootCmd.PersistentFlags().IntP("drift-check-interval", "", 0, gotext.Get("time in minutes between checks of applied policies drift. 0 to disable it."))
+	err = a.viper.BindPFlag("drift_check_interval", a.rootCmd.PersistentFlags().Lookup("drift-check-interval"))
+	decorate.LogOnError(&err)
+	a.rootCmd.PersistentFlags().BoolP("drift-repair", "", false, gotext.Get("apply again policies when the periodic check finds a drift."))
+	err = a.viper.BindPFlag("drift_repair", a.rootCmd.PersistentFlags().Lookup("drift-repair"))
+	decorate.LogOnError(&err)
+
 	a.rootCmd.PersistentFlags().StringP("ad-backend", "", "sssd", gotext.Get("Active Directory authentication backend"))
 	err = a.viper.BindPFlag("ad_backend", a.rootCmd.PersistentFlags().Lookup("ad-backend"))
 	decorate.LogOnError(&err)
@@ -202,6 +299,18 @@ func (a *App) changeServiceTimeout(timeout time.Duration) {
 	a.daemon.ChangeTimeout(timeout)
 }
 
+// hoursByType converts maximum ages in hours, by rule type, to durations.
+func hoursByType(hours map[string]int) map[string]time.Duration {
+	if len(hours) == 0 {
+		return nil
+	}
+	r := make(map[string]time.Duration, len(hours))
+	for t, h := range hours {
+		r[t] = time.Hour * time.Duration(h)
+	}
+	return r
+}
+
 // Run executes the command and associated process. It returns an error on syntax/usage error.
 func (a *App) Run() error {
 	return a.rootCmd.Execute()
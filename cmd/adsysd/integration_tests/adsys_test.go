@@ -111,7 +111,13 @@ socket: %s`, socket)), 0600)
 		"policy debug gpolist-script": {args: []string{"policy", "debug", "gpolist-script"}},
 		"policy update":               {args: []string{"policy", "update"}},
 		"policy purge":                {args: []string{"policy", "purge"}},
+		"policy plan":                 {args: []string{"policy", "plan"}},
+		"policy explain":              {args: []string{"policy", "explain", "dconf", "some/key"}},
+		"policy history":              {args: []string{"policy", "history"}},
+		"policy rollback":             {args: []string{"policy", "rollback"}},
+		"policy verify":               {args: []string{"policy", "verify"}},
 		"service cat":                 {args: []string{"service", "cat"}},
+		"service gc":                  {args: []string{"service", "gc"}},
 		"service status":              {args: []string{"service", "status"}},
 		"service stop":                {args: []string{"service", "stop"}},
 		"version":                     {args: []string{"version"}},
@@ -106,6 +106,7 @@ func TestPolicyApplied(t *testing.T) {
 		"Detailed policy with overrides (all)":           {args: []string{"--all"}},
 		"Current user gpos no color":                     {args: []string{"--no-color"}},
 		"Detailed policy with overrides (all), no color": {args: []string{"--no-color", "--all"}},
+		"Detailed policy in json format":                 {args: []string{"--details", "--format", "json"}},
 
 		// User options
 		`Current user with domain\username`:           {args: []string{`example.com\adsystestuser`}},
@@ -118,6 +119,7 @@ func TestPolicyApplied(t *testing.T) {
 		"Error on unexisting user":                                  {args: []string{"doesnotexists@example.com"}, wantErr: true},
 		"Error on user name without domain and no default domain":   {args: []string{"doesnotexists"}, wantErr: true},
 		"Error on applied denied":                                   {systemAnswer: "polkit_no", wantErr: true},
+		"Error on unsupported format":                               {args: []string{"--format", "xml"}, wantErr: true},
 		"Error on daemon not responding":                            {daemonNotStarted: true, wantErr: true},
 	}
 	for name, tc := range tests {
@@ -1354,6 +1356,27 @@ func TestPolicyCompletion(t *testing.T) {
 		"Update with all doesn't allow further completion":           {args: "update --all"},
 		"Update for machines doesn't allow further completion":       {args: "update -m"},
 
+		"Plan returns list of available users":               {args: "plan", wantOut: "adsystestuser@example.com otheruser@example.com"},
+		"Plan for machines doesn't allow further completion": {args: "plan -m"},
+		"Plan with user doesn't allow further completion":    {args: "plan adsystestuser@example.com"},
+
+		"Explain doesn't complete rule type":                    {args: "explain"},
+		"Explain returns list of users with cached policies":    {args: "explain dconf some/key", wantOut: "adsystestuser@example.com otheruser@example.com"},
+		"Explain for machines doesn't allow further completion": {args: "explain -m dconf some/key"},
+
+		"History returns list of users with cached policies":    {args: "history", wantOut: "adsystestuser@example.com otheruser@example.com"},
+		"History for machines doesn't allow further completion": {args: "history -m"},
+		"History with user doesn't allow further completion":    {args: "history adsystestuser@example.com"},
+
+		"Rollback returns list of users with cached policies":    {args: "rollback", wantOut: "adsystestuser@example.com otheruser@example.com"},
+		"Rollback for machines doesn't allow further completion": {args: "rollback -m"},
+		"Rollback with user doesn't allow further completion":    {args: "rollback adsystestuser@example.com"},
+
+		"Verify returns list of users with cached policies":    {args: "verify", wantOut: "adsystestuser@example.com otheruser@example.com"},
+		"Verify with all doesn't allow further completion":     {args: "verify --all"},
+		"Verify for machines doesn't allow further completion": {args: "verify -m"},
+		"Verify with user doesn't allow further completion":    {args: "verify adsystestuser@example.com"},
+
 		"Purge returns list of users with cached policies":    {args: "purge", wantOut: "adsystestuser@example.com otheruser@example.com"},
 		"Purge with all doesn't allow further completion":     {args: "purge --all"},
 		"Purge for machines doesn't allow further completion": {args: "purge -m"},
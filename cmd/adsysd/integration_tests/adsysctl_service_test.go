@@ -138,6 +138,39 @@ func TestServiceStopForcedWithHangingClient(t *testing.T) {
 	assert.NotEmpty(t, outCat(), "Cat has captured some outputs")
 }
 
+func TestServiceGC(t *testing.T) {
+	tests := map[string]struct {
+		daemonAnswer     string
+		daemonNotStarted bool
+
+		wantErr bool
+	}{
+		"Garbage collect empty cache": {daemonAnswer: "polkit_yes"},
+
+		// Error cases
+		"Error on garbage collect denied": {daemonAnswer: "polkit_no", wantErr: true},
+		"Error on daemon not responding":  {daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbusAnswer(t, tc.daemonAnswer)
+
+			conf := createConf(t)
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+
+			out, err := runClient(t, conf, "service", "gc")
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+				return
+			}
+			require.NoError(t, err, "client should exit with no error")
+			require.Equal(t, "No unreferenced GPO to remove from cache\n", out, "GC should report that nothing was removed")
+		})
+	}
+}
+
 func TestServiceCat(t *testing.T) {
 	// Unfortunately, we can’t easily create the cat client and other pingers in the same process:
 	// as cat will print what was forwarded to it, and the daemon, other clients and such will all write
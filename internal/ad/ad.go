@@ -11,11 +11,14 @@ import (
 	_ "embed" // embed gpolist python binary.
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -25,7 +28,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/ubuntu/adsys/internal/ad/backends"
 	adcommon "github.com/ubuntu/adsys/internal/ad/common"
+	"github.com/ubuntu/adsys/internal/ad/gpp"
 	"github.com/ubuntu/adsys/internal/ad/registry"
+	"github.com/ubuntu/adsys/internal/ad/wmi"
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/policies"
@@ -61,8 +66,38 @@ const (
 	gpoListConnectionFailed int = 2
 	// gpoListGPOFailed is returned when the GPO list couldn't be computed for the account.
 	gpoListGPOFailed int = 3
+
+	// gpoListAttribute prefixes the lines of the adsys-gpolist script listing an attribute of the object,
+	// in the form ATTR<tab>name<tab>value.
+	gpoListAttribute string = "ATTR"
+
+	// gpoListWMIFilter prefixes the lines of the adsys-gpolist script listing a WMI filter query of a GPO,
+	// in the form WMI<tab>gpo name<tab>namespace<tab>query.
+	gpoListWMIFilter string = "WMI"
+
+	// WMIFiltersPass applies the GPOs whose WMI filter can't be evaluated.
+	WMIFiltersPass string = "pass"
+	// WMIFiltersFail skips the GPOs whose WMI filter can't be evaluated.
+	WMIFiltersFail string = "fail"
+
+	// OfflineStaleWarn applies stale cached policies while offline, only logging a warning.
+	OfflineStaleWarn string = "warn"
+	// OfflineStaleRefuse fails to get stale cached policies while offline, which refuses the logon of users.
+	OfflineStaleRefuse string = "refuse"
+	// OfflineStaleFallback replaces the stale rules of cached policies with the ones of the local fallback
+	// policies while offline.
+	OfflineStaleFallback string = "fallback"
+
+	// loopbackKey is the Windows policy configuring the user policy loopback processing mode of the computer.
+	loopbackKey string = "Software/Policies/Microsoft/Windows/System/UserPolicyMode"
 )
 
+// loopbackModes are the loopback processing modes by value of loopbackKey. Any other value disables it.
+var loopbackModes = map[string]string{
+	"1": policies.LoopbackMerge,
+	"2": policies.LoopbackReplace,
+}
+
 type gpo downloadable
 
 type downloadable struct {
@@ -83,6 +118,7 @@ type AD struct {
 
 	versionID        string
 	sysvolCacheDir   string
+	manifestsDir     string
 	policiesCacheDir string
 	krb5CacheDir     string
 
@@ -90,22 +126,60 @@ type AD struct {
 	// downloadablesMu guards the downloadables map so that parsing, which only
 	// reads it, can run without holding the main AD lock.
 	downloadablesMu sync.RWMutex
+	// gposInUse counts, by ID, the requests fetching or parsing a GPO, which must not be garbage collected.
+	// It is guarded by downloadablesMu.
+	gposInUse map[string]int
 	sync.RWMutex
 	fetchMu sync.Mutex
 
 	withoutKerberos bool
 	gpoListCmd      []string
 	gpoListTimeout  time.Duration
+
+	// wmiUnknownPass applies the GPOs whose WMI filter can't be evaluated.
+	wmiUnknownPass bool
+	systemRoot     string
+
+	// offlineMaxAge is the maximum time since their online refresh for cached policies to be applied while offline.
+	// offlineMaxAgeTypes overrides it by rule type. 0 means no limit.
+	offlineMaxAge      time.Duration
+	offlineMaxAgeTypes map[string]time.Duration
+	offlineStaleAction string
+	offlineFallbackDir string
+
+	// localSource is the directory or archive GPOs are read from instead of the directory service, if set.
+	localSource string
+
+	// resolver looks up the domain controllers of the site, or of the domain, to fail over to.
+	resolver        Resolver
+	site            string
+	dcRetries       int
+	dcRetryBackoff  time.Duration
+	downloadTimeout time.Duration
 }
 
 type options struct {
-	versionID string
-	runDir    string
-	cacheDir  string
+	versionID  string
+	runDir     string
+	cacheDir   string
+	systemRoot string
 
 	withoutKerberos bool
 	gpoListCmd      []string
 	gpoListTimeout  time.Duration
+	wmiUnknownPass  bool
+
+	offlineMaxAge      time.Duration
+	offlineMaxAgeTypes map[string]time.Duration
+	offlineStaleAction string
+	offlineFallbackDir string
+	localSource        string
+
+	resolver        Resolver
+	site            string
+	dcRetries       int
+	dcRetryBackoff  time.Duration
+	downloadTimeout time.Duration
 }
 
 // Option reprents an optional function to change AD behavior.
@@ -135,6 +209,100 @@ func WithGpoListTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithWMIUnknownFilters specifies if the GPOs whose WMI filter can't be evaluated locally are applied, with
+// WMIFiltersPass, or skipped, with WMIFiltersFail.
+func WithWMIUnknownFilters(mode string) Option {
+	return func(o *options) error {
+		switch mode {
+		case WMIFiltersPass:
+			o.wmiUnknownPass = true
+		case WMIFiltersFail:
+			o.wmiUnknownPass = false
+		default:
+			return errors.New(gotext.Get("invalid mode %q for WMI filters that can't be evaluated: it must be %q or %q", mode, WMIFiltersPass, WMIFiltersFail))
+		}
+		return nil
+	}
+}
+
+// WithOfflineMaxAge specifies the maximum time since their last online refresh for cached policies to be applied
+// while offline. perType overrides it for some rule types. 0 means no limit.
+func WithOfflineMaxAge(maxAge time.Duration, perType map[string]time.Duration) Option {
+	return func(o *options) error {
+		if maxAge < 0 {
+			return errors.New(gotext.Get("invalid maximum age %s of offline policies: it can't be negative", maxAge))
+		}
+		for t, d := range perType {
+			if d < 0 {
+				return errors.New(gotext.Get("invalid maximum age %s of offline %s policies: it can't be negative", d, t))
+			}
+		}
+		o.offlineMaxAge = maxAge
+		o.offlineMaxAgeTypes = perType
+		return nil
+	}
+}
+
+// WithOfflineStaleAction specifies what is done when cached policies older than their maximum age are applied
+// while offline: OfflineStaleWarn, OfflineStaleRefuse or OfflineStaleFallback.
+func WithOfflineStaleAction(action string) Option {
+	return func(o *options) error {
+		switch action {
+		case OfflineStaleWarn, OfflineStaleRefuse, OfflineStaleFallback:
+			o.offlineStaleAction = action
+		default:
+			return errors.New(gotext.Get("invalid action %q for stale offline policies: it must be %q, %q or %q", action, OfflineStaleWarn, OfflineStaleRefuse, OfflineStaleFallback))
+		}
+		return nil
+	}
+}
+
+// WithOfflineFallbackDir specifies a personalized directory of the fallback policies applied instead of stale ones.
+func WithOfflineFallbackDir(dir string) Option {
+	return func(o *options) error {
+		o.offlineFallbackDir = dir
+		return nil
+	}
+}
+
+// WithLocalSource specifies a directory, or a tar archive, the GPOs are read from instead of the directory service,
+// for machines which can't reach any domain controller.
+func WithLocalSource(p string) Option {
+	return func(o *options) error {
+		o.localSource = p
+		return nil
+	}
+}
+
+// WithSite specifies the site of the machine, whose domain controllers are tried first when failing over.
+func WithSite(site string) Option {
+	return func(o *options) error {
+		o.site = site
+		return nil
+	}
+}
+
+// WithDCRetries specifies how many times all domain controllers are tried again once none of them can be reached,
+// after waiting for backoff, doubled on each retry.
+func WithDCRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) error {
+		if retries < 0 || backoff < 0 {
+			return errors.New(gotext.Get("invalid domain controller retries %d with backoff %s: they can't be negative", retries, backoff))
+		}
+		o.dcRetries = retries
+		o.dcRetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithDownloadTimeout specifies a custom timeout for the GPOs and assets downloads from a domain controller.
+func WithDownloadTimeout(timeout time.Duration) Option {
+	return func(o *options) error {
+		o.downloadTimeout = timeout
+		return nil
+	}
+}
+
 // AdsysGpoListCode is the embedded script which request
 // Samba to get our GPO list for the given object.
 //
@@ -157,6 +325,16 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 		gpoListCmd:     []string{"python3", "-c", AdsysGpoListCode},
 		versionID:      versionID,
 		gpoListTimeout: 30 * time.Second, // this is used in tests and set to consts.DefaultGpoListTimeout in production
+		wmiUnknownPass: consts.DefaultWMIUnknownFilters == WMIFiltersPass,
+		systemRoot:     "/",
+
+		offlineStaleAction: consts.DefaultOfflineStaleAction,
+		offlineFallbackDir: consts.DefaultOfflineFallbackDir,
+
+		resolver: net.DefaultResolver,
+		// those are used in tests and set to their consts.Default* value in production
+		dcRetryBackoff:  time.Second,
+		downloadTimeout: 30 * time.Second,
 	}
 	// applied options
 	for _, o := range opts {
@@ -183,6 +361,10 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 	if err := os.MkdirAll(filepath.Join(sysvolCacheDir, "Policies"), 0700); err != nil {
 		return nil, err
 	}
+	manifestsDir := filepath.Join(args.cacheDir, "manifests")
+	if err := os.MkdirAll(manifestsDir, 0700); err != nil {
+		return nil, err
+	}
 	policiesCacheDir := filepath.Join(args.cacheDir, policies.PoliciesCacheBaseName)
 	if err := os.MkdirAll(policiesCacheDir, 0700); err != nil {
 		return nil, err
@@ -200,12 +382,29 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 		configBackend:    configBackend,
 		versionID:        args.versionID,
 		sysvolCacheDir:   sysvolCacheDir,
+		manifestsDir:     manifestsDir,
 		policiesCacheDir: policiesCacheDir,
 		krb5CacheDir:     krb5CacheDir,
 
 		downloadables:  make(map[string]*downloadable),
+		gposInUse:      make(map[string]int),
 		gpoListCmd:     args.gpoListCmd,
 		gpoListTimeout: args.gpoListTimeout,
+		wmiUnknownPass: args.wmiUnknownPass,
+		systemRoot:     args.systemRoot,
+
+		offlineMaxAge:      args.offlineMaxAge,
+		offlineMaxAgeTypes: args.offlineMaxAgeTypes,
+		offlineStaleAction: args.offlineStaleAction,
+		offlineFallbackDir: args.offlineFallbackDir,
+
+		localSource: args.localSource,
+
+		resolver:        args.resolver,
+		site:            args.site,
+		dcRetries:       args.dcRetries,
+		dcRetryBackoff:  args.dcRetryBackoff,
+		downloadTimeout: args.downloadTimeout,
 
 		withoutKerberos: args.withoutKerberos,
 	}, nil
@@ -231,27 +430,12 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 		return pols, errors.New(gotext.Get("requested a type computer of %q which isn't current host %q", objectName, ad.hostname))
 	}
 
-	krb5CCPath := filepath.Join(ad.krb5CacheDir, objectName)
-	krb5CCSymlink := filepath.Join(ad.krb5CacheDir, "tracking", objectName)
-	// Create a ccache symlink on first fetch for future calls (on refresh for instance)
-	if userKrb5CCName != "" || objectClass == ComputerObject {
-		src := userKrb5CCName
-		// there is no env var for machine: get sss ccache
-		if objectClass == ComputerObject {
-			src, err = ad.configBackend.HostKrb5CCName()
-			if err != nil {
-				return pols, err
-			}
-		}
-
-		// Create a symlink to the ccache file
-		if err := ad.ensureKrb5CCSymlink(src, krb5CCSymlink); err != nil {
-			return pols, err
-		}
+	if ad.localSource != "" {
+		return ad.getLocalPolicies(ctx, objectName, objectClass)
 	}
 
-	// Ensure we have an up-to-date copy of the ccache file
-	if err := ad.ensureKrb5CCCopy(krb5CCSymlink, krb5CCPath); err != nil {
+	krb5CCPath, err := ad.ensureKrb5CC(objectName, objectClass, userKrb5CCName)
+	if err != nil {
 		return pols, err
 	}
 
@@ -268,66 +452,93 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 		}
 
 		log.Infof(ctx, "Can't reach AD: machine is offline and %q policies are applied using previous online update", objectName)
-		return cachedPolicies, nil
+		return ad.checkOfflineStaleness(ctx, objectName, objectClass, cachedPolicies)
 	}
 
-	// We need an AD DC to connect to
-	adServerFQDN, err := ad.configBackend.ServerFQDN(ctx)
-	if err != nil {
+	// We need an AD DC to connect to, or to discover one
+	server, err := ad.configBackend.ServerFQDN(ctx)
+	if err != nil && !errors.Is(err, backends.ErrNoActiveServer) {
 		return policies.Policies{}, errors.New(gotext.Get("can't get current Server FQDN: %v", err))
 	}
 
-	// Otherwise, try fetching the GPO list from LDAP
-	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
-	scriptArgs := []string{"--objectclass", string(objectClass), adServerFQDN, objectName}
-	if logrus.GetLevel() >= logrus.DebugLevel {
-		scriptArgs = append(scriptArgs, "--debug")
+	// Otherwise, try fetching the GPO list from LDAP, failing over to the other domain controllers
+	list := func(name string, class ObjectClass) (orderedGPOs []gpo, attributes map[string]string, err error) {
+		ccPath := krb5CCPath
+		if name != objectName {
+			if ccPath, err = ad.ensureKrb5CC(ad.hostname, ComputerObject, ""); err != nil {
+				return nil, nil, err
+			}
+		}
+		// Each attempt is already limited to the GPO list timeout.
+		server, err = ad.withFailover(ctx, []string{server}, 0, func(ctx context.Context, dc string) (err error) {
+			orderedGPOs, attributes, err = ad.listGPOs(ctx, name, class, ccPath, dc)
+			return err
+		})
+		return orderedGPOs, attributes, err
+	}
+	fetch := func(downloadables map[string]string) (assetsWereRefreshed bool, err error) {
+		// GPOs are first downloaded from the server they were listed with.
+		listServer := downloadablesServer(downloadables)
+		dc, err := ad.withFailover(ctx, []string{listServer, server}, ad.downloadTimeout, func(ctx context.Context, dc string) (err error) {
+			if err := probeSMB(ctx, dc); err != nil {
+				return err
+			}
+			// Only hold the AD lock while downloading, not during the backoff between retries.
+			ad.Lock()
+			assetsWereRefreshed, err = ad.fetch(ctx, krb5CCPath, withServer(downloadables, dc))
+			ad.Unlock()
+			if err != nil && ctx.Err() != nil {
+				// nolint:errorlint // We cannot have multiple error wrapping directives in a single call
+				return fmt.Errorf("%w: %v", errDCUnreachable, err)
+			}
+			return err
+		})
+		if err == nil && dc != listServer {
+			server = dc
+		}
+		return assetsWereRefreshed, err
 	}
-	cmdArgs := append(args, scriptArgs...)
-	cmdCtx, cancel := context.WithTimeout(ctx, ad.gpoListTimeout)
-	defer cancel()
-	log.Debugf(ctx, "Getting gpo list with arguments: %q", strings.Join(scriptArgs, " "))
-	// #nosec G204 - cmdArgs is under our control (python embedded script or mock for tests)
-	cmd := exec.CommandContext(cmdCtx, cmdArgs[0], cmdArgs[1:]...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CCPath))
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
 
-	smbsafe.WaitExec()
-	err = cmd.Run()
-	smbsafe.DoneExec()
+	pols, err = ad.policiesFromGPOs(ctx, objectName, objectClass, list, fetch)
 	if err != nil {
-		exitCode := cmd.ProcessState.ExitCode()
-		var reason string
-		switch exitCode {
-		case gpoListNotFound:
-			reason = gotext.Get("account %q was not found in Active Directory", objectName)
-		case gpoListConnectionFailed:
-			reason = gotext.Get("could not connect to the Active Directory server %q", adServerFQDN)
-		case gpoListGPOFailed:
-			reason = gotext.Get("could not compute the GPO list for %q", objectName)
-		default:
-			reason = gotext.Get("unexpected error while retrieving the GPO list")
+		return pols, err
+	}
+	pols.Server = server
+	return pols, nil
+}
+
+// policiesFromGPOs returns the policies of objectName, from the GPOs listed by list, which are then fetched to the
+// sysvol cache with fetch and parsed.
+// list returns the GPOs applied to an object, from the highest priority to the lowest, and its directory attributes.
+// fetch refreshes the sysvol cache with the GPOs and assets, by name, and returns if the assets were refreshed.
+// It must hold the AD lock while it writes to the sysvol cache.
+func (ad *AD) policiesFromGPOs(ctx context.Context, objectName string, objectClass ObjectClass,
+	list func(objectName string, objectClass ObjectClass) ([]gpo, map[string]string, error),
+	fetch func(downloadables map[string]string) (bool, error)) (pols policies.Policies, err error) {
+	orderedGPOs, attributes, err := list(objectName, objectClass)
+	if err != nil {
+		return pols, err
+	}
+
+	// With loopback processing, users get the user settings of the GPOs of the computer they log on. The GPOs of
+	// the computer configuring it are fetched with the user ones, so that enabling or disabling it applies on the
+	// next user refresh, without waiting for the computer to refresh its own policies.
+	var machineGPOs []gpo
+	if objectClass == UserObject {
+		if machineGPOs, _, err = list(ad.hostname, ComputerObject); err != nil {
+			return pols, err
 		}
-		return pols, errors.New(gotext.Get("failed to retrieve the list of GPO: %s (exited with %d): %v\n%s", reason, exitCode, err, stderr.String()))
 	}
+	fetchedGPOs := append(slices.Clone(orderedGPOs), machineGPOs...)
 
 	downloadables := make(map[string]string)
-	var orderedGPOs []gpo
-	scanner := bufio.NewScanner(&stdout)
-	for scanner.Scan() {
-		t := scanner.Text()
-		res := strings.SplitN(t, "\t", 2)
-		gpoName, gpoURL := res[0], res[1]
-		log.Debugf(ctx, "GPO %q for %q available at %q", gpoName, objectName, gpoURL)
-		downloadables[gpoName] = gpoURL
-		orderedGPOs = append(orderedGPOs, gpo{name: gpoName, url: gpoURL})
+	for _, g := range fetchedGPOs {
+		downloadables[g.name] = g.url
 
 		if _, ok := downloadables["assets"]; ok {
 			continue
 		}
-		u, err := url.Parse(gpoURL)
+		u, err := url.Parse(g.url)
 		if err != nil {
 			return pols, err
 		}
@@ -335,21 +546,33 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 		u.Path = filepath.Join(filepath.Dir(filepath.Dir(u.Path)), consts.DistroID)
 		downloadables["assets"] = u.String()
 	}
-	if err := scanner.Err(); err != nil {
-		return pols, err
-	}
+
+	// Prevent the GPOs from being garbage collected until they are parsed.
+	defer ad.useGPOs(fetchedGPOs)()
 
 	// Fetching mutates the shared on-disk caches and the krb5cc tickets and,
-	// through libsmbclient, is serialized process-wide anyway, so run it under
-	// the AD lock. Release the lock right afterwards so the CPU-bound parsing
-	// below can overlap with other objects being refreshed (e.g. during
-	// `update --all`).
-	ad.Lock()
-	assetsWereRefresh, err := ad.fetch(ctx, krb5CCPath, downloadables)
-	ad.Unlock()
+	// through libsmbclient, is serialized process-wide anyway, so fetch takes
+	// the AD lock for each download attempt only, and not while waiting for
+	// domain controllers. The CPU-bound parsing below runs without it and can
+	// overlap with other objects being refreshed (e.g. during `update --all`).
+	assetsWereRefresh, err := fetch(downloadables)
 	if err != nil {
 		return pols, err
 	}
+	ad.Lock()
+	ad.markGPOsUsed(ctx, fetchedGPOs)
+	ad.Unlock()
+
+	var loopback string
+	if objectClass == UserObject {
+		if loopback, err = ad.loopbackMode(ctx, machineGPOs); err != nil {
+			return pols, err
+		}
+	}
+	if loopback != "" {
+		log.Infof(ctx, "Loopback processing in %s mode: %q gets the user settings of the GPOs of %q", loopback, objectName, ad.hostname)
+		orderedGPOs = loopbackGPOs(loopback, orderedGPOs, machineGPOs)
+	}
 
 	var errg errgroup.Group
 	// Parse policies. This only reads the per-GPO caches (each guarded by its own
@@ -357,8 +580,10 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	// so it runs without the AD lock and can proceed concurrently for several
 	// objects at once.
 	var gposRules []policies.GPO
+	var gposVersions map[string]int
+	var gposLoopback string
 	errg.Go(func() (err error) {
-		gposRules, err = ad.parseGPOs(ctx, orderedGPOs, objectClass)
+		gposRules, gposVersions, gposLoopback, err = ad.parseGPOs(ctx, orderedGPOs, objectClass)
 		return err
 	})
 
@@ -428,7 +653,210 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 		defer ad.Unlock()
 	}
 
-	return policies.New(ctx, gposRules, assetsDBPath)
+	pols, err = policies.New(ctx, gposRules, assetsDBPath)
+	if err != nil {
+		return pols, err
+	}
+	pols.GPOVersions = gposVersions
+	pols.Attributes = attributes
+	pols.Loopback = loopback
+	if objectClass == ComputerObject {
+		pols.Loopback = gposLoopback
+	}
+	pols.OnlineRefresh = time.Now()
+	return pols, nil
+}
+
+// ensureKrb5CC returns the path to an up-to-date copy of the Kerberos ticket of objectName.
+// The ticket to copy is tracked on first call, from userKrb5CCName for a user and from the machine ticket for
+// the computer.
+func (ad *AD) ensureKrb5CC(objectName string, objectClass ObjectClass, userKrb5CCName string) (krb5CCPath string, err error) {
+	krb5CCPath = filepath.Join(ad.krb5CacheDir, objectName)
+	krb5CCSymlink := filepath.Join(ad.krb5CacheDir, "tracking", objectName)
+	// Create a ccache symlink on first fetch for future calls (on refresh for instance)
+	if userKrb5CCName != "" || objectClass == ComputerObject {
+		src := userKrb5CCName
+		// there is no env var for machine: get sss ccache
+		if objectClass == ComputerObject {
+			src, err = ad.configBackend.HostKrb5CCName()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// Create a symlink to the ccache file
+		if err := ad.ensureKrb5CCSymlink(src, krb5CCSymlink); err != nil {
+			return "", err
+		}
+	}
+
+	// Ensure we have an up-to-date copy of the ccache file
+	if err := ad.ensureKrb5CCCopy(krb5CCSymlink, krb5CCPath); err != nil {
+		return "", err
+	}
+
+	return krb5CCPath, nil
+}
+
+// listGPOs returns the GPOs applied to objectName, from the highest priority to the lowest, with its directory
+// attributes by lower-cased name. They are listed by the gpolist script, authenticated with krb5CCPath.
+// The error wraps errDCUnreachable if adServerFQDN can't be reached or doesn't answer in time.
+func (ad *AD) listGPOs(ctx context.Context, objectName string, objectClass ObjectClass, krb5CCPath, adServerFQDN string) (orderedGPOs []gpo, attributes map[string]string, err error) {
+	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
+	scriptArgs := []string{"--objectclass", string(objectClass), "--attributes", "--wmi-filters", adServerFQDN, objectName}
+	if logrus.GetLevel() >= logrus.DebugLevel {
+		scriptArgs = append(scriptArgs, "--debug")
+	}
+	cmdArgs := append(args, scriptArgs...)
+	cmdCtx, cancel := context.WithTimeout(ctx, ad.gpoListTimeout)
+	defer cancel()
+	log.Debugf(ctx, "Getting gpo list with arguments: %q", strings.Join(scriptArgs, " "))
+	// #nosec G204 - cmdArgs is under our control (python embedded script or mock for tests)
+	cmd := exec.CommandContext(cmdCtx, cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CCPath))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	smbsafe.WaitExec()
+	err = cmd.Run()
+	smbsafe.DoneExec()
+	if err != nil {
+		exitCode := cmd.ProcessState.ExitCode()
+		var reason string
+		switch exitCode {
+		case gpoListNotFound:
+			reason = gotext.Get("account %q was not found in Active Directory", objectName)
+		case gpoListConnectionFailed:
+			reason = gotext.Get("could not connect to the Active Directory server %q", adServerFQDN)
+		case gpoListGPOFailed:
+			reason = gotext.Get("could not compute the GPO list for %q", objectName)
+		default:
+			reason = gotext.Get("unexpected error while retrieving the GPO list")
+		}
+		err = errors.New(gotext.Get("failed to retrieve the list of GPO: %s (exited with %d): %v\n%s", reason, exitCode, err, stderr.String()))
+		if exitCode == gpoListConnectionFailed || errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+			// nolint:errorlint // We cannot have multiple error wrapping directives in a single call
+			err = fmt.Errorf("%w: %v", errDCUnreachable, err)
+		}
+		return nil, nil, err
+	}
+
+	return ad.parseGPOList(ctx, objectName, &stdout)
+}
+
+// parseGPOList returns the GPOs and the directory attributes of objectName from r, in the adsys-gpolist script
+// output format. GPOs whose WMI filter does not match the machine are filtered out.
+func (ad *AD) parseGPOList(ctx context.Context, objectName string, r io.Reader) (orderedGPOs []gpo, attributes map[string]string, err error) {
+	attributes = make(map[string]string)
+	wmiFilters := make(map[string][]wmiQuery)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		t := scanner.Text()
+		if t == "" {
+			continue
+		}
+		if res := strings.SplitN(t, "\t", 3); len(res) == 3 && res[0] == gpoListAttribute {
+			attributes[strings.ToLower(res[1])] = res[2]
+			continue
+		}
+		if res := strings.SplitN(t, "\t", 4); len(res) == 4 && res[0] == gpoListWMIFilter {
+			wmiFilters[res[1]] = append(wmiFilters[res[1]], wmiQuery{namespace: res[2], query: res[3]})
+			continue
+		}
+		res := strings.SplitN(t, "\t", 2)
+		if len(res) != 2 {
+			return nil, nil, errors.New(gotext.Get("invalid line in the GPO list of %q: %q", objectName, t))
+		}
+		gpoName, gpoURL := res[0], res[1]
+		log.Debugf(ctx, "GPO %q for %q available at %q", gpoName, objectName, gpoURL)
+		orderedGPOs = append(orderedGPOs, gpo{name: gpoName, url: gpoURL})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if orderedGPOs, err = ad.filterByWMI(ctx, objectName, orderedGPOs, wmiFilters); err != nil {
+		return nil, nil, err
+	}
+
+	return orderedGPOs, attributes, nil
+}
+
+// wmiQuery is a WQL query of the WMI filter of a GPO.
+type wmiQuery struct {
+	namespace string
+	query     string
+}
+
+// filterByWMI returns the GPOs whose WMI filter, by GPO name in filters, matches the local machine. The filter of a
+// GPO matches if all its queries do.
+// Filters that can't be evaluated are logged and the GPO is kept or skipped depending on the configuration.
+func (ad *AD) filterByWMI(ctx context.Context, objectName string, gpos []gpo, filters map[string][]wmiQuery) ([]gpo, error) {
+	if len(filters) == 0 {
+		return gpos, nil
+	}
+
+	// WMI filters are evaluated on the machine the object logs on, for users and computer alike.
+	facts, err := wmi.LocalFacts(ad.systemRoot, ad.hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []gpo
+	for _, g := range gpos {
+		match := true
+		for _, q := range filters[g.name] {
+			ok, err := wmi.Evaluate(q.namespace, q.query, facts)
+			if err != nil {
+				log.Warningf(ctx, "Can't evaluate the WMI filter of GPO %q for %q, it is considered as %s: %v", g.name, objectName, ad.wmiUnknownMode(), err)
+				ok = ad.wmiUnknownPass
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if !match {
+			log.Infof(ctx, "GPO %q is skipped for %q: its WMI filter does not match this machine", g.name, objectName)
+			continue
+		}
+		kept = append(kept, g)
+	}
+	return kept, nil
+}
+
+// wmiUnknownMode returns how the GPOs whose WMI filter can't be evaluated are handled.
+func (ad *AD) wmiUnknownMode() string {
+	if ad.wmiUnknownPass {
+		return WMIFiltersPass
+	}
+	return WMIFiltersFail
+}
+
+// loopbackMode returns the user policy loopback processing mode configured by the closest of the fetched GPOs of
+// the computer defining it. It is empty if loopback processing is disabled.
+func (ad *AD) loopbackMode(ctx context.Context, machineGPOs []gpo) (string, error) {
+	_, _, loopback, err := ad.parseGPOs(ctx, machineGPOs, ComputerObject)
+	return loopback, err
+}
+
+// loopbackGPOs returns, from the highest priority to the lowest, the GPOs of a user with loopback processing in
+// mode. In replace mode, they are the computer ones. In merge mode, the computer GPOs are processed after the user
+// ones, and so take precedence over them: they are listed first, and each GPO is only listed once.
+func loopbackGPOs(mode string, userGPOs, computerGPOs []gpo) []gpo {
+	if mode == policies.LoopbackReplace {
+		return computerGPOs
+	}
+
+	gpos := slices.Clone(computerGPOs)
+	for _, g := range userGPOs {
+		if slices.ContainsFunc(gpos, func(c gpo) bool { return c.name == g.name }) {
+			continue
+		}
+		gpos = append(gpos, g)
+	}
+	return gpos
 }
 
 // ListUsers returns the list of users on the system based on their cached policy information.
@@ -556,9 +984,13 @@ func safeCopyFile(src, dst string, mode os.FileMode) error {
 	return os.Rename(dst+".new", dst)
 }
 
-func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass) (r []policies.GPO, err error) {
+// parseGPOs returns the rules of gpos for objectClass, with their versions by ID.
+// It also returns the loopback processing mode configured by the closest GPO defining it for the computer.
+func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass) (r []policies.GPO, versions map[string]int, loopback string, err error) {
 	keyFilterPrefix := fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, consts.DistroID)
 
+	versions = make(map[string]int)
+	var loopbackDefined bool
 	for _, g := range gpos {
 		name, url := g.name, g.url
 		gpoWithRules := policies.GPO{
@@ -567,15 +999,50 @@ func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass
 			Rules: make(map[string][]entry.Entry),
 		}
 		r = append(r, gpoWithRules)
-		if err = ad.parseGPO(ctx, name, url, keyFilterPrefix, objectClass, gpoWithRules); err != nil {
-			return r, err
+		gpoLoopback, hasLoopback, err := ad.parseGPO(ctx, name, url, keyFilterPrefix, objectClass, gpoWithRules)
+		if err != nil {
+			return r, versions, "", err
+		}
+		if hasLoopback && !loopbackDefined {
+			loopback, loopbackDefined = gpoLoopback, true
 		}
+		if version, ok := ad.localGPOVersion(ctx, name, url); ok {
+			versions[gpoWithRules.ID] = version
+		}
+	}
+
+	return r, versions, loopback, nil
+}
+
+// localGPOVersion returns the version of the downloaded GPO, if it can be read.
+func (ad *AD) localGPOVersion(ctx context.Context, name, url string) (version int, ok bool) {
+	ad.downloadablesMu.RLock()
+	d := ad.downloadables[name]
+	ad.downloadablesMu.RUnlock()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	gptIniPath, err := findLocalGPTIni(filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(url)))
+	if err != nil {
+		log.Debugf(ctx, "Can't find version of GPO %q: %v", name, err)
+		return 0, false
+	}
+	f, err := os.Open(filepath.Clean(gptIniPath))
+	if err != nil {
+		log.Debugf(ctx, "Can't find version of GPO %q: %v", name, err)
+		return 0, false
 	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
 
-	return r, nil
+	if version, err = getGPOVersion(ctx, f, name); err != nil {
+		log.Debugf(ctx, "Can't find version of GPO %q: %v", name, err)
+		return 0, false
+	}
+	return version, true
 }
 
-func (ad *AD) parseGPO(ctx context.Context, name, url, keyFilterPrefix string, objectClass ObjectClass, gpoWithRules policies.GPO) error {
+func (ad *AD) parseGPO(ctx context.Context, name, url, keyFilterPrefix string, objectClass ObjectClass, gpoWithRules policies.GPO) (loopback string, hasLoopback bool, err error) {
 	ad.downloadablesMu.RLock()
 	d := ad.downloadables[name]
 	ad.downloadablesMu.RUnlock()
@@ -594,10 +1061,14 @@ func (ad *AD) parseGPO(ctx context.Context, name, url, keyFilterPrefix string, o
 		classes = []string{"Machine", "MACHINE"}
 	}
 
+	// Preferences are processed after the registry policies on Windows, so their entries take precedence.
+	if err := ad.parsePreferences(ctx, url, classes[0], objectClass == ComputerObject, gpoWithRules); err != nil {
+		return "", false, err
+	}
+
 	var f *os.File
 classLoop:
 	for _, class := range classes {
-		var err error
 		var files []os.DirEntry
 
 		policyDir := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(url), class)
@@ -606,7 +1077,7 @@ classLoop:
 			log.Debugf(ctx, "Policy directory %q not found", policyDir)
 			continue
 		} else if err != nil {
-			return err
+			return "", false, err
 		}
 
 		// Registry.pol can have different cases, ensure we can find it whatever its case is
@@ -620,7 +1091,7 @@ classLoop:
 
 			f, err = os.Open(policyPath)
 			if err != nil {
-				return err
+				return "", false, err
 			}
 
 			break classLoop
@@ -629,7 +1100,7 @@ classLoop:
 
 	if f == nil {
 		log.Debugf(ctx, "Policy %q doesn't have any policy for class %q", name, objectClass)
-		return nil
+		return "", false, nil
 	}
 
 	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
@@ -637,13 +1108,22 @@ classLoop:
 	// Decode and apply policies in gpo order. First win
 	pols, err := registry.DecodePolicy(f)
 	if err != nil {
-		return errors.New(gotext.Get("%s: %v", f.Name(), err))
+		return "", false, errors.New(gotext.Get("%s: %v", f.Name(), err))
 	}
 
 	// filter keys to be overridden
 	var currentKey string
 	var overrideEnabled bool
 	for _, pol := range pols {
+		// Loopback processing is a Windows policy of the computer, applying to the users logging on it.
+		if objectClass == ComputerObject && pol.Key == loopbackKey {
+			hasLoopback = true
+			if !pol.Disabled {
+				loopback = loopbackModes[pol.Value]
+			}
+			continue
+		}
+
 		// Rewrite the certificate autoenrollment key so we can easily
 		// use it in the policy manager
 		if pol.Key == certAutoEnrollKey {
@@ -659,7 +1139,7 @@ classLoop:
 			continue
 		}
 		if pol.Err != nil {
-			return errors.New(gotext.Get("%s: %v", f.Name(), pol.Err))
+			return "", false, errors.New(gotext.Get("%s: %v", f.Name(), pol.Err))
 		}
 		pol.Key = strings.TrimPrefix(pol.Key, keyFilterPrefix)
 
@@ -698,9 +1178,81 @@ classLoop:
 		gpoWithRules.Rules[keyType][iLast] = p
 	}
 
+	return loopback, hasLoopback, nil
+}
+
+// preferences are the Group Policy Preferences files, relative to a GPO class directory, with the rule type and the
+// parser of their entries.
+var preferences = []struct {
+	path     []string
+	ruleType string
+	parse    func(ctx context.Context, r io.Reader, isComputer bool) ([]entry.Entry, error)
+}{
+	{[]string{"Preferences", "Drives", "Drives.xml"}, "mount", gpp.Drives},
+	{[]string{"Preferences", "Groups", "Groups.xml"}, "localgroups", gpp.Groups},
+	{[]string{"Preferences", "EnvironmentVariables", "EnvironmentVariables.xml"}, "environment", gpp.EnvironmentVariables},
+	{[]string{"Preferences", "ScheduledTasks", "ScheduledTasks.xml"}, "scheduledtasks", gpp.ScheduledTasks},
+	{[]string{"Preferences", "Folders", "Folders.xml"}, "files", gpp.Folders},
+	{[]string{"Preferences", "Files", "Files.xml"}, "files", gpp.Files},
+}
+
+// parsePreferences adds to gpoWithRules the entries of the Group Policy Preferences of class in the GPO at url.
+// Items listed later in a file are processed later on Windows, so their entries come first, as for closer GPOs.
+func (ad *AD) parsePreferences(ctx context.Context, url, class string, isComputer bool, gpoWithRules policies.GPO) (err error) {
+	gpoDir := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(url))
+	for _, p := range preferences {
+		path, ok := findPathFold(gpoDir, append([]string{class}, p.path...)...)
+		if !ok {
+			continue
+		}
+		log.Debugf(ctx, "Found preferences file %q", path)
+
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		entries, err := p.parse(ctx, f, isComputer)
+		decorate.LogFuncOnErrorContext(ctx, f.Close)
+		if err != nil {
+			return errors.New(gotext.Get("%s: %v", path, err))
+		}
+		if p.ruleType == "files" {
+			entries = gpp.EmbedGPOFiles(ctx, gpoDir, entries)
+		}
+
+		source, err := filepath.Rel(gpoDir, path)
+		if err != nil {
+			return err
+		}
+		for _, e := range slices.Backward(entries) {
+			e.Source = filepath.ToSlash(source)
+			gpoWithRules.Rules[p.ruleType] = append(gpoWithRules.Rules[p.ruleType], e)
+		}
+	}
 	return nil
 }
 
+// findPathFold returns the path of elems under root, each element being matched case-insensitively, and if it exists.
+func findPathFold(root string, elems ...string) (string, bool) {
+	p := root
+	for _, elem := range elems {
+		if _, err := os.Stat(filepath.Join(p, elem)); err == nil {
+			p = filepath.Join(p, elem)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return "", false
+		}
+		i := slices.IndexFunc(entries, func(e os.DirEntry) bool { return strings.EqualFold(e.Name(), elem) })
+		if i == -1 {
+			return "", false
+		}
+		p = filepath.Join(p, entries[i].Name())
+	}
+	return p, true
+}
+
 // GetInfo returns all information from the selected backend: static and dynamic part.
 func (ad *AD) GetInfo(ctx context.Context) (msg string) {
 	// static part
@@ -1,17 +1,23 @@
 package ad_test
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,6 +40,12 @@ func TestNew(t *testing.T) {
 		cacheDirRO             bool
 		runDirRO               bool
 		backendServerFQDNError error
+		wmiUnknownFilters      string
+		offlineMaxAge          time.Duration
+		offlineMaxAgeTypes     map[string]time.Duration
+		offlineStaleAction     string
+		dcRetries              int
+		dcRetryBackoff         time.Duration
 
 		wantErr bool
 	}{
@@ -44,6 +56,13 @@ func TestNew(t *testing.T) {
 		"failed to create Sysvol cache directory":    {cacheDirRO: true, wantErr: true},
 		"failed to create Policies cache directory":  {sysvolCacheDirExists: true, cacheDirRO: true, wantErr: true},
 		"error on backend ServerFQDN random failure": {backendServerFQDNError: errors.New("Some failure on ServerFQDN"), wantErr: true},
+		"error on invalid WMI unknown filters mode":  {wmiUnknownFilters: "ignore", wantErr: true},
+		"error on invalid offline stale action":      {offlineStaleAction: "ignore", wantErr: true},
+		"error on negative offline maximum age":      {offlineMaxAge: -time.Hour, wantErr: true},
+		"error on negative offline rule type maximum age": {
+			offlineMaxAgeTypes: map[string]time.Duration{"dconf": -time.Hour}, wantErr: true},
+		"error on negative domain controller retries": {dcRetries: -1, wantErr: true},
+		"error on negative domain controller backoff": {dcRetryBackoff: -time.Second, wantErr: true},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -61,9 +80,16 @@ func TestNew(t *testing.T) {
 				testutils.MakeReadOnly(t, cacheDir)
 			}
 
-			adc, err := ad.New(context.Background(), mock.Backend{ErrServerFQDN: tc.backendServerFQDNError}, hostname,
-				ad.WithRunDir(runDir),
-				ad.WithCacheDir(cacheDir))
+			opts := []ad.Option{ad.WithRunDir(runDir), ad.WithCacheDir(cacheDir)}
+			if tc.wmiUnknownFilters != "" {
+				opts = append(opts, ad.WithWMIUnknownFilters(tc.wmiUnknownFilters))
+			}
+			if tc.offlineStaleAction != "" {
+				opts = append(opts, ad.WithOfflineStaleAction(tc.offlineStaleAction))
+			}
+			opts = append(opts, ad.WithOfflineMaxAge(tc.offlineMaxAge, tc.offlineMaxAgeTypes))
+			opts = append(opts, ad.WithDCRetries(tc.dcRetries, tc.dcRetryBackoff))
+			adc, err := ad.New(context.Background(), mock.Backend{ErrServerFQDN: tc.backendServerFQDNError}, hostname, opts...)
 			if tc.wantErr {
 				require.NotNil(t, err, "AD creation should have failed")
 				return
@@ -118,6 +144,10 @@ func TestGetPolicies(t *testing.T) {
 
 		turnKrb5CCCacheRO bool
 		existing          map[string]string
+		// machineLoopback is the loopback processing mode of the policies last applied to the computer.
+		machineLoopback string
+		// wmiUnknownFilters is how GPOs whose WMI filter can't be evaluated are handled.
+		wmiUnknownFilters string
 
 		want             policies.Policies
 		wantAssetsEquals string
@@ -150,11 +180,137 @@ func TestGetPolicies(t *testing.T) {
 			gpoListArgs: []string{"gpoonly.com", hostname + ":user-only"},
 			want:        policies.Policies{GPOs: []policies.GPO{{ID: "user-only", Name: "user-only-name", Rules: make(map[string][]entry.Entry)}}},
 		},
+		"Preferences are parsed after the registry policy, user object": {
+			gpoListArgs: []string{"gpoonly.com", "bob:preferences"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "preferences", Name: "preferences-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					},
+					"mount": {
+						{Key: "user-mounts", Value: "[krb5]smb://server/homes/${USER}", Strategy: entry.StrategyAppend, Source: "User/Preferences/Drives/Drives.xml"},
+						{Key: "user-mounts", Value: "[krb5]smb://server/projects", Strategy: entry.StrategyAppend, Target: `group("Developers")`, Source: "User/Preferences/Drives/Drives.xml"},
+					}}}},
+			},
+		},
+		"Preferences without registry policy, computer object": {
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			gpoListArgs: []string{"gpoonly.com", hostname + ":preferences"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "preferences", Name: "preferences-name", Rules: map[string][]entry.Entry{
+					"mount": {
+						{Key: "system-mounts", Value: "[krb5]smb://server/shared\nsmb://server/shared", Strategy: entry.StrategyRemove, Source: "Machine/Preferences/Drives/Drives.xml"},
+					},
+					"localgroups": {
+						{Key: "lpadmin", Value: "remove-all\nadd GPOONLY\\Printer Admins", Strategy: entry.StrategyAppend, Source: "Machine/Preferences/Groups/Groups.xml"},
+						{Key: "docker", Value: `add GPOONLY\Developers`, Strategy: entry.StrategyAppend, Source: "Machine/Preferences/Groups/Groups.xml"},
+					},
+					"environment": {
+						{Key: "system-environment", Value: "MACHINE=${HOSTNAME}", Strategy: entry.StrategyAppend, Source: "Machine/Preferences/EnvironmentVariables/EnvironmentVariables.xml"},
+						{Key: "system-environment", Value: "EDITOR=vim", Strategy: entry.StrategyAppend, Source: "Machine/Preferences/EnvironmentVariables/EnvironmentVariables.xml"},
+					},
+					"scheduledtasks": {
+						{Key: "Cleanup", Value: "OnCalendar=*-*-* 02:00:00\nExecStart=cleanup.sh", Source: "Machine/Preferences/ScheduledTasks/ScheduledTasks.xml"},
+					},
+					"files": {
+						{Key: "/opt/app", Value: "Action=update", Source: "Machine/Preferences/Folders/Folders.xml"},
+						{Key: "/opt/app/readme.txt", Value: "Action=update\nContent=V2VsY29tZQo=", Source: "Machine/Preferences/Files/Files.xml"},
+						{Key: "/opt/app/license.ini", Value: "Action=update\nSource=app/license.ini\nMode=0444", Source: "Machine/Preferences/Files/Files.xml"},
+					}}}},
+			},
+		},
 		"Computer only policy, user object, policy is empty": {
 			gpoListArgs: []string{"gpoonly.com", "bob:machine-only"},
 			want:        policies.Policies{GPOs: []policies.GPO{{ID: "machine-only", Name: "machine-only-name", Rules: make(map[string][]entry.Entry)}}},
 		},
 
+		// Loopback processing cases
+		"Loopback mode is read from computer GPOs": {
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			gpoListArgs: []string{"gpoonly.com", hostname + ":loopback-merge"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "loopback-merge", Name: "loopback-merge-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "D", Value: "loopbackMergeD"},
+				}}}},
+				Loopback: policies.LoopbackMerge,
+			},
+		},
+		"Loopback mode is read from the closest computer GPO": {
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			gpoListArgs: []string{"gpoonly.com", hostname + ":loopback-replace::" + hostname + ":loopback-merge"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "loopback-replace", Name: "loopback-replace-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "D", Value: "loopbackReplaceD"},
+					}}},
+				{ID: "loopback-merge", Name: "loopback-merge-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "D", Value: "loopbackMergeD"},
+					}}},
+			},
+				Loopback: policies.LoopbackReplace,
+			},
+		},
+		"Loopback mode of computer GPOs is not applied to the computer": {
+			objectName:      hostname,
+			objectClass:     ad.ComputerObject,
+			machineLoopback: policies.LoopbackReplace,
+			gpoListArgs:     []string{"gpoonly.com", hostname + ":standard"},
+			want:            policies.Policies{GPOs: []policies.GPO{standardComputerGPO("standard")}},
+		},
+		"Loopback merge mode lists user settings of computer GPOs before user GPOs": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard::" + hostname + ":loopback-merge"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "loopback-merge", Name: "loopback-merge-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "loopbackMergeA"},
+						{Key: "F", Value: "loopbackMergeF"},
+					}}},
+				standardUserGPO("standard"),
+			},
+				Loopback: policies.LoopbackMerge,
+			},
+		},
+		"Loopback merge mode lists GPOs of both user and computer once": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard::bob:user-only::" + hostname + ":loopback-merge::" + hostname + ":user-only"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "loopback-merge", Name: "loopback-merge-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "loopbackMergeA"},
+						{Key: "F", Value: "loopbackMergeF"},
+					}}},
+				{ID: "user-only", Name: "user-only-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "userOnlyA"},
+						{Key: "B", Value: "userOnlyB"},
+					}}},
+				standardUserGPO("standard"),
+			},
+				Loopback: policies.LoopbackMerge,
+			},
+		},
+		"Loopback replace mode lists only user settings of computer GPOs": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard::" + hostname + ":loopback-replace"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "loopback-replace", Name: "loopback-replace-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "loopbackReplaceA"},
+						{Key: "F", Value: "loopbackReplaceF"},
+					}}},
+			},
+				Loopback: policies.LoopbackReplace,
+			},
+		},
+		"Loopback mode is not read from the policies last applied to the computer": {
+			machineLoopback: policies.LoopbackReplace,
+			gpoListArgs:     []string{"gpoonly.com", "bob:standard::" + hostname + ":standard"},
+			want:            policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+
 		// Assets cases
 		"Standard policy with assets, downloads assets": {
 			objectName:  hostname,
@@ -473,6 +629,58 @@ func TestGetPolicies(t *testing.T) {
 			want:        policies.Policies{GPOs: []policies.GPO{standardComputerGPO("mixedcase-registry")}},
 		},
 
+		// WMI filters, evaluated against testdata/wmi/root: an Ubuntu 24.04 desktop
+		"WMI filter matching the machine keeps the GPO": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard|SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%'"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter not matching the machine skips the GPO": {
+			gpoListArgs: []string{"gpoonly.com", "bob:one-value|SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9 OR ChassisTypes = 10::bob:standard"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter skips the GPO if any of its queries does not match": {
+			gpoListArgs: []string{"gpoonly.com", "bob:one-value|SELECT * FROM Win32_OperatingSystem WHERE Version >= '22.04'|SELECT * FROM Win32_Battery::bob:standard"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter of computer GPOs": {
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			gpoListArgs: []string{"gpoonly.com", hostname + ":one-value|SELECT * FROM Win32_OperatingSystem WHERE Version < '22.04'::" + hostname + ":standard|SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 3"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardComputerGPO("standard")}},
+		},
+		"WMI filter that can't be evaluated keeps the GPO by default": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard|SELECT * FROM Win32_Product WHERE Name = 'Office'"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter that can't be evaluated keeps the GPO in pass mode": {
+			gpoListArgs:       []string{"gpoonly.com", "bob:standard|SELECT * FROM Win32_Product WHERE Name = 'Office'"},
+			wmiUnknownFilters: ad.WMIFiltersPass,
+			want:              policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter that can't be evaluated skips the GPO in fail mode": {
+			gpoListArgs:       []string{"gpoonly.com", "bob:one-value|SELECT * FROM Win32_Product WHERE Name = 'Office'::bob:standard"},
+			wmiUnknownFilters: ad.WMIFiltersFail,
+			want:              policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter that can't be read skips the GPO in fail mode": {
+			gpoListArgs:       []string{"gpoonly.com", "bob:one-value|::bob:standard"},
+			wmiUnknownFilters: ad.WMIFiltersFail,
+			want:              policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"WMI filter not matching skips the computer GPOs of users with loopback processing": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard::" + hostname + ":loopback-merge::" + hostname + ":user-only|SELECT * FROM Win32_Battery"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "loopback-merge", Name: "loopback-merge-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "A", Value: "loopbackMergeA"},
+						{Key: "F", Value: "loopbackMergeF"},
+					}}},
+				standardUserGPO("standard"),
+			},
+				Loopback: policies.LoopbackMerge,
+			},
+		},
+
 		// Error cases
 		"Machine doesn’t match": {
 			objectName:  "NotHostname",
@@ -621,10 +829,14 @@ func TestGetPolicies(t *testing.T) {
 			}
 
 			cachedir, rundir := t.TempDir(), t.TempDir()
-			adc, err := ad.New(context.Background(), tc.backend, hostname,
-				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+			opts := []ad.Option{ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)),
-				ad.WithVersionID(tc.versionID))
+				ad.WithVersionID(tc.versionID),
+				ad.WithSystemRoot(filepath.Join("testdata", "wmi", "root"))}
+			if tc.wmiUnknownFilters != "" {
+				opts = append(opts, ad.WithWMIUnknownFilters(tc.wmiUnknownFilters))
+			}
+			adc, err := ad.New(context.Background(), tc.backend, hostname, opts...)
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			if tc.turnKrb5CCCacheRO {
@@ -636,6 +848,11 @@ func TestGetPolicies(t *testing.T) {
 				testutils.Copy(t, src, filepath.Join(adc.SysvolCacheDir(), n))
 			}
 
+			if tc.machineLoopback != "" {
+				machinePols := policies.Policies{Loopback: tc.machineLoopback}
+				require.NoError(t, machinePols.Save(filepath.Join(adc.PoliciesCacheDir(), hostname)), "Setup: can't save computer policies")
+			}
+
 			entries, err := adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, krb5CCName)
 			if tc.wantErr {
 				require.Error(t, err, "GetPolicies should have errored out")
@@ -648,6 +865,14 @@ func TestGetPolicies(t *testing.T) {
 
 			// Compare GPOs
 			require.Equal(t, tc.want.GPOs, entries.GPOs, "GetPolicies returns expected GPO entries in correct order")
+			require.Equal(t, tc.want.Loopback, entries.Loopback, "GetPolicies returns the loopback processing mode")
+
+			// Compare attributes
+			accountName := strings.Split(tc.objectName, "@")[0]
+			require.Equal(t, map[string]string{
+				"department": accountName + " department",
+				"objectsid":  "S-1-5-21-" + accountName,
+			}, entries.Attributes, "GetPolicies returns attributes of the object")
 
 			// Compare assets
 			uncompressedAssets := t.TempDir()
@@ -670,11 +895,29 @@ func TestGetPoliciesOffline(t *testing.T) {
 	hostname, err := os.Hostname()
 	require.NoError(t, err, "Setup: failed to get hostname")
 
+	staleGPOs := []policies.GPO{{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{
+		"dconf": standardUserGPO("standard").Rules["dconf"],
+	}}}
+	fallbackPrivilege := map[string][]entry.Entry{"privilege": {{Key: "allow-local-admins", Disabled: true}}}
+	cachedPrivilege := map[string][]entry.Entry{"privilege": {{Key: "allow-local-admins", Value: "true"}}}
+	offline := mock.Backend{Dom: "gpoonly.com", Online: false}
+
 	tests := map[string]struct {
 		domainToCache string
 		backend       mock.Backend
 		gpoListArgs   []string
 
+		// cacheAge is the time since the cached policies were refreshed online.
+		cacheAge        time.Duration
+		noOnlineRefresh bool
+		extraRules      map[string][]entry.Entry
+		maxAge          time.Duration
+		maxAgeTypes     map[string]time.Duration
+		staleAction     string
+		fallbackDir     string
+
+		// wantGPOs are the expected GPOs when they differ from the cached ones.
+		wantGPOs   []policies.GPO
 		wantAssets bool
 		wantErr    bool
 	}{
@@ -713,6 +956,49 @@ func TestGetPoliciesOffline(t *testing.T) {
 			wantAssets:  true,
 		},
 
+		// Maximum age of offline policies
+		"Offline, cache younger than its maximum age is applied": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: time.Hour, maxAge: 2 * time.Hour, staleAction: ad.OfflineStaleRefuse,
+		},
+		"Offline, stale cache is applied in warn mode": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: 2 * time.Hour, staleAction: ad.OfflineStaleWarn,
+		},
+		"Offline, stale cache is applied by default": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: 2 * time.Hour,
+		},
+		"Offline, maximum age of a rule type only applies to its rules": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAgeTypes: map[string]time.Duration{"privilege": time.Hour}, staleAction: ad.OfflineStaleRefuse,
+		},
+		"Offline, maximum age of a rule type overrides the global one": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: time.Hour, maxAgeTypes: map[string]time.Duration{"dconf": 0}, staleAction: ad.OfflineStaleRefuse,
+		},
+		"Offline, stale rules of a type are replaced with fallback ones": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, extraRules: cachedPrivilege,
+			maxAgeTypes: map[string]time.Duration{"privilege": time.Hour}, staleAction: ad.OfflineStaleFallback,
+			wantGPOs: append([]policies.GPO{{ID: "offline-fallback", Name: "offline-fallback-name", Rules: fallbackPrivilege}}, staleGPOs...),
+		},
+		"Offline, all stale rules are replaced with fallback ones": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, extraRules: cachedPrivilege, maxAge: time.Hour, staleAction: ad.OfflineStaleFallback,
+			wantGPOs: []policies.GPO{
+				{ID: "offline-fallback", Name: "offline-fallback-name", Rules: map[string][]entry.Entry{
+					"dconf":     {{Key: "A", Value: "fallbackA"}},
+					"privilege": fallbackPrivilege["privilege"],
+				}},
+				{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{}},
+			},
+		},
+		"Offline, fallback rules of types which are not stale are ignored": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: time.Hour, maxAgeTypes: map[string]time.Duration{"dconf": 5 * time.Hour}, staleAction: ad.OfflineStaleFallback,
+		},
+
 		"Error on SSSD reports online, but we are actually offline when fetching gpo list, even with a cache": {
 			domainToCache: "assetsandgpo.com",
 			backend: mock.Backend{
@@ -730,6 +1016,27 @@ func TestGetPoliciesOffline(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		"Error offline with stale cache in refuse mode": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: time.Hour, staleAction: ad.OfflineStaleRefuse,
+			wantErr: true,
+		},
+		"Error offline with stale rule type in refuse mode": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, extraRules: cachedPrivilege,
+			maxAgeTypes: map[string]time.Duration{"privilege": time.Hour}, staleAction: ad.OfflineStaleRefuse,
+			wantErr: true,
+		},
+		"Error offline with cache without online refresh time in refuse mode": {
+			domainToCache: "gpoonly.com", backend: offline,
+			noOnlineRefresh: true, maxAge: 24 * time.Hour, staleAction: ad.OfflineStaleRefuse,
+			wantErr: true,
+		},
+		"Error offline with stale cache and missing fallback policies": {
+			domainToCache: "gpoonly.com", backend: offline,
+			cacheAge: 3 * time.Hour, maxAge: time.Hour, staleAction: ad.OfflineStaleFallback, fallbackDir: "does-not-exist",
+			wantErr: true,
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -739,10 +1046,19 @@ func TestGetPoliciesOffline(t *testing.T) {
 			tc.backend.HostKrb5CCNamePath = filepath.Join(t.TempDir(), "host_ccache")
 			testutils.CreatePath(t, tc.backend.HostKrb5CCNamePath)
 
+			if tc.fallbackDir == "" {
+				tc.fallbackDir = filepath.Join("testdata", "offline-fallback")
+			}
+
 			cachedir, rundir := t.TempDir(), t.TempDir()
-			adc, err := ad.New(context.Background(), tc.backend, hostname,
-				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
-				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)))
+			opts := []ad.Option{ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)),
+				ad.WithOfflineMaxAge(tc.maxAge, tc.maxAgeTypes),
+				ad.WithOfflineFallbackDir(tc.fallbackDir)}
+			if tc.staleAction != "" {
+				opts = append(opts, ad.WithOfflineStaleAction(tc.staleAction))
+			}
+			adc, err := ad.New(context.Background(), tc.backend, hostname, opts...)
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			objectName := fmt.Sprintf("useroffline@%s", strings.ToUpper(tc.backend.Dom))
@@ -768,6 +1084,15 @@ func TestGetPoliciesOffline(t *testing.T) {
 
 				initialPolicies, err = adcForCache.GetPolicies(context.Background(), objectNameForCache, objectClass, krb5CCNameForCache)
 				require.NoError(t, err, "Setup: caching with getPolicies failed")
+				require.WithinDuration(t, time.Now(), initialPolicies.OnlineRefresh, time.Minute, "Setup: GetPolicies should set the online refresh time")
+
+				initialPolicies.OnlineRefresh = initialPolicies.OnlineRefresh.Add(-tc.cacheAge)
+				if tc.noOnlineRefresh {
+					initialPolicies.OnlineRefresh = time.Time{}
+				}
+				for k, v := range tc.extraRules {
+					initialPolicies.GPOs[0].Rules[k] = v
+				}
 
 				// Save it and copy to finale destination
 				err = initialPolicies.Save(filepath.Join(adc.PoliciesCacheDir(), objectName))
@@ -783,12 +1108,291 @@ func TestGetPoliciesOffline(t *testing.T) {
 
 			// Ensure we only have one policy
 			require.NotEqual(t, 0, len(entries.GPOs), "GetPolicies should return at least one GPO list when not failing")
+			require.True(t, initialPolicies.OnlineRefresh.Equal(entries.OnlineRefresh), "GetPolicies should keep the online refresh time of the cache")
+			// Only the policies with fallback rules must not replace the cached ones.
+			require.Equal(t, tc.wantGPOs != nil, entries.Transient, "GetPolicies should only return transient policies with fallback rules")
 
+			if tc.wantGPOs != nil {
+				initialPolicies.GPOs = tc.wantGPOs
+			}
 			assertEqualPolicies(t, initialPolicies, entries, tc.wantAssets)
 		})
 	}
 }
 
+func TestGetPoliciesLocalSource(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	const userName = "bob@example.com"
+	sysvol := filepath.Join("testdata", "AD", "SYSVOL", "assetsandgpo.com")
+
+	tests := map[string]struct {
+		objectName  string
+		objectClass ad.ObjectClass
+		// gpoLists are the GPO lists of the local source, by file name.
+		gpoLists map[string]string
+		archive  string
+		noAssets bool
+		// extraArchiveEntry is added to the archive.
+		extraArchiveEntry string
+		noSource          bool
+
+		want       []policies.GPO
+		wantAttrs  map[string]string
+		wantAssets bool
+		wantErr    bool
+	}{
+		"Local source directory for a user": {
+			gpoLists:   map[string]string{userName: "ATTR\tdepartment\tbob department\nstandard-name\tPolicies/standard\n"},
+			want:       []policies.GPO{standardUserGPO("standard")},
+			wantAttrs:  map[string]string{"department": "bob department"},
+			wantAssets: true,
+		},
+		"Local source directory for the machine": {
+			objectName:  hostname,
+			objectClass: ad.ComputerObject,
+			gpoLists:    map[string]string{hostname: "standard-name\tPolicies/standard\n"},
+			want:        []policies.GPO{standardComputerGPO("standard")},
+			wantAssets:  true,
+		},
+		"GPOs are returned in the order of the GPO list": {
+			gpoLists: map[string]string{userName: "one-value-name\tPolicies/one-value\nstandard-name\tPolicies/standard\n"},
+			want: []policies.GPO{
+				{ID: "one-value", Name: "one-value-name", Rules: map[string][]entry.Entry{
+					"dconf": {{Key: "C", Value: "oneValueC"}}}},
+				standardUserGPO("standard"),
+			},
+			wantAssets: true,
+		},
+		"Default GPO list of the object class is used without GPO list for the object": {
+			gpoLists:   map[string]string{"user": "standard-name\tPolicies/standard\n", "other@example.com": "one-value-name\tPolicies/one-value\n"},
+			want:       []policies.GPO{standardUserGPO("standard")},
+			wantAssets: true,
+		},
+		"WMI filters of the GPO list are evaluated": {
+			gpoLists:   map[string]string{userName: "WMI\tone-value-name\troot\\CIMv2\tSELECT * FROM Win32_Battery\none-value-name\tPolicies/one-value\nstandard-name\tPolicies/standard\n"},
+			want:       []policies.GPO{standardUserGPO("standard")},
+			wantAssets: true,
+		},
+		"Local source without assets": {
+			gpoLists: map[string]string{userName: "standard-name\tPolicies/standard\n"},
+			noAssets: true,
+			want:     []policies.GPO{standardUserGPO("standard")},
+		},
+		"Local source tar archive": {
+			gpoLists:   map[string]string{userName: "standard-name\tPolicies/standard\n"},
+			archive:    "tar",
+			want:       []policies.GPO{standardUserGPO("standard")},
+			wantAssets: true,
+		},
+		"Local source tar archive compressed with gzip": {
+			gpoLists:   map[string]string{userName: "standard-name\tPolicies/standard\n"},
+			archive:    "tar.gz",
+			want:       []policies.GPO{standardUserGPO("standard")},
+			wantAssets: true,
+		},
+
+		// Error cases
+		"Error on missing local source":          {noSource: true, wantErr: true},
+		"Error on missing GPO list":              {gpoLists: map[string]string{"other@example.com": "standard-name\tPolicies/standard\n"}, wantErr: true},
+		"Error on invalid GPO list":              {gpoLists: map[string]string{userName: "standard-name Policies/standard\n"}, wantErr: true},
+		"Error on GPO missing from local source": {gpoLists: map[string]string{userName: "missing-name\tPolicies/missing\n"}, wantErr: true},
+		"Error on GPO out of local source":       {gpoLists: map[string]string{userName: "standard-name\t../Policies/standard\n"}, wantErr: true},
+		"Error on archive entry out of the archive": {
+			gpoLists:          map[string]string{userName: "standard-name\tPolicies/standard\n"},
+			archive:           "tar",
+			extraArchiveEntry: "../outside",
+			wantErr:           true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.objectName == "" {
+				tc.objectName = userName
+			}
+			if tc.objectClass == "" {
+				tc.objectClass = ad.UserObject
+			}
+
+			source := t.TempDir()
+			testutils.Copy(t, filepath.Join(sysvol, "Policies"), filepath.Join(source, "Policies"))
+			if !tc.noAssets {
+				testutils.Copy(t, filepath.Join(sysvol, "Ubuntu"), filepath.Join(source, "Ubuntu"))
+			}
+			require.NoError(t, os.MkdirAll(filepath.Join(source, "gpolist"), 0700), "Setup: can't create GPO lists directory")
+			for n, content := range tc.gpoLists {
+				require.NoError(t, os.WriteFile(filepath.Join(source, "gpolist", n), []byte(content), 0600), "Setup: can't write GPO list")
+			}
+			if tc.archive != "" {
+				source = createTar(t, source, tc.archive == "tar.gz", tc.extraArchiveEntry)
+			}
+			if tc.noSource {
+				source = filepath.Join(t.TempDir(), "does-not-exist")
+			}
+
+			// The backend is not used with a local source.
+			backend := mock.Backend{Dom: "example.com", ErrIsOnline: true, ErrKrb5CCName: true}
+			adc, err := ad.New(context.Background(), backend, hostname,
+				ad.WithCacheDir(t.TempDir()), ad.WithRunDir(t.TempDir()),
+				ad.WithGPOListCmd([]string{"false"}),
+				ad.WithSystemRoot(filepath.Join("testdata", "wmi", "root")),
+				ad.WithLocalSource(source))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			pols, err := adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, "")
+			if tc.wantErr {
+				require.Error(t, err, "GetPolicies should have errored out")
+				return
+			}
+			require.NoError(t, err, "GetPolicies should return no error")
+			defer pols.Close()
+
+			require.Equal(t, tc.want, pols.GPOs, "GetPolicies returns expected GPO entries in correct order")
+			if tc.wantAttrs == nil {
+				tc.wantAttrs = map[string]string{}
+			}
+			require.Equal(t, tc.wantAttrs, pols.Attributes, "GetPolicies returns attributes of the object")
+
+			uncompressedAssets := filepath.Join(t.TempDir(), "assets")
+			err = pols.SaveAssetsTo(context.Background(), ".", uncompressedAssets, -1, -1)
+			if !tc.wantAssets {
+				require.Error(t, err, "policies should have no assets to uncompress")
+				return
+			}
+			require.NoError(t, err, "SaveAssetsTo should deserialize successfully")
+			testutils.CompareTreesWithFiltering(t, uncompressedAssets, filepath.Join(sysvol, "Ubuntu"), false)
+
+			// No temporary directory is left behind in the sysvol cache.
+			entries, err := os.ReadDir(adc.SysvolCacheDir())
+			require.NoError(t, err, "Teardown: can't read sysvol cache directory")
+			for _, e := range entries {
+				require.False(t, strings.HasPrefix(e.Name(), "local-source."), "Temporary local source directory should be removed")
+			}
+		})
+	}
+}
+
+func TestGetPoliciesFailover(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	domainDCs := []*net.SRV{
+		{Target: "dead2.gpoonly.com.", Priority: 0, Weight: 100},
+		{Target: "dc-light.gpoonly.com.", Priority: 10, Weight: 10},
+		{Target: "dc-heavy.gpoonly.com.", Priority: 10, Weight: 50},
+	}
+
+	tests := map[string]struct {
+		serverFQDN    string
+		errServerFQDN error
+		site          string
+		srvRecords    map[string][]*net.SRV
+		gpoListArgs   []string
+		retries       int
+
+		wantServer      string
+		wantErrContains string
+	}{
+		"Static server serves the policies": {
+			serverFQDN: "myserver.gpoonly.com",
+			srvRecords: map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs},
+			wantServer: "myserver.gpoonly.com",
+		},
+		"Fail over to domain controllers in priority and weight order": {
+			srvRecords: map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs},
+			wantServer: "dc-heavy.gpoonly.com",
+		},
+		"Domain controllers of the site are tried first": {
+			site: "paris",
+			srvRecords: map[string][]*net.SRV{
+				"_ldap._tcp.paris._sites.dc._msdcs.gpoonly.com": {{Target: "dc-paris.gpoonly.com."}},
+				"_ldap._tcp.dc._msdcs.gpoonly.com":              domainDCs,
+			},
+			wantServer: "dc-paris.gpoonly.com",
+		},
+		"Domain controllers of the domain are tried without any in the site": {
+			site:       "lyon",
+			srvRecords: map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs},
+			wantServer: "dc-heavy.gpoonly.com",
+		},
+		"Domain controllers are discovered without active server": {
+			errServerFQDN: backends.ErrNoActiveServer,
+			srvRecords:    map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs},
+			wantServer:    "dc-heavy.gpoonly.com",
+		},
+		"Downloads fail over to another domain controller": {
+			serverFQDN: "no-smb.gpoonly.com",
+			srvRecords: map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": {{Target: fmt.Sprintf("localhost:%d.", ad.SmbPort)}}},
+			wantServer: fmt.Sprintf("localhost:%d", ad.SmbPort),
+		},
+		"Retry all domain controllers once unreachable": {
+			srvRecords:      map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs[:1]},
+			retries:         1,
+			wantErrContains: "no domain controller could be reached after 2 attempts",
+		},
+
+		// Error cases
+		"Error on no domain controller found": {
+			errServerFQDN:   backends.ErrNoActiveServer,
+			wantErrContains: "no domain controller found",
+		},
+		"Error on other failures without failing over": {
+			serverFQDN:      "myserver.gpoonly.com",
+			srvRecords:      map[string][]*net.SRV{"_ldap._tcp.dc._msdcs.gpoonly.com": domainDCs},
+			gpoListArgs:     []string{"-Exit1-"},
+			wantErrContains: "was not found in Active Directory",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.serverFQDN == "" {
+				tc.serverFQDN = "dead.gpoonly.com"
+			}
+			if tc.gpoListArgs == nil {
+				tc.gpoListArgs = []string{"gpoonly.com", "bob:standard"}
+			}
+			backend := mock.Backend{
+				Dom:                "gpoonly.com",
+				ServURL:            tc.serverFQDN,
+				ErrServerFQDN:      tc.errServerFQDN,
+				Online:             true,
+				HostKrb5CCNamePath: filepath.Join(t.TempDir(), "host_ccache"),
+			}
+			testutils.CreatePath(t, backend.HostKrb5CCNamePath)
+			krb5CCName := setKrb5CC(t, "kbr5cc_adsys_tests_bob")
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), backend, hostname,
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)),
+				ad.WithResolver(stubResolver(tc.srvRecords)),
+				ad.WithSite(tc.site),
+				ad.WithDCRetries(tc.retries, time.Millisecond))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			pols, err := adc.GetPolicies(context.Background(), "bob@GPOONLY.COM", ad.UserObject, krb5CCName)
+			if tc.wantErrContains != "" {
+				require.ErrorContains(t, err, tc.wantErrContains, "GetPolicies returned an unexpected error")
+				return
+			}
+			require.NoError(t, err, "GetPolicies should return no error")
+
+			require.Equal(t, []policies.GPO{standardUserGPO("standard")}, pols.GPOs, "GetPolicies returns expected GPO entries")
+			require.Equal(t, tc.wantServer, pols.Server, "GetPolicies records the domain controller which served the policies")
+		})
+	}
+}
+
 func TestGetPoliciesWorkflows(t *testing.T) {
 	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
 
@@ -1244,6 +1848,98 @@ func TestListUsers(t *testing.T) {
 	}
 }
 
+func TestGarbageCollect(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	tests := map[string]struct {
+		cachedGPOs    map[string][]string
+		gracePeriod   time.Duration
+		corruptCache  bool
+		noPoliciesDir bool
+
+		wantRemoved []string
+		wantErr     bool
+	}{
+		"Remove unreferenced GPOs unused for longer than grace period": {
+			cachedGPOs:  map[string][]string{"bob@example.com": {"referenced-old"}},
+			gracePeriod: time.Hour,
+			wantRemoved: []string{"unreferenced-old"},
+		},
+		"Keep GPOs referenced by any object": {
+			cachedGPOs: map[string][]string{
+				"bob@example.com": {"referenced-old"},
+				hostname:          {"unreferenced-old", "unreferenced-recent"},
+			},
+			gracePeriod: time.Hour,
+		},
+		"Remove all unreferenced GPOs without grace period": {
+			cachedGPOs:  map[string][]string{"bob@example.com": {"referenced-old"}},
+			wantRemoved: []string{"unreferenced-old", "unreferenced-recent"},
+		},
+		"Remove all GPOs without cached policies": {
+			gracePeriod: time.Hour,
+			wantRemoved: []string{"referenced-old", "unreferenced-old"},
+		},
+
+		// Error cases
+		"Error on unreadable cached policies": {corruptCache: true, wantErr: true},
+		"Error on missing GPOs cache":         {noPoliciesDir: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), mock.Backend{Dom: "example.com"}, hostname,
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			gposDir := filepath.Join(adc.SysvolCacheDir(), "Policies")
+			old := time.Now().Add(-2 * time.Hour)
+			for _, id := range []string{"referenced-old", "unreferenced-old", "unreferenced-recent"} {
+				require.NoError(t, os.MkdirAll(filepath.Join(gposDir, id), 0700), "Setup: can't create cached GPO")
+				if strings.HasSuffix(id, "-old") {
+					require.NoError(t, os.Chtimes(filepath.Join(gposDir, id), old, old), "Setup: can't age cached GPO")
+				}
+			}
+			if tc.noPoliciesDir {
+				require.NoError(t, os.RemoveAll(gposDir), "Setup: can't remove GPOs cache")
+			}
+
+			for objectName, ids := range tc.cachedGPOs {
+				var pols policies.Policies
+				for _, id := range ids {
+					pols.GPOs = append(pols.GPOs, policies.GPO{ID: id, Name: id + "-name"})
+				}
+				require.NoError(t, pols.Save(filepath.Join(adc.PoliciesCacheDir(), objectName)), "Setup: can't save cached policies")
+			}
+			if tc.corruptCache {
+				require.NoError(t, os.MkdirAll(filepath.Join(adc.PoliciesCacheDir(), "bob@example.com"), 0700), "Setup: can't create cached policies directory")
+				require.NoError(t, os.WriteFile(filepath.Join(adc.PoliciesCacheDir(), "bob@example.com", "policies"), []byte("not: [yaml"), 0600), "Setup: can't write corrupted cached policies")
+			}
+
+			removed, err := adc.GarbageCollect(context.Background(), tc.gracePeriod)
+			if tc.wantErr {
+				require.Error(t, err, "GarbageCollect should have errored out")
+				return
+			}
+			require.NoError(t, err, "GarbageCollect should return no error")
+
+			require.ElementsMatch(t, tc.wantRemoved, removed, "GarbageCollect should return the removed GPOs")
+			for _, id := range []string{"referenced-old", "unreferenced-old", "unreferenced-recent"} {
+				if slices.Contains(tc.wantRemoved, id) {
+					require.NoDirExists(t, filepath.Join(gposDir, id), "Removed GPO should not be in cache anymore")
+					continue
+				}
+				require.DirExists(t, filepath.Join(gposDir, id), "Kept GPO should still be in cache")
+			}
+		})
+	}
+}
+
 func TestGetInfo(t *testing.T) {
 	t.Parallel()
 
@@ -1380,6 +2076,17 @@ func TestMockGPOList(_ *testing.T) {
 		os.Exit(code)
 	}
 
+	// simulating unreachable servers, and servers whose GPOs can't be downloaded from, by their name.
+	server := args[len(args)-2]
+	if strings.HasPrefix(server, "dead") {
+		fmt.Fprintf(os.Stderr, "Can't connect to %s", server)
+		os.Exit(2)
+	}
+	smbServer := fmt.Sprintf("localhost:%d", ad.SmbPort)
+	if strings.HasPrefix(server, "no-smb") {
+		smbServer = "127.0.0.1:1"
+	}
+
 	// Get Domain
 	domain := args[0]
 
@@ -1388,18 +2095,36 @@ func TestMockGPOList(_ *testing.T) {
 	objectName = strings.Split(objectName, "@")[0]
 
 	var gpos []string
+	wmiFilters := make(map[string][]string)
 
 	// Arg 0 is the list of GPOs to return, in the form: "user1:GPO1::user2:GPO2::user1:GPO3"
+	// A GPO can be followed by the queries of its WMI filter, in the form "GPO1|query1|query2".
+	// An empty query is a WMI filter which can't be read.
 	for _, gpoItem := range strings.Split(args[1], "::") {
 		e := strings.SplitN(gpoItem, ":", 2)
 		if e[0] != objectName {
 			continue
 		}
-		gpos = append(gpos, e[1])
+		gpo, queries, found := strings.Cut(e[1], "|")
+		gpos = append(gpos, gpo)
+		if found {
+			wmiFilters[gpo] = strings.Split(queries, "|")
+		}
 	}
 
+	fmt.Fprintf(os.Stdout, "ATTR\tdepartment\t%s department\n", objectName)
+	fmt.Fprintf(os.Stdout, "ATTR\tobjectSid\tS-1-5-21-%s\n", objectName)
 	for _, gpo := range gpos {
-		fmt.Fprintf(os.Stdout, "%s-name\tsmb://localhost:%d/SYSVOL/%s/Policies/%s\n", gpo, ad.SmbPort, domain, gpo)
+		for _, query := range wmiFilters[gpo] {
+			namespace := `root\CIMv2`
+			if query == "" {
+				namespace = ""
+			}
+			fmt.Fprintf(os.Stdout, "WMI\t%s-name\t%s\t%s\n", gpo, namespace, query)
+		}
+	}
+	for _, gpo := range gpos {
+		fmt.Fprintf(os.Stdout, "%s-name\tsmb://%s/SYSVOL/%s/Policies/%s\n", gpo, smbServer, domain, gpo)
 	}
 }
 
@@ -1411,8 +2136,78 @@ func mockGPOListCmd(t *testing.T, args ...string) []string {
 	return cmdArgs
 }
 
+// createTar returns the path to a tar archive of the content of dir, compressed with gzip if requested.
+// extraEntry is the name of an additional file entry, if not empty.
+func createTar(t *testing.T, dir string, compress bool, extraEntry string) string {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), "source.tar")
+	f, err := os.Create(p)
+	require.NoError(t, err, "Setup: can't create archive")
+	defer f.Close()
+
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if hdr.Name, err = filepath.Rel(dir, path); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		return err
+	})
+	require.NoError(t, err, "Setup: can't add content to archive")
+
+	if extraEntry != "" {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: extraEntry, Mode: 0600, Size: 4, Typeflag: tar.TypeReg}), "Setup: can't add extra entry to archive")
+		_, err := tw.Write([]byte("data"))
+		require.NoError(t, err, "Setup: can't add extra entry to archive")
+	}
+
+	return p
+}
+
 // setKrb5CC create a temporary file for a KRB5 ticket.
 // It will be automatically purged when the test ends.
+// stubResolver returns the SRV records by name, and an error for any unknown name.
+type stubResolver map[string][]*net.SRV
+
+// LookupSRV returns a copy of the SRV records of _service._proto.name.
+func (r stubResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	addrs, ok := r[fmt.Sprintf("_%s._%s.%s", service, proto, name)]
+	if !ok {
+		return "", nil, fmt.Errorf("no SRV record for _%s._%s.%s", service, proto, name)
+	}
+	return "", slices.Clone(addrs), nil
+}
+
 func setKrb5CC(t *testing.T, ccRootName string) string {
 	t.Helper()
 
@@ -44,6 +44,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"text/template"
@@ -102,6 +103,24 @@ var (
 	defaultAppendNote = gotext.Get(`
  * Enabled: The value(s) referenced in the entry are applied on the client machine.
  * Disabled: The value(s) are removed from the target machine.
+ * Not configured: Value(s) declared higher in the GPO hierarchy will be used if available.`)
+
+	// defaultPrependNote is the default note for prepend-type policies. It will be used unless a specific note is provided.
+	defaultPrependNote = gotext.Get(`
+ * Enabled: The value(s) referenced in the entry are applied on the client machine, before the ones declared higher in the GPO hierarchy.
+ * Disabled: The value(s) are removed from the target machine.
+ * Not configured: Value(s) declared higher in the GPO hierarchy will be used if available.`)
+
+	// defaultUnionNote is the default note for union-type policies. It will be used unless a specific note is provided.
+	defaultUnionNote = gotext.Get(`
+ * Enabled: The value(s) referenced in the entry are applied on the client machine, after the ones declared higher in the GPO hierarchy. Duplicated values are only applied once.
+ * Disabled: The value(s) are removed from the target machine.
+ * Not configured: Value(s) declared higher in the GPO hierarchy will be used if available.`)
+
+	// defaultRemoveNote is the default note for remove-type policies. It will be used unless a specific note is provided.
+	defaultRemoveNote = gotext.Get(`
+ * Enabled: The value(s) referenced in the entry are removed from the ones declared higher in the GPO hierarchy.
+ * Disabled: Value(s) declared higher in the GPO hierarchy will be used if available.
  * Not configured: Value(s) declared higher in the GPO hierarchy will be used if available.`)
 
 	// defaultOverrideNote is the default note for override-type policies. It will be used unless a specific note is provided.
@@ -258,13 +277,24 @@ func (g generator) generateExpandedCategories(categories []category, policies []
 		}
 
 		explainText = gotext.Get("%s\n\nNote:", explainText)
+		strategy := releasesElements["all"].Meta["strategy"]
+		if strategy != "" && !slices.Contains(entry.Strategies(), strategy) {
+			return nil, errors.New(gotext.Get("policy %s has an unsupported strategy %q, supported strategies are: %s",
+				releasesElements["all"].Key, strategy, strings.Join(entry.Strategies(), ", ")))
+		}
 		var note string
 		if releasesElements["all"].Note != "" {
 			note = releasesElements["all"].Note
 		} else {
-			switch releasesElements["all"].Meta["strategy"] {
+			switch strategy {
 			case entry.StrategyAppend:
 				note = defaultAppendNote
+			case entry.StrategyPrepend:
+				note = defaultPrependNote
+			case entry.StrategyUnion:
+				note = defaultUnionNote
+			case entry.StrategyRemove:
+				note = defaultRemoveNote
 			default:
 				note = defaultOverrideNote
 			}
@@ -43,11 +43,14 @@ func TestGenerateExpandedCategories(t *testing.T) {
 		"with prefix": {},
 
 		// Optional content
-		"no defaults":             {},
-		"no note":                 {},
-		"no note strategy append": {},
-		"range":                   {},
-		"choices":                 {},
+		"no defaults":              {},
+		"no note":                  {},
+		"no note strategy append":  {},
+		"no note strategy prepend": {},
+		"no note strategy union":   {},
+		"no note strategy remove":  {},
+		"range":                    {},
+		"choices":                  {},
 
 		"default policy class is capitalized": {},
 		"requires ubuntu pro":                 {},
@@ -79,6 +82,7 @@ func TestGenerateExpandedCategories(t *testing.T) {
 		"error on empty default policy class":                                        {wantErr: true},
 		"error on policy not attached to any releases":                               {wantErr: true},
 		"error on key independent of any release key but with one release specified": {wantErr: true},
+		"error on unknown strategy":                                                  {wantErr: true},
 
 		"policy directory doesn't exist":    {wantErrLoadDefinitions: true},
 		"category definition doesn't exist": {wantErrLoadDefinitions: true},
@@ -30,6 +30,8 @@ func TestAdsysGPOList(t *testing.T) {
 		accountName     string
 		objectClass     string
 		krb5ccNameState string
+		attributes      bool
+		wmiFilters      bool
 
 		wantErr        bool
 		wantReturnCode int
@@ -148,6 +150,26 @@ func TestAdsysGPOList(t *testing.T) {
 			accountName: "UserEveryoneDenied@GPOONLY.COM",
 		},
 
+		// Attributes cases
+		"Return object attributes before GPOs": {
+			accountName: "RnDUser@GPOONLY.COM",
+			attributes:  true,
+		},
+		"Return machine attributes before GPOs": {
+			accountName: "hostname1",
+			objectClass: "computer",
+			attributes:  true,
+		},
+
+		// WMI filters cases
+		"Return WMI filters before GPOs": {
+			accountName: "UserWMIFilter@GPOONLY.COM",
+			wmiFilters:  true,
+		},
+		"WMI filters are not returned by default": {
+			accountName: "UserWMIFilter@GPOONLY.COM",
+		},
+
 		"No gPOptions fallbacks to 0": {
 			accountName: "UserNogPOptions@GPOONLY.COM",
 		},
@@ -273,7 +295,14 @@ func TestAdsysGPOList(t *testing.T) {
 			}
 
 			// #nosec G204: we control the command line name and only change it for tests
-			cmd := exec.Command(adsysGPOListcmd, "--objectclass", tc.objectClass, tc.url, tc.accountName)
+			args := []string{"--objectclass", tc.objectClass, tc.url, tc.accountName}
+			if tc.attributes {
+				args = append(args, "--attributes")
+			}
+			if tc.wmiFilters {
+				args = append(args, "--wmi-filters")
+			}
+			cmd := exec.Command(adsysGPOListcmd, args...)
 			got, err := cmd.CombinedOutput()
 			if tc.wantErr {
 				require.Error(t, err, "adsys-gpostlist should have failed but didn’t")
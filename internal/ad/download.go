@@ -35,11 +35,17 @@ For each logged in user (sequentially):
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -55,8 +61,10 @@ import (
 /*
 fetch downloads a list of gpos from a url for a given kerberosTicket and stores the downloaded files in dest.
 In addition, assetsURL is always refreshed if not empty.
+Only the files which changed since the previous download, according to the downloadable manifest, are transferred.
 Each gpo entry must be a gpo, with a name, url of the form: smb://<server>/SYSVOL/<AD domain>/<GPO_ID> and mutex.
 If krb5Ticket is empty, no authentication is done on samba.
+Downloads stop between two files or directories once ctx is done.
 This should not be called concurrently.
 
 It returns if the assets were refreshed or not.
@@ -87,23 +95,15 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 	if !ad.withoutKerberos {
 		client.SetUseKerberos()
 	}
+	stater, err := newSMBStater(!ad.withoutKerberos)
+	if err != nil {
+		return false, err
+	}
+	defer stater.close()
 
 	var errg errgroup.Group
 	for name, url := range downloadables {
-		// Guard the shared downloadables map: parsing reads it concurrently
-		// without holding the AD lock.
-		ad.downloadablesMu.Lock()
-		g, ok := ad.downloadables[name]
-		if !ok {
-			g = &downloadable{
-				name:     name,
-				url:      url,
-				mu:       &sync.RWMutex{},
-				isAssets: name == "assets",
-			}
-			ad.downloadables[name] = g
-		}
-		ad.downloadablesMu.Unlock()
+		g := ad.downloadable(name, url)
 		errg.Go(func() (err error) {
 			defer decorate.OnError(&err, gotext.Get("can't download %q", g.name))
 
@@ -112,13 +112,19 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 
 			log.Debugf(ctx, "Analyzing %q", g.name)
 
-			dest := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(g.url))
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// The GPO may be downloaded from another server than the one it was first registered with.
+			dest := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(url))
 			if g.isAssets {
 				dest = filepath.Join(ad.sysvolCacheDir, "assets")
 			}
+			manifestPath := ad.manifestPath(filepath.Base(dest))
 
 			// Look at GPO version and compare with the one on AD to decide if we redownload or not
-			shouldDownload, err := needsDownload(ctx, client, g, dest)
+			shouldDownload, err := needsDownload(ctx, client, g, url, dest)
 			if err != nil {
 				if g.isAssets && errors.Is(err, errNoGPTINI) {
 					log.Info(ctx, "No assets directory with GPT.INI file found on AD, skipping assets download")
@@ -129,6 +135,9 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 							return err
 						}
 					}
+					if err := os.Remove(manifestPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+						return err
+					}
 					return nil
 				}
 				return err
@@ -152,7 +161,7 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 				assetsWereRefreshed = true
 			}
 
-			return downloadDir(ctx, client, g.url, dest)
+			return syncDir(ctx, client, stater, url, dest, manifestPath)
 		})
 	}
 
@@ -163,11 +172,36 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 	return assetsWereRefreshed, nil
 }
 
+// manifestPath returns the path of the manifest of the downloadable stored in the sysvol cache under base.
+func (ad *AD) manifestPath(base string) string {
+	return filepath.Join(ad.manifestsDir, base+".yaml")
+}
+
+// downloadable returns the downloadable tracking name, which is created with url on first call.
+func (ad *AD) downloadable(name, url string) *downloadable {
+	// Guard the shared downloadables map: parsing reads it concurrently
+	// without holding the AD lock.
+	ad.downloadablesMu.Lock()
+	defer ad.downloadablesMu.Unlock()
+
+	g, ok := ad.downloadables[name]
+	if !ok {
+		g = &downloadable{
+			name:     name,
+			url:      url,
+			mu:       &sync.RWMutex{},
+			isAssets: name == "assets",
+		}
+		ad.downloadables[name] = g
+	}
+	return g
+}
+
 var errNoGPTINI = errors.New("no GPT.INI file")
 
-// needsDownload returns if the downloadable should be refreshed.
+// needsDownload returns if the downloadable should be refreshed from url.
 // This is done by comparing GPT.INI Version= content.
-func needsDownload(ctx context.Context, client *libsmbclient.Client, g *downloadable, localPath string) (updateNeeded bool, err error) {
+func needsDownload(ctx context.Context, client *libsmbclient.Client, g *downloadable, url, localPath string) (updateNeeded bool, err error) {
 	defer decorate.OnError(&err, gotext.Get("can't check if %s needs refreshing", g.name))
 
 	g.mu.RLock()
@@ -184,7 +218,7 @@ func needsDownload(ctx context.Context, client *libsmbclient.Client, g *download
 		}
 	}
 
-	f, err := client.Open(fmt.Sprintf("%s/GPT.INI", g.url), 0, 0)
+	f, err := client.Open(fmt.Sprintf("%s/GPT.INI", url), 0, 0)
 	if err != nil {
 		// nolint:errorlint // We cannot have multiple error wrapping directives in a single call
 		return false, fmt.Errorf("%w: %v", errNoGPTINI, err)
@@ -231,22 +265,26 @@ func getGPOVersion(ctx context.Context, r io.Reader, downloadableName string) (v
 	return version, nil
 }
 
-// downloadDir will dl in a temporary directory and only commit it if fully downloaded without any errors.
-func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest string) (err error) {
+// syncDir synchronises dest with the directory at url in a temporary directory and only commits it if fully
+// synchronised without any errors.
+// Files whose size and modification time did not change since they were recorded in the manifest at manifestPath,
+// and whose local content is intact, are kept instead of being downloaded again. Files removed remotely are removed.
+func syncDir(ctx context.Context, client *libsmbclient.Client, stater *smbStater, url, dest, manifestPath string) (err error) {
 	defer decorate.OnError(&err, gotext.Get("download %q failed", url))
 
 	smbsafe.WaitSmb()
 	defer smbsafe.DoneSmb()
 
-	// Check if we have a file or a directory
-	d, err := client.Opendir(url)
+	previous, err := loadManifest(manifestPath)
 	if err != nil {
-		return err
+		log.Warningf(ctx, "Downloading %s again entirely: %v", url, err)
+		previous = manifest{}
 	}
 
-	// It is a directory: recursive download
-	if err := d.Closedir(); err != nil {
-		return errors.New(gotext.Get("could not close directory: %v", err))
+	remote := make(manifest)
+	var dirs []string
+	if err := listRemote(ctx, client, stater, url, "", remote, &dirs); err != nil {
+		return err
 	}
 
 	tmpdest, err := os.MkdirTemp(filepath.Dir(dest), fmt.Sprintf("%s.*", filepath.Base(dest)))
@@ -259,9 +297,39 @@ func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest str
 			log.Info(ctx, gotext.Get("Could not clean up temporary directory:"), err)
 		}
 	}()
-	if err := downloadRecursive(ctx, client, url, tmpdest); err != nil {
-		return err
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(tmpdest, filepath.FromSlash(d)), 0700); err != nil {
+			return fmt.Errorf("can't create %q", d)
+		}
 	}
+
+	var downloaded, kept int
+	for _, rel := range slices.Sorted(maps.Keys(remote)) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := remote[rel]
+		entityDest := filepath.Join(tmpdest, filepath.FromSlash(rel))
+		if old, ok := previous[rel]; ok && old.Size == entry.Size && old.Mtime == entry.Mtime {
+			if reuseLocal(filepath.Join(dest, filepath.FromSlash(rel)), entityDest, old.Hash) {
+				entry.Hash = old.Hash
+				remote[rel] = entry
+				kept++
+				continue
+			}
+			log.Debugf(ctx, "Local copy of %s/%s does not match its manifest", url, rel)
+		}
+
+		entityURL := url + "/" + rel
+		log.Debug(ctx, gotext.Get("Downloading %s", entityURL))
+		if entry.Hash, err = downloadFile(client, entityURL, entityDest); err != nil {
+			return err
+		}
+		remote[rel] = entry
+		downloaded++
+	}
+
 	// Remove previous download content
 	if err := os.RemoveAll(dest); err != nil {
 		return err
@@ -270,11 +338,32 @@ func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest str
 	if err := os.Rename(tmpdest, dest); err != nil {
 		return err
 	}
+
+	// A missing or outdated manifest only leads to more files being downloaded on next synchronisation.
+	if err := remote.save(manifestPath); err != nil {
+		log.Warning(ctx, err)
+	}
+
+	var removed int
+	for rel := range previous {
+		if _, ok := remote[rel]; !ok {
+			removed++
+		}
+	}
+	log.Infof(ctx, "Synchronised %s: %d files downloaded, %d unchanged, %d removed", url, downloaded, kept, removed)
+
 	return nil
 }
 
-func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, dest string) error {
-	d, err := client.Opendir(url)
+// listRemote records in files the size and modification time of the files under the directory rel of url, and
+// appends to dirs its subdirectories, with slash-separated paths relative to url.
+func listRemote(ctx context.Context, client *libsmbclient.Client, stater *smbStater, url, rel string, files manifest, dirs *[]string) error {
+	dirURL := url
+	if rel != "" {
+		dirURL = url + "/" + rel
+	}
+
+	d, err := client.Opendir(dirURL)
 	if err != nil {
 		return err
 	}
@@ -284,10 +373,6 @@ func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, de
 		}
 	}()
 
-	if err := os.MkdirAll(dest, 0700); err != nil {
-		return fmt.Errorf("can't create %q", dest)
-	}
-
 	for {
 		dirent, err := d.Readdir()
 		if errors.Is(err, io.EOF) {
@@ -300,19 +385,22 @@ func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, de
 		if dirent.Name == "." || dirent.Name == ".." {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		entityURL := url + "/" + dirent.Name
-		entityDest := filepath.Join(dest, dirent.Name)
+		entityRel := path.Join(rel, dirent.Name)
 
 		switch dirent.Type {
 		case libsmbclient.SmbcFile:
-			log.Debug(ctx, gotext.Get("Downloading %s", entityURL))
-			if err := downloadFile(client, entityURL, entityDest); err != nil {
+			size, mtime, err := stater.stat(url + "/" + entityRel)
+			if err != nil {
 				return err
 			}
+			files[entityRel] = manifestEntry{Size: size, Mtime: mtime}
 		case libsmbclient.SmbcDir:
-			err := downloadRecursive(ctx, client, entityURL, entityDest)
-			if err != nil {
+			*dirs = append(*dirs, entityRel)
+			if err := listRemote(ctx, client, stater, url, entityRel, files, dirs); err != nil {
 				return err
 			}
 		default:
@@ -332,13 +420,13 @@ const smbReadBufferSize = 1024 * 1024
 
 // downloadFile streams a single SMB file to dest, using a large fixed buffer to
 // minimize the number of SMB read round-trips and to avoid holding the whole
-// file in memory.
-func downloadFile(client *libsmbclient.Client, url, dest string) (err error) {
+// file in memory. It returns the hex-encoded SHA-256 hash of the content.
+func downloadFile(client *libsmbclient.Client, url, dest string) (hash string, err error) {
 	defer decorate.OnError(&err, gotext.Get("download %q failed", url))
 
 	f, err := client.Open(url, 0, 0)
 	if err != nil {
-		return err
+		return "", err
 	}
 	// Read() is on *libsmbclient.File, not libsmbclient.File
 	pf := &f
@@ -346,7 +434,7 @@ func downloadFile(client *libsmbclient.Client, url, dest string) (err error) {
 
 	dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer func() {
 		if cerr := dst.Close(); cerr != nil && err == nil {
@@ -357,22 +445,24 @@ func downloadFile(client *libsmbclient.Client, url, dest string) (err error) {
 	// Copy manually with our own buffer rather than io.CopyBuffer: *os.File
 	// implements io.ReaderFrom, which would make io.CopyBuffer ignore our buffer
 	// and fall back to a small (32KiB) internal one.
+	h := sha256.New()
 	buf := make([]byte, smbReadBufferSize)
 	for {
 		n, rerr := pf.Read(buf)
 		if n > 0 {
 			if _, werr := dst.Write(buf[:n]); werr != nil {
-				return werr
+				return "", werr
 			}
+			h.Write(buf[:n])
 		}
 		if errors.Is(rerr, io.EOF) {
 			break
 		}
 		if rerr != nil {
-			return rerr
+			return "", rerr
 		}
 	}
-	return nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // findLocalGPTIni will look for a GPT.INI file in the given path (non-recursive).
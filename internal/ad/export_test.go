@@ -3,6 +3,7 @@ package ad
 var (
 	WithoutKerberos = withoutKerberos
 	WithGPOListCmd  = withGPOListCmd
+	WithResolver    = withResolver
 )
 
 func (ad *AD) SysvolCacheDir() string {
@@ -0,0 +1,176 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+)
+
+// maxDCRetryBackoff caps the exponential backoff between two rounds of attempts on all domain controllers.
+const maxDCRetryBackoff = 5 * time.Minute
+
+// errDCUnreachable is wrapped by the errors of attempts which failed because the domain controller could not be
+// reached or did not answer in time, so that the next one is tried.
+var errDCUnreachable = errors.New(gotext.Get("domain controller unreachable"))
+
+// Resolver looks up the SRV records of the domain controllers. It is implemented by *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// withFailover calls attempt with each domain controller in turn until one serves the request, and returns it.
+// The servers in first are tried before the ones discovered from the SRV records, which are only looked up if
+// needed. Each attempt is limited to timeout, if not 0. An attempt failing for another reason than its domain
+// controller being unreachable ends the failover.
+// Once no domain controller can be reached, they are all tried again after an exponential backoff, up to the
+// configured number of retries.
+func (ad *AD) withFailover(ctx context.Context, first []string, timeout time.Duration, attempt func(ctx context.Context, dc string) error) (server string, err error) {
+	var candidates []string
+	for _, dc := range first {
+		if dc != "" && !slices.Contains(candidates, dc) {
+			candidates = append(candidates, dc)
+		}
+	}
+
+	discovered := false
+	backoff := ad.dcRetryBackoff
+	for retry := 0; ; retry++ {
+		for i := 0; ; i++ {
+			if i == len(candidates) && !discovered {
+				discovered = true
+				for _, dc := range ad.lookupDCs(ctx) {
+					if !slices.Contains(candidates, dc) {
+						candidates = append(candidates, dc)
+					}
+				}
+			}
+			if i >= len(candidates) {
+				break
+			}
+
+			dc := candidates[i]
+			attemptCtx, cancel := ctx, func() {}
+			if timeout > 0 {
+				attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			err = attempt(attemptCtx, dc)
+			cancel()
+			if err == nil {
+				if dc != candidates[0] || retry > 0 {
+					log.Infof(ctx, "Request served by domain controller %q", dc)
+				}
+				return dc, nil
+			}
+			if !errors.Is(err, errDCUnreachable) || ctx.Err() != nil {
+				return "", err
+			}
+			log.Warning(ctx, gotext.Get("Domain controller %q can't be reached: %v", dc, err))
+		}
+
+		if len(candidates) == 0 {
+			return "", errors.New(gotext.Get("no domain controller found for %q", ad.configBackend.Domain()))
+		}
+		if retry >= ad.dcRetries {
+			return "", errors.New(gotext.Get("no domain controller could be reached after %d attempts: %v", retry+1, err))
+		}
+
+		log.Infof(ctx, "No domain controller could be reached, trying again in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxDCRetryBackoff)
+	}
+}
+
+// lookupDCs returns the domain controllers of the site of the machine, or of the whole domain if there are none,
+// from their LDAP SRV records in priority and weight order.
+func (ad *AD) lookupDCs(ctx context.Context) (dcs []string) {
+	domain := ad.configBackend.Domain()
+	if domain == "" {
+		return nil
+	}
+
+	var names []string
+	if ad.site != "" {
+		names = append(names, fmt.Sprintf("%s._sites.dc._msdcs.%s", ad.site, domain))
+	}
+	names = append(names, fmt.Sprintf("dc._msdcs.%s", domain))
+
+	for _, name := range names {
+		_, addrs, err := ad.resolver.LookupSRV(ctx, "ldap", "tcp", name)
+		if err != nil {
+			log.Debugf(ctx, "Can't look up domain controllers of _ldap._tcp.%s: %v", name, err)
+			continue
+		}
+		// Lower priorities first, then higher weights.
+		slices.SortStableFunc(addrs, func(a, b *net.SRV) int {
+			if a.Priority != b.Priority {
+				return int(a.Priority) - int(b.Priority)
+			}
+			return int(b.Weight) - int(a.Weight)
+		})
+		for _, a := range addrs {
+			dc := strings.TrimSuffix(a.Target, ".")
+			if dc != "" && !slices.Contains(dcs, dc) {
+				dcs = append(dcs, dc)
+			}
+		}
+		if len(dcs) > 0 {
+			log.Debugf(ctx, "Domain controllers from _ldap._tcp.%s: %s", name, strings.Join(dcs, ", "))
+			return dcs
+		}
+	}
+	return nil
+}
+
+// probeSMB returns an error wrapping errDCUnreachable if no connection can be opened to the SMB service of dc, which
+// is a host with an optional port.
+func probeSMB(ctx context.Context, dc string) error {
+	addr := dc
+	if _, _, err := net.SplitHostPort(dc); err != nil {
+		addr = net.JoinHostPort(dc, "445")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		// nolint:errorlint // We cannot have multiple error wrapping directives in a single call
+		return fmt.Errorf("%w: %v", errDCUnreachable, err)
+	}
+	return conn.Close()
+}
+
+// downloadablesServer returns the server, with its optional port, of the downloadables URLs.
+func downloadablesServer(downloadables map[string]string) string {
+	for _, u := range downloadables {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			return parsed.Host
+		}
+	}
+	return ""
+}
+
+// withServer returns a copy of the downloadables whose URLs point to dc instead of their server.
+func withServer(downloadables map[string]string, dc string) map[string]string {
+	r := make(map[string]string, len(downloadables))
+	for name, u := range downloadables {
+		r[name] = u
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Host == dc {
+			continue
+		}
+		parsed.Host = dc
+		r[name] = parsed.String()
+	}
+	return r
+}
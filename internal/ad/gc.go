@@ -0,0 +1,157 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+)
+
+// GarbageCollect removes from the sysvol cache the GPOs which are not referenced by the cached policies of any
+// object and were last used more than gracePeriod ago, with their download tracking.
+// It returns the IDs of the removed GPOs.
+func (ad *AD) GarbageCollect(ctx context.Context, gracePeriod time.Duration) (removed []string, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't garbage collect the GPOs cache"))
+
+	log.Debugf(ctx, "Garbage collecting GPOs unused for %s", gracePeriod)
+
+	// Exclude concurrent fetches, which write to the sysvol cache.
+	ad.Lock()
+	defer ad.Unlock()
+
+	referenced, err := ad.referencedGPOs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gposDir := filepath.Join(ad.sysvolCacheDir, "Policies")
+	entries, err := os.ReadDir(gposDir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() || slices.Contains(referenced, id) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return removed, err
+		}
+		if lastUsed := info.ModTime(); now.Sub(lastUsed) < gracePeriod {
+			log.Debugf(ctx, "Keeping unreferenced GPO %q, last used on %s", id, lastUsed)
+			continue
+		}
+
+		ok, err := ad.removeGPO(filepath.Join(gposDir, id), id)
+		if err != nil {
+			return removed, err
+		}
+		if !ok {
+			log.Debugf(ctx, "Keeping unreferenced GPO %q, which is being refreshed", id)
+			continue
+		}
+		log.Infof(ctx, "Removed unreferenced GPO %q from cache", id)
+		removed = append(removed, id)
+	}
+
+	return removed, nil
+}
+
+// referencedGPOs returns the IDs of the GPOs of the cached policies of all objects.
+func (ad *AD) referencedGPOs(ctx context.Context) (ids []string, err error) {
+	entries, err := os.ReadDir(ad.policiesCacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		pols, err := policies.NewFromCache(ctx, filepath.Join(ad.policiesCacheDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range pols.GPOs {
+			if !slices.Contains(ids, g.ID) {
+				ids = append(ids, g.ID)
+			}
+		}
+		if err := pols.Close(); err != nil {
+			log.Warning(ctx, err)
+		}
+	}
+
+	return ids, nil
+}
+
+// removeGPO removes the GPO id cached at p, its manifest and its download tracking, unless it is in use.
+// It returns if the GPO was removed.
+func (ad *AD) removeGPO(p, id string) (ok bool, err error) {
+	ad.downloadablesMu.Lock()
+	defer ad.downloadablesMu.Unlock()
+
+	if ad.gposInUse[id] > 0 {
+		return false, nil
+	}
+
+	for name, g := range ad.downloadables {
+		if g.isAssets || filepath.Base(g.url) != id {
+			continue
+		}
+		// Wait for any reader, as for a refresh.
+		g.mu.Lock()
+		delete(ad.downloadables, name)
+		g.mu.Unlock()
+	}
+
+	if err := os.Remove(ad.manifestPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, os.RemoveAll(p)
+}
+
+// useGPOs prevents gpos from being garbage collected until the returned function is called.
+func (ad *AD) useGPOs(gpos []gpo) (release func()) {
+	ad.downloadablesMu.Lock()
+	defer ad.downloadablesMu.Unlock()
+
+	for _, g := range gpos {
+		ad.gposInUse[filepath.Base(g.url)]++
+	}
+
+	return func() {
+		ad.downloadablesMu.Lock()
+		defer ad.downloadablesMu.Unlock()
+
+		for _, g := range gpos {
+			id := filepath.Base(g.url)
+			ad.gposInUse[id]--
+			if ad.gposInUse[id] <= 0 {
+				delete(ad.gposInUse, id)
+			}
+		}
+	}
+}
+
+// markGPOsUsed records that gpos were just used, which starts again their garbage collection grace period.
+func (ad *AD) markGPOsUsed(ctx context.Context, gpos []gpo) {
+	now := time.Now()
+	for _, g := range gpos {
+		p := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(g.url))
+		if err := os.Chtimes(p, now, now); err != nil {
+			log.Debugf(ctx, "Can't record last use of GPO %q: %v", g.name, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package gpp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// krb5Tag requires the mount manager to authenticate with Kerberos.
+const krb5Tag = "[krb5]"
+
+// drives is the content of Drives.xml.
+type drives struct {
+	Drives []drive `xml:"Drive"`
+}
+
+type drive struct {
+	item
+	Properties struct {
+		Action   string `xml:"action,attr"`
+		Path     string `xml:"path,attr"`
+		UserName string `xml:"userName,attr"`
+	} `xml:"Properties"`
+}
+
+// Drives returns the mount entries of the drive maps of the Drives.xml file read from r, in processing order.
+// Drives are mapped with the credentials of the user on Windows, so they are mounted with Kerberos authentication.
+// Deleted drives remove their location from the mounts set by the previous items and GPOs.
+// isComputer selects the system mounts instead of the user mounts.
+func Drives(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse drive maps"))
+
+	var d drives
+	if err := decode(r, &d); err != nil {
+		return nil, err
+	}
+
+	key := "user-mounts"
+	if isComputer {
+		key = "system-mounts"
+	}
+
+	for _, drv := range d.Drives {
+		if drv.isDisabled() {
+			log.Debugf(ctx, "Drive map %q is disabled", drv.Name)
+			continue
+		}
+		target, err := target(drv.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping drive map %q: %v", drv.Name, err))
+			continue
+		}
+		location, err := smbURL(drv.Properties.Path, isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping drive map %q: %v", drv.Name, err))
+			continue
+		}
+		if drv.Properties.UserName != "" {
+			log.Warning(ctx, gotext.Get("Drive map %q is mounted with Kerberos authentication instead of the credentials of %s", drv.Name, drv.Properties.UserName))
+		}
+
+		e := entry.Entry{
+			Key:      key,
+			Value:    krb5Tag + location,
+			Strategy: entry.StrategyAppend,
+			Target:   target,
+		}
+		if action(drv.Properties.Action) == ActionDelete {
+			// The location may have been set with or without authentication.
+			e.Value = krb5Tag + location + "\n" + location
+			e.Strategy = entry.StrategyRemove
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// smbURL returns the smb:// location of the UNC path unc.
+// isComputer rejects the paths referencing variables of the user.
+func smbURL(unc string, isComputer bool) (string, error) {
+	p, ok := strings.CutPrefix(unc, `\\`)
+	if !ok {
+		return "", errors.New(gotext.Get("%q is not a UNC path", unc))
+	}
+	p = strings.Trim(strings.ReplaceAll(p, `\`, "/"), "/")
+	if server, share, _ := strings.Cut(p, "/"); server == "" || share == "" {
+		return "", errors.New(gotext.Get("%q is not a UNC path", unc))
+	}
+	p, err := expandVariables(p, isComputer)
+	if err != nil {
+		return "", err
+	}
+	return "smb://" + p, nil
+}
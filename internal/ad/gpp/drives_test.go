@@ -0,0 +1,25 @@
+package gpp_test
+
+import (
+	"testing"
+
+	"github.com/ubuntu/adsys/internal/ad/gpp"
+)
+
+func TestDrives(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "drives", gpp.Drives, map[string]preferenceTest{
+		"Drives are converted to user mounts":                  {file: "actions.xml"},
+		"Drives are converted to system mounts for computers":  {file: "actions.xml", isComputer: true},
+		"Filters are converted to targeting expressions":       {file: "filters.xml"},
+		"Variables are converted to dynamic values":            {file: "variables.xml"},
+		"User variables are skipped for computers":             {file: "variables.xml", isComputer: true},
+		"Drives with credentials use Kerberos":                 {file: "credentials.xml"},
+		"Disabled, invalid and unsupported drives are skipped": {file: "skipped.xml"},
+		"File starting with a byte order mark":                 {file: "bom.xml"},
+		"File without drives":                                  {file: "empty.xml", wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", wantErr: true},
+	})
+}
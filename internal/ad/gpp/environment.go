@@ -0,0 +1,110 @@
+package gpp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// environmentVariables is the content of EnvironmentVariables.xml.
+type environmentVariables struct {
+	Variables []environmentVariable `xml:"EnvironmentVariable"`
+}
+
+type environmentVariable struct {
+	item
+	Properties struct {
+		Action  string `xml:"action,attr"`
+		Name    string `xml:"name,attr"`
+		Value   string `xml:"value,attr"`
+		User    string `xml:"user,attr"`
+		Partial string `xml:"partial,attr"`
+	} `xml:"Properties"`
+}
+
+// variableName matches the names of the variables which can be set in a session environment.
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvironmentVariables returns the environment entries of the variables of the EnvironmentVariables.xml file read
+// from r, in processing order.
+// Each value is a NAME=value line, or only the name of the variable to unset it when it is deleted.
+// Windows variables of the values are converted to dynamic values, and variables referencing other Windows
+// variables are skipped.
+// isComputer selects the system environment instead of the user one.
+func EnvironmentVariables(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse environment variables"))
+
+	var vars environmentVariables
+	if err := decode(r, &vars); err != nil {
+		return nil, err
+	}
+
+	key := "user-environment"
+	if isComputer {
+		key = "system-environment"
+	}
+
+	for _, v := range vars.Variables {
+		if v.isDisabled() {
+			log.Debugf(ctx, "Environment variable %q is disabled", v.Name)
+			continue
+		}
+		target, err := target(v.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping environment variable %q: %v", v.Name, err))
+			continue
+		}
+		line, err := environmentLine(v, isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping environment variable %q: %v", v.Name, err))
+			continue
+		}
+
+		entries = append(entries, entry.Entry{
+			Key:      key,
+			Value:    line,
+			Strategy: entry.StrategyAppend,
+			Target:   target,
+		})
+	}
+
+	return entries, nil
+}
+
+// environmentLine returns the line of the environment entries setting or unsetting v.
+func environmentLine(v environmentVariable, isComputer bool) (string, error) {
+	name := v.Properties.Name
+	if name == "" {
+		name = v.Name
+	}
+	if !variableName.MatchString(name) {
+		return "", errors.New(gotext.Get("invalid variable name"))
+	}
+	// The editor only offers system variables in the computer configuration, but offers both kinds in the user one.
+	if !isComputer && v.Properties.User == "0" {
+		return "", errors.New(gotext.Get("system variables are only supported in the computer configuration"))
+	}
+	if v.Properties.Partial == "1" {
+		return "", errors.New(gotext.Get("partial values are not supported"))
+	}
+
+	if action(v.Properties.Action) == ActionDelete {
+		return name, nil
+	}
+
+	if strings.ContainsAny(v.Properties.Value, "\r\n") {
+		return "", errors.New(gotext.Get("values can't span multiple lines"))
+	}
+	value, err := expandAllVariables(v.Properties.Value, isComputer)
+	if err != nil {
+		return "", err
+	}
+	return name + "=" + value, nil
+}
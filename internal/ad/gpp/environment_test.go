@@ -0,0 +1,23 @@
+package gpp_test
+
+import (
+	"testing"
+
+	"github.com/ubuntu/adsys/internal/ad/gpp"
+)
+
+func TestEnvironmentVariables(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "environment", gpp.EnvironmentVariables, map[string]preferenceTest{
+		"Variables are set and unset for users":               {file: "actions.xml"},
+		"Variables are set and unset for computers":           {file: "system.xml", isComputer: true},
+		"Filters are converted to targeting expressions":      {file: "filters.xml", isComputer: true},
+		"Windows variables are converted to dynamic values":   {file: "variables.xml"},
+		"User variables are skipped for computers":            {file: "variables.xml", isComputer: true},
+		"Disabled, invalid and unsupported items are skipped": {file: "skipped.xml"},
+		"File without variables":                              {file: "empty.xml", wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", wantErr: true},
+	})
+}
@@ -0,0 +1,395 @@
+package gpp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// fileActions are the actions of the files entries, by preference item action.
+var fileActions = map[string]string{
+	ActionCreate:  "create",
+	ActionReplace: "replace",
+	ActionUpdate:  "update",
+	ActionDelete:  "delete",
+}
+
+// gpoFileSetting is the setting of the files entries whose source is a file of a GPO, with its ID and its path in
+// the GPO, until EmbedGPOFiles replaces it with the content of the file.
+const gpoFileSetting = "GPOFile="
+
+// maxGPOFileSize is the maximum size of the files of a GPO which are embedded in the entries, as they are stored in
+// the policies cache.
+const maxGPOFileSize = 1 << 20
+
+// userProfile is the prefix of the paths of the user files, in their home directory.
+const userProfile = "%userprofile%/"
+
+// files is the content of Files.xml.
+type files struct {
+	Files []file `xml:"File"`
+}
+
+type file struct {
+	item
+	Properties struct {
+		Action     string `xml:"action,attr"`
+		FromPath   string `xml:"fromPath,attr"`
+		TargetPath string `xml:"targetPath,attr"`
+		ReadOnly   string `xml:"readOnly,attr"`
+	} `xml:"Properties"`
+}
+
+// folders is the content of Folders.xml.
+type folders struct {
+	Folders []folder `xml:"Folder"`
+}
+
+type folder struct {
+	item
+	Properties struct {
+		Action           string `xml:"action,attr"`
+		Path             string `xml:"path,attr"`
+		ReadOnly         string `xml:"readOnly,attr"`
+		DeleteFolder     string `xml:"deleteFolder,attr"`
+		DeleteSubFolders string `xml:"deleteSubFolders,attr"`
+		DeleteFiles      string `xml:"deleteFiles,attr"`
+	} `xml:"Properties"`
+}
+
+// Files returns the files entries of the files of the Files.xml file read from r, in processing order.
+// Each entry is keyed by the path of its file, which is absolute for computers and relative to the home directory
+// for users. Each value lists the settings of the file, one per line:
+//   - Action=: create, replace, update or delete, converted from the action of the item;
+//   - Source=: the file, relative to the GPO assets, the target is a copy of;
+//   - GPOFile=: the ID of the GPO and the path in it of the file the target is a copy of, which EmbedGPOFiles replaces
+//     with its content;
+//   - Mode=0444: the file is read-only.
+//
+// Sources can be relative to the GPO assets, the UNC path of a file in them, or the UNC path of a file of a GPO.
+// Files copying several files with wildcards, and files of a GPO whose path references variables, are skipped.
+// isComputer selects the files of the computer, which can be written anywhere on the system, instead of the files in
+// the profile of the user.
+func Files(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse files"))
+
+	var doc files
+	if err := decode(r, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, f := range doc.Files {
+		if f.isDisabled() {
+			log.Debugf(ctx, "File %q is disabled", f.Name)
+			continue
+		}
+		target, err := target(f.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping file %q: %v", f.Name, err))
+			continue
+		}
+		p, err := targetPath(f.Properties.TargetPath, isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping file %q: %v", f.Name, err))
+			continue
+		}
+		settings, err := f.settings(isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping file %q: %v", f.Name, err))
+			continue
+		}
+
+		entries = append(entries, entry.Entry{
+			Key:    p,
+			Value:  strings.Join(settings, "\n"),
+			Target: target,
+		})
+	}
+
+	return entries, nil
+}
+
+// settings returns the settings of the files entry of f.
+// isComputer rejects the sources referencing variables of the user.
+func (f file) settings(isComputer bool) (settings []string, err error) {
+	a, ok := fileActions[action(f.Properties.Action)]
+	if !ok {
+		return nil, errors.New(gotext.Get("unsupported action %q", f.Properties.Action))
+	}
+	settings = append(settings, "Action="+a)
+	if a == "delete" {
+		return settings, nil
+	}
+
+	source, err := sourceSetting(f.Properties.FromPath, isComputer)
+	if err != nil {
+		return nil, err
+	}
+	settings = append(settings, source)
+	if f.Properties.ReadOnly == "1" {
+		settings = append(settings, "Mode=0444")
+	}
+
+	return settings, nil
+}
+
+// Folders returns the files entries of the folders of the Folders.xml file read from r, in processing order.
+// Each entry is keyed by the path of its folder, as for Files, with the same settings, except for the source:
+//   - Mode=0555: the folder is read-only;
+//   - Recursive=true: the deleted folder is removed with all its content.
+//
+// Folders are only deleted with all their content or if they are empty, and items only deleting their content are
+// skipped.
+// isComputer selects the folders of the computer instead of the folders in the profile of the user.
+func Folders(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse folders"))
+
+	var doc folders
+	if err := decode(r, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, f := range doc.Folders {
+		if f.isDisabled() {
+			log.Debugf(ctx, "Folder %q is disabled", f.Name)
+			continue
+		}
+		target, err := target(f.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping folder %q: %v", f.Name, err))
+			continue
+		}
+		p, err := targetPath(f.Properties.Path, isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping folder %q: %v", f.Name, err))
+			continue
+		}
+		settings, err := f.settings()
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping folder %q: %v", f.Name, err))
+			continue
+		}
+
+		entries = append(entries, entry.Entry{
+			Key:    p,
+			Value:  strings.Join(settings, "\n"),
+			Target: target,
+		})
+	}
+
+	return entries, nil
+}
+
+// settings returns the settings of the files entry of the folder f.
+func (f folder) settings() (settings []string, err error) {
+	a, ok := fileActions[action(f.Properties.Action)]
+	if !ok {
+		return nil, errors.New(gotext.Get("unsupported action %q", f.Properties.Action))
+	}
+	settings = append(settings, "Action="+a)
+
+	if a != "delete" {
+		if f.Properties.ReadOnly == "1" {
+			settings = append(settings, "Mode=0555")
+		}
+		return settings, nil
+	}
+
+	content := f.Properties.DeleteFiles == "1"
+	if content != (f.Properties.DeleteSubFolders == "1") {
+		return nil, errors.New(gotext.Get("only deleting the files or the subfolders of a folder is not supported"))
+	}
+	if f.Properties.DeleteFolder != "1" {
+		return nil, errors.New(gotext.Get("only deleting the content of a folder is not supported"))
+	}
+	if content {
+		settings = append(settings, "Recursive=true")
+	}
+
+	return settings, nil
+}
+
+// targetPath returns the path of a file or folder from its Windows path p.
+// Paths of computers must be absolute paths on the client, and paths of users must be in their profile, and are
+// returned relative to their home directory.
+func targetPath(p string, isComputer bool) (string, error) {
+	p = strings.ReplaceAll(strings.TrimSpace(p), `\`, "/")
+	if p == "" {
+		return "", errors.New(gotext.Get("no path"))
+	}
+	if strings.ContainsAny(p, "\r\n") {
+		return "", errors.New(gotext.Get("invalid path %q", p))
+	}
+
+	if !isComputer {
+		if !strings.HasPrefix(strings.ToLower(p), userProfile) {
+			return "", errors.New(gotext.Get("%q is not in the profile of the user", p))
+		}
+		p = path.Clean(p[len(userProfile):])
+		if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return "", errors.New(gotext.Get("%q is not in the profile of the user", p))
+		}
+	} else if !path.IsAbs(p) || path.Clean(p) == "/" {
+		return "", errors.New(gotext.Get("%q is not an absolute path on the client", p))
+	}
+
+	// Keys are not expanded as dynamic values.
+	if strings.ContainsAny(p, "%:") {
+		return "", errors.New(gotext.Get("Windows variables and drives are not supported in %q", p))
+	}
+
+	return path.Clean(p), nil
+}
+
+// sourceSetting returns the setting of the source file from of a files entry: a file of the GPO assets, or the file
+// of a GPO, from its UNC path //server/SYSVOL/domain/Policies/{GUID}/path.
+// isComputer rejects the paths referencing variables of the user.
+func sourceSetting(from string, isComputer bool) (string, error) {
+	p := strings.ReplaceAll(strings.TrimSpace(from), `\`, "/")
+	parts := strings.SplitN(strings.TrimPrefix(p, "//"), "/", 6)
+	if !strings.HasPrefix(p, "//") || len(parts) != 6 || !strings.EqualFold(parts[1], "SYSVOL") || !strings.EqualFold(parts[3], "Policies") {
+		asset, err := assetPath(from, isComputer)
+		if err != nil {
+			return "", err
+		}
+		return "Source=" + asset, nil
+	}
+
+	// The file is read when parsing the GPO, so its path can't be expanded on the client.
+	if strings.ContainsAny(p, "*?") {
+		return "", errors.New(gotext.Get("wildcards are not supported in %q", from))
+	}
+	if strings.Contains(p, "%") {
+		return "", errors.New(gotext.Get("Windows variables are not supported in the files of a GPO %q", from))
+	}
+	rel := path.Clean(parts[5])
+	if parts[4] == "" || strings.ContainsAny(p, ":\r\n") || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.New(gotext.Get("%q is not a file of a GPO", from))
+	}
+
+	return gpoFileSetting + parts[4] + "/" + rel, nil
+}
+
+// assetPath returns the path of the file from, relative to the GPO assets.
+// It can be relative to the GPO assets, or the UNC path of a file in them.
+// isComputer rejects the paths referencing variables of the user.
+func assetPath(from string, isComputer bool) (string, error) {
+	p := strings.ReplaceAll(strings.TrimSpace(from), `\`, "/")
+	if p == "" {
+		return "", errors.New(gotext.Get("no source file"))
+	}
+
+	if strings.HasPrefix(p, "//") {
+		// //server/SYSVOL/domain/ubuntu/path
+		parts := strings.SplitN(strings.TrimPrefix(p, "//"), "/", 5)
+		if len(parts) != 5 || !strings.EqualFold(parts[1], "SYSVOL") || !strings.EqualFold(parts[3], "ubuntu") {
+			return "", errors.New(gotext.Get("%q is not a file of the GPO assets", from))
+		}
+		p = parts[4]
+	}
+
+	if strings.ContainsAny(p, "*?") {
+		return "", errors.New(gotext.Get("wildcards are not supported in %q", from))
+	}
+	if strings.Contains(p, ":") || path.IsAbs(p) || strings.ContainsAny(p, "\r\n") {
+		return "", errors.New(gotext.Get("%q is not a file of the GPO assets", from))
+	}
+	p = path.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.New(gotext.Get("%q is not a file of the GPO assets", from))
+	}
+
+	return expandAllVariables(p, isComputer)
+}
+
+// EmbedGPOFiles returns the files entries with the files of the GPO they are a copy of replaced by their content.
+// gpoDir is the directory the GPO was downloaded to, named after its ID. Entries copying the files of other GPOs, or
+// files which can't be read, are skipped.
+func EmbedGPOFiles(ctx context.Context, gpoDir string, entries []entry.Entry) (embedded []entry.Entry) {
+	for _, e := range entries {
+		settings := strings.Split(e.Value, "\n")
+		i := slices.IndexFunc(settings, func(s string) bool { return strings.HasPrefix(s, gpoFileSetting) })
+		if i == -1 {
+			embedded = append(embedded, e)
+			continue
+		}
+
+		content, err := readGPOFile(gpoDir, strings.TrimPrefix(settings[i], gpoFileSetting))
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping file %q: %v", e.Key, err))
+			continue
+		}
+		settings[i] = "Content=" + base64.StdEncoding.EncodeToString(content)
+		e.Value = strings.Join(settings, "\n")
+		embedded = append(embedded, e)
+	}
+	return embedded
+}
+
+// readGPOFile returns the content of the file p, made of the ID of a GPO and the path of the file in it, in the GPO
+// downloaded to gpoDir. The path is matched case-insensitively, as on Windows.
+func readGPOFile(gpoDir, p string) (content []byte, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't read file %q of the GPO", p))
+
+	id, rel, _ := strings.Cut(p, "/")
+	if !strings.EqualFold(id, filepath.Base(gpoDir)) {
+		return nil, errors.New(gotext.Get("only the files of the GPO itself are supported"))
+	}
+
+	root, err := os.OpenRoot(gpoDir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	resolved := "."
+	for _, elem := range strings.Split(rel, "/") {
+		if _, err := root.Lstat(filepath.Join(resolved, elem)); err == nil {
+			resolved = filepath.Join(resolved, elem)
+			continue
+		}
+		d, err := root.Open(resolved)
+		if err != nil {
+			return nil, err
+		}
+		names, err := d.Readdirnames(-1)
+		_ = d.Close()
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(names, func(n string) bool { return strings.EqualFold(n, elem) })
+		if i == -1 {
+			return nil, fs.ErrNotExist
+		}
+		resolved = filepath.Join(resolved, names[i])
+	}
+
+	f, err := root.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errors.New(gotext.Get("not a file"))
+	}
+	if fi.Size() > maxGPOFileSize {
+		return nil, errors.New(gotext.Get("the file is larger than %d bytes", maxGPOFileSize))
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,93 @@
+package gpp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/ad/gpp"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestFiles(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "files", gpp.Files, map[string]preferenceTest{
+		"Files of computers":                                  {file: "machine.xml", isComputer: true},
+		"Files of users are in their home directory":          {file: "user.xml"},
+		"Filters are converted to targeting expressions":      {file: "filters.xml", isComputer: true},
+		"Disabled, invalid and unsupported items are skipped": {file: "skipped.xml", isComputer: true},
+		"Files of users out of their profile are skipped":     {file: "user_skipped.xml"},
+		"File without files":                                  {file: "empty.xml", wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", wantErr: true},
+	})
+}
+
+func TestFolders(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "folders", gpp.Folders, map[string]preferenceTest{
+		"Folders of computers":                                {file: "machine.xml", isComputer: true},
+		"Folders of users are in their home directory":        {file: "user.xml"},
+		"Disabled, invalid and unsupported items are skipped": {file: "skipped.xml", isComputer: true},
+		"File without folders":                                {file: "empty.xml", wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", wantErr: true},
+	})
+}
+
+func TestEmbedGPOFiles(t *testing.T) {
+	t.Parallel()
+
+	const gpoID = "{31B2F340-016D-11D2-945F-00C04FB984F9}"
+
+	tests := map[string]struct {
+		entries []entry.Entry
+		// largeFile is created in the GPO, with a size over the maximum.
+		largeFile string
+
+		wantNone bool
+	}{
+		"Files of the GPO are embedded": {entries: []entry.Entry{
+			{Key: "/opt/app/gpo.ini", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/Preferences/Files/gpo.ini\nMode=0444"},
+			{Key: "/opt/app/empty.ini", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/Preferences/Files/empty.ini"}}},
+		"Paths are matched case-insensitively": {entries: []entry.Entry{
+			{Key: "/opt/app/nested.txt", Value: "Action=update\nGPOFile=" + "{31b2f340-016d-11d2-945f-00c04fb984f9}/MACHINE/preferences/FILES/folder/nested.TXT"}}},
+		"Entries without files of the GPO are unchanged": {entries: []entry.Entry{
+			{Key: "/opt/app/license.ini", Value: "Action=update\nSource=app/license.ini"},
+			{Key: "/opt/app/old.ini", Value: "Action=delete"}}},
+		"Files which can't be embedded are skipped": {entries: []entry.Entry{
+			{Key: "/opt/app/other.ini", Value: "Action=update\nGPOFile={6AC1786C-016F-11D2-945F-00C04FB984F9}/Machine/Preferences/Files/gpo.ini"},
+			{Key: "/opt/app/missing.ini", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/Preferences/Files/missing.ini"},
+			{Key: "/opt/app/folder", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/Preferences/Files/Folder"},
+			{Key: "/opt/app/large.bin", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/large.bin"},
+			{Key: "/opt/app/gpo.ini", Value: "Action=update\nGPOFile=" + gpoID + "/Machine/Preferences/Files/gpo.ini"}},
+			largeFile: "Machine/large.bin"},
+		"No entries": {wantNone: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			gpoDir := filepath.Join(t.TempDir(), gpoID)
+			testutils.Copy(t, filepath.Join("testdata", "files", "gpo", gpoID), gpoDir)
+			if tc.largeFile != "" {
+				err := os.WriteFile(filepath.Join(gpoDir, tc.largeFile), make([]byte, 1<<20+1), 0600)
+				require.NoError(t, err, "Setup: can't create large file")
+			}
+
+			got := gpp.EmbedGPOFiles(context.Background(), gpoDir, tc.entries)
+			if tc.wantNone {
+				require.Empty(t, got, "EmbedGPOFiles should not return any entry")
+				return
+			}
+
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, got)
+			require.Equal(t, want, got, "EmbedGPOFiles returned unexpected entries")
+		})
+	}
+}
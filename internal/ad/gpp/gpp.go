@@ -0,0 +1,197 @@
+// Package gpp converts Group Policy Preferences to policy entries.
+//
+// Preferences are stored as XML files under the Preferences directory of the User and Machine classes of a GPO,
+// one per preference extension, e.g. Preferences/Drives/Drives.xml. Each file lists items, processed in order, with
+// an action to create, replace, update or delete what they describe.
+//
+// Item-level targeting filters of the items are converted to targeting expressions when there is an equivalent
+// predicate. Items with other filters are skipped, so that they never apply to more objects than intended.
+// Windows variables of the values, like %LogonUser%, are converted to dynamic values when there is an equivalent.
+// Items of the computer configuration referencing variables of the user are skipped.
+package gpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+)
+
+// Actions of the preference items.
+const (
+	// ActionCreate creates what the item describes, if it does not exist yet.
+	ActionCreate = "C"
+	// ActionReplace deletes then creates again what the item describes.
+	ActionReplace = "R"
+	// ActionUpdate creates what the item describes, or modifies it if it already exists.
+	ActionUpdate = "U"
+	// ActionDelete deletes what the item describes.
+	ActionDelete = "D"
+)
+
+// item holds the attributes common to the items of every preference file.
+type item struct {
+	Name     string  `xml:"name,attr"`
+	Disabled string  `xml:"disabled,attr"`
+	Filters  filters `xml:"Filters"`
+}
+
+// isDisabled returns if the item was disabled by the administrator, and should be ignored.
+func (i item) isDisabled() bool {
+	return i.Disabled == "1"
+}
+
+// action returns the normalized action a, which is an update if not set.
+func action(a string) string {
+	a = strings.ToUpper(strings.TrimSpace(a))
+	if a == "" {
+		return ActionUpdate
+	}
+	return a
+}
+
+type filters struct {
+	Filters []filter `xml:",any"`
+}
+
+// filter is an item-level targeting filter, or a collection of filters.
+type filter struct {
+	XMLName xml.Name
+	Bool    string   `xml:"bool,attr"`
+	Not     string   `xml:"not,attr"`
+	Name    string   `xml:"name,attr"`
+	Type    string   `xml:"type,attr"`
+	Filters []filter `xml:",any"`
+}
+
+// target returns the targeting expression equivalent to filters, which is empty if there is none.
+// Filters are evaluated in order, each one being combined with the result of the previous ones with its
+// boolean operator.
+func target(filters []filter) (expression string, err error) {
+	for i, f := range filters {
+		e, err := f.expression()
+		if err != nil {
+			return "", err
+		}
+		if f.Not == "1" {
+			e = "not " + e
+		}
+		if i == 0 {
+			expression = e
+			continue
+		}
+
+		op := "and"
+		if strings.EqualFold(f.Bool, "OR") {
+			op = "or"
+		}
+		// Only a combination of filters needs to be grouped to be evaluated first.
+		if i > 1 {
+			expression = "(" + expression + ")"
+		}
+		expression = fmt.Sprintf("%s %s %s", expression, op, e)
+	}
+	return expression, nil
+}
+
+// expression returns the targeting expression equivalent to the filter, without its negation.
+func (f filter) expression() (string, error) {
+	switch f.XMLName.Local {
+	case "FilterGroup":
+		// Groups are matched without their NetBIOS domain.
+		name := f.Name[strings.LastIndex(f.Name, `\`)+1:]
+		if name == "" {
+			return "", errors.New(gotext.Get("group filter without a group name"))
+		}
+		return fmt.Sprintf("group(%s)", quote(name)), nil
+	case "FilterComputer":
+		name := f.Name
+		if strings.EqualFold(f.Type, "DNS") {
+			name, _, _ = strings.Cut(name, ".")
+		}
+		if name == "" {
+			return "", errors.New(gotext.Get("computer filter without a computer name"))
+		}
+		return fmt.Sprintf("hostname(%s)", quote(name)), nil
+	case "FilterCollection":
+		e, err := target(f.Filters)
+		if err != nil {
+			return "", err
+		}
+		if e == "" {
+			return "", errors.New(gotext.Get("empty filter collection"))
+		}
+		return "(" + e + ")", nil
+	}
+	return "", errors.New(gotext.Get("unsupported item-level targeting filter %q", f.XMLName.Local))
+}
+
+// quote returns s as a double-quoted targeting argument.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+var windowsVariable = regexp.MustCompile(`%([[:alpha:]]+)%`)
+
+// dynamicValues are the dynamic values equivalent to the Windows variables, by lowercase name.
+var dynamicValues = map[string]string{
+	"logonuser":     "${USER}",
+	"username":      "${USER}",
+	"userprofile":   "${HOME}",
+	"userdnsdomain": "${DOMAIN}",
+	"computername":  "${HOSTNAME}",
+}
+
+// userVariables are the Windows variables of the user, by lowercase name, which have no value in the computer
+// configuration.
+var userVariables = []string{"logonuser", "username", "userprofile"}
+
+// expandVariables returns s with its Windows variables converted to dynamic values when there is an equivalent,
+// and its other dynamic values placeholders escaped.
+// If isComputer is true, it fails if s references a variable of the user.
+func expandVariables(s string, isComputer bool) (string, error) {
+	if isComputer {
+		for _, v := range windowsVariable.FindAllString(s, -1) {
+			if slices.Contains(userVariables, strings.ToLower(strings.Trim(v, "%"))) {
+				return "", errors.New(gotext.Get("user variable %s is not supported in the computer configuration", v))
+			}
+		}
+	}
+
+	s = strings.ReplaceAll(s, "${", "$${")
+	return windowsVariable.ReplaceAllStringFunc(s, func(v string) string {
+		if d, ok := dynamicValues[strings.ToLower(strings.Trim(v, "%"))]; ok {
+			return d
+		}
+		return v
+	}), nil
+}
+
+// expandAllVariables returns s with its Windows variables converted to dynamic values, as expandVariables, but
+// fails if one of them has no equivalent instead of keeping it as is.
+func expandAllVariables(s string, isComputer bool) (string, error) {
+	for _, v := range windowsVariable.FindAllString(s, -1) {
+		if _, ok := dynamicValues[strings.ToLower(strings.Trim(v, "%"))]; !ok {
+			return "", errors.New(gotext.Get("unsupported Windows variable %s", v))
+		}
+	}
+	return expandVariables(s, isComputer)
+}
+
+// utf8BOM is the byte order mark which can start the preference files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// decode decodes into v the preference file read from r.
+func decode(r io.Reader, v any) error {
+	d, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(bytes.TrimPrefix(d, utf8BOM), v)
+}
@@ -0,0 +1,59 @@
+package gpp_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/targeting"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+// preferenceTest is a test case of a preference parser.
+type preferenceTest struct {
+	file       string
+	isComputer bool
+
+	wantNone bool
+	wantErr  bool
+}
+
+// runPreferenceTests runs the test cases of parse, with the preference files from testdata/dir. The returned entries
+// are compared to the golden files.
+func runPreferenceTests(t *testing.T, dir string, parse func(context.Context, io.Reader, bool) ([]entry.Entry, error), tests map[string]preferenceTest) {
+	t.Helper()
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(filepath.Join("testdata", dir, tc.file))
+			require.NoError(t, err, "Setup: can't open preference file")
+			defer f.Close()
+
+			got, err := parse(context.Background(), f, tc.isComputer)
+			if tc.wantErr {
+				require.Error(t, err, "Parsing should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Parsing should not have failed")
+			if tc.wantNone {
+				require.Empty(t, got, "Parsing should not return any entry")
+				return
+			}
+
+			for _, e := range got {
+				if e.Target != "" {
+					require.NoError(t, targeting.Validate(e.Target), "Parsing should return valid targeting expressions")
+				}
+			}
+
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, got)
+			require.Equal(t, want, got, "Parsing returned unexpected entries")
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package gpp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// Operations of the localgroups manager on the members of a group, one per line of the entries values.
+const (
+	// membersAdd adds the account, following it after a space, to the group.
+	membersAdd = "add"
+	// membersRemove removes the account, following it after a space, from the group.
+	membersRemove = "remove"
+	// membersRemoveAll removes all members of the group.
+	membersRemoveAll = "remove-all"
+)
+
+// builtinSuffix is appended to the names of the groups created with the system.
+const builtinSuffix = " (built-in)"
+
+// groups is the content of Groups.xml.
+type groups struct {
+	Groups []group `xml:"Group"`
+	Users  []item  `xml:"User"`
+}
+
+type group struct {
+	item
+	Properties struct {
+		Action         string `xml:"action,attr"`
+		GroupName      string `xml:"groupName,attr"`
+		NewName        string `xml:"newName,attr"`
+		DeleteAllUsers string `xml:"deleteAllUsers,attr"`
+		Members        []struct {
+			Name   string `xml:"name,attr"`
+			Action string `xml:"action,attr"`
+		} `xml:"Members>Member"`
+	} `xml:"Properties"`
+}
+
+// Groups returns the localgroups entries of the local groups of the Groups.xml file read from r, in processing order.
+// The key of each entry is the group name and its value lists the operations on the group members, one per line.
+// Local groups and users are never created, renamed or deleted: only the membership of existing groups is managed.
+// Local groups are only supported in the computer configuration, as their membership applies to the whole machine.
+func Groups(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse local groups"))
+
+	var g groups
+	if err := decode(r, &g); err != nil {
+		return nil, err
+	}
+
+	if !isComputer {
+		if len(g.Groups) > 0 {
+			log.Warning(ctx, gotext.Get("Local groups are only supported in the computer configuration, ignoring them"))
+		}
+		return nil, nil
+	}
+
+	for _, u := range g.Users {
+		log.Warning(ctx, gotext.Get("Skipping local user %q: local users are not supported", u.Name))
+	}
+
+	for _, grp := range g.Groups {
+		if grp.isDisabled() {
+			log.Debugf(ctx, "Local group %q is disabled", grp.Name)
+			continue
+		}
+		target, err := target(grp.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping local group %q: %v", grp.Name, err))
+			continue
+		}
+
+		name := grp.Properties.GroupName
+		if name == "" {
+			name = grp.Name
+		}
+		name = strings.TrimSuffix(name, builtinSuffix)
+		if name == "" || strings.ContainsAny(name, ":,\n") {
+			log.Warning(ctx, gotext.Get("Skipping local group %q: invalid group name", grp.Name))
+			continue
+		}
+
+		var ops []string
+		switch action(grp.Properties.Action) {
+		case ActionDelete:
+			log.Warning(ctx, gotext.Get("Skipping local group %q: local groups can't be deleted", grp.Name))
+			continue
+		case ActionReplace:
+			// The group is created again, without any member.
+			ops = append(ops, membersRemoveAll)
+		default:
+			if grp.Properties.DeleteAllUsers == "1" {
+				ops = append(ops, membersRemoveAll)
+			}
+		}
+		if grp.Properties.NewName != "" {
+			log.Warning(ctx, gotext.Get("Local group %q is not renamed to %q: local groups can't be renamed", name, grp.Properties.NewName))
+		}
+
+		for _, m := range grp.Properties.Members {
+			if m.Name == "" || strings.ContainsAny(m.Name, ":,\n") {
+				log.Warning(ctx, gotext.Get("Skipping invalid member %q of local group %q", m.Name, name))
+				continue
+			}
+			op := membersAdd
+			if strings.EqualFold(m.Action, "REMOVE") {
+				op = membersRemove
+			}
+			ops = append(ops, fmt.Sprintf("%s %s", op, m.Name))
+		}
+		if len(ops) == 0 {
+			continue
+		}
+
+		entries = append(entries, entry.Entry{
+			Key:      name,
+			Value:    strings.Join(ops, "\n"),
+			Strategy: entry.StrategyAppend,
+			Target:   target,
+		})
+	}
+
+	return entries, nil
+}
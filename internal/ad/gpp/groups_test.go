@@ -0,0 +1,22 @@
+package gpp_test
+
+import (
+	"testing"
+
+	"github.com/ubuntu/adsys/internal/ad/gpp"
+)
+
+func TestGroups(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "groups", gpp.Groups, map[string]preferenceTest{
+		"Members are added to and removed from groups":        {file: "members.xml", isComputer: true},
+		"Replaced groups and groups deleting all users":       {file: "actions.xml", isComputer: true},
+		"Filters are converted to targeting expressions":      {file: "filters.xml", isComputer: true},
+		"Disabled, invalid and unsupported items are skipped": {file: "skipped.xml", isComputer: true},
+		"Groups are ignored for users":                        {file: "members.xml", wantNone: true},
+		"File without groups":                                 {file: "empty.xml", isComputer: true, wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", isComputer: true, wantErr: true},
+	})
+}
@@ -0,0 +1,416 @@
+package gpp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// scheduledTasks is the content of ScheduledTasks.xml.
+type scheduledTasks struct {
+	Tasks []scheduledTask `xml:",any"`
+}
+
+type scheduledTask struct {
+	XMLName xml.Name
+	item
+	Properties struct {
+		Action string         `xml:"action,attr"`
+		Name   string         `xml:"name,attr"`
+		RunAs  string         `xml:"runAs,attr"`
+		Task   taskDefinition `xml:"Task"`
+	} `xml:"Properties"`
+}
+
+// taskDefinition is the definition of a task, in the Task Scheduler schema.
+type taskDefinition struct {
+	Settings struct {
+		Enabled            string `xml:"Enabled"`
+		StartWhenAvailable string `xml:"StartWhenAvailable"`
+	} `xml:"Settings"`
+	Triggers struct {
+		Triggers []taskTrigger `xml:",any"`
+	} `xml:"Triggers"`
+	Actions struct {
+		Actions []taskAction `xml:",any"`
+	} `xml:"Actions"`
+}
+
+type taskTrigger struct {
+	XMLName       xml.Name
+	Enabled       string `xml:"Enabled"`
+	StartBoundary string `xml:"StartBoundary"`
+	EndBoundary   string `xml:"EndBoundary"`
+	Repetition    struct {
+		Interval string `xml:"Interval"`
+	} `xml:"Repetition"`
+	ScheduleByDay *struct {
+		DaysInterval string `xml:"DaysInterval"`
+	} `xml:"ScheduleByDay"`
+	ScheduleByWeek *struct {
+		WeeksInterval string   `xml:"WeeksInterval"`
+		DaysOfWeek    elements `xml:"DaysOfWeek"`
+	} `xml:"ScheduleByWeek"`
+	ScheduleByMonth *struct {
+		DaysOfMonth struct {
+			Days []string `xml:"Day"`
+		} `xml:"DaysOfMonth"`
+		Months elements `xml:"Months"`
+	} `xml:"ScheduleByMonth"`
+	ScheduleByMonthDayOfWeek *struct{} `xml:"ScheduleByMonthDayOfWeek"`
+}
+
+type taskAction struct {
+	XMLName   xml.Name
+	Command   string `xml:"Command"`
+	Arguments string `xml:"Arguments"`
+}
+
+// elements are empty elements only listed by their name, like the days of a week.
+type elements struct {
+	Elements []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// names returns the names of the elements, converted with the values of m, or an error if one of them is missing.
+func (e elements) names(m map[string]string) (names []string, err error) {
+	for _, el := range e.Elements {
+		n, ok := m[el.XMLName.Local]
+		if !ok {
+			return nil, errors.New(gotext.Get("unexpected element %q", el.XMLName.Local))
+		}
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+var weekDays = map[string]string{
+	"Monday":    "Mon",
+	"Tuesday":   "Tue",
+	"Wednesday": "Wed",
+	"Thursday":  "Thu",
+	"Friday":    "Fri",
+	"Saturday":  "Sat",
+	"Sunday":    "Sun",
+}
+
+var months = map[string]string{
+	"January":   "01",
+	"February":  "02",
+	"March":     "03",
+	"April":     "04",
+	"May":       "05",
+	"June":      "06",
+	"July":      "07",
+	"August":    "08",
+	"September": "09",
+	"October":   "10",
+	"November":  "11",
+	"December":  "12",
+}
+
+// ScheduledTasks returns the scheduled tasks entries of the tasks of the ScheduledTasks.xml file read from r, in
+// processing order. Each entry is keyed by the name of its task.
+// Each value lists the timer and service settings of the task, one per line:
+//   - OnCalendar=: a calendar event the task is run on, converted from the triggers of the task;
+//   - Persistent=true: the task is run when the machine starts if it missed its last run;
+//   - Transient=true: the task is run once, when it is applied, instead of on calendar events;
+//   - User=: the account running the task of a computer, which is root if not set;
+//   - ExecStart=: the script, relative to the scripts directory of the GPO assets, and its arguments.
+//
+// Immediate tasks are transient. Deleted and disabled tasks are returned as disabled entries, so that they are
+// removed even if a further GPO creates them.
+// Tasks with actions, triggers or accounts which have no equivalent are skipped.
+// isComputer selects the tasks of the computer, which can run as other accounts than the user.
+func ScheduledTasks(ctx context.Context, r io.Reader, isComputer bool) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't parse scheduled tasks"))
+
+	var tasks scheduledTasks
+	if err := decode(r, &tasks); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tasks.Tasks {
+		name := t.Properties.Name
+		if name == "" {
+			name = t.Name
+		}
+		if t.isDisabled() {
+			log.Debugf(ctx, "Scheduled task %q is disabled", name)
+			continue
+		}
+		if name == "" {
+			log.Warning(ctx, gotext.Get("Skipping scheduled task without a name"))
+			continue
+		}
+		target, err := target(t.Filters.Filters)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping scheduled task %q: %v", name, err))
+			continue
+		}
+
+		e := entry.Entry{
+			Key:    name,
+			Target: target,
+		}
+		if action(t.Properties.Action) == ActionDelete || strings.EqualFold(t.Properties.Task.Settings.Enabled, "false") {
+			e.Disabled = true
+			entries = append(entries, e)
+			continue
+		}
+
+		var settings []string
+		switch t.XMLName.Local {
+		case "TaskV2":
+			settings, err = t.timerSettings(ctx)
+		case "ImmediateTaskV2":
+			settings = []string{"Transient=true"}
+		default:
+			err = errors.New(gotext.Get("%s tasks are not supported", t.XMLName.Local))
+		}
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping scheduled task %q: %v", name, err))
+			continue
+		}
+		service, err := t.serviceSettings(isComputer)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping scheduled task %q: %v", name, err))
+			continue
+		}
+
+		e.Value = strings.Join(append(settings, service...), "\n")
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// timerSettings returns the timer settings of the recurring task t.
+// Disabled and unsupported triggers are ignored, but at least one trigger must be supported.
+func (t scheduledTask) timerSettings(ctx context.Context) (settings []string, err error) {
+	for _, tr := range t.Properties.Task.Triggers.Triggers {
+		if strings.EqualFold(tr.Enabled, "false") {
+			continue
+		}
+		events, err := tr.calendarEvents()
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Ignoring trigger of scheduled task %q: %v", t.Properties.Name, err))
+			continue
+		}
+		for _, ev := range events {
+			settings = append(settings, "OnCalendar="+ev)
+		}
+	}
+	if len(settings) == 0 {
+		return nil, errors.New(gotext.Get("no supported trigger"))
+	}
+
+	if strings.EqualFold(t.Properties.Task.Settings.StartWhenAvailable, "true") {
+		settings = append(settings, "Persistent=true")
+	}
+	return settings, nil
+}
+
+// boundaryLayout is the layout of the boundaries of the triggers, without their optional time zone.
+const boundaryLayout = "2006-01-02T15:04:05"
+
+// calendarEvents returns the systemd calendar events equivalent to the trigger.
+func (tr taskTrigger) calendarEvents() (events []string, err error) {
+	if tr.XMLName.Local != "TimeTrigger" && tr.XMLName.Local != "CalendarTrigger" {
+		return nil, errors.New(gotext.Get("%s triggers are not supported", tr.XMLName.Local))
+	}
+	if tr.Repetition.Interval != "" {
+		return nil, errors.New(gotext.Get("repetitions are not supported"))
+	}
+	if tr.EndBoundary != "" {
+		return nil, errors.New(gotext.Get("expiration dates are not supported"))
+	}
+
+	// The time zone of the boundary, if any, is ignored: tasks run at the local time of the client.
+	if len(tr.StartBoundary) < len(boundaryLayout) {
+		return nil, errors.New(gotext.Get("invalid start boundary %q", tr.StartBoundary))
+	}
+	start, err := time.Parse(boundaryLayout, tr.StartBoundary[:len(boundaryLayout)])
+	if err != nil {
+		return nil, errors.New(gotext.Get("invalid start boundary %q", tr.StartBoundary))
+	}
+	clock := start.Format("15:04:05")
+
+	if tr.XMLName.Local == "TimeTrigger" {
+		return []string{start.Format("2006-01-02") + " " + clock}, nil
+	}
+
+	switch {
+	case tr.ScheduleByDay != nil:
+		if !isInterval1(tr.ScheduleByDay.DaysInterval) {
+			return nil, errors.New(gotext.Get("days intervals are not supported"))
+		}
+		return []string{"*-*-* " + clock}, nil
+
+	case tr.ScheduleByWeek != nil:
+		if !isInterval1(tr.ScheduleByWeek.WeeksInterval) {
+			return nil, errors.New(gotext.Get("weeks intervals are not supported"))
+		}
+		days, err := tr.ScheduleByWeek.DaysOfWeek.names(weekDays)
+		if err != nil {
+			return nil, err
+		}
+		if len(days) == 0 {
+			return nil, errors.New(gotext.Get("no days of the week"))
+		}
+		return []string{strings.Join(days, ",") + " *-*-* " + clock}, nil
+
+	case tr.ScheduleByMonth != nil:
+		ms, err := tr.ScheduleByMonth.Months.names(months)
+		if err != nil {
+			return nil, err
+		}
+		month := strings.Join(ms, ",")
+		if len(ms) == 0 || len(ms) == len(months) {
+			month = "*"
+		}
+
+		var days []string
+		for _, d := range tr.ScheduleByMonth.DaysOfMonth.Days {
+			d = strings.TrimSpace(d)
+			if strings.EqualFold(d, "Last") {
+				// The last day of the month is counted backwards, and can't be listed with the other days.
+				events = append(events, fmt.Sprintf("*-%s~01 %s", month, clock))
+				continue
+			}
+			n, err := strconv.Atoi(d)
+			if err != nil || n < 1 || n > 31 {
+				return nil, errors.New(gotext.Get("invalid day of the month %q", d))
+			}
+			days = append(days, strconv.Itoa(n))
+		}
+		if len(days) > 0 {
+			events = append([]string{fmt.Sprintf("*-%s-%s %s", month, strings.Join(days, ","), clock)}, events...)
+		}
+		if len(events) == 0 {
+			return nil, errors.New(gotext.Get("no days of the month"))
+		}
+		return events, nil
+
+	case tr.ScheduleByMonthDayOfWeek != nil:
+		return nil, errors.New(gotext.Get("schedules by weeks of the month are not supported"))
+	}
+
+	return nil, errors.New(gotext.Get("calendar trigger without a schedule"))
+}
+
+// isInterval1 returns if the interval of a schedule is to run every day or week.
+func isInterval1(interval string) bool {
+	interval = strings.TrimSpace(interval)
+	return interval == "" || interval == "1"
+}
+
+// serviceSettings returns the service settings of the task, running its scripts as its account.
+func (t scheduledTask) serviceSettings(isComputer bool) (settings []string, err error) {
+	user, err := runAs(t.Properties.RunAs, isComputer)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		settings = append(settings, "User="+user)
+	}
+
+	var commands int
+	for _, a := range t.Properties.Task.Actions.Actions {
+		if a.XMLName.Local != "Exec" {
+			return nil, errors.New(gotext.Get("%s actions are not supported", a.XMLName.Local))
+		}
+		script, err := scriptPath(a.Command, isComputer)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ContainsAny(a.Arguments, "\r\n") {
+			return nil, errors.New(gotext.Get("arguments can't span multiple lines"))
+		}
+		args, err := expandAllVariables(strings.TrimSpace(a.Arguments), isComputer)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, strings.TrimSpace("ExecStart="+script+" "+args))
+		commands++
+	}
+	if commands == 0 {
+		return nil, errors.New(gotext.Get("no command to run"))
+	}
+
+	return settings, nil
+}
+
+// runAs returns the user running a task set to run as account, which is empty for root or the user itself.
+// Accounts of the domain are qualified with the domain of the client.
+func runAs(account string, isComputer bool) (string, error) {
+	account = strings.TrimSpace(account)
+
+	if !isComputer {
+		switch strings.ToLower(account) {
+		case "", `%logondomain%\%logonuser%`, "%logonuser%":
+			return "", nil
+		}
+		return "", errors.New(gotext.Get("tasks of the user configuration can only run as the user"))
+	}
+
+	switch strings.ToLower(account) {
+	case "", `nt authority\system`, "system", "s-1-5-18":
+		return "", nil
+	}
+
+	domain, name, found := strings.Cut(account, `\`)
+	if !found {
+		domain, name = "", account
+	}
+	if strings.EqualFold(domain, "NT AUTHORITY") || strings.EqualFold(domain, "BUILTIN") ||
+		name == "" || strings.ContainsAny(name, "%$\\ \t") {
+		return "", errors.New(gotext.Get("unsupported account %q", account))
+	}
+	if domain == "" || domain == "." {
+		return name, nil
+	}
+	return name + "@${DOMAIN}", nil
+}
+
+// scriptPath returns the path of the script run by command, relative to the scripts directory of the GPO assets.
+// Commands can be relative to this directory, or the UNC path of a script in it.
+// isComputer rejects the commands referencing variables of the user.
+func scriptPath(command string, isComputer bool) (string, error) {
+	p := strings.ReplaceAll(strings.TrimSpace(command), `\`, "/")
+	if p == "" {
+		return "", errors.New(gotext.Get("no command to run"))
+	}
+
+	if strings.HasPrefix(p, "//") {
+		// //server/SYSVOL/domain/ubuntu/scripts/path
+		parts := strings.SplitN(strings.TrimPrefix(p, "//"), "/", 6)
+		if len(parts) != 6 || !strings.EqualFold(parts[1], "SYSVOL") ||
+			!strings.EqualFold(parts[3], "ubuntu") || !strings.EqualFold(parts[4], "scripts") {
+			return "", errors.New(gotext.Get("%q is not a script of the GPO assets", command))
+		}
+		p = parts[5]
+	}
+
+	if strings.Contains(p, ":") || path.IsAbs(p) || strings.ContainsAny(p, " \t\r\n") {
+		return "", errors.New(gotext.Get("%q is not a script of the GPO assets", command))
+	}
+	p = path.Clean(p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.New(gotext.Get("%q is not a script of the GPO assets", command))
+	}
+
+	return expandAllVariables(p, isComputer)
+}
@@ -0,0 +1,21 @@
+package gpp_test
+
+import (
+	"testing"
+
+	"github.com/ubuntu/adsys/internal/ad/gpp"
+)
+
+func TestScheduledTasks(t *testing.T) {
+	t.Parallel()
+
+	runPreferenceTests(t, "scheduledtasks", gpp.ScheduledTasks, map[string]preferenceTest{
+		"Tasks of computers": {file: "machine.xml", isComputer: true},
+		"Tasks of users":     {file: "user.xml"},
+		"Filters are converted to targeting expressions":      {file: "filters.xml", isComputer: true},
+		"Disabled, invalid and unsupported tasks are skipped": {file: "skipped.xml", isComputer: true},
+		"File without tasks": {file: "empty.xml", wantNone: true},
+
+		"Error on invalid file": {file: "invalid.xml", wantErr: true},
+	})
+}
@@ -491,6 +491,47 @@ func TestFetchTweakSysvolCacheDir(t *testing.T) {
 	}
 }
 
+func TestFetchOnlyTransfersChangedFiles(t *testing.T) {
+	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	dest, rundir := t.TempDir(), t.TempDir()
+	adc, err := New(context.Background(), mock.Backend{}, hostname,
+		WithCacheDir(dest), WithRunDir(rundir), withoutKerberos())
+	require.NoError(t, err, "Setup: cannot create ad object")
+
+	downloadables := map[string]string{"gpo1-name": fmt.Sprintf("smb://localhost:%d/SYSVOL/fakegpo.com/Policies/gpo1", SmbPort)}
+	_, err = adc.fetch(context.Background(), "", downloadables)
+	require.NoError(t, err, "Setup: initial fetch failed")
+
+	gpoDir := filepath.Join(adc.sysvolCacheDir, "Policies", "gpo1")
+	m, err := loadManifest(adc.manifestPath("gpo1"))
+	require.NoError(t, err, "Setup: can't load manifest after initial fetch")
+	require.Contains(t, m, "User/Gpo1Dir1/Gpo1File1.1", "Setup: manifest should list the downloaded files")
+	unchanged, err := os.Stat(filepath.Join(gpoDir, "User", "Gpo1Dir1", "Gpo1File1.1"))
+	require.NoError(t, err, "Setup: can't stat downloaded file")
+
+	// Alter a file locally, track a file which was since removed from AD and force a refresh of the GPO.
+	require.NoError(t, os.WriteFile(filepath.Join(gpoDir, "User", "Gpo1File1"), []byte("altered"), 0600), "Setup: can't alter downloaded file")
+	require.NoError(t, os.WriteFile(filepath.Join(gpoDir, "User", "Removed"), []byte("removed"), 0600), "Setup: can't create removed file")
+	m["User/Removed"] = manifestEntry{Size: 7}
+	require.NoError(t, m.save(adc.manifestPath("gpo1")), "Setup: can't save manifest")
+	require.NoError(t, os.WriteFile(filepath.Join(gpoDir, "GPT.INI"), []byte("[General]\nVersion=1\n"), 0600), "Setup: can't downgrade local GPO version")
+
+	_, err = adc.fetch(context.Background(), "", downloadables)
+	require.NoError(t, err, "fetch returned an error but shouldn't")
+
+	testutils.CompareTreesWithFiltering(t, gpoDir, filepath.Join("testdata", "AD", "SYSVOL", "fakegpo.com", "Policies", "gpo1"), false)
+	got, err := os.Stat(filepath.Join(gpoDir, "User", "Gpo1Dir1", "Gpo1File1.1"))
+	require.NoError(t, err, "can't stat kept file")
+	assert.True(t, os.SameFile(unchanged, got), "unchanged file should have been kept instead of downloaded again")
+	m, err = loadManifest(adc.manifestPath("gpo1"))
+	require.NoError(t, err, "can't load manifest after refresh")
+	assert.NotContains(t, m, "User/Removed", "manifest should not list files removed from AD")
+}
+
 func TestFetchOneGPOWhileParsingItConcurrently(t *testing.T) {
 	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
 
@@ -535,7 +576,7 @@ func TestFetchOneGPOWhileParsingItConcurrently(t *testing.T) {
 	go func() {
 		defer wg.Done()
 		// we can’t test returned values as it’s either the old of new version of the gpo
-		_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
+		_, _, _, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
 		require.NoError(t, err, "parseGPOs returned an error but shouldn't")
 	}()
 	wg.Wait()
@@ -569,7 +610,7 @@ func TestParseGPOConcurrent(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			// we can’t test returned values as it’s either the old of new version of the gpo
-			_, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
+			_, _, _, err := adc.parseGPOs(context.Background(), orderedGPOs, UserObject)
 			require.NoError(t, err, "parseGPOs returned an error but shouldn't")
 		}()
 	}
@@ -0,0 +1,325 @@
+package ad
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+)
+
+/*
+A local source replaces the directory service for air-gapped machines. It is a directory, or a tar archive,
+optionally compressed with gzip, of an export of the sysvol share:
+
+	Policies/<GPO ID>/      the GPOs, as in sysvol, with their GPT.INI
+	<DistroID>/             the assets, as in sysvol
+	gpolist/<object name>   the GPO list of a user (user@domain) or of the machine (hostname)
+	gpolist/user            the GPO list of the users without their own list
+	gpolist/computer        the GPO list of the machine without its own list
+
+GPO lists have the adsys-gpolist script output format, where the GPO URL is the relative path of its directory:
+
+	ATTR<tab>attribute name<tab>value
+	WMI<tab>GPO name<tab>namespace<tab>query
+	GPO name<tab>Policies/<GPO ID>
+
+GPOs are listed from the highest priority to the lowest.
+*/
+
+const localGPOListDir = "gpolist"
+
+// getLocalPolicies returns the policies of objectName from the local source.
+func (ad *AD) getLocalPolicies(ctx context.Context, objectName string, objectClass ObjectClass) (pols policies.Policies, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't get policies from local source %s", ad.localSource))
+
+	log.Infof(ctx, "Reading %q policies from local source %s", objectName, ad.localSource)
+
+	root, cleanup, err := ad.openLocalSource(ctx)
+	if err != nil {
+		return pols, err
+	}
+	defer cleanup()
+
+	list := func(name string, class ObjectClass) ([]gpo, map[string]string, error) {
+		return ad.listLocalGPOs(ctx, root, name, class)
+	}
+	fetch := func(downloadables map[string]string) (bool, error) {
+		ad.Lock()
+		defer ad.Unlock()
+		return ad.fetchLocal(ctx, root, downloadables)
+	}
+
+	return ad.policiesFromGPOs(ctx, objectName, objectClass, list, fetch)
+}
+
+// openLocalSource returns the root directory of the local source, which is extracted if it is an archive.
+// cleanup removes the extracted archive and must always be called.
+func (ad *AD) openLocalSource(ctx context.Context) (root string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	info, err := os.Stat(ad.localSource)
+	if err != nil {
+		return "", cleanup, err
+	}
+	if info.IsDir() {
+		return ad.localSource, cleanup, nil
+	}
+
+	root, err = os.MkdirTemp(ad.sysvolCacheDir, "local-source.*")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() {
+		if err := os.RemoveAll(root); err != nil {
+			log.Info(ctx, gotext.Get("Could not clean up temporary directory:"), err)
+		}
+	}
+	if err := extractTar(ad.localSource, root); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return root, cleanup, nil
+}
+
+// extractTar extracts the directories and regular files of the tar archive p, optionally compressed with gzip,
+// to dest. Any other entry, or any entry out of dest, is an error.
+func extractTar(p, dest string) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't extract archive %s", p))
+
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnError(f.Close)
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, err := r.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer decorate.LogFuncOnError(gz.Close)
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if name == "." {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return errors.New(gotext.Get("entry %q is out of the archive", hdr.Name))
+		}
+		target := filepath.Join(dest, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		default:
+			return errors.New(gotext.Get("unsupported type %q for entry %q", hdr.Typeflag, hdr.Name))
+		}
+	}
+}
+
+// writeFile creates p, only readable by the owner, with the content of r.
+func writeFile(p string, r io.Reader) (err error) {
+	f, err := os.OpenFile(filepath.Clean(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	// #nosec G110 - the archive is provided by the administrator
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// listLocalGPOs returns the GPOs applied to objectName, from the highest priority to the lowest, with its directory
+// attributes by lower-cased name, from the GPO list of the object in the local source at root.
+func (ad *AD) listLocalGPOs(ctx context.Context, root, objectName string, objectClass ObjectClass) (orderedGPOs []gpo, attributes map[string]string, err error) {
+	if !filepath.IsLocal(objectName) {
+		return nil, nil, errors.New(gotext.Get("invalid object name %q for local source", objectName))
+	}
+	p := filepath.Join(root, localGPOListDir, objectName)
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		p = filepath.Join(root, localGPOListDir, string(objectClass))
+	}
+
+	log.Debugf(ctx, "Getting gpo list of %q from %s", objectName, p)
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return nil, nil, errors.New(gotext.Get("no GPO list for %q in local source: %v", objectName, err))
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	return ad.parseGPOList(ctx, objectName, f)
+}
+
+// fetchLocal refreshes the sysvol cache with the GPOs and assets, by name, at their relative path in the local
+// source at root. Only the ones whose version is higher than the cached one are copied.
+// It returns if the assets were refreshed or not.
+func (ad *AD) fetchLocal(ctx context.Context, root string, downloadables map[string]string) (assetsWereRefreshed bool, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't copy all gpos and assets from local source"))
+
+	for name, relPath := range downloadables {
+		if !filepath.IsLocal(relPath) {
+			return false, errors.New(gotext.Get("path %q of %q is out of the local source", relPath, name))
+		}
+		src := filepath.Join(root, relPath)
+
+		g := ad.downloadable(name, relPath)
+		dest := filepath.Join(ad.sysvolCacheDir, "Policies", filepath.Base(relPath))
+		if g.isAssets {
+			dest = filepath.Join(ad.sysvolCacheDir, "assets")
+		}
+
+		refreshed, err := copyIfNewer(ctx, g, src, dest)
+		if err != nil {
+			return false, err
+		}
+		if g.isAssets && refreshed {
+			assetsWereRefreshed = true
+		}
+	}
+
+	return assetsWereRefreshed, nil
+}
+
+// copyIfNewer replaces dest with a copy of the GPO or assets of g at src if its version is higher than the one of
+// dest. Assets without a GPT.INI in src are removed from dest.
+// It returns if dest was changed.
+func copyIfNewer(ctx context.Context, g *downloadable, src, dest string) (changed bool, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't copy %q", g.name))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	srcGPTIni, err := findLocalGPTIni(src)
+	if err != nil {
+		if !g.isAssets {
+			return false, err
+		}
+		log.Info(ctx, "No assets directory with GPT.INI file found in local source, skipping assets copy")
+		if _, err := os.Stat(dest); err != nil {
+			return false, nil
+		}
+		// we remove the assets existing directory. We need to repack the db.
+		return true, os.RemoveAll(dest)
+	}
+	srcVersion, err := gptIniVersion(ctx, srcGPTIni, g.name)
+	if err != nil {
+		return false, err
+	}
+
+	// Unlike downloads, a GPO missing from the cache is always copied, even without version.
+	destVersion := -1
+	if destGPTIni, err := findLocalGPTIni(dest); err == nil {
+		if destVersion, err = gptIniVersion(ctx, destGPTIni, g.name); err != nil {
+			log.Warningf(ctx, "Invalid local GPT.INI for %s: %v\nCopying it again…", g.name, err)
+			destVersion = -1
+		}
+	}
+
+	log.Debugf(ctx, "Local version for %q: %d, local source version: %d", g.name, destVersion, srcVersion)
+	if destVersion >= srcVersion {
+		if g.isAssets {
+			log.Info(ctx, gotext.Get("Assets directory is already up to date"))
+		} else {
+			log.Info(ctx, gotext.Get("GPO %q is already up to date", g.name))
+		}
+		return false, nil
+	}
+
+	log.Infof(ctx, "Copying %q from local source", g.name)
+	tmpdest, err := os.MkdirTemp(filepath.Dir(dest), fmt.Sprintf("%s.*", filepath.Base(dest)))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpdest); err != nil {
+			log.Info(ctx, gotext.Get("Could not clean up temporary directory:"), err)
+		}
+	}()
+	if err := copyDir(src, tmpdest); err != nil {
+		return false, err
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpdest, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// gptIniVersion returns the version of the GPO in the GPT.INI file at p.
+func gptIniVersion(ctx context.Context, p, name string) (int, error) {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return 0, err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	return getGPOVersion(ctx, f, name)
+}
+
+// copyDir copies the directories and regular files of src to the existing dest directory.
+func copyDir(src, dest string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0700)
+		case d.Type().IsRegular():
+			f, err := os.Open(filepath.Clean(p))
+			if err != nil {
+				return err
+			}
+			defer decorate.LogFuncOnError(f.Close)
+			return writeFile(target, f)
+		default:
+			return errors.New(gotext.Get("unsupported type %q for %q", d.Type(), p))
+		}
+	})
+}
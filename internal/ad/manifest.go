@@ -0,0 +1,96 @@
+package ad
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestEntry is the state of a downloaded file when it was last synchronised.
+type manifestEntry struct {
+	Size  int64  `yaml:"size"`
+	Mtime int64  `yaml:"mtime"`
+	Hash  string `yaml:"sha256"`
+}
+
+// manifest lists the files of a downloadable by their slash-separated path, relative to its root.
+type manifest map[string]manifestEntry
+
+// loadManifest returns the manifest stored at p, which is empty if there is none.
+func loadManifest(p string) (m manifest, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't load manifest %s", p))
+
+	d, err := os.ReadFile(filepath.Clean(p))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(d, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = manifest{}
+	}
+	return m, nil
+}
+
+// save atomically stores the manifest at p.
+func (m manifest) save(p string) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't save manifest %s", p))
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	d, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// reuseLocal links or copies to dest the previously downloaded file at src, if its content still has the hash
+// recorded in the manifest. It returns if the file could be reused.
+func reuseLocal(src, dest, hash string) bool {
+	f, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil || hex.EncodeToString(h.Sum(nil)) != hash {
+		return false
+	}
+
+	// Downloaded files are never modified in place, only replaced, so they can be shared with the new tree.
+	if err := os.Link(src, dest); err == nil {
+		return true
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(dst, f)
+	if cerr := dst.Close(); err != nil || cerr != nil {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// checkOfflineStaleness returns the cached policies of objectName to apply while offline, depending on the
+// configured action if some of their rule types are older than allowed.
+// With OfflineStaleRefuse, cached is closed and an error is returned.
+func (ad *AD) checkOfflineStaleness(ctx context.Context, objectName string, objectClass ObjectClass, cached policies.Policies) (policies.Policies, error) {
+	stale := ad.staleRuleTypes(cached, time.Now())
+	if len(stale) == 0 {
+		return cached, nil
+	}
+
+	age := gotext.Get("unknown")
+	if !cached.OnlineRefresh.IsZero() {
+		age = time.Since(cached.OnlineRefresh).Round(time.Minute).String()
+	}
+
+	switch ad.offlineStaleAction {
+	case OfflineStaleRefuse:
+		if err := cached.Close(); err != nil {
+			log.Warning(ctx, err)
+		}
+		return policies.Policies{}, errors.New(gotext.Get("machine is offline and the cached policies of %q are older than allowed for %s (last online refresh: %s ago)",
+			objectName, strings.Join(stale, ", "), age))
+
+	case OfflineStaleFallback:
+		fallback, err := policies.NewFromCache(ctx, filepath.Join(ad.offlineFallbackDir, string(objectClass)))
+		if err != nil {
+			if errClose := cached.Close(); errClose != nil {
+				log.Warning(ctx, errClose)
+			}
+			return policies.Policies{}, errors.New(gotext.Get("machine is offline and the cached policies of %q are older than allowed for %s, but the fallback policies can't be loaded: %v",
+				objectName, strings.Join(stale, ", "), err))
+		}
+		// Only the rules of the fallback policies are used, the assets are the cached ones.
+		if err := fallback.Close(); err != nil {
+			log.Warning(ctx, err)
+		}
+		log.Warning(ctx, gotext.Get("Cached policies of %q are older than allowed for %s (last online refresh: %s ago): applying the fallback policies instead",
+			objectName, strings.Join(stale, ", "), age))
+		return withFallbackRules(cached, fallback, stale), nil
+
+	default:
+		log.Warning(ctx, gotext.Get("Cached policies of %q are applied while offline, but they are older than allowed for %s (last online refresh: %s ago)",
+			objectName, strings.Join(stale, ", "), age))
+		return cached, nil
+	}
+}
+
+// staleRuleTypes returns the sorted rule types of pols whose last online refresh is older than their maximum age
+// at now. Policies without an online refresh time, cached by previous versions, are stale if a maximum age is set.
+func (ad *AD) staleRuleTypes(pols policies.Policies, now time.Time) (stale []string) {
+	age := now.Sub(pols.OnlineRefresh)
+	for _, g := range pols.GPOs {
+		for t, rules := range g.Rules {
+			if len(rules) == 0 || slices.Contains(stale, t) {
+				continue
+			}
+			maxAge := ad.offlineMaxAge
+			if d, ok := ad.offlineMaxAgeTypes[t]; ok {
+				maxAge = d
+			}
+			if maxAge == 0 {
+				continue
+			}
+			if pols.OnlineRefresh.IsZero() || age > maxAge {
+				stale = append(stale, t)
+			}
+		}
+	}
+	slices.Sort(stale)
+	return stale
+}
+
+// withFallbackRules returns pols where the rules of the stale types are replaced by the ones of fallback.
+// The fallback GPOs take precedence over the cached ones. The returned policies are transient, so that the cache keeps
+// the rules downloaded during the last online refresh.
+func withFallbackRules(pols, fallback policies.Policies, stale []string) policies.Policies {
+	var gpos []policies.GPO
+	for _, g := range fallback.GPOs {
+		rules := make(map[string][]entry.Entry)
+		for t, r := range g.Rules {
+			if slices.Contains(stale, t) {
+				rules[t] = r
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		gpos = append(gpos, policies.GPO{ID: g.ID, Name: g.Name, Rules: rules})
+	}
+
+	for _, g := range pols.GPOs {
+		rules := make(map[string][]entry.Entry)
+		for t, r := range g.Rules {
+			if !slices.Contains(stale, t) {
+				rules[t] = r
+			}
+		}
+		gpos = append(gpos, policies.GPO{ID: g.ID, Name: g.Name, Rules: rules})
+	}
+
+	pols.GPOs = gpos
+	pols.Transient = true
+	return pols
+}
@@ -14,6 +14,13 @@ func withGPOListCmd(cmd []string) Option {
 	}
 }
 
+func withResolver(r Resolver) Option {
+	return func(o *options) error {
+		o.resolver = r
+		return nil
+	}
+}
+
 // WithVersionID specifies a personalized release id.
 func WithVersionID(versionID string) Option {
 	return func(o *options) error {
@@ -21,3 +28,11 @@ func WithVersionID(versionID string) Option {
 		return nil
 	}
 }
+
+// WithSystemRoot specifies a personalized machine root directory, to evaluate the WMI filters against.
+func WithSystemRoot(root string) Option {
+	return func(o *options) error {
+		o.systemRoot = root
+		return nil
+	}
+}
@@ -53,6 +53,7 @@ type meta struct {
 	Empty    string
 	Meta     string
 	Strategy string
+	Target   string
 }
 
 // DecodePolicy parses a policy stream in registry file format and returns a slice of entries.
@@ -164,6 +165,7 @@ func DecodePolicy(rs io.ReadSeeker) (entries []entry.Entry, err error) {
 			Disabled: disabled,
 			Meta:     metaValues[e.key].Meta,
 			Strategy: metaValues[e.key].Strategy,
+			Target:   metaValues[e.key].Target,
 			Err:      e.err,
 		})
 	}
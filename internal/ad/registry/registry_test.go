@@ -116,6 +116,16 @@ func TestDecodePolicy(t *testing.T) {
 					Strategy: "override",
 				},
 			}},
+		"basic type with target": {
+			want: []entry.Entry{
+				{
+					Key:      `Software/Policies/Ubuntu/privilege/allow-local-admins/all`,
+					Value:    "",
+					Meta:     "foo",
+					Strategy: "override",
+					Target:   `group("Domain Admins") and laptop()`,
+				},
+			}},
 		"basic type is ignored for meta of wrong type": {
 			want: nil},
 
@@ -0,0 +1,98 @@
+package ad
+
+/*
+#include <errno.h>
+#include <stdlib.h>
+#include <sys/stat.h>
+
+#include <libsmbclient.h>
+
+SMBCCTX *new_stat_context(int use_kerberos) {
+  SMBCCTX *c = smbc_new_context();
+  if (c == NULL) {
+    return NULL;
+  }
+  if (use_kerberos) {
+    smbc_setOptionUseKerberos(c, 1);
+  }
+  if (smbc_init_context(c) == NULL) {
+    int err = errno;
+    smbc_free_context(c, 1);
+    errno = err;
+    return NULL;
+  }
+  return c;
+}
+
+int stat_file(SMBCCTX *c, const char *url, long long *size, long long *mtime) {
+  struct stat st;
+  smbc_stat_fn stat_fn = smbc_getFunctionStat(c);
+  if (stat_fn == NULL) {
+    errno = ENOSYS;
+    return -1;
+  }
+  if (stat_fn(c, url, &st) < 0) {
+    return -1;
+  }
+  *size = st.st_size;
+  *mtime = st.st_mtime;
+  return 0;
+}
+*/
+// #cgo pkg-config: smbclient
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/leonelquinteros/gotext"
+)
+
+// smbStater gets the size and modification time of remote files, which the libsmbclient bindings don’t expose.
+// It uses its own samba context, authenticated the same way as the download client.
+type smbStater struct {
+	// libsmbclient is not thread safe
+	mu  sync.Mutex
+	ctx *C.SMBCCTX
+}
+
+// newSMBStater returns a new smbStater, which should be closed after use.
+func newSMBStater(useKerberos bool) (s *smbStater, err error) {
+	var kerberos C.int
+	if useKerberos {
+		kerberos = 1
+	}
+	ctx, err := C.new_stat_context(kerberos)
+	if ctx == nil {
+		return nil, errors.New(gotext.Get("can't create samba context: %v", err))
+	}
+	return &smbStater{ctx: ctx}, nil
+}
+
+// stat returns the size and modification time, in seconds since the epoch, of the remote file at url.
+func (s *smbStater) stat(url string) (size, mtime int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cURL := C.CString(url)
+	defer C.free(unsafe.Pointer(cURL))
+
+	var cSize, cMtime C.longlong
+	if ret, err := C.stat_file(s.ctx, cURL, &cSize, &cMtime); ret < 0 {
+		return 0, 0, errors.New(gotext.Get("can't get status of %s: %v", url, err))
+	}
+	return int64(cSize), int64(cMtime), nil
+}
+
+// close releases the samba context.
+func (s *smbStater) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx != nil {
+		C.smbc_free_context(s.ctx, 1)
+		s.ctx = nil
+	}
+}
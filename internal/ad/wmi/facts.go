@@ -0,0 +1,196 @@
+package wmi
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+)
+
+// LocalFacts returns the instances of the supported classes for the machine, from os-release, the DMI
+// information, /proc/meminfo, /proc/cpuinfo and its hostname.
+// root is the root directory of the machine, which is / except for tests.
+// Properties that can't be read on the machine, like DMI information on some virtual machines, have no value.
+func LocalFacts(root, hostname string) (facts Facts, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't get WMI facts"))
+
+	osRelease, err := readKeyValues(filepath.Join(root, "etc", "os-release"), "=")
+	if err != nil {
+		return nil, err
+	}
+	meminfo, err := readKeyValues(filepath.Join(root, "proc", "meminfo"), ":")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	cpuinfo, logicalProcessors, err := readCPUInfo(filepath.Join(root, "proc", "cpuinfo"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	batteries, err := batteries(root)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname = strings.Split(hostname, ".")[0]
+	dmi := func(name string) []string {
+		return values(readFile(filepath.Join(root, "sys", "class", "dmi", "id", name)))
+	}
+
+	// MemTotal is in kB.
+	var memoryKB, memoryBytes string
+	if kb, err := strconv.ParseUint(strings.TrimSuffix(meminfo["MemTotal"], " kB"), 10, 64); err == nil {
+		memoryKB = strconv.FormatUint(kb, 10)
+		memoryBytes = strconv.FormatUint(kb*1024, 10)
+	}
+
+	// The computer is mobile if it has a battery, a desktop otherwise.
+	pcSystemType := "1"
+	if len(batteries) > 0 {
+		pcSystemType = "2"
+	}
+
+	var nLogicalProcessors string
+	if logicalProcessors > 0 {
+		nLogicalProcessors = strconv.Itoa(logicalProcessors)
+	}
+
+	osArchitecture := "64-bit"
+	if strconv.IntSize == 32 {
+		osArchitecture = "32-bit"
+	}
+
+	facts = Facts{
+		"win32_operatingsystem": {{
+			"caption":                values(osRelease["PRETTY_NAME"]),
+			"name":                   values(osRelease["PRETTY_NAME"]),
+			"version":                values(osRelease["VERSION_ID"]),
+			"osarchitecture":         {osArchitecture},
+			"producttype":            {"1"}, // Workstation
+			"csname":                 {hostname},
+			"totalvisiblememorysize": values(memoryKB),
+		}},
+		"win32_computersystem": {{
+			"name":                      {hostname},
+			"dnshostname":               {hostname},
+			"manufacturer":              dmi("sys_vendor"),
+			"model":                     dmi("product_name"),
+			"pcsystemtype":              {pcSystemType},
+			"totalphysicalmemory":       values(memoryBytes),
+			"numberoflogicalprocessors": values(nLogicalProcessors),
+		}},
+		"win32_systemenclosure": {{
+			"chassistypes": dmi("chassis_type"),
+			"manufacturer": dmi("chassis_vendor"),
+		}},
+		"win32_processor": {{
+			"name":                      values(cpuinfo["model name"]),
+			"manufacturer":              values(cpuinfo["vendor_id"]),
+			"numberofcores":             values(cpuinfo["cpu cores"]),
+			"numberoflogicalprocessors": values(nLogicalProcessors),
+		}},
+	}
+	for _, b := range batteries {
+		facts["win32_battery"] = append(facts["win32_battery"], Instance{"name": {b}})
+	}
+
+	return facts, nil
+}
+
+// values returns v as the values of a property, which has none if v is empty.
+func values(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// readFile returns the trimmed content of p, or an empty string if it can't be read.
+func readFile(p string) string {
+	d, err := os.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(d))
+}
+
+// readKeyValues returns the unquoted values of the lines of p in the form key<sep>value, by key.
+// The first value of a key wins.
+func readKeyValues(p, sep string) (map[string]string, error) {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return nil, err
+	}
+	defer decorate.LogFuncOnError(f.Close)
+
+	r := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, found := strings.Cut(scanner.Text(), sep)
+		if !found {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if _, exists := r[k]; exists {
+			continue
+		}
+		r[k] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readCPUInfo returns the fields of the first processor of cpuinfo at p and the number of processors listed.
+func readCPUInfo(p string) (fields map[string]string, processors int, err error) {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer decorate.LogFuncOnError(f.Close)
+
+	fields = make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if k == "processor" {
+			processors++
+		}
+		if _, exists := fields[k]; !exists {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return fields, processors, nil
+}
+
+// batteries returns the names of the power supplies of the machine which are batteries.
+func batteries(root string) ([]string, error) {
+	powerSupplies := filepath.Join(root, "sys", "class", "power_supply")
+	entries, err := os.ReadDir(powerSupplies)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var r []string
+	for _, e := range entries {
+		if readFile(filepath.Join(powerSupplies, e.Name(), "type")) == "Battery" {
+			r = append(r, e.Name())
+		}
+	}
+	return r, nil
+}
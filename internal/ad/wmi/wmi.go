@@ -0,0 +1,461 @@
+// Package wmi evaluates the WMI filters linked to GPOs against the facts of the local machine.
+//
+// A WMI filter is a list of WQL queries, all of which must return at least one instance for the GPO to apply.
+// Only a subset of WQL is supported, on a subset of the root\CIMv2 classes mapped to Linux facts:
+//
+//	SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%' AND Version >= '22.04'
+//	SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9 OR ChassisTypes = 10
+//
+// Conditions compare a property with =, !=, <>, <, <=, >, >= or LIKE, in which % matches any sequence of
+// characters and _ any single character, or check it with IS [NOT] NULL. They are combined with AND, OR, NOT
+// and parentheses. Strings are compared case-insensitively, and numerically when both sides are numbers.
+// A condition on an array property, like ChassisTypes, matches if any of its values does.
+//
+// Any other class, property or syntax can't be evaluated and returns an error.
+package wmi
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+)
+
+// Instance is an instance of a WMI class, with the values of its properties by lower-cased name.
+// Array properties have several values and unknown properties none.
+type Instance map[string][]string
+
+// Facts are the instances of the supported WMI classes, by lower-cased class name.
+type Facts map[string][]Instance
+
+// classes are the supported properties, lower-cased, of the supported classes.
+var classes = map[string][]string{
+	"win32_operatingsystem": {"caption", "name", "version", "osarchitecture", "producttype", "csname", "totalvisiblememorysize"},
+	"win32_computersystem":  {"name", "dnshostname", "manufacturer", "model", "pcsystemtype", "totalphysicalmemory", "numberoflogicalprocessors"},
+	"win32_systemenclosure": {"chassistypes", "manufacturer"},
+	"win32_processor":       {"name", "manufacturer", "numberofcores", "numberoflogicalprocessors"},
+	"win32_battery":         {"name"},
+}
+
+// Evaluate returns if the WQL query in namespace returns at least one instance of facts.
+// It returns an error if the query can't be evaluated.
+func Evaluate(namespace, query string, facts Facts) (match bool, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't evaluate WMI query %q", query))
+
+	if namespace != "" && !strings.EqualFold(namespace, `root\CIMv2`) {
+		return false, errors.New(gotext.Get("unsupported namespace %q", namespace))
+	}
+
+	class, where, err := parse(query)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(facts[class], func(i Instance) bool {
+		return where == nil || where.eval(i)
+	}), nil
+}
+
+// node is an element of a parsed WHERE clause.
+type node interface {
+	eval(Instance) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(i Instance) bool { return n.left.eval(i) && n.right.eval(i) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(i Instance) bool { return n.left.eval(i) || n.right.eval(i) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(i Instance) bool { return !n.operand.eval(i) }
+
+// nullNode checks if property has no value.
+type nullNode struct{ property string }
+
+func (n nullNode) eval(i Instance) bool { return len(i[n.property]) == 0 }
+
+// compareNode compares any value of property with a literal.
+type compareNode struct {
+	property string
+	matches  func(value string) bool
+}
+
+func (n compareNode) eval(i Instance) bool { return slices.ContainsFunc(i[n.property], n.matches) }
+
+// comparison returns the function comparing a value with literal using op.
+func comparison(op, literal string) (func(string) bool, error) {
+	if op == "like" {
+		var pattern strings.Builder
+		pattern.WriteString("(?is)^")
+		for _, c := range literal {
+			switch c {
+			case '%':
+				pattern.WriteString(".*")
+			case '_':
+				pattern.WriteString(".")
+			default:
+				pattern.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		pattern.WriteString("$")
+		re := regexp.MustCompile(pattern.String())
+		return re.MatchString, nil
+	}
+
+	var matches func(int) bool
+	switch op {
+	case "=":
+		matches = func(c int) bool { return c == 0 }
+	case "!=", "<>":
+		matches = func(c int) bool { return c != 0 }
+	case "<":
+		matches = func(c int) bool { return c < 0 }
+	case "<=":
+		matches = func(c int) bool { return c <= 0 }
+	case ">":
+		matches = func(c int) bool { return c > 0 }
+	case ">=":
+		matches = func(c int) bool { return c >= 0 }
+	default:
+		return nil, errors.New(gotext.Get("unsupported operator %q", op))
+	}
+
+	return func(value string) bool { return matches(compare(value, literal)) }, nil
+}
+
+// compare returns the comparison of a and b, numerically if both are numbers.
+func compare(a, b string) int {
+	na, errA := strconv.ParseFloat(a, 64)
+	nb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// parser is a recursive descent parser of WQL queries.
+type parser struct {
+	tokens []token
+	pos    int
+	// property returns the lower-cased name of a property of the queried class, or an error if it is unsupported.
+	property func(name string) (string, error)
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+	tokenStar
+	tokenEnd
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// parse returns the lower-cased class queried and the WHERE clause, nil if there is none.
+func parse(query string) (class string, where node, err error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return "", nil, err
+	}
+	p := parser{tokens: tokens}
+
+	if !p.isKeyword("select") {
+		return "", nil, errors.New(gotext.Get("only SELECT queries are supported"))
+	}
+	p.next()
+
+	// Properties are checked once the class is known.
+	var selected []string
+	for {
+		t := p.next()
+		if t.kind != tokenStar && t.kind != tokenIdent {
+			return "", nil, errors.New(gotext.Get("unexpected %q in selected properties", t.value))
+		}
+		if t.kind == tokenIdent {
+			selected = append(selected, t.value)
+		}
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.next()
+	}
+
+	if !p.isKeyword("from") {
+		return "", nil, errors.New(gotext.Get("missing FROM"))
+	}
+	p.next()
+	t := p.next()
+	if t.kind != tokenIdent {
+		return "", nil, errors.New(gotext.Get("missing class name"))
+	}
+	class = strings.ToLower(t.value)
+	properties, ok := classes[class]
+	if !ok {
+		return "", nil, errors.New(gotext.Get("unsupported class %q", t.value))
+	}
+	p.property = func(name string) (string, error) {
+		name = strings.ToLower(name)
+		if !slices.Contains(properties, name) {
+			return "", errors.New(gotext.Get("unsupported property %q of %q", name, class))
+		}
+		return name, nil
+	}
+	for _, s := range selected {
+		if _, err := p.property(s); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if p.isKeyword("where") {
+		p.next()
+		if where, err = p.parseOr(); err != nil {
+			return "", nil, err
+		}
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return "", nil, errors.New(gotext.Get("unexpected %q", t.value))
+	}
+
+	return class, where, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword returns if the next token is the keyword k.
+func (p *parser) isKeyword(k string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.value, k)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenOpen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenClose {
+			return nil, errors.New(gotext.Get("missing closing parenthesis"))
+		}
+		return n, nil
+	case tokenIdent:
+		return p.parseCondition(t.value)
+	case tokenEnd:
+		return nil, errors.New(gotext.Get("unexpected end of query"))
+	}
+	return nil, errors.New(gotext.Get("unexpected %q", t.value))
+}
+
+// parseCondition parses the condition on property name.
+func (p *parser) parseCondition(name string) (node, error) {
+	property, err := p.property(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("is") {
+		p.next()
+		not := p.isKeyword("not")
+		if not {
+			p.next()
+		}
+		if !p.isKeyword("null") {
+			return nil, errors.New(gotext.Get("IS must be followed by NULL or NOT NULL"))
+		}
+		p.next()
+		var n node = nullNode{property}
+		if not {
+			n = notNode{n}
+		}
+		return n, nil
+	}
+
+	var op string
+	switch t := p.next(); {
+	case t.kind == tokenOperator:
+		op = t.value
+	case t.kind == tokenIdent && strings.EqualFold(t.value, "like"):
+		op = "like"
+	default:
+		return nil, errors.New(gotext.Get("missing operator after %q", name))
+	}
+
+	var literal string
+	switch t := p.next(); {
+	case t.kind == tokenString:
+		literal = t.value
+	case op == "like":
+		return nil, errors.New(gotext.Get("LIKE pattern must be a string"))
+	case t.kind == tokenNumber:
+		literal = t.value
+	case t.kind == tokenIdent && (strings.EqualFold(t.value, "true") || strings.EqualFold(t.value, "false")):
+		literal = strings.ToLower(t.value)
+	default:
+		return nil, errors.New(gotext.Get("%q must be compared with a string, a number or a boolean", name))
+	}
+
+	matches, err := comparison(op, literal)
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{property: property, matches: matches}, nil
+}
+
+// tokenize splits query into tokens, ending with a tokenEnd one.
+func tokenize(query string) (tokens []token, err error) {
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, value: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, value: ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, value: ","})
+			i++
+		case c == '*':
+			tokens = append(tokens, token{kind: tokenStar, value: "*"})
+			i++
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(query) && (query[i+1] == '=' || (c == '<' && query[i+1] == '>')) {
+				op = query[i : i+2]
+			}
+			if op == "!" {
+				return nil, errors.New(gotext.Get("unexpected character %q", c))
+			}
+			tokens = append(tokens, token{kind: tokenOperator, value: op})
+			i += len(op)
+		case c == '\'' || c == '"':
+			var s strings.Builder
+			quote := c
+			i++
+			for {
+				if i >= len(query) {
+					return nil, errors.New(gotext.Get("unterminated string"))
+				}
+				c := query[i]
+				if c == quote {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(query) && (query[i+1] == quote || query[i+1] == '\\') {
+					i++
+					c = query[i]
+				}
+				s.WriteByte(c)
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, value: s.String()})
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			for i < len(query) && (query[i] == '.' || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			if _, err := strconv.ParseFloat(query[start:i], 64); err != nil {
+				return nil, errors.New(gotext.Get("invalid number %q", query[start:i]))
+			}
+			tokens = append(tokens, token{kind: tokenNumber, value: query[start:i]})
+		case isIdentChar(c):
+			start := i
+			for i < len(query) && (isIdentChar(query[i]) || (query[i] >= '0' && query[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: query[start:i]})
+		default:
+			return nil, errors.New(gotext.Get("unexpected character %q", c))
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(gotext.Get("empty query"))
+	}
+
+	return append(tokens, token{kind: tokenEnd}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
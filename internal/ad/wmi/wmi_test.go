@@ -0,0 +1,247 @@
+package wmi_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/ad/wmi"
+)
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	facts := wmi.Facts{
+		"win32_operatingsystem": {{
+			"caption":        {"Ubuntu 24.04 LTS"},
+			"version":        {"24.04"},
+			"osarchitecture": {"64-bit"},
+		}},
+		"win32_computersystem": {{
+			"name":                {"ws-01"},
+			"totalphysicalmemory": {"17179869184"},
+		}},
+		"win32_systemenclosure": {{
+			"chassistypes": {"3", "10"},
+		}},
+		"win32_processor": {{
+			"name": {"Intel(R) Core(TM) i7 CPU"},
+		}},
+	}
+
+	tests := map[string]struct {
+		namespace string
+		query     string
+		facts     wmi.Facts
+
+		want    bool
+		wantErr bool
+	}{
+		// Conditions
+		"Equal":                               {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = '24.04'`, want: true},
+		"Equal with double quotes":            {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = "24.04"`, want: true},
+		"Equal is case-insensitive":           {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption = 'ubuntu 24.04 lts'`, want: true},
+		"Not equal":                           {query: `SELECT * FROM Win32_OperatingSystem WHERE Version != '22.04'`, want: true},
+		"Not equal with <>":                   {query: `SELECT * FROM Win32_OperatingSystem WHERE Version <> '24.04'`, want: false},
+		"Greater or equal":                    {query: `SELECT * FROM Win32_OperatingSystem WHERE Version >= '22.04'`, want: true},
+		"Greater":                             {query: `SELECT * FROM Win32_OperatingSystem WHERE Version > '24.10'`, want: false},
+		"Lower":                               {query: `SELECT * FROM Win32_OperatingSystem WHERE Version < '24.10'`, want: true},
+		"Lower or equal":                      {query: `SELECT * FROM Win32_OperatingSystem WHERE Version <= '23.10'`, want: false},
+		"Numbers are compared numerically":    {query: `SELECT * FROM Win32_ComputerSystem WHERE TotalPhysicalMemory >= 8589934592`, want: true},
+		"Strings are compared alphabetically": {query: `SELECT * FROM Win32_ComputerSystem WHERE Name > 'WS-00'`, want: true},
+		"Like":                                {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%'`, want: true},
+		"Like is case-insensitive":            {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption like '%ubuntu 24.__ LTS'`, want: true},
+		"Like matches the whole value":        {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE 'Ubuntu'`, want: false},
+		"Like with special characters":        {query: `SELECT * FROM Win32_Processor WHERE Name LIKE '%(R) Core(TM)%'`, want: true},
+		"Any value of an array matches":       {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 10`, want: true},
+		"No value of an array matches":        {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9`, want: false},
+		"Is null":                             {query: `SELECT * FROM Win32_ComputerSystem WHERE Model IS NULL`, want: true},
+		"Is not null":                         {query: `SELECT * FROM Win32_ComputerSystem WHERE Model IS NOT NULL`, want: false},
+		"Missing value never compares":        {query: `SELECT * FROM Win32_ComputerSystem WHERE Model != 'Laptop'`, want: false},
+		"Boolean":                             {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = TRUE`, want: false},
+
+		// Queries
+		"Without WHERE on class with instances":    {query: `SELECT * FROM Win32_Processor`, want: true},
+		"Without WHERE on class without instances": {query: `SELECT * FROM Win32_Battery`, want: false},
+		"Selected properties":                      {query: `SELECT Caption, Version FROM Win32_OperatingSystem WHERE Version = '24.04'`, want: true},
+		"Keywords and names are case-insensitive":  {query: `select * from WIN32_operatingsystem where VERSION = '24.04'`, want: true},
+		"Namespace is case-insensitive":            {namespace: `ROOT\cimv2`, query: `SELECT * FROM Win32_Processor`, want: true},
+		"Spaces and new lines":                     {query: "SELECT *\r\nFROM\tWin32_OperatingSystem WHERE Version='24.04' ", want: true},
+		"Escaped quotes in strings":                {query: `SELECT * FROM Win32_ComputerSystem WHERE Name = 'ws-\'01'`, facts: wmi.Facts{"win32_computersystem": {{"name": {"ws-'01"}}}}, want: true},
+
+		// Operators
+		"And":                         {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%' AND Version = '24.04'`, want: true},
+		"And with one false operand":  {query: `SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%' AND Version = '22.04'`, want: false},
+		"Or":                          {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9 OR ChassisTypes = 10`, want: true},
+		"Or with both false operands": {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9 OR ChassisTypes = 14`, want: false},
+		"Not":                         {query: `SELECT * FROM Win32_OperatingSystem WHERE NOT Version = '22.04'`, want: true},
+		"And binds tighter than or":   {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = '24.04' OR Version = '24.04' AND Version = '22.04'`, want: true},
+		"Parentheses":                 {query: `SELECT * FROM Win32_OperatingSystem WHERE (Version = '24.04' OR Version = '24.04') AND Version = '22.04'`, want: false},
+		"One matching instance is enough": {query: `SELECT * FROM Win32_Battery WHERE Name = 'BAT1'`,
+			facts: wmi.Facts{"win32_battery": {{"name": {"BAT0"}}, {"name": {"BAT1"}}}}, want: true},
+
+		// Error cases
+		"Error on empty query":                  {query: " ", wantErr: true},
+		"Error on unsupported namespace":        {namespace: `root\SecurityCenter2`, query: `SELECT * FROM Win32_Processor`, wantErr: true},
+		"Error on unsupported statement":        {query: `DELETE FROM Win32_Processor`, wantErr: true},
+		"Error on unsupported class":            {query: `SELECT * FROM Win32_Product WHERE Name = 'Office'`, wantErr: true},
+		"Error on unsupported property":         {query: `SELECT * FROM Win32_OperatingSystem WHERE BuildNumber = '19045'`, wantErr: true},
+		"Error on unsupported selected":         {query: `SELECT BuildNumber FROM Win32_OperatingSystem`, wantErr: true},
+		"Error on missing FROM":                 {query: `SELECT * Win32_OperatingSystem`, wantErr: true},
+		"Error on missing class":                {query: `SELECT * FROM`, wantErr: true},
+		"Error on missing selected properties":  {query: `SELECT FROM Win32_OperatingSystem`, wantErr: true},
+		"Error on missing condition":            {query: `SELECT * FROM Win32_OperatingSystem WHERE`, wantErr: true},
+		"Error on missing operator":             {query: `SELECT * FROM Win32_OperatingSystem WHERE Version '24.04'`, wantErr: true},
+		"Error on missing value":                {query: `SELECT * FROM Win32_OperatingSystem WHERE Version =`, wantErr: true},
+		"Error on property as value":            {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = Caption`, wantErr: true},
+		"Error on like with a number":           {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes LIKE 9`, wantErr: true},
+		"Error on is without null":              {query: `SELECT * FROM Win32_ComputerSystem WHERE Model IS 'Laptop'`, wantErr: true},
+		"Error on unterminated string":          {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = '24.04`, wantErr: true},
+		"Error on invalid number":               {query: `SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 1.2.3`, wantErr: true},
+		"Error on unexpected character":         {query: `SELECT * FROM Win32_OperatingSystem WHERE Version == '24.04' && Version != ''`, wantErr: true},
+		"Error on missing closing parenthesis":  {query: `SELECT * FROM Win32_OperatingSystem WHERE (Version = '24.04'`, wantErr: true},
+		"Error on trailing tokens":              {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = '24.04' Version`, wantErr: true},
+		"Error even in branch not evaluated":    {query: `SELECT * FROM Win32_OperatingSystem WHERE Version = '24.04' OR BuildNumber = 1`, wantErr: true},
+		"Error on unsupported operator (bang)":  {query: `SELECT * FROM Win32_OperatingSystem WHERE Version ! '24.04'`, wantErr: true},
+		"Error on unsupported operator (other)": {query: `SELECT * FROM Win32_OperatingSystem WHERE Version ISA '24.04'`, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := facts
+			if tc.facts != nil {
+				f = tc.facts
+			}
+
+			got, err := wmi.Evaluate(tc.namespace, tc.query, f)
+			if tc.wantErr {
+				require.Error(t, err, "Evaluate should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Evaluate should return no error but got one")
+			require.Equal(t, tc.want, got, "Evaluate returned an unexpected result")
+		})
+	}
+}
+
+func TestLocalFacts(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		files map[string]string
+
+		want    map[string]wmi.Instance
+		wantErr bool
+	}{
+		"Laptop with all facts": {
+			files: map[string]string{
+				"etc/os-release":                       "PRETTY_NAME=\"Ubuntu 24.04 LTS\"\nNAME=\"Ubuntu\"\nVERSION_ID=\"24.04\"\n",
+				"proc/meminfo":                         "MemTotal:       16384000 kB\nMemFree:         8192000 kB\n",
+				"proc/cpuinfo":                         "processor\t: 0\nvendor_id\t: GenuineIntel\nmodel name\t: Intel(R) Core(TM) i7 CPU\ncpu cores\t: 2\n\nprocessor\t: 1\nvendor_id\t: GenuineIntel\nmodel name\t: Intel(R) Core(TM) i7 CPU\ncpu cores\t: 2\n",
+				"sys/class/dmi/id/chassis_type":        "10\n",
+				"sys/class/dmi/id/chassis_vendor":      "LENOVO\n",
+				"sys/class/dmi/id/sys_vendor":          "LENOVO\n",
+				"sys/class/dmi/id/product_name":        "ThinkPad\n",
+				"sys/class/power_supply/AC/type":       "Mains\n",
+				"sys/class/power_supply/BAT0/type":     "Battery\n",
+				"sys/class/power_supply/hid-0/present": "1\n",
+			},
+			want: map[string]wmi.Instance{
+				"win32_operatingsystem": {
+					"caption":                {"Ubuntu 24.04 LTS"},
+					"name":                   {"Ubuntu 24.04 LTS"},
+					"version":                {"24.04"},
+					"osarchitecture":         {"64-bit"},
+					"producttype":            {"1"},
+					"csname":                 {"ws-01"},
+					"totalvisiblememorysize": {"16384000"},
+				},
+				"win32_computersystem": {
+					"name":                      {"ws-01"},
+					"dnshostname":               {"ws-01"},
+					"manufacturer":              {"LENOVO"},
+					"model":                     {"ThinkPad"},
+					"pcsystemtype":              {"2"},
+					"totalphysicalmemory":       {"16777216000"},
+					"numberoflogicalprocessors": {"2"},
+				},
+				"win32_systemenclosure": {
+					"chassistypes": {"10"},
+					"manufacturer": {"LENOVO"},
+				},
+				"win32_processor": {
+					"name":                      {"Intel(R) Core(TM) i7 CPU"},
+					"manufacturer":              {"GenuineIntel"},
+					"numberofcores":             {"2"},
+					"numberoflogicalprocessors": {"2"},
+				},
+				"win32_battery": {
+					"name": {"BAT0"},
+				},
+			},
+		},
+		"Only os-release": {
+			files: map[string]string{
+				"etc/os-release": "PRETTY_NAME=\"Ubuntu 24.04 LTS\"\nVERSION_ID=\"24.04\"\n",
+			},
+			want: map[string]wmi.Instance{
+				"win32_operatingsystem": {
+					"caption":                {"Ubuntu 24.04 LTS"},
+					"name":                   {"Ubuntu 24.04 LTS"},
+					"version":                {"24.04"},
+					"osarchitecture":         {"64-bit"},
+					"producttype":            {"1"},
+					"csname":                 {"ws-01"},
+					"totalvisiblememorysize": nil,
+				},
+				"win32_computersystem": {
+					"name":                      {"ws-01"},
+					"dnshostname":               {"ws-01"},
+					"manufacturer":              nil,
+					"model":                     nil,
+					"pcsystemtype":              {"1"},
+					"totalphysicalmemory":       nil,
+					"numberoflogicalprocessors": nil,
+				},
+				"win32_systemenclosure": {
+					"chassistypes": nil,
+					"manufacturer": nil,
+				},
+				"win32_processor": {
+					"name":                      nil,
+					"manufacturer":              nil,
+					"numberofcores":             nil,
+					"numberoflogicalprocessors": nil,
+				},
+			},
+		},
+
+		"Error on missing os-release": {wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			for p, content := range tc.files {
+				p = filepath.Join(root, p)
+				require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700), "Setup: can't create directory")
+				require.NoError(t, os.WriteFile(p, []byte(content), 0600), "Setup: can't create file")
+			}
+
+			got, err := wmi.LocalFacts(root, "ws-01.example.com")
+			if tc.wantErr {
+				require.Error(t, err, "LocalFacts should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "LocalFacts should return no error but got one")
+
+			require.Len(t, got, len(tc.want), "LocalFacts should return all the classes with instances")
+			for class, want := range tc.want {
+				require.Equal(t, []wmi.Instance{want}, got[class], "LocalFacts returned unexpected instances of %s", class)
+			}
+		})
+	}
+}
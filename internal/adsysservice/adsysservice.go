@@ -45,6 +45,11 @@ type Service struct {
 
 	bus    *dbus.Conn
 	daemon *daemon.Daemon
+
+	stopDriftCheck          func()
+	stopSubscriptionWatcher func()
+
+	gcGracePeriod time.Duration
 }
 
 type state struct {
@@ -70,11 +75,36 @@ type options struct {
 	apparmorFsDir  string
 	systemUnitDir  string
 	globalTrustDir string
+	pluginsDir     string
 	adBackend      string
 	gpoListTimeout time.Duration
-	sssConfig      sss.Config
-	winbindConfig  winbind.Config
-	authorizer     authorizerer
+	wmiUnknown     string
+	// pluginsTimeout is nil when not configured, as 0 disables the timeout.
+	pluginsTimeout *time.Duration
+
+	offlineMaxAge      time.Duration
+	offlineMaxAgeTypes map[string]time.Duration
+	offlineStaleAction string
+	offlineFallbackDir string
+
+	localSource string
+
+	site            string
+	dcRetries       int
+	dcRetryBackoff  time.Duration
+	downloadTimeout time.Duration
+
+	filesAllowedPrefixes []string
+
+	// historySize and historyMaxAge are nil when not configured, as 0 disables history or its maximum age.
+	historySize   *int
+	historyMaxAge *time.Duration
+	driftInterval time.Duration
+	driftRepair   bool
+	gcGracePeriod time.Duration
+	sssConfig     sss.Config
+	winbindConfig winbind.Config
+	authorizer    authorizerer
 }
 type option func(*options) error
 
@@ -164,6 +194,14 @@ func WithGlobalTrustDir(p string) func(o *options) error {
 	}
 }
 
+// WithPluginsDir specifies a personalized directory for policy manager plugins.
+func WithPluginsDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.pluginsDir = p
+		return nil
+	}
+}
+
 // WithADBackend specifies our specific backend to select.
 func WithADBackend(backend string) func(o *options) error {
 	return func(o *options) error {
@@ -196,6 +234,132 @@ func WithGpoListTimeout(t time.Duration) func(o *options) error {
 	}
 }
 
+// WithWMIUnknownFilters specifies if GPOs whose WMI filter can't be evaluated are applied ("pass") or skipped ("fail").
+func WithWMIUnknownFilters(mode string) func(o *options) error {
+	return func(o *options) error {
+		o.wmiUnknown = mode
+		return nil
+	}
+}
+
+// WithOfflineMaxAge specifies the maximum time since their last online refresh for cached policies to be applied
+// while offline, overridden by rule type with perType. 0 means no limit.
+func WithOfflineMaxAge(maxAge time.Duration, perType map[string]time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.offlineMaxAge = maxAge
+		o.offlineMaxAgeTypes = perType
+		return nil
+	}
+}
+
+// WithOfflineStaleAction specifies if stale cached policies are applied while offline with a warning ("warn"),
+// refused ("refuse") or have their stale rules replaced with the fallback policies ("fallback").
+func WithOfflineStaleAction(action string) func(o *options) error {
+	return func(o *options) error {
+		o.offlineStaleAction = action
+		return nil
+	}
+}
+
+// WithOfflineFallbackDir specifies a personalized directory of the fallback policies applied instead of stale ones.
+func WithOfflineFallbackDir(dir string) func(o *options) error {
+	return func(o *options) error {
+		o.offlineFallbackDir = dir
+		return nil
+	}
+}
+
+// WithLocalSource specifies a directory or archive the policies are read from instead of the directory service.
+func WithLocalSource(p string) func(o *options) error {
+	return func(o *options) error {
+		o.localSource = p
+		return nil
+	}
+}
+
+// WithSite specifies the site of the machine, whose domain controllers are tried first when failing over.
+func WithSite(site string) func(o *options) error {
+	return func(o *options) error {
+		o.site = site
+		return nil
+	}
+}
+
+// WithDCRetries specifies how many times all domain controllers are tried again once none of them can be reached,
+// after an exponential backoff.
+func WithDCRetries(retries int, backoff time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.dcRetries = retries
+		o.dcRetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithDownloadTimeout specifies a custom timeout for the GPOs and assets downloads from a domain controller.
+func WithDownloadTimeout(t time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.downloadTimeout = t
+		return nil
+	}
+}
+
+// WithPluginsTimeout specifies the timeout for policy manager plugins.
+func WithPluginsTimeout(t time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.pluginsTimeout = &t
+		return nil
+	}
+}
+
+// WithFilesAllowedPrefixes specifies the directories the machine files and folders can be deployed to.
+// The default ones of the files manager are used if there is none.
+func WithFilesAllowedPrefixes(prefixes []string) func(o *options) error {
+	return func(o *options) error {
+		o.filesAllowedPrefixes = prefixes
+		return nil
+	}
+}
+
+// WithHistorySize specifies how many applied policies are kept in history per object.
+func WithHistorySize(n int) func(o *options) error {
+	return func(o *options) error {
+		o.historySize = &n
+		return nil
+	}
+}
+
+// WithHistoryMaxAge specifies after how long applied policies are removed from history.
+func WithHistoryMaxAge(d time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.historyMaxAge = &d
+		return nil
+	}
+}
+
+// WithDriftCheckInterval specifies how often applied policies are verified for drift. 0 disables it.
+func WithDriftCheckInterval(d time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.driftInterval = d
+		return nil
+	}
+}
+
+// WithDriftRepair specifies if drift found by the periodic check is repaired by applying policies again.
+func WithDriftRepair(repair bool) func(o *options) error {
+	return func(o *options) error {
+		o.driftRepair = repair
+		return nil
+	}
+}
+
+// WithGCGracePeriod specifies for how long GPOs no longer referenced by any cached policies are kept in cache.
+func WithGCGracePeriod(d time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.gcGracePeriod = d
+		return nil
+	}
+}
+
 // New returns a new instance of an AD service.
 // If url or domain is empty, we load the missing parameters from sssd.conf, taking first
 // domain in the list if not provided.
@@ -252,6 +416,24 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 	}
 
 	adOptions = append(adOptions, ad.WithGpoListTimeout(args.gpoListTimeout))
+	if args.wmiUnknown != "" {
+		adOptions = append(adOptions, ad.WithWMIUnknownFilters(args.wmiUnknown))
+	}
+	adOptions = append(adOptions, ad.WithOfflineMaxAge(args.offlineMaxAge, args.offlineMaxAgeTypes))
+	if args.offlineStaleAction != "" {
+		adOptions = append(adOptions, ad.WithOfflineStaleAction(args.offlineStaleAction))
+	}
+	if args.offlineFallbackDir != "" {
+		adOptions = append(adOptions, ad.WithOfflineFallbackDir(args.offlineFallbackDir))
+	}
+	if args.localSource != "" {
+		adOptions = append(adOptions, ad.WithLocalSource(args.localSource))
+	}
+	if args.site != "" {
+		adOptions = append(adOptions, ad.WithSite(args.site))
+	}
+	adOptions = append(adOptions, ad.WithDCRetries(args.dcRetries, args.dcRetryBackoff))
+	adOptions = append(adOptions, ad.WithDownloadTimeout(args.downloadTimeout))
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -321,6 +503,19 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 	if args.globalTrustDir != "" {
 		policyOptions = append(policyOptions, policies.WithGlobalTrustDir(args.globalTrustDir))
 	}
+	if args.pluginsDir != "" {
+		policyOptions = append(policyOptions, policies.WithPluginsDir(args.pluginsDir))
+	}
+	if args.pluginsTimeout != nil {
+		policyOptions = append(policyOptions, policies.WithPluginsTimeout(*args.pluginsTimeout))
+	}
+	policyOptions = append(policyOptions, policies.WithFilesAllowedPrefixes(args.filesAllowedPrefixes))
+	if args.historySize != nil {
+		policyOptions = append(policyOptions, policies.WithHistorySize(*args.historySize))
+	}
+	if args.historyMaxAge != nil {
+		policyOptions = append(policyOptions, policies.WithHistoryMaxAge(*args.historyMaxAge))
+	}
 	m, err := policies.NewManager(bus, hostname, adBackend, policyOptions...)
 	if err != nil {
 		return nil, err
@@ -329,7 +524,7 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 	// Init system reference time
 	initSysTime := initSystemTime(bus)
 
-	return &Service{
+	s = &Service{
 		adc:           adc,
 		policyManager: m,
 		authorizer:    args.authorizer,
@@ -346,7 +541,30 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 		},
 		initSystemTime: initSysTime,
 		bus:            bus,
-	}, nil
+		stopDriftCheck: func() {},
+		gcGracePeriod:  args.gcGracePeriod,
+	}
+
+	// Apply Ubuntu Pro-only rules, or remove them, as soon as the subscription changes.
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	watchDone := make(chan struct{})
+	go s.watchSubscription(watchCtx, watchDone)
+	s.stopSubscriptionWatcher = func() {
+		cancelWatch()
+		<-watchDone
+	}
+
+	if args.driftInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go s.checkDriftPeriodically(ctx, args.driftInterval, args.driftRepair, done)
+		s.stopDriftCheck = func() {
+			cancel()
+			<-done
+		}
+	}
+
+	return s, nil
 }
 
 // RegisterGRPCServer registers our service with the new interceptor chains.
@@ -366,6 +584,8 @@ func (s *Service) RegisterGRPCServer(d *daemon.Daemon) *grpc.Server {
 
 // Quit cleans every ressources than the service was using.
 func (s *Service) Quit(ctx context.Context) {
+	s.stopDriftCheck()
+	s.stopSubscriptionWatcher()
 	if err := s.bus.Close(); err != nil {
 		log.Warning(ctx, gotext.Get("Can't disconnect system dbus: %v", err))
 	}
@@ -16,6 +16,7 @@ import (
 	"github.com/ubuntu/adsys/internal/ad/backends/winbind"
 	"github.com/ubuntu/adsys/internal/adsysservice"
 	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -123,6 +124,85 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewKeepsPolicyManagerDefaults(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	cacheDir := filepath.Join(temp, "cache")
+	s, err := adsysservice.New(context.Background(),
+		adsysservice.WithCacheDir(cacheDir),
+		adsysservice.WithStateDir(filepath.Join(temp, "var", "lib")),
+		adsysservice.WithRunDir(filepath.Join(temp, "run")),
+		adsysservice.WithDconfDir(filepath.Join(temp, "dconf")),
+		adsysservice.WithSudoersDir(filepath.Join(temp, "sudoers.d")),
+		adsysservice.WithPolicyKitDir(filepath.Join(temp, "polkit-1")),
+		adsysservice.WithApparmorDir(filepath.Join(temp, "apparmor.d", "adsys")),
+		adsysservice.WithApparmorFsDir(filepath.Join(temp, "apparmorfs")),
+		adsysservice.WithSystemUnitDir(filepath.Join(temp, "systemd", "system")),
+		adsysservice.WithGlobalTrustDir(filepath.Join(temp, "ca-certificates")),
+		adsysservice.WithSSSConfig(sss.Config{Conf: "testdata/sssd.conf", CacheDir: t.TempDir()}))
+	require.NoError(t, err, "Setup: New should not return an error")
+	defer s.Quit(context.Background())
+
+	pols, err := policies.New(context.Background(), nil, "")
+	require.NoError(t, err, "Setup: can't create empty policies")
+	err = s.ApplyPolicies(context.Background(), "hostname", true, &pols)
+	require.NoError(t, err, "ApplyPolicies should not return an error")
+
+	// History is kept with the default size and maximum age when they are not configured.
+	history, err := os.ReadDir(filepath.Join(cacheDir, policies.HistoryBaseName, "hostname"))
+	require.NoError(t, err, "Policies should have been added to history")
+	require.Len(t, history, 1, "Policies should have been added to history")
+}
+
+func TestSubscriptionChanged(t *testing.T) {
+	t.Parallel()
+
+	const propertiesChanged = "org.freedesktop.DBus.Properties.PropertiesChanged"
+
+	tests := map[string]struct {
+		name        string
+		path        dbus.ObjectPath
+		iface       string
+		changed     map[string]dbus.Variant
+		invalidated []string
+
+		want bool
+	}{
+		"Attached is changed":     {changed: map[string]dbus.Variant{"Attached": dbus.MakeVariant(true)}, want: true},
+		"Attached is invalidated": {invalidated: []string{"Name", "Attached"}, want: true},
+
+		"Other properties changed": {changed: map[string]dbus.Variant{"Name": dbus.MakeVariant("ua")}, invalidated: []string{"Name"}},
+		"Other interface":          {iface: "com.example.Other", changed: map[string]dbus.Variant{"Attached": dbus.MakeVariant(true)}},
+		"Other object":             {path: "/com/example/Other", changed: map[string]dbus.Variant{"Attached": dbus.MakeVariant(true)}},
+		"Other signal":             {name: "com.example.Other.Signal", changed: map[string]dbus.Variant{"Attached": dbus.MakeVariant(true)}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.name == "" {
+				tc.name = propertiesChanged
+			}
+			if tc.path == "" {
+				tc.path = consts.SubscriptionDbusObjectPath
+			}
+			if tc.iface == "" {
+				tc.iface = consts.SubscriptionDbusInterface
+			}
+			if tc.changed == nil {
+				tc.changed = map[string]dbus.Variant{}
+			}
+			if tc.invalidated == nil {
+				tc.invalidated = []string{}
+			}
+
+			sig := &dbus.Signal{Name: tc.name, Path: tc.path, Body: []interface{}{tc.iface, tc.changed, tc.invalidated}}
+			require.Equal(t, tc.want, adsysservice.SubscriptionChanged(sig), "SubscriptionChanged should return the expected value")
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	// export SSSD domain
 	defer testutils.StartLocalSystemBus()()
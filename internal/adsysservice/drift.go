@@ -0,0 +1,75 @@
+package adsysservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies"
+)
+
+// checkDriftPeriodically verifies every interval the policies applied to the machine and all users, until ctx is
+// cancelled. Drifts are logged, and repaired if requested.
+// done is closed once the check has stopped.
+func (s *Service) checkDriftPeriodically(ctx context.Context, interval time.Duration, repair bool, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkDrift(ctx, repair)
+		}
+	}
+}
+
+// checkDrift verifies the policies applied to the machine and all users, logging drifts and errors.
+func (s *Service) checkDrift(ctx context.Context, repair bool) {
+	log.Debug(ctx, "Checking drift of applied policies")
+
+	for _, o := range s.policyObjects(ctx, false) {
+		drifts, err := s.policyManager.VerifyPolicies(ctx, o.name, o.isComputer)
+		if err != nil {
+			log.Warning(ctx, err)
+			continue
+		}
+		if len(drifts) == 0 {
+			continue
+		}
+		log.Warning(ctx, gotext.Get("Policies of %s drifted:\n%s", o.name, policies.FormatDrifts(drifts)))
+
+		if !repair {
+			continue
+		}
+		if _, err := s.policyManager.RepairPolicies(ctx, o.name, o.isComputer); err != nil {
+			log.Warning(ctx, err)
+			continue
+		}
+		log.Info(ctx, gotext.Get("Policies of %s repaired", o.name))
+	}
+}
+
+// policyObject is the machine or a user policies are applied to.
+type policyObject struct {
+	name       string
+	isComputer bool
+}
+
+// policyObjects returns the machine, followed by the users with cached policies, or only the active ones.
+// Failing to list users is logged and only the machine is returned.
+func (s *Service) policyObjects(ctx context.Context, active bool) []policyObject {
+	objects := []policyObject{{name: s.adc.Hostname(), isComputer: true}}
+	users, err := s.adc.ListUsers(ctx, active)
+	if err != nil {
+		log.Warning(ctx, gotext.Get("Can't list users to handle their policies: %v", err))
+	}
+	for _, u := range users {
+		objects = append(objects, policyObject{name: u})
+	}
+	return objects
+}
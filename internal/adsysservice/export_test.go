@@ -3,6 +3,8 @@ package adsysservice
 import (
 	"context"
 	"strings"
+
+	"github.com/ubuntu/adsys/internal/policies"
 )
 
 // Option type exported for tests.
@@ -22,3 +24,12 @@ func (s *Service) SelectedBackend() string {
 
 	return backend
 }
+
+// SubscriptionChanged is exported for tests.
+var SubscriptionChanged = subscriptionChanged
+
+// ApplyPolicies applies pols to objectName with the policy manager of the service, for tests.
+func (s *Service) ApplyPolicies(ctx context.Context, objectName string, isComputer bool, pols *policies.Policies) error {
+	_, err := s.policyManager.ApplyPolicies(ctx, objectName, isComputer, pols)
+	return err
+}
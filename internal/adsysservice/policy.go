@@ -3,6 +3,7 @@ package adsysservice
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/leonelquinteros/gotext"
 	"github.com/ubuntu/adsys"
@@ -14,6 +15,7 @@ import (
 	"github.com/ubuntu/adsys/internal/policies/certificate"
 	"github.com/ubuntu/decorate"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
 )
 
 // UpdatePolicy refreshes or creates a policy for current user or user given as argument.
@@ -21,11 +23,25 @@ import (
 func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Service_UpdatePolicyServer) (err error) {
 	defer decorate.OnError(&err, gotext.Get("error while updating policy"))
 
+	return s.updatePolicy(stream.Context(), r, func(context.Context, []policies.ApplyResult) {})
+}
+
+// UpdatePolicyWithResults refreshes, creates or purges a policy as UpdatePolicy does, and streams the outcome of each
+// policy manager to the client.
+func (s *Service) UpdatePolicyWithResults(r *adsys.UpdatePolicyRequest, stream adsys.Service_UpdatePolicyWithResultsServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while updating policy"))
+
+	return s.updatePolicy(stream.Context(), r, applyResultsSender(stream))
+}
+
+// updatePolicy refreshes, creates or purges the policies of the request r, and sends the outcome of each policy
+// manager with send.
+func (s *Service) updatePolicy(ctx context.Context, r *adsys.UpdatePolicyRequest, send func(context.Context, []policies.ApplyResult)) (err error) {
 	objectClass := ad.UserObject
 	if r.GetIsComputer() || r.GetAll() {
 		objectClass = ad.ComputerObject
 	}
-	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	target, err := s.adc.NormalizeTargetName(ctx, r.GetTarget(), objectClass)
 	if err != nil {
 		return err
 	}
@@ -36,7 +52,7 @@ func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Servic
 		targetForAuthorizer = "root"
 	}
 
-	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, targetForAuthorizer),
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(ctx, authorizer.OnUserKey, targetForAuthorizer),
 		actions.ActionPolicyUpdate); err != nil {
 		return err
 	}
@@ -44,17 +60,17 @@ func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Servic
 	if r.GetIsComputer() || r.GetAll() {
 		hostname := s.adc.Hostname()
 
-		err = s.updatePolicyFor(stream.Context(), true, hostname, ad.ComputerObject, "", r.GetPurge())
+		err = s.updatePolicyFor(ctx, send, true, hostname, ad.ComputerObject, "", r.GetPurge())
 
 		if r.GetAll() {
-			users, err := s.adc.ListUsers(stream.Context(), !r.GetPurge())
+			users, err := s.adc.ListUsers(ctx, !r.GetPurge())
 			if err != nil {
 				return err
 			}
 			errg := new(errgroup.Group)
 			for _, user := range users {
 				errg.Go(func() (err error) {
-					return s.updatePolicyFor(stream.Context(), false, user, ad.UserObject, "", r.GetPurge())
+					return s.updatePolicyFor(ctx, send, false, user, ad.UserObject, "", r.GetPurge())
 				})
 			}
 			if err := errg.Wait(); err != nil {
@@ -65,11 +81,11 @@ func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Servic
 		return err
 	}
 	// Update a single user
-	return s.updatePolicyFor(stream.Context(), r.GetIsComputer(), target, objectClass, r.Krb5Cc, r.GetPurge())
+	return s.updatePolicyFor(ctx, send, r.GetIsComputer(), target, objectClass, r.Krb5Cc, r.GetPurge())
 }
 
-// updatePolicyFor updates the policy for a given object.
-func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string, purge bool) (err error) {
+// updatePolicyFor updates the policy for a given object and sends the outcome of each policy manager with send.
+func (s *Service) updatePolicyFor(ctx context.Context, send func(context.Context, []policies.ApplyResult), isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string, purge bool) (err error) {
 	var pols policies.Policies
 	if !purge {
 		pols, err = s.adc.GetPolicies(ctx, target, objectClass, krb5cc)
@@ -78,7 +94,97 @@ func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target s
 		}
 	}
 
-	return s.policyManager.ApplyPolicies(ctx, target, isComputer, &pols)
+	results, err := s.policyManager.ApplyPolicies(ctx, target, isComputer, &pols)
+	send(ctx, results)
+	return err
+}
+
+// applyResultsSender returns a function streaming the outcome of policy managers to the client.
+// It can be called concurrently when policies of multiple objects are applied in parallel.
+func applyResultsSender(stream grpc.ServerStreamingServer[adsys.ApplyResult]) func(context.Context, []policies.ApplyResult) {
+	var mu sync.Mutex
+	return func(ctx context.Context, results []policies.ApplyResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, r := range results {
+			var errMsg string
+			if r.Err != nil {
+				errMsg = r.Err.Error()
+			}
+			if err := stream.Send(&adsys.ApplyResult{
+				Manager:    r.Manager,
+				Object:     r.Object,
+				Status:     applyStatusToProto(r.Status),
+				Files:      r.Files,
+				DurationMs: r.Duration.Milliseconds(),
+				Error:      errMsg,
+			}); err != nil {
+				log.Warningf(ctx, "couldn't send policy apply result to client: %v", err)
+			}
+		}
+	}
+}
+
+// applyStatusToProto converts a policy manager status to its protobuf representation.
+func applyStatusToProto(s policies.ApplyStatus) adsys.ApplyResult_Status {
+	switch s {
+	case policies.StatusChanged:
+		return adsys.ApplyResult_CHANGED
+	case policies.StatusUnchanged:
+		return adsys.ApplyResult_UNCHANGED
+	case policies.StatusChangesUnknown:
+		return adsys.ApplyResult_CHANGES_UNKNOWN
+	case policies.StatusSkippedNonPro:
+		return adsys.ApplyResult_SKIPPED_NON_PRO
+	case policies.StatusFailed:
+		return adsys.ApplyResult_FAILED
+	}
+	return adsys.ApplyResult_STATUS_UNSPECIFIED
+}
+
+// PlanPolicy displays the changes that updating the policy for current user or user given as argument would do,
+// without applying them.
+func (s *Service) PlanPolicy(r *adsys.PlanPolicyRequest, stream adsys.Service_PlanPolicyServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while planning policy"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	targetForAuthorizer := target
+	// prevent case of username == machine name to allow planning machine or anyone abusing the API passing an user.
+	if r.GetIsComputer() {
+		targetForAuthorizer = "root"
+	}
+
+	// Planning requires fetching the GPOs, which is as privileged as updating the policy.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, targetForAuthorizer),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	pols, err := s.adc.GetPolicies(stream.Context(), target, objectClass, r.GetKrb5Cc())
+	if err != nil {
+		return err
+	}
+
+	plans, err := s.policyManager.PlanPolicies(stream.Context(), target, r.GetIsComputer(), &pols)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: policies.FormatPlans(plans),
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy plan to client: %v", err)
+	}
+
+	return nil
 }
 
 // DumpPolicies displays all applied policies for a given user.
@@ -103,7 +209,7 @@ func (s *Service) DumpPolicies(r *adsys.DumpPoliciesRequest, stream adsys.Servic
 		}
 	}
 
-	msg, err := s.policyManager.DumpPolicies(stream.Context(), target, r.GetIsComputer(), r.GetDetails(), r.GetAll())
+	msg, err := s.policyManager.DumpPolicies(stream.Context(), target, r.GetIsComputer(), r.GetDetails(), r.GetAll(), r.GetFormat())
 	if err != nil {
 		return err
 	}
@@ -116,6 +222,179 @@ func (s *Service) DumpPolicies(r *adsys.DumpPoliciesRequest, stream adsys.Servic
 	return nil
 }
 
+// ExplainPolicy displays how a rule applied to a given user is set by the GPOs defining it.
+func (s *Service) ExplainPolicy(r *adsys.ExplainPolicyRequest, stream adsys.Service_ExplainPolicyServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while explaining policy"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	// hostname policy explanation is allowed to all users
+	if target != s.adc.Hostname() {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	msg, err := s.policyManager.ExplainPolicy(stream.Context(), target, r.GetIsComputer(), r.GetType(), r.GetKey())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy explanation to client: %v", err)
+	}
+
+	return nil
+}
+
+// PolicyHistory displays the policies previously applied to a given user.
+func (s *Service) PolicyHistory(r *adsys.PolicyHistoryRequest, stream adsys.Service_PolicyHistoryServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while displaying policies history"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	// hostname policy history is allowed to all users
+	if target != s.adc.Hostname() {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	msg, err := s.policyManager.History(stream.Context(), target)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policies history to client: %v", err)
+	}
+
+	return nil
+}
+
+// RollbackPolicy applies again policies previously applied to current user or user given as argument.
+func (s *Service) RollbackPolicy(r *adsys.RollbackPolicyRequest, stream adsys.Service_RollbackPolicyServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while rolling back policy"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	targetForAuthorizer := target
+	// prevent case of username == machine name to allow rolling back machine or anyone abusing the API passing an user.
+	if r.GetIsComputer() {
+		targetForAuthorizer = "root"
+	}
+
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, targetForAuthorizer),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	results, err := s.policyManager.RollbackPolicies(stream.Context(), target, r.GetIsComputer(), int(r.GetTo()))
+	applyResultsSender(stream)(stream.Context(), results)
+	return err
+}
+
+// VerifyPolicy checks that the system is still in the state set up by the policies applied to current user or
+// user given as argument. It can repair any drift by applying again those policies.
+func (s *Service) VerifyPolicy(r *adsys.VerifyPolicyRequest, stream adsys.Service_VerifyPolicyServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while verifying policy"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() || r.GetAll() {
+		objectClass = ad.ComputerObject
+	}
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	targetForAuthorizer := target
+	// prevent case of username == machine name to allow verifying machine or anyone abusing the API passing an user.
+	if r.GetIsComputer() || r.GetAll() {
+		targetForAuthorizer = "root"
+	}
+
+	// Repairing applies policies again, which is as privileged as updating them.
+	action := actions.ActionPolicyDump
+	if r.GetRepair() {
+		action = actions.ActionPolicyUpdate
+	}
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, targetForAuthorizer),
+		action); err != nil {
+		return err
+	}
+
+	send := func(msg string) {
+		if err := stream.Send(&adsys.StringResponse{Msg: msg}); err != nil {
+			log.Warningf(stream.Context(), "couldn't send policy verification to client: %v", err)
+		}
+	}
+
+	if !r.GetAll() {
+		return s.verifyPolicyFor(stream.Context(), send, r.GetIsComputer(), target, r.GetRepair())
+	}
+
+	if err := s.verifyPolicyFor(stream.Context(), send, true, s.adc.Hostname(), r.GetRepair()); err != nil {
+		return err
+	}
+	users, err := s.adc.ListUsers(stream.Context(), false)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := s.verifyPolicyFor(stream.Context(), send, false, user, r.GetRepair()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyPolicyFor verifies the policies applied to a given object and sends the drifts found with send.
+// Drifts are repaired if requested.
+func (s *Service) verifyPolicyFor(ctx context.Context, send func(string), isComputer bool, target string, repair bool) error {
+	drifts, err := s.policyManager.VerifyPolicies(ctx, target, isComputer)
+	if err != nil {
+		return err
+	}
+	send(fmt.Sprintf("%s:\n%s", target, policies.FormatDrifts(drifts)))
+
+	if !repair || len(drifts) == 0 {
+		return nil
+	}
+	if _, err := s.policyManager.RepairPolicies(ctx, target, isComputer); err != nil {
+		return err
+	}
+	send(gotext.Get("Policies of %s repaired.", target) + "\n")
+	return nil
+}
+
 // DumpPoliciesDefinitions dumps requested policy definitions stored in daemon at build time.
 func (s *Service) DumpPoliciesDefinitions(r *adsys.DumpPolicyDefinitionsRequest, stream adsys.Service_DumpPoliciesDefinitionsServer) (err error) {
 	defer decorate.OnError(&err, gotext.Get("error while dumping policy definitions"))
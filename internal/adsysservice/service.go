@@ -1,6 +1,7 @@
 package adsysservice
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -15,7 +16,6 @@ import (
 	"github.com/ubuntu/adsys/internal/authorizer"
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
-	"github.com/ubuntu/adsys/internal/policies"
 	"github.com/ubuntu/adsys/internal/stdforward"
 	"github.com/ubuntu/decorate"
 	"google.golang.org/grpc"
@@ -109,7 +109,8 @@ func (s *Service) Status(_ *adsys.Empty, stream adsys.Service_StatusServer) (err
 	updateMachine := gotext.Get("Machine, no gpo applied found")
 	t, err := s.policyManager.LastUpdateFor(stream.Context(), "", true)
 	if err == nil {
-		updateMachine = fmt.Sprintf(updateFmt, gotext.Get("Machine"), t.Format(timeLayout))
+		updateMachine = fmt.Sprintf(updateFmt, gotext.Get("Machine"), t.Format(timeLayout)) +
+			s.onlineRefreshStatus(stream.Context(), "", true, timeLayout)
 	}
 
 	updateUsers := fmt.Sprint(gotext.Get("Can't get connected users"))
@@ -118,7 +119,8 @@ func (s *Service) Status(_ *adsys.Empty, stream adsys.Service_StatusServer) (err
 		updateUsers = fmt.Sprint(gotext.Get("Connected users:"))
 		for _, u := range users {
 			if t, err := s.policyManager.LastUpdateFor(stream.Context(), u, false); err == nil {
-				updateUsers = updateUsers + "\n  " + fmt.Sprintf(updateFmt, u, t.Format(timeLayout))
+				updateUsers = updateUsers + "\n  " + fmt.Sprintf(updateFmt, u, t.Format(timeLayout)) +
+					s.onlineRefreshStatus(stream.Context(), u, false, timeLayout)
 			} else {
 				updateUsers = updateUsers + "\n  " + gotext.Get("%s, no gpo applied found", u)
 			}
@@ -129,7 +131,7 @@ func (s *Service) Status(_ *adsys.Empty, stream adsys.Service_StatusServer) (err
 	}
 
 	ubuntuProStatus := gotext.Get("Ubuntu Pro subscription is not active on this machine. Rules belonging to the following policy types will not be applied:\n")
-	proOnlyRules := slices.Clone(policies.ProOnlyRules)
+	proOnlyRules := s.policyManager.ProOnlyRules()
 	slices.Sort(proOnlyRules)
 	ubuntuProStatus = ubuntuProStatus + "  - " + strings.Join(proOnlyRules, "\n  - ")
 
@@ -155,11 +157,13 @@ Daemon:
   Dconf path: %s
   Sudoers path: %s
   PolicyKit path: %s
-  Apparmor path: %s`, updateMachine, updateUsers, nextRefresh,
+  Apparmor path: %s
+  Policy managers: %s`, updateMachine, updateUsers, nextRefresh,
 		ubuntuProStatus,
 		strings.Join(strings.Split(adInfo, "\n"), "\n  "),
 		timeout, socket, state.cacheDir, state.runDir, state.dconfDir,
-		state.sudoersDir, state.policyKitDir, state.apparmorDir)
+		state.sudoersDir, state.policyKitDir, state.apparmorDir,
+		strings.Join(s.policyManager.PolicyTypes(), ", "))
 
 	if err := stream.Send(&adsys.StringResponse{
 		Msg: status,
@@ -183,6 +187,27 @@ func (s *Service) Stop(r *adsys.StopRequest, stream adsys.Service_StopServer) (e
 	return nil
 }
 
+// GarbageCollect removes the GPOs which are no longer referenced by any cached policies from the cache, once their
+// grace period is over.
+func (s *Service) GarbageCollect(_ *adsys.Empty, stream adsys.Service_GarbageCollectServer) (err error) {
+	defer decorate.OnError(&err, gotext.Get("error while garbage collecting the cache"))
+
+	if err := s.authorizer.IsAllowedFromContext(stream.Context(), actions.ActionServiceManage); err != nil {
+		return err
+	}
+
+	removed, err := s.adc.GarbageCollect(stream.Context(), s.gcGracePeriod)
+	if err != nil {
+		return err
+	}
+
+	msg := gotext.Get("No unreferenced GPO to remove from cache")
+	if len(removed) > 0 {
+		msg = gotext.Get("Removed %d unreferenced GPOs from cache:\n  %s", len(removed), strings.Join(removed, "\n  "))
+	}
+	return stream.Send(&adsys.StringResponse{Msg: msg})
+}
+
 // ListUsers returns the list of currently active users.
 func (s *Service) ListUsers(r *adsys.ListUsersRequest, stream adsys.Service_ListUsersServer) (err error) {
 	defer decorate.OnError(&err, gotext.Get("error while trying to get the list of active users"))
@@ -235,3 +260,17 @@ func (s Service) nextRefreshTime() (next *time.Time, err error) {
 	nextRefresh := s.initSystemTime.Add(time.Duration(int64(nextRaw)))
 	return &nextRefresh, nil
 }
+
+// onlineRefreshStatus returns when, and from which domain controller, the cached policies of objectName were last
+// refreshed online and their age, to be appended to its last update time.
+func (s *Service) onlineRefreshStatus(ctx context.Context, objectName string, isMachine bool, timeLayout string) string {
+	t, server, err := s.policyManager.LastOnlineRefreshFor(ctx, objectName, isMachine)
+	if err != nil {
+		log.Debug(ctx, err)
+		return gotext.Get(", last online refresh unknown")
+	}
+	if server == "" {
+		return gotext.Get(", last online refresh on %s (cache age: %s)", t.Format(timeLayout), time.Since(t).Round(time.Minute))
+	}
+	return gotext.Get(", last online refresh on %s from %s (cache age: %s)", t.Format(timeLayout), server, time.Since(t).Round(time.Minute))
+}
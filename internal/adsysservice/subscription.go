@@ -0,0 +1,89 @@
+package adsysservice
+
+import (
+	"context"
+	"slices"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/adsys/internal/consts"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+)
+
+const propertiesChangedSignal = "org.freedesktop.DBus.Properties.PropertiesChanged"
+
+// watchSubscription applies again the cached policies of the machine and active users each time the machine is
+// attached to or detached from Ubuntu Pro, until ctx is cancelled. This way, Ubuntu Pro-only rules are applied, or
+// removed, without waiting for the next refresh.
+// done is closed once the watch has stopped.
+func (s *Service) watchSubscription(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	if err := s.bus.AddMatchSignal(
+		dbus.WithMatchObjectPath(consts.SubscriptionDbusObjectPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchArg(0, consts.SubscriptionDbusInterface),
+	); err != nil {
+		log.Warning(ctx, gotext.Get("Can't watch Ubuntu Pro subscription changes: %v", err))
+		return
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	s.bus.Signal(signals)
+	defer s.bus.RemoveSignal(signals)
+
+	attached := s.policyManager.GetSubscriptionState(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig, ok := <-signals:
+			if !ok {
+				return
+			}
+			if !subscriptionChanged(sig) {
+				continue
+			}
+			// Only the current state matters, whatever the signal contains.
+			newState := s.policyManager.GetSubscriptionState(ctx)
+			if newState == attached {
+				continue
+			}
+			attached = newState
+			s.applyCachedPolicies(ctx, attached)
+		}
+	}
+}
+
+// subscriptionChanged returns true if sig notifies a change of the Ubuntu Pro attachment state.
+func subscriptionChanged(sig *dbus.Signal) bool {
+	if sig.Name != propertiesChangedSignal || sig.Path != consts.SubscriptionDbusObjectPath || len(sig.Body) < 3 {
+		return false
+	}
+	if iface, ok := sig.Body[0].(string); !ok || iface != consts.SubscriptionDbusInterface {
+		return false
+	}
+	if changed, ok := sig.Body[1].(map[string]dbus.Variant); ok {
+		if _, ok := changed["Attached"]; ok {
+			return true
+		}
+	}
+	invalidated, ok := sig.Body[2].([]string)
+	return ok && slices.Contains(invalidated, "Attached")
+}
+
+// applyCachedPolicies applies again the cached policies of the machine and active users, logging errors.
+func (s *Service) applyCachedPolicies(ctx context.Context, attached bool) {
+	state := gotext.Get("detached from")
+	if attached {
+		state = gotext.Get("attached to")
+	}
+	log.Info(ctx, gotext.Get("Machine %s Ubuntu Pro, applying cached policies again", state))
+
+	for _, o := range s.policyObjects(ctx, true) {
+		if _, err := s.policyManager.RepairPolicies(ctx, o.name, o.isComputer); err != nil {
+			log.Warning(ctx, err)
+		}
+	}
+}
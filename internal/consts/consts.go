@@ -41,6 +41,36 @@ const (
 	// DefaultGpoListTimeout is the default time to wait for the GPO list subcommand to finish.
 	DefaultGpoListTimeout = 10
 
+	// DefaultDCRetries is the default number of times all domain controllers are tried again once unreachable.
+	DefaultDCRetries = 2
+	// DefaultDCRetryBackoff is the default time in seconds to wait before trying all domain controllers again,
+	// doubled on each retry.
+	DefaultDCRetryBackoff = 2
+	// DefaultDownloadTimeout is the default time in seconds for GPOs and assets downloads from a domain controller.
+	DefaultDownloadTimeout = 120
+
+	// DefaultWMIUnknownFilters is how GPOs with a WMI filter that can't be evaluated are handled by default:
+	// "pass" applies them and "fail" skips them.
+	DefaultWMIUnknownFilters = "pass"
+
+	// DefaultOfflineStaleAction is what is done by default when the cached policies applied while offline are
+	// older than their maximum age: "warn" only logs it, "refuse" fails and "fallback" applies the fallback policies.
+	DefaultOfflineStaleAction = "warn"
+	// DefaultOfflineFallbackDir is the default directory of the policies applied instead of stale cached ones.
+	DefaultOfflineFallbackDir = "/etc/adsys/offline-fallback"
+
+	// DefaultGCGracePeriod is the default time in hours GPOs no longer referenced by any cached policies are kept.
+	DefaultGCGracePeriod = 168
+
+	// DefaultPluginsTimeout is the default time in seconds a policy manager plugin can run before being killed.
+	DefaultPluginsTimeout = 30
+
+	// DefaultHistorySize is the default number of applied policies kept in history per object.
+	DefaultHistorySize = 10
+
+	// DefaultHistoryMaxAge is the default age in days after which applied policies are removed from history.
+	DefaultHistoryMaxAge = 30
+
 	// DistroID is the distro ID which can be overridden at build time.
 	DistroID = "Ubuntu"
 )
@@ -63,8 +93,20 @@ const (
 	DefaultPolicyKitSystemDir = "/usr/share/polkit-1"
 	// DefaultApparmorDir is the default directory for apparmor configuration.
 	DefaultApparmorDir = "/etc/apparmor.d/adsys"
+	// DefaultApparmorFsDir is the default directory of the apparmor security filesystem.
+	DefaultApparmorFsDir = "/sys/kernel/security/apparmor"
 	// DefaultSystemUnitDir is the default directory for systemd unit files.
 	DefaultSystemUnitDir = "/etc/systemd/system"
+	// DefaultUserUnitDir is the default directory for systemd user unit files of every user.
+	DefaultUserUnitDir = "/etc/systemd/user"
+	// DefaultRuntimeSystemUnitDir is the default directory for systemd unit files removed on reboot.
+	DefaultRuntimeSystemUnitDir = "/run/systemd/system"
+	// DefaultRuntimeUserUnitDir is the default directory for systemd user unit files of every user removed on reboot.
+	DefaultRuntimeUserUnitDir = "/run/systemd/user"
+	// DefaultEnvironmentDir is the default directory for the environment variables of the sessions.
+	DefaultEnvironmentDir = "/etc/environment.d"
+	// DefaultPluginsDir is the default directory for policy manager plugins.
+	DefaultPluginsDir = "/usr/lib/adsys/managers"
 	// DefaultGlobalTrustDir is the default directory for the global trust store.
 	DefaultGlobalTrustDir = "/usr/local/share/ca-certificates"
 )
@@ -80,7 +80,7 @@ func New(apparmorDir string, opts ...Option) *Manager {
 	// defaults
 	args := options{
 		apparmorParserCmd: []string{"apparmor_parser"},
-		apparmorFsDir:     "/sys/kernel/security/apparmor",
+		apparmorFsDir:     consts.DefaultApparmorFsDir,
 	}
 	// applied options
 	for _, o := range opts {
@@ -158,6 +158,35 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	return err
 }
 
+// UnloadedPolicies returns the apparmor policies of the machine profiles managed by adsys which are not loaded
+// in the kernel.
+func (m *Manager) UnloadedPolicies(ctx context.Context) (policies []string, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't check loaded apparmor policies"))
+
+	// Apparmor can't be executed concurrently, so we need a lock to prevent it.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	profiles, err := filesInDir(filepath.Join(m.apparmorDir, "machine"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := m.policiesFromFiles(ctx, profiles)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := m.loadedPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	return difference(expected, loaded), nil
+}
+
 // applyUserPolicy applies apparmor policies for the machine object.
 func (m *Manager) applyMachinePolicy(ctx context.Context, e entry.Entry, apparmorPath string, assetsDumper AssetsDumper) (err error) {
 	defer decorate.OnError(&err, gotext.Get("can't apply machine policy"))
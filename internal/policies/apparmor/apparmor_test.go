@@ -191,6 +191,67 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
+func TestUnloadedPolicies(t *testing.T) {
+	// The apparmor parser is written by the test before executing it.
+	//t.Parallel()
+
+	tests := map[string]struct {
+		profiles         []string
+		loadedPolicies   []string
+		noLoadedPolicies bool
+		parserFails      bool
+
+		want    []string
+		wantErr bool
+	}{
+		"All policies are loaded":      {profiles: []string{"usr.bin.foo", "usr.bin.bar"}, loadedPolicies: []string{"/usr/bin/foo", "/usr/bin/bar", "other"}},
+		"Unloaded policies are listed": {profiles: []string{"usr.bin.foo", "usr.bin.bar"}, loadedPolicies: []string{"/usr/bin/foo", "other"}, want: []string{"/usr/bin/bar"}},
+		"No machine profiles":          {loadedPolicies: []string{"other"}},
+
+		"Error on apparmor parser failing":      {profiles: []string{"usr.bin.foo"}, parserFails: true, wantErr: true},
+		"Error on loaded policies not readable": {profiles: []string{"usr.bin.foo"}, noLoadedPolicies: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//t.Parallel()
+
+			apparmorDir := t.TempDir()
+			if tc.profiles != nil {
+				require.NoError(t, os.MkdirAll(filepath.Join(apparmorDir, "machine"), 0750), "Setup: can't create machine profiles directory")
+			}
+			for _, p := range tc.profiles {
+				err := os.WriteFile(filepath.Join(apparmorDir, "machine", p), []byte("profile\n"), 0600)
+				require.NoError(t, err, "Setup: can't write profile")
+			}
+
+			// The mock parser prints the policy of each profile, named after the binary path.
+			script := "#!/bin/sh\nshift\nfor p in \"$@\"; do basename \"$p\" | tr . / | sed 's#^#/#'; done\n"
+			if tc.parserFails {
+				script = "#!/bin/sh\nexit 1\n"
+			}
+			parser := filepath.Join(t.TempDir(), "apparmor_parser")
+			require.NoError(t, os.WriteFile(parser, []byte(script), 0700), "Setup: can't write mock apparmor parser")
+
+			loadedPoliciesFile := mockLoadedPoliciesFile(t, tc.loadedPolicies)
+			if tc.noLoadedPolicies {
+				loadedPoliciesFile = filepath.Join(t.TempDir(), "profiles")
+			}
+
+			m := apparmor.New(apparmorDir,
+				apparmor.WithApparmorParserCmd([]string{parser}),
+				apparmor.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)))
+
+			got, err := m.UnloadedPolicies(context.Background())
+			if tc.wantErr {
+				require.Error(t, err, "UnloadedPolicies should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "UnloadedPolicies failed but shouldn't have")
+			require.Equal(t, tc.want, got, "UnloadedPolicies returned unexpected policies")
+		})
+	}
+}
+
 func appendToFile(t *testing.T, path string, data []byte) {
 	t.Helper()
 
@@ -0,0 +1,415 @@
+package policies
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/ad/backends"
+	"github.com/ubuntu/adsys/internal/policies/apparmor"
+	"github.com/ubuntu/adsys/internal/policies/certificate"
+	"github.com/ubuntu/adsys/internal/policies/dconf"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/policies/files"
+	"github.com/ubuntu/adsys/internal/policies/gdm"
+	"github.com/ubuntu/adsys/internal/policies/localgroups"
+	"github.com/ubuntu/adsys/internal/policies/mount"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"github.com/ubuntu/adsys/internal/policies/proxy"
+	"github.com/ubuntu/adsys/internal/policies/scheduledtasks"
+	"github.com/ubuntu/adsys/internal/policies/scripts"
+)
+
+// builtinManager adapts a policy manager shipped with adsys to the PolicyManager interface.
+type builtinManager struct {
+	ruleType    string
+	proOnly     bool
+	scope       Scope
+	after       []string
+	needsAssets bool
+	// reapplyOnRollback is true if the policy manager changes more than the files it replaces, and applies the
+	// previous rules again when rolling back.
+	reapplyOnRollback bool
+
+	apply  func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error
+	paths  func(objectName string, isComputer bool, entries []entry.Entry) []string
+	verify func(ctx context.Context, objectName string, isComputer bool) ([]Drift, error)
+}
+
+// Type returns the type of rules handled by the policy manager.
+func (b builtinManager) Type() string { return b.ruleType }
+
+// ApplyPolicy applies entries to objectName.
+func (b builtinManager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+	return b.apply(ctx, objectName, isComputer, entries, assetsDumper)
+}
+
+// ProOnly returns true if rules are only applied when the machine is subscribed to Ubuntu Pro.
+func (b builtinManager) ProOnly() bool { return b.proOnly }
+
+// Scope returns the kind of objects the policy manager applies rules to.
+func (b builtinManager) Scope() Scope { return b.scope }
+
+// After returns the types of the policy managers that must be applied before this one.
+func (b builtinManager) After() []string { return b.after }
+
+// NeedsAssets returns true if the policy manager copies GPO assets to the system.
+func (b builtinManager) NeedsAssets() bool { return b.needsAssets }
+
+// SnapshotPaths returns the files, as glob patterns, that the policy manager can replace for objectName when applying entries.
+func (b builtinManager) SnapshotPaths(objectName string, isComputer bool, entries []entry.Entry) []string {
+	if b.paths == nil {
+		return nil
+	}
+	return b.paths(objectName, isComputer, entries)
+}
+
+// Rollback applies entries, the previous rules, again if the policy manager changes more than the files it replaces.
+func (b builtinManager) Rollback(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+	if !b.reapplyOnRollback {
+		return nil
+	}
+	return b.apply(ctx, objectName, isComputer, entries, assetsDumper)
+}
+
+// Verify returns how the system drifted, outside of the files the policy manager writes, from the state it set up.
+func (b builtinManager) Verify(ctx context.Context, objectName string, isComputer bool) ([]Drift, error) {
+	if b.verify == nil {
+		return nil, nil
+	}
+	return b.verify(ctx, objectName, isComputer)
+}
+
+// newBuiltinManagers creates all policy managers shipped with adsys from the given options, in the order they are applied.
+func newBuiltinManagers(bus *dbus.Conn, backend backends.Backend, args options) (pms []PolicyManager, err error) {
+	// dconf manager
+	var dconfOptions []dconf.Option
+	if args.dconfCmd != nil {
+		dconfOptions = append(dconfOptions, dconf.WithDconfCmd(args.dconfCmd))
+	}
+	dconfManager := dconf.NewWithDconfDir(args.dconfDir, dconfOptions...)
+
+	// privilege manager
+	privilegeManager := privilege.NewWithDirs(args.sudoersDir, args.policyKitDir, args.policyKitSystemDir)
+
+	// scripts manager
+	scriptsManager, err := scripts.New(args.runDir, args.systemdCaller)
+	if err != nil {
+		return nil, err
+	}
+
+	// mount manager
+	mountManager, err := mount.New(args.runDir, args.systemUnitDir, args.systemdCaller)
+	if err != nil {
+		return nil, err
+	}
+
+	// apparmor manager
+	var apparmorOptions []apparmor.Option
+	if args.apparmorParserCmd != nil {
+		apparmorOptions = append(apparmorOptions, apparmor.WithApparmorParserCmd(args.apparmorParserCmd))
+	}
+	if args.apparmorFsDir != "" {
+		apparmorOptions = append(apparmorOptions, apparmor.WithApparmorFsDir(args.apparmorFsDir))
+	}
+	apparmorManager := apparmor.New(args.apparmorDir, apparmorOptions...)
+
+	// proxy manager
+	var proxyOptions []proxy.Option
+	if args.proxyApplier != nil {
+		proxyOptions = append(proxyOptions, proxy.WithProxyApplier(args.proxyApplier))
+	}
+	proxyManager := proxy.New(bus, proxyOptions...)
+
+	// certificate manager
+	certificateOpts := []certificate.Option{
+		certificate.WithStateDir(args.stateDir),
+		certificate.WithRunDir(args.runDir),
+		certificate.WithShareDir(args.shareDir),
+		certificate.WithGlobalTrustDir(args.globalTrustDir),
+	}
+	if args.certAutoenrollCmd != nil {
+		certificateOpts = append(certificateOpts, certificate.WithCertAutoenrollCmd(args.certAutoenrollCmd))
+	}
+	certificateManager := certificate.New(backend.Domain(), certificateOpts...)
+
+	// local groups manager
+	var localGroupsOptions []localgroups.Option
+	if args.groupFile != "" {
+		localGroupsOptions = append(localGroupsOptions, localgroups.WithGroupFile(args.groupFile))
+	}
+	if args.gpasswdCmd != nil {
+		localGroupsOptions = append(localGroupsOptions, localgroups.WithGpasswdCmd(args.gpasswdCmd))
+	}
+	localGroupsManager := localgroups.New(backend.Domain(), args.localGroupsStateDir, localGroupsOptions...)
+
+	// environment manager
+	environmentManager := environment.New(args.environmentDir,
+		environment.WithHomeRoot(args.homeRoot),
+		environment.WithUserLookup(args.userLookup))
+
+	// scheduled tasks manager
+	scheduledTasksManager := scheduledtasks.New(args.scheduledTasksStateDir, args.systemdCaller,
+		scheduledtasks.WithSystemUnitDir(args.systemUnitDir),
+		scheduledtasks.WithUserUnitDir(args.userUnitDir),
+		scheduledtasks.WithRuntimeSystemUnitDir(args.runtimeSystemUnitDir),
+		scheduledtasks.WithRuntimeUserUnitDir(args.runtimeUserUnitDir),
+		scheduledtasks.WithUserLookup(args.userLookup))
+
+	// files manager
+	filesManager := args.filesManager()
+
+	// inject applied dconf mangager if we need to build a gdm manager
+	gdmManager := args.gdm
+	if gdmManager == nil {
+		if gdmManager, err = gdm.New(gdm.WithDconf(dconfManager)); err != nil {
+			return nil, err
+		}
+	}
+
+	return []PolicyManager{
+		builtinManager{
+			ruleType: "dconf",
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return dconfManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				if isComputer {
+					objectName = "machine"
+				}
+				return dconfPaths(args.dconfDir, objectName)
+			},
+		},
+		builtinManager{
+			ruleType: "privilege",
+			proOnly:  true,
+			scope:    ScopeMachine,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return privilegeManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+			paths: func(string, bool, []entry.Entry) []string {
+				return []string{
+					filepath.Join(args.sudoersDir, "*-adsys-privilege-enforcement"),
+					filepath.Join(args.policyKitDir, "rules.d", "*-adsys-privilege-enforcement.rules"),
+					filepath.Join(args.policyKitDir, "localauthority.conf.d", "*-adsys-privilege-enforcement.conf"),
+				}
+			},
+		},
+		builtinManager{
+			ruleType:    "scripts",
+			proOnly:     true,
+			needsAssets: true,
+			// Restoring the scripts directory is enough: the scripts are only run when a session starts, and the
+			// startup scripts which already ran can't be undone, while applying the previous rules again would run
+			// them a second time.
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+				return scriptsManager.ApplyPolicy(ctx, objectName, isComputer, entries, scripts.AssetsDumper(assetsDumper))
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				objectRunDir := args.objectRunDir(objectName, isComputer)
+				if objectRunDir == "" {
+					return nil
+				}
+				return []string{filepath.Join(objectRunDir, "scripts")}
+			},
+		},
+		builtinManager{
+			ruleType: "mount",
+			proOnly:  true,
+			// Applying the previous rules again enables and starts the restored mount units of the machine, and
+			// stops and disables the new ones.
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return mountManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				if isComputer {
+					return []string{filepath.Join(args.systemUnitDir, "adsys-*.mount")}
+				}
+				objectRunDir := args.objectRunDir(objectName, isComputer)
+				if objectRunDir == "" {
+					return nil
+				}
+				return []string{filepath.Join(objectRunDir, "mounts")}
+			},
+		},
+		builtinManager{
+			ruleType:          "apparmor",
+			proOnly:           true,
+			needsAssets:       true,
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+				return apparmorManager.ApplyPolicy(ctx, objectName, isComputer, entries, apparmor.AssetsDumper(assetsDumper))
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				if isComputer {
+					return []string{filepath.Join(args.apparmorDir, "machine")}
+				}
+				return []string{filepath.Join(args.apparmorDir, "users", objectName)}
+			},
+			verify: func(ctx context.Context, _ string, isComputer bool) ([]Drift, error) {
+				if !isComputer {
+					return nil, nil
+				}
+				unloaded, err := apparmorManager.UnloadedPolicies(ctx)
+				if err != nil {
+					return nil, err
+				}
+				var drifts []Drift
+				for _, p := range unloaded {
+					drifts = append(drifts, Drift{Manager: "apparmor", Path: p, Kind: DriftNotLoaded})
+				}
+				return drifts, nil
+			},
+		},
+		builtinManager{
+			ruleType:          "proxy",
+			proOnly:           true,
+			scope:             ScopeMachine,
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return proxyManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+		},
+		builtinManager{
+			ruleType:          "certificate",
+			proOnly:           true,
+			scope:             ScopeMachine,
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				// Ignore error as we don't want to fail because of online status this late in the process
+				isOnline, _ := backend.IsOnline()
+				return certificateManager.ApplyPolicy(ctx, objectName, isComputer, isOnline, entries)
+			},
+		},
+		builtinManager{
+			ruleType: "localgroups",
+			proOnly:  true,
+			scope:    ScopeMachine,
+			// The state directory is not snapshotted: applying the previous rules again undoes the memberships
+			// recorded in it, and records the restored ones.
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return localGroupsManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+		},
+		builtinManager{
+			ruleType: "environment",
+			proOnly:  true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, _ AssetsDumper) error {
+				return environmentManager.ApplyPolicy(ctx, objectName, isComputer, entries)
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				if isComputer {
+					return []string{filepath.Join(args.environmentDir, environment.FileName)}
+				}
+				home := args.userHome(objectName)
+				if home == "" {
+					return nil
+				}
+				return []string{environment.UserFile(home)}
+			},
+		},
+		builtinManager{
+			ruleType:          "scheduledtasks",
+			proOnly:           true,
+			needsAssets:       true,
+			reapplyOnRollback: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+				return scheduledTasksManager.ApplyPolicy(ctx, objectName, isComputer, entries, scheduledtasks.AssetsDumper(assetsDumper))
+			},
+			paths: func(objectName string, isComputer bool, _ []entry.Entry) []string {
+				if isComputer {
+					prefix := scheduledtasks.UnitsPrefix("")
+					return []string{
+						filepath.Join(args.systemUnitDir, prefix+"*"),
+						filepath.Join(args.systemUnitDir, "timers.target.wants", prefix+"*"),
+						filepath.Join(args.runtimeSystemUnitDir, prefix+"*"),
+						filepath.Join(args.scheduledTasksStateDir, "machine"),
+					}
+				}
+				u, err := args.userLookup(objectName)
+				if err != nil {
+					return nil
+				}
+				prefix := scheduledtasks.UnitsPrefix(u.Uid)
+				return []string{
+					filepath.Join(args.userUnitDir, prefix+"*"),
+					filepath.Join(args.userUnitDir, "timers.target.wants", prefix+"*"),
+					filepath.Join(args.runtimeUserUnitDir, prefix+"*"),
+					filepath.Join(args.runtimeUserUnitDir, "timers.target.wants", prefix+"*"),
+					filepath.Join(args.scheduledTasksStateDir, "users", u.Uid),
+				}
+			},
+		},
+		builtinManager{
+			ruleType:    "files",
+			proOnly:     true,
+			needsAssets: true,
+			apply: func(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error {
+				return filesManager.ApplyPolicy(ctx, objectName, isComputer, entries, files.AssetsDumper(assetsDumper))
+			},
+			paths: func(objectName string, isComputer bool, entries []entry.Entry) []string {
+				return filesManager.Paths(objectName, isComputer, entries)
+			},
+		},
+		builtinManager{
+			ruleType: "gdm",
+			scope:    ScopeMachine,
+			// We need dconf machine database to be ready first
+			after: []string{"dconf"},
+			apply: func(ctx context.Context, _ string, _ bool, entries []entry.Entry, _ AssetsDumper) error {
+				return gdmManager.ApplyPolicy(ctx, entries)
+			},
+			paths: func(string, bool, []entry.Entry) []string {
+				return dconfPaths(args.dconfDir, "gdm")
+			},
+		},
+	}, nil
+}
+
+// dconfPaths returns the dconf profile and databases, sources and compiled one, of dconfObject.
+func dconfPaths(dconfDir, dconfObject string) []string {
+	return []string{
+		filepath.Join(dconfDir, "profile", dconfObject),
+		filepath.Join(dconfDir, "db", dconfObject+".d"),
+		filepath.Join(dconfDir, "db", dconfObject),
+	}
+}
+
+// objectRunDir returns the run directory of objectName.
+// It is empty if objectName is a user which does not exist on the system.
+func (o options) objectRunDir(objectName string, isComputer bool) string {
+	if isComputer {
+		return filepath.Join(o.runDir, "machine")
+	}
+	u, err := o.userLookup(objectName)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(o.runDir, "users", u.Uid)
+}
+
+// filesManager returns the files manager, deploying the targets under the files root.
+func (o options) filesManager() *files.Manager {
+	opts := []files.Option{
+		files.WithRoot(o.filesRoot),
+		files.WithHomeRoot(o.homeRoot),
+		files.WithUserLookup(o.userLookup),
+	}
+	if len(o.filesAllowedPrefixes) > 0 {
+		opts = append(opts, files.WithAllowedPrefixes(o.filesAllowedPrefixes))
+	}
+	return files.New(o.filesStateDir, opts...)
+}
+
+// userHome returns the home directory of objectName, relative to the home root.
+// It is empty if objectName is a user which does not exist on the system.
+func (o options) userHome(objectName string) string {
+	u, err := o.userLookup(objectName)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(o.homeRoot, u.HomeDir)
+}
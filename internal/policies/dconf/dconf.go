@@ -35,6 +35,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -57,11 +58,38 @@ type Manager struct {
 	dconfUpdateMu sync.Mutex
 
 	dconfDir string
+	dconfCmd []string
+}
+
+type options struct {
+	dconfCmd []string
+}
+
+// Option reprents an optional function to change the dconf manager.
+type Option func(*options)
+
+// WithDconfCmd overrides the default dconf command.
+func WithDconfCmd(cmd []string) Option {
+	return func(o *options) {
+		o.dconfCmd = cmd
+	}
 }
 
 // NewWithDconfDir creates a manager with a specific dconf directory.
-func NewWithDconfDir(dir string) *Manager {
-	return &Manager{dconfDir: dir}
+func NewWithDconfDir(dir string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		dconfCmd: []string{"dconf"},
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		dconfDir: dir,
+		dconfCmd: args.dconfCmd,
+	}
 }
 
 // ApplyPolicy generates a dconf computer or user policy based on a list of entries.
@@ -184,12 +212,19 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		return nil
 	}
 
+	// The manager can be created without options, e.g. by the gdm manager.
+	dconfCmd := m.dconfCmd
+	if dconfCmd == nil {
+		dconfCmd = []string{"dconf"}
+	}
+	args := append(slices.Clone(dconfCmd[1:]), "update", filepath.Join(dconfDir, "db"))
+
 	// request an update now that we released the read lock
 	// we will call update multiple times.
 	smbsafe.WaitExec()
 	m.dconfUpdateMu.Lock()
 	// #nosec G204 - we control the input
-	out, errExec := exec.Command("dconf", "update", filepath.Join(dconfDir, "db")).CombinedOutput()
+	out, errExec := exec.Command(dconfCmd[0], args...).CombinedOutput()
 	m.dconfUpdateMu.Unlock()
 	smbsafe.DoneExec()
 	if errExec != nil {
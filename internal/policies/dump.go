@@ -0,0 +1,110 @@
+package policies
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"gopkg.in/yaml.v3"
+)
+
+// dumpedPolicies is the machine readable version of applied policies.
+type dumpedPolicies struct {
+	GPOs  []dumpedGPO  `json:"gpos" yaml:"gpos"`
+	Rules []dumpedRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// dumpedGPO is a GPO applied to the machine or to the user, as listed in dumpedPolicies.
+type dumpedGPO struct {
+	ID            string `json:"id" yaml:"id"`
+	Name          string `json:"name" yaml:"name"`
+	Configuration string `json:"configuration" yaml:"configuration"`
+}
+
+// dumpedRule is a rule with the GPO providing it, as listed in dumpedPolicies.
+type dumpedRule struct {
+	Type          string `json:"type" yaml:"type"`
+	Key           string `json:"key" yaml:"key"`
+	Value         string `json:"value" yaml:"value"`
+	Disabled      bool   `json:"disabled" yaml:"disabled"`
+	Meta          string `json:"meta,omitempty" yaml:"meta,omitempty"`
+	Strategy      string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	GPOID         string `json:"gpoId" yaml:"gpoId"`
+	GPOName       string `json:"gpoName" yaml:"gpoName"`
+	Configuration string `json:"configuration" yaml:"configuration"`
+	Overridden    bool   `json:"overridden" yaml:"overridden"`
+	Target        string `json:"target,omitempty" yaml:"target,omitempty"`
+	// Source is the file of the GPO the rule comes from, when it is not the registry policy.
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// FilteredByTargeting is set if the rule is not applied as its target does not match.
+	FilteredByTargeting bool `json:"filteredByTargeting" yaml:"filteredByTargeting"`
+}
+
+// add appends the GPOs, and their rules if withRules is set, applied to the configuration to the dump.
+// Overridden rules are only listed if withOverridden is set, while rules for which filtered returns true are always
+// listed as filtered by targeting.
+func (d *dumpedPolicies) add(gpos []GPO, configuration string, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}, filtered func(entry.Entry) bool) {
+	for _, g := range gpos {
+		d.GPOs = append(d.GPOs, dumpedGPO{ID: g.ID, Name: g.Name, Configuration: configuration})
+
+		if !withRules {
+			continue
+		}
+
+		var domains []string
+		for domain := range g.Rules {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+
+		for _, domain := range domains {
+			for _, r := range g.Rules[domain] {
+				var overr bool
+				isFiltered := filtered(r)
+				if !isFiltered {
+					overr = processRule(alreadyProcessedRules, domain, r)
+				}
+				if !withOverridden && overr {
+					continue
+				}
+				d.Rules = append(d.Rules, dumpedRule{
+					Type:          domain,
+					Key:           r.Key,
+					Value:         r.Value,
+					Disabled:      r.Disabled,
+					Meta:          r.Meta,
+					Strategy:      r.Strategy,
+					GPOID:         g.ID,
+					GPOName:       g.Name,
+					Configuration: configuration,
+					Overridden:    overr,
+					Target:        r.Target,
+					Source:        r.Source,
+
+					FilteredByTargeting: isFiltered,
+				})
+			}
+		}
+	}
+}
+
+// marshal returns the dump in the given format: json or yaml.
+func (d dumpedPolicies) marshal(format string) (string, error) {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case "yaml":
+		b, err := yaml.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return "", errors.New(gotext.Get("unknown format %q: only text, json and yaml are supported", format))
+}
@@ -7,10 +7,15 @@
 // constants); anything else is rejected so that typos surface loudly instead of
 // silently applying a broken value.
 //
+// Directory attributes of the user or computer object are available as
+// ${AD:attribute}, e.g. "${AD:department}". An attribute which is not set on the
+// object is rejected the same way as an unknown variable.
+//
 // Only the ${VAR} syntax (braces required) is recognized. A lone "$" or a bare
-// "$VAR" without braces is passed through literally. The Windows-style %VAR%
-// form is intentionally not supported as it collides with URL percent-encoding
-// (e.g. %20) used in mount values.
+// "$VAR" without braces is passed through literally, and "$${" is the escape
+// for a literal "${". The Windows-style %VAR% form is intentionally not
+// supported as it collides with URL percent-encoding (e.g. %20) used in mount
+// values.
 package dynamicvalues
 
 import (
@@ -32,13 +37,39 @@ const (
 	VarFullHostname = "FULL_HOSTNAME"
 	// VarDomain is the AD DNS domain, e.g. "dom.com".
 	VarDomain = "DOMAIN"
+	// VarUID is the numeric user ID on the machine, e.g. "1234".
+	VarUID = "UID"
+	// VarGID is the numeric primary group ID of the user on the machine, e.g. "1234".
+	VarGID = "GID"
+	// VarHome is the home directory of the user on the machine, e.g. "/home/bob@dom.com".
+	VarHome = "HOME"
+	// VarSID is the security identifier of the user or computer object, e.g. "S-1-5-21-1-2-3-1103".
+	VarSID = "SID"
+
+	// ADAttributePrefix prefixes the name of a directory attribute of the object, e.g. "AD:department".
+	ADAttributePrefix = "AD:"
 )
 
+// escapedPlaceholder is the escape of a literal "${".
+const escapedPlaceholder = "$${"
+
 // userOnlyVars are variables that only make sense in a user policy. Using them
 // in a machine policy is an error.
 var userOnlyVars = map[string]bool{
 	VarUser:     true,
 	VarFullUser: true,
+	VarUID:      true,
+	VarGID:      true,
+	VarHome:     true,
+}
+
+// resolvedVars are variables looked up on the machine or in the directory. They
+// are empty if the lookup failed and using them is then an error.
+var resolvedVars = map[string]bool{
+	VarUID:  true,
+	VarGID:  true,
+	VarHome: true,
+	VarSID:  true,
 }
 
 // Context carries the resolved values for one ApplyPolicies invocation.
@@ -48,14 +79,22 @@ type Context struct {
 	Hostname     string
 	FullHostname string // always built from the machine domain, even in user policies
 	Domain       string // user domain for users, machine domain for computers
-	IsComputer   bool
+	UID          string // "" for computer policies or if the user can't be looked up
+	GID          string // "" for computer policies or if the user can't be looked up
+	Home         string // "" for computer policies or if the user can't be looked up
+	SID          string // "" if not returned by the directory
+	// Attributes are the directory attributes of the object, by lower-cased name.
+	Attributes map[string]string
+	IsComputer bool
 }
 
 // Expand replaces ${VAR} placeholders in value according to ctx.
 //
-// It returns an error if value contains an unknown variable, a malformed
-// placeholder (unterminated or empty), or a user-only variable while
-// ctx.IsComputer is true. A value without any "${" marker is returned unchanged.
+// It returns an error if value contains an unknown variable or directory
+// attribute, a malformed placeholder (unterminated or empty), a user-only
+// variable while ctx.IsComputer is true, or a variable which couldn't be
+// resolved. "$${" is replaced with a literal "${". A value without any "${"
+// marker is returned unchanged.
 func Expand(value string, ctx Context) (string, error) {
 	// Fast path: nothing to expand.
 	if !strings.Contains(value, "${") {
@@ -68,12 +107,23 @@ func Expand(value string, ctx Context) (string, error) {
 		VarHostname:     ctx.Hostname,
 		VarFullHostname: ctx.FullHostname,
 		VarDomain:       ctx.Domain,
+		VarUID:          ctx.UID,
+		VarGID:          ctx.GID,
+		VarHome:         ctx.Home,
+		VarSID:          ctx.SID,
 	}
 
 	var b strings.Builder
 	b.Grow(len(value))
 
 	for i := 0; i < len(value); {
+		// An escaped "${" is copied literally, without looking for a placeholder.
+		if strings.HasPrefix(value[i:], escapedPlaceholder) {
+			b.WriteString("${")
+			i += len(escapedPlaceholder)
+			continue
+		}
+
 		// Copy everything that is not the start of a "${" placeholder verbatim.
 		if value[i] != '$' || i+1 >= len(value) || value[i+1] != '{' {
 			b.WriteByte(value[i])
@@ -94,6 +144,21 @@ func Expand(value string, ctx Context) (string, error) {
 		}
 
 		canonical := strings.ToUpper(name)
+
+		if strings.HasPrefix(canonical, ADAttributePrefix) {
+			attribute := strings.ToLower(name[len(ADAttributePrefix):])
+			if attribute == "" {
+				return "", errors.New(gotext.Get("empty directory attribute in dynamic value %q in %q", token, value))
+			}
+			resolved, ok := ctx.Attributes[attribute]
+			if !ok {
+				return "", errors.New(gotext.Get("unknown directory attribute in dynamic value %q in %q", token, value))
+			}
+			b.WriteString(resolved)
+			i += 2 + rel + 1
+			continue
+		}
+
 		resolved, ok := values[canonical]
 		if !ok {
 			return "", errors.New(gotext.Get("unknown dynamic value %q in %q", token, value))
@@ -101,6 +166,9 @@ func Expand(value string, ctx Context) (string, error) {
 		if ctx.IsComputer && userOnlyVars[canonical] {
 			return "", errors.New(gotext.Get("dynamic value %q is only available in user policies but was used in a machine policy in %q", token, value))
 		}
+		if resolvedVars[canonical] && resolved == "" {
+			return "", errors.New(gotext.Get("dynamic value %q could not be resolved in %q", token, value))
+		}
 
 		b.WriteString(resolved)
 		i += 2 + rel + 1
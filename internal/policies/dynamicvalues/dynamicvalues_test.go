@@ -16,14 +16,26 @@ func TestExpand(t *testing.T) {
 		Hostname:     "workstation01",
 		FullHostname: "workstation01.dom.com",
 		Domain:       "dom.com",
+		UID:          "1234",
+		GID:          "5678",
+		Home:         "/home/bob@dom.com",
+		SID:          "S-1-5-21-1-2-3-1103",
+		Attributes:   map[string]string{"department": "R&D", "physicaldeliveryofficename": "Paris"},
 		IsComputer:   false,
 	}
 	computerCtx := dynamicvalues.Context{
 		Hostname:     "workstation01",
 		FullHostname: "workstation01.dom.com",
 		Domain:       "dom.com",
+		SID:          "S-1-5-21-1-2-3-1104",
+		Attributes:   map[string]string{"department": "IT"},
 		IsComputer:   true,
 	}
+	unresolvedCtx := dynamicvalues.Context{
+		User:     "bob",
+		FullUser: "bob@dom.com",
+		Domain:   "dom.com",
+	}
 
 	tests := map[string]struct {
 		value string
@@ -38,15 +50,23 @@ func TestExpand(t *testing.T) {
 		"Hostname variable":      {value: "${HOSTNAME}", ctx: userCtx, want: "workstation01"},
 		"Full hostname variable": {value: "${FULL_HOSTNAME}", ctx: userCtx, want: "workstation01.dom.com"},
 		"Domain variable":        {value: "${DOMAIN}", ctx: userCtx, want: "dom.com"},
+		"UID variable":           {value: "${UID}", ctx: userCtx, want: "1234"},
+		"GID variable":           {value: "${GID}", ctx: userCtx, want: "5678"},
+		"Home variable":          {value: "${HOME}/.cache", ctx: userCtx, want: "/home/bob@dom.com/.cache"},
+		"SID variable":           {value: "${SID}", ctx: userCtx, want: "S-1-5-21-1-2-3-1103"},
+		"Directory attribute":    {value: "smb://h/${AD:department}", ctx: userCtx, want: "smb://h/R&D"},
 
 		// Computer context.
 		"Hostname in computer policy":      {value: "${HOSTNAME}", ctx: computerCtx, want: "workstation01"},
 		"Full hostname in computer policy": {value: "${FULL_HOSTNAME}", ctx: computerCtx, want: "workstation01.dom.com"},
 		"Domain in computer policy":        {value: "${DOMAIN}", ctx: computerCtx, want: "dom.com"},
+		"SID in computer policy":           {value: "${SID}", ctx: computerCtx, want: "S-1-5-21-1-2-3-1104"},
+		"Attribute in computer policy":     {value: "${AD:department}", ctx: computerCtx, want: "IT"},
 
 		// Case-insensitivity.
-		"Lowercase variable name":  {value: "${user}", ctx: userCtx, want: "bob"},
-		"Mixed case variable name": {value: "${User}", ctx: userCtx, want: "bob"},
+		"Lowercase variable name":   {value: "${user}", ctx: userCtx, want: "bob"},
+		"Mixed case variable name":  {value: "${User}", ctx: userCtx, want: "bob"},
+		"Mixed case attribute name": {value: "${ad:physicalDeliveryOfficeName}", ctx: userCtx, want: "Paris"},
 
 		// Composition.
 		"Multiple tokens in one value": {value: "${USER}@${DOMAIN}", ctx: userCtx, want: "bob@dom.com"},
@@ -61,6 +81,9 @@ func TestExpand(t *testing.T) {
 		"Bare variable without braces literal":    {value: "$USER", ctx: userCtx, want: "$USER"},
 		"Dollar then text without braces literal": {value: "a$b", ctx: userCtx, want: "a$b"},
 		"URL percent-encoding preserved":          {value: "smb://h/a%20b%2Fc/${USER}", ctx: userCtx, want: "smb://h/a%20b%2Fc/bob"},
+		"Escaped placeholder is literal":          {value: "$${USER}", ctx: userCtx, want: "${USER}"},
+		"Escaped placeholder next to a token":     {value: "$${TYPO}-${USER}", ctx: userCtx, want: "${TYPO}-bob"},
+		"Escaped unterminated placeholder":        {value: "echo $${", ctx: userCtx, want: "echo ${"},
 
 		// Error cases.
 		"Error on unknown variable":              {value: "${TYPO}", ctx: userCtx, wantErr: true},
@@ -71,6 +94,14 @@ func TestExpand(t *testing.T) {
 		"Error on user var in computer policy":   {value: "${USER}", ctx: computerCtx, wantErr: true},
 		"Error on full user in computer policy":  {value: "${FULL_USER}", ctx: computerCtx, wantErr: true},
 		"Error reported even after valid tokens": {value: "${HOSTNAME}/${TYPO}", ctx: userCtx, wantErr: true},
+		"Error on unknown attribute":             {value: "${AD:unknown}", ctx: userCtx, wantErr: true},
+		"Error on empty attribute":               {value: "${AD:}", ctx: userCtx, wantErr: true},
+		"Error on uid in computer policy":        {value: "${UID}", ctx: computerCtx, wantErr: true},
+		"Error on home in computer policy":       {value: "${HOME}", ctx: computerCtx, wantErr: true},
+		"Error on unresolved uid":                {value: "${UID}", ctx: unresolvedCtx, wantErr: true},
+		"Error on unresolved gid":                {value: "${GID}", ctx: unresolvedCtx, wantErr: true},
+		"Error on unresolved sid":                {value: "${SID}", ctx: unresolvedCtx, wantErr: true},
+		"Error on attribute without attributes":  {value: "${AD:department}", ctx: unresolvedCtx, wantErr: true},
 	}
 
 	for name, tc := range tests {
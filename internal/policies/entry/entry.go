@@ -13,6 +13,11 @@ type Entry struct {
 	// Strategy are overlay rules for the same keys between multiple GPOs.
 	// Default (empty or unknown value) means "override".
 	Strategy string `yaml:",omitempty"`
+	// Target is the targeting expression restricting the entry to the users and machines it matches.
+	// Empty means that the entry applies to every object the GPO applies to.
+	Target string `yaml:",omitempty"`
+	// Source is the file of the GPO the entry comes from, when it is not the registry policy.
+	Source string `yaml:",omitempty"`
 	// Err is set if there was an error parsing the entry. It is ignored if the
 	// underlying key is not supported by adsys.
 	Err error `yaml:"-"`
@@ -25,5 +30,26 @@ const (
 	// append means from a GPO standpoint that the further GPO value is listed before closest GPO
 	// (and then, enforced GPO in reverse order).
 	StrategyAppend = "append"
-	// This can be extended to support prepend but it is implemented yet as there is no real world cases.
+	// StrategyPrepend is the strategy to prepend a value to an existing one: the closest GPO value is listed first.
+	StrategyPrepend = "prepend"
+	// StrategyUnion is the strategy to append a value to an existing one, listing each line only once, in the
+	// order it first appears.
+	StrategyUnion = "union"
+	// StrategyRemove is the strategy to remove the lines of a value from the existing one, set by further GPOs.
+	StrategyRemove = "remove"
 )
+
+// Strategies returns all supported strategies.
+func Strategies() []string {
+	return []string{StrategyOverride, StrategyAppend, StrategyPrepend, StrategyUnion, StrategyRemove}
+}
+
+// MergesValues returns if values with this strategy are merged, line by line, with the ones of the same key
+// in further GPOs instead of overriding them.
+func MergesValues(strategy string) bool {
+	switch strategy {
+	case StrategyAppend, StrategyPrepend, StrategyUnion, StrategyRemove:
+		return true
+	}
+	return false
+}
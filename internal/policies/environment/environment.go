@@ -0,0 +1,262 @@
+// Package environment provides a manager to set the environment variables of the sessions.
+//
+// The entry value lists the variables, one per line, in the order they are processed:
+// - NAME=value: sets the variable, overriding the value set by a previous line;
+// - NAME: unsets the variable set by a previous line.
+//
+// Empty lines and lines starting with # are ignored.
+//
+// Machine variables are written to a drop-in of /etc/environment.d and user variables to the
+// ~/.config/environment.d directory of the user, owned by the user, both in the environment.d(5) format. They are
+// read by the systemd user instance when a session starts, so values can reference other variables, e.g. $HOME.
+// The files are removed once there are no variables to set.
+//
+// User variables are only written if the home directory of the user exists. Files are written inside the home
+// directory without following symlinks leading out of it.
+package environment
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/rootfs"
+	"github.com/ubuntu/decorate"
+)
+
+// FileName is the name of the environment file written by the manager, for the machine and the users.
+const FileName = "99-adsys-environment.conf"
+
+// userDir is the environment directory of a user, relative to the home directory.
+var userDir = filepath.Join(".config", "environment.d")
+
+const header = `# This file is managed by adsys.
+# Do not edit this file manually.
+# Any changes will be overwritten.
+
+`
+
+// variableName matches the names of the variables which can be set in a session environment.
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Manager holds information needed for handling the environment policies.
+type Manager struct {
+	environmentDir string
+	homeRoot       string
+
+	userLookup func(string) (*user.User, error)
+}
+
+type options struct {
+	homeRoot   string
+	userLookup func(string) (*user.User, error)
+}
+
+// Option reprents an optional function to change the environment manager.
+type Option func(*options)
+
+// WithHomeRoot specifies a personalized directory the home directories of the users are relative to.
+func WithHomeRoot(p string) Option {
+	return func(o *options) {
+		o.homeRoot = p
+	}
+}
+
+// WithUserLookup specifies a personalized function to retrieve the users and their home directory.
+func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
+	return func(o *options) {
+		o.userLookup = userLookup
+	}
+}
+
+// New returns a new environment policy manager writing the machine variables to environmentDir.
+func New(environmentDir string, opts ...Option) *Manager {
+	o := options{
+		homeRoot:   "/",
+		userLookup: user.Lookup,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Manager{
+		environmentDir: environmentDir,
+		homeRoot:       o.homeRoot,
+		userLookup:     o.userLookup,
+	}
+}
+
+// UserFile returns the environment file of the user whose home directory is home.
+func UserFile(home string) string {
+	return filepath.Join(home, userDir, FileName)
+}
+
+// ApplyPolicy writes the environment variables of entries for objectName.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't apply environment policy to %s", objectName))
+
+	log.Debugf(ctx, "Applying environment policy to %s", objectName)
+
+	key := "user-environment"
+	if isComputer {
+		key = "system-environment"
+	}
+
+	var vars []string
+	for _, e := range entries {
+		if e.Key != key {
+			log.Debug(ctx, gotext.Get("The entry %q does not apply to %s and will be skipped", e.Key, objectName))
+			continue
+		}
+		if e.Disabled {
+			log.Debug(ctx, gotext.Get("The entry %q is disabled and will be skipped", e.Key))
+			continue
+		}
+		if vars, err = variables(e); err != nil {
+			return err
+		}
+	}
+
+	var content string
+	if len(vars) > 0 {
+		content = header + strings.Join(vars, "\n") + "\n"
+	}
+
+	if isComputer {
+		return m.applyMachine(content)
+	}
+	return m.applyUser(ctx, objectName, content)
+}
+
+// variables returns the NAME=value lines of the variables set by e, in the order they were last set.
+func variables(e entry.Entry) (vars []string, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to parse entry values"))
+
+	if e.Err != nil {
+		return nil, errors.New(gotext.Get("entry is errored: %v", e.Err))
+	}
+
+	for _, l := range strings.Split(e.Value, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		name, _, set := strings.Cut(l, "=")
+		if !variableName.MatchString(name) {
+			return nil, errors.New(gotext.Get("invalid variable name %q", name))
+		}
+
+		// A variable set again is moved last, so that it can reference the variables set before it.
+		vars = slices.DeleteFunc(vars, func(v string) bool {
+			return strings.HasPrefix(v, name+"=")
+		})
+		if set {
+			vars = append(vars, l)
+		}
+	}
+
+	return vars, nil
+}
+
+// applyMachine writes content to the machine environment file, or removes it if content is empty.
+func (m *Manager) applyMachine(content string) (err error) {
+	p := filepath.Join(m.environmentDir, FileName)
+	if content == "" {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	//nolint:gosec // G301 - /etc/environment.d permissions are 0755, so we should keep the same pattern.
+	if err := os.MkdirAll(m.environmentDir, 0755); err != nil {
+		return err
+	}
+	//nolint:gosec // G306 - The environment is readable by every user.
+	if err := os.WriteFile(p+".new", []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// applyUser writes content to the environment file of the user, or removes it if content is empty.
+func (m *Manager) applyUser(ctx context.Context, username, content string) (err error) {
+	u, err := m.userLookup(username)
+	if err != nil {
+		return errors.New(gotext.Get("could not retrieve user for %q: %v", username, err))
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return errors.New(gotext.Get("couldn't convert %q to a valid uid for %q", u.Uid, username))
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return errors.New(gotext.Get("couldn't convert %q to a valid gid for %q", u.Gid, username))
+	}
+
+	// The home directory is opened as a root, so that symlinks set by the user can't lead out of it.
+	home, err := os.OpenRoot(filepath.Join(m.homeRoot, u.HomeDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		if content != "" {
+			log.Warning(ctx, gotext.Get("Home directory %q of %q does not exist yet, environment variables will be set on next refresh", u.HomeDir, username))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer home.Close()
+
+	p := filepath.Join(userDir, FileName)
+	if content == "" {
+		if err := home.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// Directories are only created, and given to the user, if they don't exist yet.
+	var dir string
+	for _, d := range strings.Split(userDir, string(filepath.Separator)) {
+		dir = filepath.Join(dir, d)
+		if err := home.Mkdir(dir, 0700); errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := rootfs.Chown(home, dir, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	// The file is always created again, so that we never write through a file planted by the user.
+	if err := home.Remove(p + ".new"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := home.OpenFile(p+".new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := rootfs.Chown(home, p+".new", uid, gid); err != nil {
+		return err
+	}
+	return home.Rename(p+".new", p)
+}
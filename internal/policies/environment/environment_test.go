@@ -0,0 +1,115 @@
+package environment_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		isUser   bool
+		existing bool
+		noHome   bool
+		// homeSymlink replaces the environment directory of the user with a symlink leading out of the home directory.
+		homeSymlink   bool
+		userLookupErr bool
+		invalidUID    bool
+
+		wantErr bool
+	}{
+		"Set machine variables":                    {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nPAGER=less -R\nEMPTY="}}},
+		"Variables set again are moved last":       {entries: []entry.Entry{{Key: "system-environment", Value: "A=1\nB=2\nA=3\nC=$A"}}},
+		"Variables can be unset":                   {entries: []entry.Entry{{Key: "system-environment", Value: "A=1\nB=2\nA\nC"}}},
+		"Blank lines and comments are ignored":     {entries: []entry.Entry{{Key: "system-environment", Value: "\n  EDITOR=vim  \n# PAGER=less\n\n"}}},
+		"Existing file is replaced":                {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim"}}, existing: true},
+		"Existing file is removed without entry":   {existing: true},
+		"Existing file is removed if all unset":    {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nEDITOR"}}, existing: true},
+		"Disabled entries are ignored":             {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim", Disabled: true}}, existing: true},
+		"User entries are ignored for computers":   {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}},
+		"No entries and no existing file":          {},
+		"Set user variables":                       {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim\nPAGER=less -R"}}, isUser: true},
+		"Existing user file is replaced":           {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, isUser: true, existing: true},
+		"Existing user file is removed":            {isUser: true, existing: true},
+		"Machine entries are ignored for users":    {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim"}}, isUser: true, existing: true},
+		"Users without home directory are skipped": {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, isUser: true, noHome: true},
+
+		// Error cases
+		"Error on invalid variable name":                     {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim\nMY-VAR=1"}}, existing: true, wantErr: true},
+		"Error on errored entry":                             {entries: []entry.Entry{{Key: "system-environment", Value: "EDITOR=vim", Err: errors.New("some error")}}, wantErr: true},
+		"Error on unknown user":                              {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, isUser: true, userLookupErr: true, wantErr: true},
+		"Error on invalid user id":                           {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, isUser: true, invalidUID: true, wantErr: true},
+		"Error on symlink leading out of the home directory": {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, isUser: true, homeSymlink: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out := t.TempDir()
+			environmentDir := filepath.Join(out, "etc", "environment.d")
+			home := filepath.Join("home", "bob@example.com")
+
+			if !tc.noHome {
+				require.NoError(t, os.MkdirAll(filepath.Join(out, home), 0700), "Setup: can't create home directory")
+			}
+			if tc.existing {
+				require.NoError(t, os.MkdirAll(environmentDir, 0700), "Setup: can't create environment directory")
+				require.NoError(t, os.MkdirAll(filepath.Dir(environment.UserFile(filepath.Join(out, home))), 0700), "Setup: can't create user environment directory")
+				testutils.Copy(t, filepath.Join("testdata", "existing.conf"), filepath.Join(environmentDir, environment.FileName))
+				testutils.Copy(t, filepath.Join("testdata", "existing.conf"), environment.UserFile(filepath.Join(out, home)))
+			}
+			outside := t.TempDir()
+			if tc.homeSymlink {
+				require.NoError(t, os.MkdirAll(filepath.Join(out, home, ".config"), 0700), "Setup: can't create config directory")
+				require.NoError(t, os.Symlink(outside, filepath.Join(out, home, ".config", "environment.d")), "Setup: can't create symlink")
+			}
+
+			// The user is the current one, so that files can be given to it without being root.
+			uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+			if tc.invalidUID {
+				uid = "invalid"
+			}
+			userLookup := func(name string) (*user.User, error) {
+				if tc.userLookupErr {
+					return nil, errors.New("user not found")
+				}
+				return &user.User{Username: name, Uid: uid, Gid: gid, HomeDir: "/" + home}, nil
+			}
+
+			m := environment.New(environmentDir, environment.WithHomeRoot(out), environment.WithUserLookup(userLookup))
+
+			objectName := "ubuntu"
+			if tc.isUser {
+				objectName = "bob@example.com"
+			}
+			err := m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries)
+			if tc.wantErr {
+				// We don't return here as we want to check that nothing was changed in error cases
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			if tc.homeSymlink {
+				written, err := os.ReadDir(outside)
+				require.NoError(t, err, "Teardown: can't read directory outside of home")
+				require.Empty(t, written, "ApplyPolicy should not write out of the home directory")
+				return
+			}
+
+			testutils.CompareTreesWithFiltering(t, out, testutils.GoldenPath(t), testutils.UpdateEnabled())
+		})
+	}
+}
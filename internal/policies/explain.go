@@ -0,0 +1,188 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/dynamicvalues"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// ruleOutcome is how a GPO definition of a rule contributes to the effective rule.
+type ruleOutcome int
+
+const (
+	// outcomeWon is the closest definition, which sets the strategy of the effective rule.
+	outcomeWon ruleOutcome = iota
+	// outcomeMerged is a definition whose value is merged in the effective rule.
+	outcomeMerged
+	// outcomeOverridden is a definition ignored as a closer definition overrides it.
+	outcomeOverridden
+	// outcomeDisabledMerge is a disabled definition ignored as only enabled values are merged.
+	outcomeDisabledMerge
+	// outcomeFiltered is a definition ignored as its targeting expression does not match.
+	outcomeFiltered
+)
+
+// String returns a human readable version of the outcome.
+func (o ruleOutcome) String() string {
+	switch o {
+	case outcomeWon:
+		return gotext.Get("won")
+	case outcomeMerged:
+		return gotext.Get("merged")
+	case outcomeOverridden:
+		return gotext.Get("overridden")
+	case outcomeDisabledMerge:
+		return gotext.Get("ignored, disabled values are not merged")
+	case outcomeFiltered:
+		return gotext.Get("filtered by targeting")
+	}
+	return gotext.Get("unknown")
+}
+
+// ruleDefinition is a rule as defined by one GPO.
+type ruleDefinition struct {
+	gpo     GPO
+	entry   entry.Entry
+	outcome ruleOutcome
+}
+
+// ruleDefinitions returns, from the closest GPO to the furthest one, all the definitions of the rule of type
+// ruleType and key, with how each contributes to the effective rule computed by GetUniqueRules.
+// Definitions not kept by their targeting expression contribute nothing.
+func (pols Policies) ruleDefinitions(ruleType, key string, keep targetFilter) (defs []ruleDefinition, err error) {
+	var winner *entry.Entry
+	for _, g := range pols.GPOs {
+		for _, e := range g.Rules[ruleType] {
+			if e.Key != key {
+				continue
+			}
+
+			kept, err := keep(e)
+			if err != nil {
+				return nil, err
+			}
+
+			d := ruleDefinition{gpo: g, entry: e}
+			switch {
+			case !kept:
+				d.outcome = outcomeFiltered
+			case entry.MergesValues(e.Strategy) && e.Disabled:
+				d.outcome = outcomeDisabledMerge
+			case winner == nil:
+				d.outcome = outcomeWon
+				winner = &e
+			case entry.MergesValues(winner.Strategy) && entry.MergesValues(e.Strategy):
+				d.outcome = outcomeMerged
+			default:
+				d.outcome = outcomeOverridden
+			}
+			defs = append(defs, d)
+		}
+	}
+
+	return defs, nil
+}
+
+// ExplainPolicy explains how the rule of type ruleType and key is set by the policies applied to objectName:
+// every GPO defining it, which one won and if values were merged, and the effective value after dynamic
+// values expansion.
+func (m *Manager) ExplainPolicy(ctx context.Context, objectName string, isComputer bool, ruleType, key string) (msg string, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to explain %s %s for %q", ruleType, key, objectName))
+
+	log.Infof(ctx, "Explaining policy %s %s for %s", ruleType, key, objectName)
+
+	pols, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	if err != nil {
+		return "", errors.New(gotext.Get("no policy applied for %q: %v", objectName, err))
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, pols.Close)
+
+	var out strings.Builder
+	fmt.Fprintln(&out, gotext.Get("Policy %s %s for %s:", ruleType, key, objectName))
+
+	keep := m.targetFilter(objectName, isComputer)
+	defs, err := pols.ruleDefinitions(ruleType, key, keep)
+	if err != nil {
+		return "", err
+	}
+	if len(defs) == 0 {
+		fmt.Fprintln(&out, gotext.Get("Not defined by any applied GPO."))
+		return out.String(), nil
+	}
+
+	var merged bool
+	for _, d := range defs {
+		strategy := d.entry.Strategy
+		if strategy == "" {
+			strategy = entry.StrategyOverride
+		}
+		fmt.Fprintf(&out, "* %s (%s) [%s]: %s\n", d.gpo.Name, d.gpo.ID, strategy, explainValue(d.entry))
+		if d.entry.Source != "" {
+			fmt.Fprintf(&out, "  %s\n", gotext.Get("source: %s", d.entry.Source))
+		}
+		if d.entry.Target != "" {
+			fmt.Fprintf(&out, "  %s\n", gotext.Get("target: %s", d.entry.Target))
+		}
+		fmt.Fprintf(&out, "  %s\n", d.outcome)
+		if d.outcome == outcomeMerged {
+			merged = true
+		}
+	}
+
+	if i := slices.IndexFunc(defs, func(d ruleDefinition) bool { return d.outcome == outcomeWon }); i != -1 {
+		fmt.Fprintln(&out, gotext.Get("Winning GPO: %s (%s)", defs[i].gpo.Name, defs[i].gpo.ID))
+	}
+	if merged {
+		fmt.Fprintln(&out, gotext.Get("Values merged: yes"))
+	} else {
+		fmt.Fprintln(&out, gotext.Get("Values merged: no"))
+	}
+
+	// The effective rule is computed the same way as when applying policies.
+	targeted, err := pols.withoutTargetedOut(ctx, keep)
+	if err != nil {
+		return "", err
+	}
+	rules := targeted.GetUniqueRules()
+	i := slices.IndexFunc(rules[ruleType], func(e entry.Entry) bool { return e.Key == key })
+	if i == -1 {
+		fmt.Fprintln(&out, gotext.Get("Effective value: not set"))
+		return out.String(), nil
+	}
+	effective := rules[ruleType][i]
+
+	if !effective.Disabled {
+		dynCtx, err := m.dynamicValuesContext(objectName, isComputer, &pols)
+		if err != nil {
+			return "", err
+		}
+		if effective.Value, err = dynamicvalues.Expand(effective.Value, dynCtx); err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintln(&out, gotext.Get("Effective value: %s", explainValue(effective)))
+
+	if slices.Contains(m.ProOnlyRules(), ruleType) && !m.GetSubscriptionState(ctx) {
+		fmt.Fprintln(&out, gotext.Get("The rule is not applied as the machine is not enrolled to Ubuntu Pro."))
+	}
+
+	return out.String(), nil
+}
+
+// explainValue returns the value of e on a single line, or that it is disabled.
+func explainValue(e entry.Entry) string {
+	if e.Disabled {
+		return gotext.Get("Disabled")
+	}
+	// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
+	return strings.ReplaceAll(strings.TrimSpace(e.Value), "\n", `\n`)
+}
@@ -0,0 +1,152 @@
+package policies_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestExplainPolicy(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
+		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+
+	// gpo returns a GPO named name, defining the given entries of ruleType.
+	gpo := func(name, ruleType string, entries ...entry.Entry) policies.GPO {
+		return policies.GPO{ID: "{" + name + "}", Name: name, Rules: map[string][]entry.Entry{ruleType: entries}}
+	}
+
+	tests := map[string]struct {
+		gpos            []policies.GPO
+		ruleType        string
+		isComputer      bool
+		isNotSubscribed bool
+		noCache         bool
+
+		wantErr bool
+	}{
+		"Single GPO definition": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'value'"}),
+		}},
+		"Closest GPO overrides furthest ones": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'closest'"}),
+			gpo("Middle", "dconf", entry.Entry{Key: "path/to/key", Value: "'middle'"}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "'furthest'"}),
+		}},
+		"Append values are concatenated": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "closest", Strategy: entry.StrategyAppend}),
+			gpo("Middle", "dconf", entry.Entry{Key: "path/to/key", Value: "middle", Strategy: entry.StrategyAppend}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest\nmultilines", Strategy: entry.StrategyAppend}),
+		}},
+		"Closest override prevents append concatenation": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "closest"}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest", Strategy: entry.StrategyAppend}),
+		}},
+		"Override between append values is ignored": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "closest", Strategy: entry.StrategyAppend}),
+			gpo("Middle", "dconf", entry.Entry{Key: "path/to/key", Value: "middle"}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest", Strategy: entry.StrategyAppend}),
+		}},
+		"Removed values are subtracted from furthest ones": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "middle", Strategy: entry.StrategyRemove}),
+			gpo("Middle", "dconf", entry.Entry{Key: "path/to/key", Value: "middle", Strategy: entry.StrategyPrepend}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest", Strategy: entry.StrategyAppend}),
+		}},
+		"Disabled append values are ignored": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Disabled: true, Strategy: entry.StrategyAppend}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest", Strategy: entry.StrategyAppend}),
+		}},
+		"Disabled rule": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Disabled: true}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest"}),
+		}},
+		"Only other keys and types are defined": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/other", Value: "other"}),
+			gpo("Furthest", "scripts", entry.Entry{Key: "path/to/key", Value: "script"}),
+		}},
+		"Dynamic values are expanded": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'/home/${USER}@${DOMAIN}'"}),
+		}},
+		"Dynamic values are expanded for the machine": {isComputer: true, gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'${HOSTNAME}'"}),
+		}},
+		"Definitions not matching their target are filtered": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'closest'", Target: `group("Domain Admins")`}),
+			gpo("Middle", "dconf", entry.Entry{Key: "path/to/key", Value: "'middle'", Target: `group("Developers") and file("/etc/adsys/kiosk")`}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "'furthest'"}),
+		}},
+		"Filtered append values are not merged": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "closest", Strategy: entry.StrategyAppend, Target: "laptop()"}),
+			gpo("Furthest", "dconf", entry.Entry{Key: "path/to/key", Value: "furthest", Strategy: entry.StrategyAppend}),
+		}},
+		"Every definition filtered leaves the rule unset": {gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'closest'", Target: `release("<22.04")`}),
+		}},
+		"Source of definitions from preferences is listed": {ruleType: "mount", gpos: []policies.GPO{
+			gpo("Closest", "mount", entry.Entry{Key: "path/to/key", Value: "[krb5]smb://server/share", Strategy: entry.StrategyAppend, Source: "User/Preferences/Drives/Drives.xml"}),
+			gpo("Furthest", "mount", entry.Entry{Key: "path/to/key", Value: "smb://server/public", Strategy: entry.StrategyAppend}),
+		}},
+		"Pro only rule is not applied on machine not enrolled": {ruleType: "privilege", isNotSubscribed: true, gpos: []policies.GPO{
+			gpo("Closest", "privilege", entry.Entry{Key: "path/to/key", Value: "bob@example.com"}),
+		}},
+
+		"Error on no policies applied": {noCache: true, wantErr: true},
+		"Error on invalid targeting expression": {wantErr: true, gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'closest'", Target: `group(Developers)`}),
+		}},
+		"Error on unknown dynamic value": {wantErr: true, gpos: []policies.GPO{
+			gpo("Closest", "dconf", entry.Entry{Key: "path/to/key", Value: "'${UNKNOWN}'"}),
+		}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			status := !tc.isNotSubscribed
+			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", status), "Setup: can not set subscription status to %q", status)
+			defer func() {
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
+			}()
+
+			if tc.ruleType == "" {
+				tc.ruleType = "dconf"
+			}
+			objectName := "bob@example.com"
+			if tc.isComputer {
+				objectName = "myhost"
+			}
+
+			cacheDir := t.TempDir()
+			m, err := policies.NewManager(bus, "myhost", mockBackend{}, policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()),
+				policies.WithSystemRoot(filepath.Join("testdata", "targeting", "root")), policies.WithUserGroups(mockUserGroups))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if !tc.noCache {
+				pols := policies.Policies{GPOs: tc.gpos}
+				err = pols.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, objectName))
+				require.NoError(t, err, "Setup: couldn’t save policies in cache")
+			}
+
+			got, err := m.ExplainPolicy(context.Background(), objectName, tc.isComputer, tc.ruleType, "path/to/key")
+			if tc.wantErr {
+				require.Error(t, err, "ExplainPolicy should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ExplainPolicy should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "ExplainPolicy returned unexpected explanation")
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package policies
 
 import (
+	"os/user"
+	"reflect"
+	"strings"
+	"time"
+
 	"github.com/ubuntu/adsys/internal/policies/dynamicvalues"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
@@ -29,11 +34,86 @@ func (pols Policies) HasAssets() bool {
 }
 
 // DynamicValuesContext exposes dynamicValuesContext for testing.
-func (m *Manager) DynamicValuesContext(objectName string, isComputer bool) (dynamicvalues.Context, error) {
-	return m.dynamicValuesContext(objectName, isComputer)
+func (m *Manager) DynamicValuesContext(objectName string, isComputer bool, pols *Policies) (dynamicvalues.Context, error) {
+	return m.dynamicValuesContext(objectName, isComputer, pols)
 }
 
 // ExpandDynamicValues exposes expandDynamicValues for testing.
 func ExpandDynamicValues(rules map[string][]entry.Entry, dynCtx dynamicvalues.Context) error {
 	return expandDynamicValues(rules, dynCtx)
 }
+
+// WithUserLookup specifies a personalized function to look up users on the machine.
+func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
+	return func(o *options) error {
+		o.userLookup = userLookup
+		return nil
+	}
+}
+
+// WithNow specifies a personalized function returning the current time.
+func WithNow(now func() time.Time) Option {
+	return func(o *options) error {
+		o.now = now
+		return nil
+	}
+}
+
+// WithUserGroups specifies a personalized function returning the groups of users and computers on the machine.
+func WithUserGroups(userGroups func(string) ([]string, error)) Option {
+	return func(o *options) error {
+		o.userGroups = userGroups
+		return nil
+	}
+}
+
+// WithSystemRoot specifies a personalized root directory to gather targeting facts from.
+func WithSystemRoot(p string) Option {
+	return func(o *options) error {
+		o.systemRoot = p
+		return nil
+	}
+}
+
+// WithFilesRoot specifies a personalized root directory the files manager deploys machine targets to.
+func WithFilesRoot(p string) Option {
+	return func(o *options) error {
+		o.filesRoot = p
+		return nil
+	}
+}
+
+// WithPluginsOwner specifies a personalized user who must own the policy manager plugins, instead of root.
+func WithPluginsOwner(uid int) Option {
+	return func(o *options) error {
+		o.pluginsOwner = uid
+		return nil
+	}
+}
+
+// PlanDirs returns the directories, by option name, that the policy managers of m use when planning under root.
+func (m *Manager) PlanDirs(root string) map[string]string {
+	dirs := make(map[string]string)
+	o := reflect.ValueOf(m.opts.rootedAt(root))
+	for i := range o.NumField() {
+		f := o.Type().Field(i)
+		if f.Type.Kind() != reflect.String || !(strings.HasSuffix(f.Name, "Dir") || strings.HasSuffix(f.Name, "Root")) {
+			continue
+		}
+		dirs[f.Name] = o.Field(i).String()
+	}
+	return dirs
+}
+
+// SnapshotPaths returns the files, by rule type, that the built-in policy managers of m can replace for objectName.
+func (m *Manager) SnapshotPaths(objectName string, isComputer bool) map[string][]string {
+	paths := make(map[string][]string)
+	for _, pm := range m.policyManagers {
+		b, ok := pm.(builtinManager)
+		if !ok {
+			continue
+		}
+		paths[b.Type()] = b.SnapshotPaths(objectName, isComputer, nil)
+	}
+	return paths
+}
@@ -0,0 +1,798 @@
+// Package files provides a manager to deploy files and directories from the GPO assets.
+//
+// Each entry is a file or a directory, keyed by its target path, whose value lists its settings, one per line:
+//   - Action=<action>: create, to only create the target if it does not exist; replace, to write it again;
+//     update, the default, to create the target if it does not exist or only set its owner and mode otherwise;
+//     delete, to remove it;
+//   - Source=<path>: the file of the GPO assets the target is a copy of;
+//   - Content=<base64>: the content of the target, for files which are not in the GPO assets, like the files of the
+//     GPO itself. Targets without a source nor a content are directories;
+//   - Owner=<user> and Group=<group>: the owner of the target, only for machine targets;
+//   - Mode=<octal permissions>: the mode of the target, which defaults to 0644 for files and 0755 for directories;
+//   - Recursive=true: a deleted directory is removed with its content, instead of only if empty.
+//
+// Machine targets are absolute paths, which must be under one of the allowed prefixes and not be security-sensitive
+// paths, like /etc/passwd, /etc/sudoers.d or /root, whatever the allowed prefixes. Other targets are skipped.
+// They are listed from the root of the system in the manifest, even when the manager is redirected to another root.
+// User targets are relative to the home directory of the user, and are owned by the user.
+// Targets are written without following symlinks leading out of their allowed prefix or home directory.
+//
+// A manifest records the files and directories created by the manager. They are removed once no policy sets them,
+// directories only when empty. Files and directories which existed before are only removed by a delete action.
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/rootfs"
+	"github.com/ubuntu/decorate"
+)
+
+// Actions of the entries on their target.
+const (
+	// ActionCreate creates the target if it does not exist.
+	ActionCreate = "create"
+	// ActionReplace writes the target again, replacing its content.
+	ActionReplace = "replace"
+	// ActionUpdate creates the target if it does not exist, or only sets its owner and mode.
+	ActionUpdate = "update"
+	// ActionDelete removes the target.
+	ActionDelete = "delete"
+)
+
+// DefaultAllowedPrefixes are the directories machine targets can be deployed to by default.
+var DefaultAllowedPrefixes = []string{"/etc/skel", "/opt", "/srv", "/usr/local/etc", "/usr/local/share"}
+
+// deniedPaths are the patterns of the security-sensitive paths machine targets can never be deployed to, or under,
+// whatever the allowed prefixes.
+var deniedPaths = []string{
+	// Accounts and authentication.
+	"/etc/passwd*",
+	"/etc/group*",
+	"/etc/shadow*",
+	"/etc/gshadow*",
+	"/etc/sudoers*",
+	"/etc/pam.d",
+	"/etc/security",
+	"/etc/polkit-1",
+	"/etc/ssh",
+	"/etc/krb5.keytab",
+	"/etc/sssd",
+	"/etc/nsswitch.conf",
+	// Code run as root or by every user.
+	"/etc/systemd",
+	"/etc/cron*",
+	"/etc/anacrontab",
+	"/etc/ld.so.preload",
+	"/etc/ld.so.conf*",
+	"/etc/profile*",
+	"/etc/environment",
+	"/etc/bash.bashrc",
+	"/root",
+}
+
+// Manager holds information needed for handling the files policies.
+type Manager struct {
+	stateDir        string
+	allowedPrefixes []string
+	root            string
+	homeRoot        string
+
+	userLookup  func(string) (*user.User, error)
+	groupLookup func(string) (*user.Group, error)
+}
+
+type options struct {
+	allowedPrefixes []string
+	root            string
+	homeRoot        string
+	userLookup      func(string) (*user.User, error)
+	groupLookup     func(string) (*user.Group, error)
+}
+
+// Option represents an optional function to change the files manager.
+type Option func(*options)
+
+// WithAllowedPrefixes specifies personalized directories machine targets can be deployed to.
+func WithAllowedPrefixes(prefixes []string) Option {
+	return func(o *options) {
+		o.allowedPrefixes = prefixes
+	}
+}
+
+// WithRoot specifies a personalized directory the machine targets and allowed prefixes are relative to.
+func WithRoot(p string) Option {
+	return func(o *options) {
+		o.root = p
+	}
+}
+
+// WithHomeRoot specifies a personalized directory the home directories of the users are relative to.
+func WithHomeRoot(p string) Option {
+	return func(o *options) {
+		o.homeRoot = p
+	}
+}
+
+// WithUserLookup specifies a personalized function to retrieve the users and their home directory.
+func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
+	return func(o *options) {
+		o.userLookup = userLookup
+	}
+}
+
+// WithGroupLookup specifies a personalized function to retrieve the groups owning the targets.
+func WithGroupLookup(groupLookup func(string) (*user.Group, error)) Option {
+	return func(o *options) {
+		o.groupLookup = groupLookup
+	}
+}
+
+// New returns a new files policy manager, recording the files and directories it creates in stateDir.
+func New(stateDir string, opts ...Option) *Manager {
+	o := options{
+		allowedPrefixes: DefaultAllowedPrefixes,
+		root:            "/",
+		homeRoot:        "/",
+		userLookup:      user.Lookup,
+		groupLookup:     user.LookupGroup,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Manager{
+		stateDir:        stateDir,
+		allowedPrefixes: o.allowedPrefixes,
+		root:            o.root,
+		homeRoot:        o.homeRoot,
+		userLookup:      o.userLookup,
+		groupLookup:     o.groupLookup,
+	}
+}
+
+// AssetsDumper is a function which uncompress policies assets to a directory.
+type AssetsDumper func(ctx context.Context, relSrc, dest string, uid int, gid int) (err error)
+
+// item is a file or a directory to deploy, parsed from an entry.
+type item struct {
+	target    string
+	action    string
+	source    string
+	content   []byte
+	owner     string
+	group     string
+	mode      fs.FileMode
+	modeSet   bool
+	recursive bool
+}
+
+// object is the machine or the user the files are deployed for.
+type object struct {
+	isComputer bool
+	// root is the directory the paths of the manifest are relative to: the root of the system for the machine,
+	// or the home directory of the user.
+	root     string
+	uid, gid int
+	// manifest is the file listing the paths created by the manager.
+	manifest string
+}
+
+// errNotAllowed is returned when a machine target is not under one of the allowed prefixes, or is denied.
+var errNotAllowed = errors.New("target is not under an allowed directory")
+
+// ApplyPolicy deploys the files and directories of entries for objectName.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't apply files policy to %s", objectName))
+
+	log.Debugf(ctx, "Applying files policy to %s", objectName)
+
+	o, err := m.object(objectName, isComputer)
+	if err != nil {
+		return err
+	}
+
+	var items []item
+	for _, e := range entries {
+		if e.Disabled {
+			log.Debug(ctx, gotext.Get("The entry %q is disabled and will be skipped", e.Key))
+			continue
+		}
+		it, err := parseItem(e, isComputer)
+		if err != nil {
+			return err
+		}
+		items = append(items, it)
+	}
+	// Parent directories are deployed before their content.
+	slices.SortFunc(items, func(a, b item) int { return strings.Compare(a.target, b.target) })
+
+	if !isComputer {
+		if _, err := os.Stat(o.root); errors.Is(err, fs.ErrNotExist) {
+			if len(items) > 0 {
+				log.Warning(ctx, gotext.Get("Home directory %q of %q does not exist yet, files will be deployed on next refresh", o.root, objectName))
+			}
+			return nil
+		}
+	}
+
+	previous, err := readManifest(o.manifest)
+	if err != nil {
+		return err
+	}
+	manifest := maps.Clone(previous)
+	// The manifest always lists what was created, even if deploying some of the items failed.
+	defer func() {
+		if errManifest := writeManifest(o.manifest, manifest); errManifest != nil {
+			err = errors.Join(err, errManifest)
+		}
+	}()
+
+	sources, err := os.MkdirTemp("", "adsys-files-*")
+	if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, func() error { return os.RemoveAll(sources) })
+
+	rs := make(roots)
+	defer rs.close(ctx)
+
+	// deployed are the targets which are kept, with the paths created for them previously.
+	var deployed []string
+	for i, it := range items {
+		r, base, rel, err := m.resolve(rs, o, it.target)
+		if errors.Is(err, errNotAllowed) {
+			log.Warning(ctx, gotext.Get("Skipping %q: it is not under an allowed directory", it.target))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if it.action == ActionDelete {
+			removed, err := remove(ctx, r, rel, it.recursive)
+			if err != nil {
+				return errors.New(gotext.Get("can't delete %q: %v", it.target, err))
+			}
+			if !removed {
+				log.Warning(ctx, gotext.Get("Directory %q is not empty and is not deleted", it.target))
+				continue
+			}
+			for p := range manifest {
+				if p == it.target || strings.HasPrefix(p, it.target+string(filepath.Separator)) {
+					delete(manifest, p)
+				}
+			}
+			continue
+		}
+
+		deployed = append(deployed, it.target)
+		uid, gid, err := m.owner(it, o)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Skipping %q: %v", it.target, err))
+			continue
+		}
+		content := func() ([]byte, error) {
+			if it.content != nil {
+				return it.content, nil
+			}
+			return readSource(ctx, it.source, filepath.Join(sources, strconv.Itoa(i)), o, assetsDumper)
+		}
+		created, err := deploy(r, rel, it, o, uid, gid, content)
+		for _, p := range created {
+			manifest[filepath.Join(base, p)] = struct{}{}
+		}
+		if err != nil {
+			return errors.New(gotext.Get("can't deploy %q: %v", it.target, err))
+		}
+	}
+
+	// Paths created previously are removed if they are not deployed anymore, nor parents of deployed targets.
+	// Children are listed after their parent, so that directories are emptied first.
+	for _, p := range slices.Backward(slices.Sorted(maps.Keys(previous))) {
+		if _, ok := manifest[p]; !ok {
+			continue
+		}
+		if slices.ContainsFunc(deployed, func(t string) bool {
+			return t == p || strings.HasPrefix(t, p+string(filepath.Separator))
+		}) {
+			continue
+		}
+		removed, err := m.removeCreated(ctx, rs, o, p)
+		if err != nil {
+			return errors.New(gotext.Get("can't remove %q: %v", p, err))
+		}
+		if removed {
+			delete(manifest, p)
+		}
+	}
+
+	return nil
+}
+
+// Paths returns the manifest of objectName, the paths it lists and the files entries can write or delete, as glob
+// patterns. It is empty if objectName is a user which does not exist on the system.
+func (m *Manager) Paths(objectName string, isComputer bool, entries []entry.Entry) []string {
+	o, err := m.object(objectName, isComputer)
+	if err != nil {
+		return nil
+	}
+
+	paths := []string{escapeGlob(o.manifest)}
+	// There is nothing more to list if the manifest can't be read.
+	previous, _ := readManifest(o.manifest)
+	for _, p := range slices.Sorted(maps.Keys(previous)) {
+		paths = append(paths, escapeGlob(filepath.Join(o.root, p)))
+	}
+	for _, e := range entries {
+		it, err := parseItem(e, isComputer)
+		if err != nil || e.Disabled || (it.isDir() && it.action != ActionDelete) {
+			continue
+		}
+		paths = append(paths, escapeGlob(filepath.Join(o.root, it.target)))
+	}
+
+	return paths
+}
+
+// escapeGlob returns p as a glob pattern only matching itself.
+func escapeGlob(p string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(p)
+}
+
+// object returns the machine, or the user objectName, the files are deployed for.
+func (m *Manager) object(objectName string, isComputer bool) (o object, err error) {
+	if isComputer {
+		return object{isComputer: true, root: m.root, uid: -1, gid: -1, manifest: filepath.Join(m.stateDir, "machine")}, nil
+	}
+
+	// The manifest of the user is named after them.
+	if !filepath.IsLocal(objectName) || strings.ContainsRune(objectName, filepath.Separator) {
+		return o, errors.New(gotext.Get("invalid user name %q", objectName))
+	}
+	u, err := m.userLookup(objectName)
+	if err != nil {
+		return o, errors.New(gotext.Get("could not retrieve user for %q: %v", objectName, err))
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return o, errors.New(gotext.Get("couldn't convert %q to a valid uid for %q", u.Uid, objectName))
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return o, errors.New(gotext.Get("couldn't convert %q to a valid gid for %q", u.Gid, objectName))
+	}
+
+	return object{
+		root:     filepath.Join(m.homeRoot, u.HomeDir),
+		uid:      uid,
+		gid:      gid,
+		manifest: filepath.Join(m.stateDir, "users", objectName),
+	}, nil
+}
+
+// parseItem returns the file or directory set by e.
+func parseItem(e entry.Entry, isComputer bool) (it item, err error) {
+	defer decorate.OnError(&err, gotext.Get("invalid file %q", e.Key))
+
+	if e.Err != nil {
+		return item{}, errors.New(gotext.Get("entry is errored: %v", e.Err))
+	}
+	if strings.ContainsAny(e.Key, "\r\n") || filepath.Clean(e.Key) != e.Key {
+		return item{}, errors.New(gotext.Get("invalid target"))
+	}
+	if isComputer && (!filepath.IsAbs(e.Key) || e.Key == "/") {
+		return item{}, errors.New(gotext.Get("machine targets must be absolute paths"))
+	}
+	if !isComputer && (!filepath.IsLocal(e.Key) || e.Key == ".") {
+		return item{}, errors.New(gotext.Get("user targets must be relative to the home directory"))
+	}
+	it = item{target: e.Key, action: ActionUpdate}
+
+	for _, l := range strings.Split(e.Value, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		k, v, found := strings.Cut(l, "=")
+		v = strings.TrimSpace(v)
+		// Empty files have an empty content.
+		if !found || (v == "" && k != "Content") {
+			return item{}, errors.New(gotext.Get("invalid setting %q", l))
+		}
+		switch k {
+		case "Action":
+			if !slices.Contains([]string{ActionCreate, ActionReplace, ActionUpdate, ActionDelete}, v) {
+				return item{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+			it.action = v
+		case "Source":
+			if !filepath.IsLocal(v) {
+				return item{}, errors.New(gotext.Get("source %q is not relative to the GPO assets", v))
+			}
+			it.source = v
+		case "Content":
+			if it.content, err = base64.StdEncoding.DecodeString(v); err != nil {
+				return item{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+		case "Owner", "Group":
+			if !isComputer {
+				return item{}, errors.New(gotext.Get("the owner of user targets can't be changed"))
+			}
+			if k == "Owner" {
+				it.owner = v
+			} else {
+				it.group = v
+			}
+		case "Mode":
+			mode, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || mode > uint64(fs.ModePerm) {
+				return item{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+			it.mode, it.modeSet = fs.FileMode(mode), true
+		case "Recursive":
+			if it.recursive, err = strconv.ParseBool(v); err != nil {
+				return item{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+		default:
+			return item{}, errors.New(gotext.Get("unsupported setting %q", k))
+		}
+	}
+
+	if it.source != "" && it.content != nil {
+		return item{}, errors.New(gotext.Get("targets can't have both a source and a content"))
+	}
+	if it.action == ActionDelete && (!it.isDir() || it.owner != "" || it.group != "" || it.modeSet) {
+		return item{}, errors.New(gotext.Get("only the recursive setting applies to deleted targets"))
+	}
+	if it.action != ActionDelete && it.recursive {
+		return item{}, errors.New(gotext.Get("only deleted targets can be recursive"))
+	}
+
+	return it, nil
+}
+
+// isDir returns if the target of it is a directory, which has no source nor content.
+func (it item) isDir() bool {
+	return it.source == "" && it.content == nil
+}
+
+// roots are the opened allowed prefixes and home directories, by path.
+type roots map[string]*os.Root
+
+// open returns the root opened at dir.
+func (rs roots) open(dir string) (*os.Root, error) {
+	if r, ok := rs[dir]; ok {
+		return r, nil
+	}
+	r, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	rs[dir] = r
+	return r, nil
+}
+
+// close closes all opened roots.
+func (rs roots) close(ctx context.Context) {
+	for _, r := range rs {
+		decorate.LogFuncOnErrorContext(ctx, r.Close)
+	}
+}
+
+// resolve returns the root target is deployed in, with the allowed prefix it is opened at for machine targets, and
+// the path of target relative to it.
+// Machine targets are opened in the allowed prefix they are under, which is created if needed, and user targets
+// in the home directory.
+func (m *Manager) resolve(rs roots, o object, target string) (r *os.Root, base, rel string, err error) {
+	if !o.isComputer {
+		r, err := rs.open(o.root)
+		return r, "", target, err
+	}
+
+	if isDenied(target) {
+		return nil, "", "", errNotAllowed
+	}
+	for _, p := range m.allowedPrefixes {
+		rel, err := filepath.Rel(p, target)
+		if err != nil || rel == "." || !filepath.IsLocal(rel) {
+			continue
+		}
+		dir := filepath.Join(o.root, p)
+		//nolint:gosec // G301 - Allowed prefixes are system directories, traversable by every user.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, "", "", err
+		}
+		r, err := rs.open(dir)
+		return r, p, rel, err
+	}
+	return nil, "", "", errNotAllowed
+}
+
+// isDenied returns true if target, or one of its parent directories, matches one of the denied paths.
+func isDenied(target string) bool {
+	for p := target; p != "/" && p != "."; p = filepath.Dir(p) {
+		for _, d := range deniedPaths {
+			if ok, _ := filepath.Match(d, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// owner returns the uid and gid item is owned by, which are -1 to keep the current ones.
+func (m *Manager) owner(it item, o object) (uid, gid int, err error) {
+	if !o.isComputer {
+		return o.uid, o.gid, nil
+	}
+
+	uid, gid = -1, -1
+	if it.owner != "" {
+		u, err := m.userLookup(it.owner)
+		if err != nil {
+			return 0, 0, errors.New(gotext.Get("could not retrieve owner %q: %v", it.owner, err))
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, errors.New(gotext.Get("couldn't convert %q to a valid uid for %q", u.Uid, it.owner))
+		}
+	}
+	if it.group != "" {
+		g, err := m.groupLookup(it.group)
+		if err != nil {
+			return 0, 0, errors.New(gotext.Get("could not retrieve group %q: %v", it.group, err))
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, errors.New(gotext.Get("couldn't convert %q to a valid gid for %q", g.Gid, it.group))
+		}
+	}
+	return uid, gid, nil
+}
+
+// readSource returns the content of the file source of the GPO assets, dumped to dest.
+func readSource(ctx context.Context, source, dest string, o object, assetsDumper AssetsDumper) ([]byte, error) {
+	if err := assetsDumper(ctx, source, dest, o.uid, o.gid); err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(dest)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errors.New(gotext.Get("source %q is not a file", source))
+	}
+	return os.ReadFile(dest)
+}
+
+// deploy creates or updates the file or directory of item at rel in r, owned by uid and gid.
+// It returns the paths it created, including the missing parent directories, which are owned by the object.
+func deploy(r *os.Root, rel string, it item, o object, uid, gid int, content func() ([]byte, error)) (created []string, err error) {
+	isDir := it.isDir()
+	fi, err := r.Lstat(rel)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if exists && isDir && !fi.IsDir() {
+		return nil, errors.New(gotext.Get("target exists and is not a directory"))
+	}
+	if exists && !isDir && !fi.Mode().IsRegular() {
+		return nil, errors.New(gotext.Get("target exists and is not a regular file"))
+	}
+	if exists && it.action == ActionCreate {
+		return nil, nil
+	}
+
+	mode := it.mode
+	switch {
+	case it.modeSet:
+	case exists:
+		mode = fi.Mode().Perm()
+	case isDir:
+		mode = 0755
+	default:
+		mode = 0644
+	}
+
+	// Only new files and replaced ones are written, the others only have their owner and mode set.
+	var data []byte
+	if !isDir && (!exists || it.action == ActionReplace) {
+		if data, err = content(); err != nil {
+			return nil, err
+		}
+		if exists {
+			if current, err := r.ReadFile(rel); err == nil && bytes.Equal(current, data) {
+				data = nil
+			}
+		}
+	}
+
+	if !exists {
+		if created, err = mkdirParents(r, rel, o.uid, o.gid); err != nil {
+			return created, err
+		}
+	}
+
+	switch {
+	case isDir && !exists:
+		if err := r.Mkdir(rel, 0700); err != nil {
+			return created, err
+		}
+		created = append(created, rel)
+	case data != nil:
+		if err := writeFile(r, rel, data, mode, uid, gid); err != nil {
+			return created, err
+		}
+		if !exists {
+			created = append(created, rel)
+		}
+		return created, nil
+	}
+
+	if err := r.Chmod(rel, mode); err != nil {
+		return created, err
+	}
+	return created, rootfs.Chown(r, rel, uid, gid)
+}
+
+// mkdirParents creates the missing parent directories of rel in r, owned by uid and gid, and returns them.
+func mkdirParents(r *os.Root, rel string, uid, gid int) (created []string, err error) {
+	var dir string
+	for _, d := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if d == "." {
+			break
+		}
+		dir = filepath.Join(dir, d)
+		//nolint:gosec // G301 - Parent directories are traversable by every user, as the default on the system.
+		if err := r.Mkdir(dir, 0755); errors.Is(err, fs.ErrExist) {
+			continue
+		} else if err != nil {
+			return created, err
+		}
+		created = append(created, dir)
+		if err := rootfs.Chown(r, dir, uid, gid); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// writeFile atomically writes data to rel in r with mode, owned by uid and gid.
+func writeFile(r *os.Root, rel string, data []byte, mode fs.FileMode, uid, gid int) (err error) {
+	// The file is always created again, so that we never write through a file planted by a user.
+	tmp := rel + ".adsys.new"
+	if err := r.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := r.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := r.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	if err := rootfs.Chown(r, tmp, uid, gid); err != nil {
+		return err
+	}
+	return r.Rename(tmp, rel)
+}
+
+// remove removes rel from r, with its content if it is a directory and recursive is true, and returns if it is now
+// removed. Directories are kept if they are not empty and recursive is false.
+func remove(ctx context.Context, r *os.Root, rel string, recursive bool) (removed bool, err error) {
+	fi, err := r.Lstat(rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if fi.IsDir() && recursive {
+		return true, r.RemoveAll(rel)
+	}
+	if fi.IsDir() {
+		d, err := r.Open(rel)
+		if err != nil {
+			return false, err
+		}
+		names, err := d.Readdirnames(1)
+		decorate.LogFuncOnErrorContext(ctx, d.Close)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		if len(names) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, r.Remove(rel)
+}
+
+// removeCreated removes the path p created by a previous policy, and returns if it is now removed.
+// Directories are kept if they are not empty.
+func (m *Manager) removeCreated(ctx context.Context, rs roots, o object, p string) (removed bool, err error) {
+	r, _, rel, err := m.resolve(rs, o, p)
+	if errors.Is(err, errNotAllowed) {
+		log.Warning(ctx, gotext.Get("%q was created by a previous policy, but is not under an allowed directory anymore and is kept", p))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	removed, err = remove(ctx, r, rel, false)
+	if err != nil {
+		return false, err
+	}
+	if !removed {
+		log.Warning(ctx, gotext.Get("Directory %q created by a previous policy is not empty and is kept", p))
+	}
+	return removed, nil
+}
+
+// readManifest returns the paths listed in the manifest p, which is empty if it does not exist.
+func readManifest(p string) (paths map[string]struct{}, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't read manifest %q", p))
+
+	paths = make(map[string]struct{})
+	d, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range strings.Split(string(d), "\n") {
+		if l == "" {
+			continue
+		}
+		paths[l] = struct{}{}
+	}
+	return paths, nil
+}
+
+// writeManifest writes paths to the manifest p, or removes it if there is none.
+func writeManifest(p string, paths map[string]struct{}) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't write manifest %q", p))
+
+	if len(paths) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	content := fmt.Sprintln(strings.Join(slices.Sorted(maps.Keys(paths)), "\n"))
+	if err := os.WriteFile(p+".new", []byte(content), 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
@@ -0,0 +1,323 @@
+package files_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/files"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	license := entry.Entry{Key: "/etc/app/license.ini", Value: "Source=license.ini\nMode=0640"}
+	appDir := entry.Entry{Key: "/opt/app", Value: "Mode=0750"}
+	nested := entry.Entry{Key: "/opt/a/b/c.ini", Value: "Source=license.ini"}
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		previous []entry.Entry
+		isUser   bool
+		existing bool
+		noHome   bool
+		// extraFile is created, relative to the root, after applying the previous entries.
+		extraFile string
+		// outsideSymlink is replaced with a symlink leading out of the root.
+		outsideSymlink string
+		// defaultPrefixes uses the default allowed prefixes instead of the whole directories of the tests.
+		defaultPrefixes bool
+
+		userLookupErr bool
+		invalidUID    bool
+		assetsErr     bool
+
+		wantModes map[string]fs.FileMode
+		wantErr   bool
+	}{
+		"Machine files and directories": {entries: []entry.Entry{license, appDir, {Key: "/etc/skel/.bashrc", Value: "Action=create\nSource=skel/bashrc"}},
+			wantModes: map[string]fs.FileMode{"etc/app/license.ini": 0640, "opt/app": 0750, "etc/skel/.bashrc": 0644, "etc/skel": 0755}},
+		"Missing parent directories are created":      {entries: []entry.Entry{nested}},
+		"Sources can be in directories of the assets": {entries: []entry.Entry{{Key: "/srv/readme.txt", Value: "Source=folder/readme.txt"}}},
+		"Files with a content are written without assets": {entries: []entry.Entry{{Key: "/opt/app/gpo.ini", Value: "Content=W2FwcF0Ka2V5PWdwbwo=\nMode=0600"}, {Key: "/opt/app/empty", Value: "Content="}},
+			assetsErr: true, wantModes: map[string]fs.FileMode{"opt/app/gpo.ini": 0600, "opt/app/empty": 0644}},
+		"Targets out of allowed prefixes are skipped": {entries: []entry.Entry{license, {Key: "/var/lib/app/license.ini", Value: "Source=license.ini"}, {Key: "/etcetera/license.ini", Value: "Source=license.ini"}}},
+		"Security-sensitive targets are skipped": {entries: []entry.Entry{license,
+			{Key: "/etc/shadow", Value: "Source=license.ini"}, {Key: "/etc/gshadow-", Value: "Source=license.ini"},
+			{Key: "/etc/sudoers", Value: "Source=license.ini"}, {Key: "/etc/sudoers.d/app", Value: "Source=license.ini"},
+			{Key: "/etc/pam.d/common-auth", Value: "Source=license.ini"}, {Key: "/etc/ssh/sshd_config.d/app.conf", Value: "Source=license.ini"},
+			{Key: "/etc/security/limits.d/app.conf", Value: "Source=license.ini"}, {Key: "/etc/systemd/system/app.service", Value: "Source=license.ini"}}},
+		"Targets out of default allowed prefixes are skipped": {entries: []entry.Entry{
+			{Key: "/etc/skel/.bashrc", Value: "Source=skel/bashrc"}, {Key: "/usr/local/share/app/license.ini", Value: "Source=license.ini"},
+			{Key: "/etc/app/license.ini", Value: "Source=license.ini"}, {Key: "/usr/local/bin/app", Value: "Source=license.ini"}},
+			defaultPrefixes: true},
+		"Owner and group are set":                         {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nOwner=bob\nGroup=app"}}},
+		"Targets with unknown owner or group are skipped": {entries: []entry.Entry{license, {Key: "/etc/ghost.ini", Value: "Source=license.ini\nOwner=ghost"}, {Key: "/etc/ghosts.ini", Value: "Source=license.ini\nGroup=ghost"}}},
+		"Blank lines and comments are ignored":            {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "\n# License of the app\n Source=license.ini \n\n"}}},
+		"Disabled entries are ignored":                    {entries: []entry.Entry{appDir, {Key: "/etc/app/license.ini", Disabled: true}}},
+		"No entries":                                      {},
+
+		// Existing targets
+		"Create keeps existing files":                  {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=create\nSource=license.ini\nMode=0600"}}, existing: true, wantModes: map[string]fs.FileMode{"etc/app/license.ini": 0644}},
+		"Replace writes existing files again":          {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=replace\nSource=license.ini"}}, existing: true, wantModes: map[string]fs.FileMode{"etc/app/license.ini": 0644}},
+		"Update only sets the mode of existing files":  {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nMode=0600"}}, existing: true, wantModes: map[string]fs.FileMode{"etc/app/license.ini": 0600}},
+		"Update sets the mode of existing directories": {entries: []entry.Entry{{Key: "/etc/keep", Value: "Mode=0700"}}, existing: true, wantModes: map[string]fs.FileMode{"etc/keep": 0700}},
+		"Delete files":             {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=delete"}}, existing: true},
+		"Delete empty directories": {entries: []entry.Entry{{Key: "/opt/empty", Value: "Action=delete"}}, existing: true},
+		"Directories which are not empty are not deleted": {entries: []entry.Entry{{Key: "/opt/tree", Value: "Action=delete"}}, existing: true},
+		"Delete directories recursively":                  {entries: []entry.Entry{{Key: "/opt/tree", Value: "Action=delete\nRecursive=true"}}, existing: true},
+		"Delete targets which do not exist":               {entries: []entry.Entry{{Key: "/opt/doesnotexist", Value: "Action=delete"}}},
+
+		// Users
+		"User files and directories":               {entries: []entry.Entry{{Key: ".config/app/license.ini", Value: "Source=license.ini\nMode=0600"}, {Key: "Documents", Value: ""}}, isUser: true},
+		"Users without home directory are skipped": {entries: []entry.Entry{{Key: ".config/app/license.ini", Value: "Source=license.ini"}}, isUser: true, noHome: true},
+
+		// Previous policies
+		"Files no longer set are removed":                     {previous: []entry.Entry{license, nested, appDir}, entries: []entry.Entry{appDir}},
+		"All created files are removed without entries":       {previous: []entry.Entry{license, nested, appDir}},
+		"Parents of files still set are kept":                 {previous: []entry.Entry{nested, {Key: "/opt/a/b/d.ini", Value: "Source=license.ini"}}, entries: []entry.Entry{nested}},
+		"Created directories which are not empty are kept":    {previous: []entry.Entry{nested}, extraFile: "opt/a/extra"},
+		"Existing files are not removed when no longer set":   {previous: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=replace\nSource=license.ini"}}, existing: true},
+		"Deleted created files are removed from the manifest": {previous: []entry.Entry{license, nested}, entries: []entry.Entry{license, {Key: "/opt/a", Value: "Action=delete\nRecursive=true"}}},
+		"User files no longer set are removed":                {previous: []entry.Entry{{Key: ".config/app/license.ini", Value: "Source=license.ini"}}, isUser: true},
+
+		// Error cases
+		"Error on errored entry":                         {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini", Err: errors.New("some error")}}, wantErr: true},
+		"Error on relative machine target":               {entries: []entry.Entry{{Key: "etc/app/license.ini", Value: "Source=license.ini"}}, wantErr: true},
+		"Error on unclean target":                        {entries: []entry.Entry{{Key: "/etc/../var/license.ini", Value: "Source=license.ini"}}, wantErr: true},
+		"Error on absolute user target":                  {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini"}}, isUser: true, wantErr: true},
+		"Error on user target out of the home directory": {entries: []entry.Entry{{Key: "../license.ini", Value: "Source=license.ini"}}, isUser: true, wantErr: true},
+		"Error on home directory as target":              {entries: []entry.Entry{{Key: ".", Value: "Mode=0700"}}, isUser: true, wantErr: true},
+		"Error on invalid setting":                       {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source license.ini"}}, wantErr: true},
+		"Error on setting without value":                 {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source="}}, wantErr: true},
+		"Error on invalid action":                        {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=copy\nSource=license.ini"}}, wantErr: true},
+		"Error on invalid mode":                          {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nMode=0999"}}, wantErr: true},
+		"Error on special mode bits":                     {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nMode=4755"}}, wantErr: true},
+		"Error on invalid recursive setting":             {entries: []entry.Entry{{Key: "/opt/tree", Value: "Action=delete\nRecursive=maybe"}}, wantErr: true},
+		"Error on unsupported setting":                   {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nAttributes=hidden"}}, wantErr: true},
+		"Error on source out of the assets":              {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=../license.ini"}}, wantErr: true},
+		"Error on owner of user target":                  {entries: []entry.Entry{{Key: "license.ini", Value: "Source=license.ini\nOwner=bob"}}, isUser: true, wantErr: true},
+		"Error on invalid content":                       {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Content=not base64"}}, wantErr: true},
+		"Error on target with a source and a content":    {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini\nContent=W2FwcF0K"}}, wantErr: true},
+		"Error on deleted target with content":           {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=delete\nContent=W2FwcF0K"}}, wantErr: true},
+		"Error on deleted target with source":            {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Action=delete\nSource=license.ini"}}, wantErr: true},
+		"Error on recursive target not deleted":          {entries: []entry.Entry{{Key: "/opt/app", Value: "Recursive=true"}}, wantErr: true},
+		"Error on source which does not exist":           {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=doesnotexist.ini"}}, wantErr: true},
+		"Error on source being a directory":              {entries: []entry.Entry{{Key: "/etc/app/folder", Value: "Source=folder"}}, wantErr: true},
+		"Error on file target being a directory":         {entries: []entry.Entry{{Key: "/etc/keep", Value: "Source=license.ini"}}, existing: true, wantErr: true},
+		"Error on directory target being a file":         {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Mode=0755"}}, existing: true, wantErr: true},
+		"Error on assets dumping failing":                {entries: []entry.Entry{license}, assetsErr: true, wantErr: true},
+		"Error on user lookup failing":                   {entries: []entry.Entry{{Key: "license.ini", Value: "Source=license.ini"}}, isUser: true, userLookupErr: true, wantErr: true},
+		"Error on invalid user id":                       {entries: []entry.Entry{{Key: "license.ini", Value: "Source=license.ini"}}, isUser: true, invalidUID: true, wantErr: true},
+		"Error on symlink leading out of allowed prefix": {entries: []entry.Entry{{Key: "/etc/app/license.ini", Value: "Source=license.ini"}}, outsideSymlink: "etc/app", wantErr: true},
+		"Error on symlink leading out of home directory": {entries: []entry.Entry{{Key: ".config/license.ini", Value: "Source=license.ini"}}, isUser: true, outsideSymlink: "home/bob@example.com/.config", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out := t.TempDir()
+			home := filepath.Join("home", "bob@example.com")
+			if !tc.noHome {
+				require.NoError(t, os.MkdirAll(filepath.Join(out, home), 0700), "Setup: can't create home directory")
+			}
+			if tc.existing {
+				testutils.Copy(t, filepath.Join("testdata", "existing", "etc"), filepath.Join(out, "etc"))
+				testutils.Copy(t, filepath.Join("testdata", "existing", "opt"), filepath.Join(out, "opt"))
+				require.NoError(t, os.Mkdir(filepath.Join(out, "opt", "empty"), 0755), "Setup: can't create empty directory")
+			}
+			outside := t.TempDir()
+			if tc.outsideSymlink != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(out, tc.outsideSymlink)), 0755), "Setup: can't create parent of symlink")
+				require.NoError(t, os.Symlink(outside, filepath.Join(out, tc.outsideSymlink)), "Setup: can't create symlink")
+			}
+
+			// The owners are the current user, so that files can be given to them without being root.
+			uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+			if tc.invalidUID {
+				uid = "invalid"
+			}
+			userLookup := func(name string) (*user.User, error) {
+				if tc.userLookupErr || name == "ghost" {
+					return nil, errors.New("user not found")
+				}
+				return &user.User{Username: name, Uid: uid, Gid: gid, HomeDir: "/" + home}, nil
+			}
+			groupLookup := func(name string) (*user.Group, error) {
+				if name == "ghost" {
+					return nil, errors.New("group not found")
+				}
+				return &user.Group{Name: name, Gid: gid}, nil
+			}
+
+			opts := []files.Option{
+				files.WithRoot(out),
+				files.WithHomeRoot(out),
+				files.WithUserLookup(userLookup),
+				files.WithGroupLookup(groupLookup),
+			}
+			if !tc.defaultPrefixes {
+				opts = append(opts, files.WithAllowedPrefixes([]string{"/etc", "/opt", "/srv", "/usr/local"}))
+			}
+			m := files.New(filepath.Join(out, "var", "lib", "adsys", "files"), opts...)
+
+			objectName := "ubuntu"
+			if tc.isUser {
+				objectName = "bob@example.com"
+			}
+
+			if tc.previous != nil {
+				err := m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.previous, mockAssetsDumper{t: t}.SaveAssetsTo)
+				require.NoError(t, err, "Setup: can't apply previous files")
+			}
+			if tc.extraFile != "" {
+				testutils.WriteFile(t, filepath.Join(out, tc.extraFile), []byte("Not created by the manager.\n"), 0600)
+			}
+
+			err := m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries, mockAssetsDumper{t: t, err: tc.assetsErr}.SaveAssetsTo)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				if tc.outsideSymlink != "" {
+					written, err := os.ReadDir(outside)
+					require.NoError(t, err, "Teardown: can't read directory outside of the root")
+					require.Empty(t, written, "ApplyPolicy should not write out of the allowed prefixes or home directory")
+				}
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			for p, want := range tc.wantModes {
+				fi, err := os.Stat(filepath.Join(out, p))
+				require.NoError(t, err, "Teardown: can't stat %q", p)
+				require.Equal(t, want, fi.Mode().Perm(), "Mode of %q is not the expected one", p)
+			}
+
+			testutils.CompareTreesWithFiltering(t, out, testutils.GoldenPath(t), testutils.UpdateEnabled())
+		})
+	}
+}
+
+func TestApplyPolicyDeniedPaths(t *testing.T) {
+	t.Parallel()
+
+	// Security-sensitive targets are never written, even when the allowed prefixes include them.
+	targets := []string{
+		"/etc/passwd", "/etc/passwd-", "/etc/group", "/etc/group-", "/etc/shadow", "/etc/gshadow",
+		"/etc/sudoers", "/etc/sudoers.d/app", "/etc/pam.d/common-auth", "/etc/security/limits.d/app.conf",
+		"/etc/polkit-1/rules.d/00-app.rules", "/etc/ssh/sshd_config.d/app.conf", "/etc/krb5.keytab", "/etc/sssd/sssd.conf",
+		"/etc/nsswitch.conf", "/etc/systemd/system/app.service", "/etc/crontab", "/etc/cron.d/app", "/etc/cron.hourly/app",
+		"/etc/anacrontab", "/etc/ld.so.preload", "/etc/ld.so.conf.d/app.conf", "/etc/profile", "/etc/profile.d/app.sh",
+		"/etc/environment", "/etc/bash.bashrc", "/root/.bashrc", "/root/.ssh/authorized_keys",
+	}
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			t.Parallel()
+
+			out := t.TempDir()
+			m := files.New(filepath.Join(out, "var", "lib", "adsys", "files"),
+				files.WithRoot(out),
+				files.WithAllowedPrefixes([]string{"/etc", "/root"}))
+
+			entries := []entry.Entry{{Key: target, Value: "Source=license.ini"}, {Key: "/etc/app/license.ini", Value: "Source=license.ini"}}
+			err := m.ApplyPolicy(context.Background(), "ubuntu", true, entries, mockAssetsDumper{t: t}.SaveAssetsTo)
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			require.FileExists(t, filepath.Join(out, "etc", "app", "license.ini"), "Allowed target should be written")
+			require.NoFileExists(t, filepath.Join(out, target), "Security-sensitive target should not be written")
+			if parent := filepath.Dir(target); parent != "/etc" {
+				require.NoDirExists(t, filepath.Join(out, parent), "Parents of security-sensitive target should not be created")
+			}
+		})
+	}
+}
+
+func TestPaths(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		previous []entry.Entry
+		isUser   bool
+
+		userLookupErr bool
+
+		want []string
+	}{
+		"Manifest and targets": {entries: []entry.Entry{
+			{Key: "/etc/app/license.ini", Value: "Source=license.ini"},
+			{Key: "/opt/tree", Value: "Action=delete\nRecursive=true"},
+			{Key: "/opt/app", Value: "Mode=0750"},
+			{Key: "/opt/gpo.ini", Value: "Content=W2FwcF0K"},
+			{Key: "/etc/disabled.ini", Value: "Source=license.ini", Disabled: true},
+			{Key: "etc/invalid.ini", Value: "Source=license.ini"},
+		}, want: []string{"var/lib/adsys/files/machine", "etc/app/license.ini", "opt/tree", "opt/gpo.ini"}},
+		"Paths of the manifest": {previous: []entry.Entry{{Key: "/opt/a/b.ini", Value: "Source=license.ini"}},
+			want: []string{"var/lib/adsys/files/machine", "opt/a", "opt/a/b.ini"}},
+		"Glob characters are escaped": {entries: []entry.Entry{{Key: "/etc/app/[1]*?.ini", Value: "Source=license.ini"}},
+			want: []string{"var/lib/adsys/files/machine", `etc/app/\[1]\*\?.ini`}},
+		"User targets are in the home directory": {entries: []entry.Entry{{Key: ".config/license.ini", Value: "Source=license.ini"}}, isUser: true,
+			want: []string{"var/lib/adsys/files/users/bob@example.com", "home/bob/.config/license.ini"}},
+
+		"No paths for unknown users": {isUser: true, userLookupErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(out, "home", "bob"), 0700), "Setup: can't create home directory")
+			userLookup := func(string) (*user.User, error) {
+				if tc.userLookupErr {
+					return nil, errors.New("user not found")
+				}
+				return &user.User{Uid: "4242", Gid: "4242", HomeDir: "/home/bob"}, nil
+			}
+
+			m := files.New(filepath.Join(out, "var", "lib", "adsys", "files"),
+				files.WithRoot(out),
+				files.WithHomeRoot(out),
+				files.WithUserLookup(userLookup))
+
+			objectName := "ubuntu"
+			if tc.isUser {
+				objectName = "bob@example.com"
+			}
+			if tc.previous != nil {
+				err := m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.previous, mockAssetsDumper{t: t}.SaveAssetsTo)
+				require.NoError(t, err, "Setup: can't apply previous files")
+			}
+
+			var want []string
+			for _, p := range tc.want {
+				want = append(want, filepath.Join(out, p))
+			}
+			require.Equal(t, want, m.Paths(objectName, !tc.isUser, tc.entries), "Paths should return the manifest and the targets")
+		})
+	}
+}
+
+// mockAssetsDumper copies the files of testdata/assets, or fails if err is set.
+type mockAssetsDumper struct {
+	t   *testing.T
+	err bool
+}
+
+func (m mockAssetsDumper) SaveAssetsTo(_ context.Context, relSrc, dest string, _, _ int) error {
+	if m.err {
+		return errors.New("SaveAssetsTo error")
+	}
+	src := filepath.Join("testdata", "assets", relSrc)
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	testutils.Copy(m.t, src, dest)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/leonelquinteros/gotext"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 )
 
@@ -19,7 +20,9 @@ type GPO struct {
 }
 
 // Format write to w a formatted GPO. overridden entries are prepended with -.
-func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}) map[string]struct{} {
+// Entries for which filtered returns true are always listed, marked as filtered by targeting, and override nothing.
+// A nil filtered lists all entries as applied.
+func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}, filtered func(entry.Entry) bool) map[string]struct{} {
 	fmt.Fprintf(w, "* %s (%s)\n", g.Name, g.ID)
 
 	if !withRules {
@@ -39,8 +42,16 @@ func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcesse
 	for _, d := range domains {
 		fmt.Fprintf(w, "** %s:\n", d)
 		for _, r := range g.Rules[d] {
-			k := filepath.Join(d, r.Key)
-			_, overr := alreadyProcessedRules[k]
+			var suffix string
+			if r.Source != "" {
+				suffix = " " + gotext.Get("(from %s)", r.Source)
+			}
+			var overr bool
+			if filtered != nil && filtered(r) {
+				suffix += " " + gotext.Get("(filtered by targeting)")
+			} else {
+				overr = processRule(alreadyProcessedRules, d, r)
+			}
 			if !withOverridden && overr {
 				continue
 			}
@@ -52,18 +63,26 @@ func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcesse
 			v := strings.ReplaceAll(strings.TrimSpace(r.Value), "\n", `\n`)
 			if r.Disabled {
 				prefix += "+"
-				fmt.Fprintf(w, "%s %s\n", prefix, r.Key)
+				fmt.Fprintf(w, "%s %s%s\n", prefix, r.Key, suffix)
 			} else {
-				fmt.Fprintf(w, "%s %s: %s\n", prefix, r.Key, v)
-			}
-
-			// Do not add non overridable key to the alreadyProcessedRules override detection map.
-			if r.Strategy == "append" {
-				continue
+				fmt.Fprintf(w, "%s %s: %s%s\n", prefix, r.Key, v, suffix)
 			}
-			alreadyProcessedRules[k] = struct{}{}
 		}
 	}
 
 	return alreadyProcessedRules
 }
+
+// processRule returns if rule r of domain d is overridden by a rule processed before, and adds it to
+// alreadyProcessedRules to override the next rules with the same key.
+func processRule(alreadyProcessedRules map[string]struct{}, d string, r entry.Entry) (overridden bool) {
+	k := filepath.Join(d, r.Key)
+	_, overridden = alreadyProcessedRules[k]
+
+	// Do not add non overridable key to the alreadyProcessedRules override detection map.
+	if !entry.MergesValues(r.Strategy) {
+		alreadyProcessedRules[k] = struct{}{}
+	}
+
+	return overridden
+}
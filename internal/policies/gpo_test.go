@@ -8,6 +8,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -26,6 +27,7 @@ func TestFormat(t *testing.T) {
 		withRules             bool
 		withOverridden        bool
 		alreadyProcessedRules map[string]struct{}
+		filtered              func(entry.Entry) bool
 
 		wantAlreadyProcessedRules map[string]struct{}
 	}{
@@ -58,6 +60,21 @@ func TestFormat(t *testing.T) {
 			alreadyProcessedRules:     map[string]struct{}{"scripts/path/to/key3": {}},
 			wantAlreadyProcessedRules: defaultProcessedRules},
 
+		// targeting cases
+		"GPO with rules, filtered rules are displayed and do not override": {
+			withRules:      true,
+			withOverridden: true,
+			filtered:       func(e entry.Entry) bool { return e.Key == "path/to/key1" },
+			wantAlreadyProcessedRules: map[string]struct{}{
+				"dconf/path/to/key2":   {},
+				"scripts/path/to/key3": {},
+			}},
+		"GPO with rules, filtered rules are displayed even if overridden": {
+			withRules:                 true,
+			alreadyProcessedRules:     map[string]struct{}{"dconf/path/to/key1": {}},
+			filtered:                  func(e entry.Entry) bool { return e.Key == "path/to/key1" },
+			wantAlreadyProcessedRules: defaultProcessedRules},
+
 		// append strategy cases
 		"GPO and assets with rules, appending to same key do not add to processed rules": {
 			cachedPoliciesSrc: "with_assets_other",
@@ -96,7 +113,7 @@ func TestFormat(t *testing.T) {
 
 			var out strings.Builder
 
-			got := pols.GPOs[0].Format(&out, tc.withRules, tc.withOverridden, tc.alreadyProcessedRules)
+			got := pols.GPOs[0].Format(&out, tc.withRules, tc.withOverridden, tc.alreadyProcessedRules, tc.filtered)
 			// check cache between Format calls
 			require.Equal(t, tc.wantAlreadyProcessedRules, got, "Format returns expected alreadyProcessedRules cache")
 
@@ -0,0 +1,204 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/decorate"
+)
+
+// HistoryBaseName is the base directory where we keep previously applied policies.
+const HistoryBaseName = "history"
+
+// historyEntry is a set of policies that was applied to an object.
+type historyEntry struct {
+	AppliedAt time.Time
+	Policies  Policies
+
+	dir string
+}
+
+// historyDir returns the directory where applied policies are kept.
+func (m *Manager) historyDir() string {
+	return filepath.Join(m.opts.cacheDir, HistoryBaseName)
+}
+
+// addToHistory keeps a copy of the policies cached for objectName, unless their rules and GPO versions are the same
+// as the latest history entry, then removes the history entries which are not retained anymore.
+func (m *Manager) addToHistory(ctx context.Context, objectName string) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't add policies of %q to history", objectName))
+
+	entries, err := m.historyFor(ctx, objectName, false)
+	if err != nil {
+		return err
+	}
+
+	if m.opts.historySize > 0 {
+		unchanged := false
+		if len(entries) > 0 {
+			if unchanged, err = samePolicies(ctx, entries[0].dir, filepath.Join(m.policiesCacheDir, objectName)); err != nil {
+				return err
+			}
+		}
+		if unchanged {
+			log.Debugf(ctx, "Policies of %s did not change since %s, not adding them to history", objectName, entries[0].AppliedAt)
+		} else {
+			now := m.opts.now()
+			dest := filepath.Join(m.historyDir(), objectName, strconv.FormatInt(now.UnixNano(), 10))
+			if err := copyTree(filepath.Join(m.policiesCacheDir, objectName), dest); err != nil {
+				return err
+			}
+			entries = append([]historyEntry{{AppliedAt: now, dir: dest}}, entries...)
+		}
+	}
+
+	for i, e := range entries {
+		// The latest entry, which are the currently applied policies, is always kept.
+		if i == 0 && m.opts.historySize > 0 {
+			continue
+		}
+		if i < m.opts.historySize && (m.opts.historyMaxAge == 0 || m.opts.now().Sub(e.AppliedAt) <= m.opts.historyMaxAge) {
+			continue
+		}
+		log.Debugf(ctx, "Removing policies applied on %s to %s from history", e.AppliedAt, objectName)
+		if err := os.RemoveAll(e.dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// samePolicies returns true if the policies cached in dirs a and b have the same GPOs, with the same rules, and the
+// same GPO versions.
+func samePolicies(ctx context.Context, a, b string) (same bool, err error) {
+	polsA, err := NewFromCache(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, polsA.Close)
+	polsB, err := NewFromCache(ctx, b)
+	if err != nil {
+		return false, err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, polsB.Close)
+
+	return reflect.DeepEqual(polsA.GPOs, polsB.GPOs) && maps.Equal(polsA.GPOVersions, polsB.GPOVersions), nil
+}
+
+// historyFor returns the history entries of objectName, from the most recent to the oldest one.
+// The policies of each entry are only loaded if withPolicies is true. It's up to the caller to close them.
+func (m *Manager) historyFor(ctx context.Context, objectName string, withPolicies bool) (entries []historyEntry, err error) {
+	dirs, err := os.ReadDir(filepath.Join(m.historyDir(), objectName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, d := range dirs {
+		ts, err := strconv.ParseInt(d.Name(), 10, 64)
+		if err != nil || !d.IsDir() {
+			log.Debugf(ctx, "Ignoring unexpected %q in policies history of %s", d.Name(), objectName)
+			continue
+		}
+		e := historyEntry{
+			AppliedAt: time.Unix(0, ts),
+			dir:       filepath.Join(m.historyDir(), objectName, d.Name()),
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b historyEntry) int { return b.AppliedAt.Compare(a.AppliedAt) })
+
+	if !withPolicies {
+		return entries, nil
+	}
+	for i := range entries {
+		if entries[i].Policies, err = NewFromCache(ctx, entries[i].dir); err != nil {
+			for _, e := range entries[:i] {
+				decorate.LogFuncOnErrorContext(ctx, e.Policies.Close)
+			}
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// History returns the list of policies previously applied to objectName, from the most recent to the oldest one.
+// Each entry is numbered so that it can be passed to RollbackPolicies, 0 being the currently applied policies.
+func (m *Manager) History(ctx context.Context, objectName string) (msg string, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to get policies history for %q", objectName))
+
+	log.Infof(ctx, "Listing policies history for %s", objectName)
+
+	entries, err := m.historyFor(ctx, objectName, true)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		for _, e := range entries {
+			decorate.LogFuncOnErrorContext(ctx, e.Policies.Close)
+		}
+	}()
+	if len(entries) == 0 {
+		return "", errors.New(gotext.Get("no policies history for %q", objectName))
+	}
+
+	var out strings.Builder
+	for i, e := range entries {
+		current := ""
+		if i == 0 {
+			current = gotext.Get(" (current)")
+		}
+		fmt.Fprintf(&out, "%d: %s%s\n", i, e.AppliedAt.Format(time.DateTime), current)
+		if len(e.Policies.GPOs) == 0 {
+			fmt.Fprintln(&out, gotext.Get("  No GPO applied"))
+		}
+		for _, g := range e.Policies.GPOs {
+			version := gotext.Get("unknown version")
+			if v, ok := e.Policies.GPOVersions[g.ID]; ok {
+				version = gotext.Get("version %d", v)
+			}
+			fmt.Fprintf(&out, "  * %s (%s), %s\n", g.Name, g.ID, version)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// RollbackPolicies applies again to objectName the policies of the n-th history entry and returns the outcome of
+// each policy manager.
+// The rolled back policies are added on top of the history.
+func (m *Manager) RollbackPolicies(ctx context.Context, objectName string, isComputer bool, n int) (results []ApplyResult, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to roll back policies of %q", objectName))
+
+	entries, err := m.historyFor(ctx, objectName, false)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(entries) {
+		return nil, errors.New(gotext.Get("no history entry %d for %q: there are %d entries", n, objectName, len(entries)))
+	}
+
+	log.Infof(ctx, "Rolling back policies of %s to the ones applied on %s", objectName, entries[n].AppliedAt.Format(time.DateTime))
+
+	pols, err := NewFromCache(ctx, entries[n].dir)
+	if err != nil {
+		return nil, err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, pols.Close)
+
+	return m.ApplyPolicies(ctx, objectName, isComputer, &pols)
+}
@@ -0,0 +1,148 @@
+package policies_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		// applied are the policies applied, one hour apart, identified by their GPO name.
+		applied       []string
+		historySize   int
+		historyMaxAge time.Duration
+
+		wantErr bool
+	}{
+		"List applied policies, latest first":       {applied: []string{"first", "second", "third"}},
+		"Only keep history size entries":            {applied: []string{"first", "second", "third"}, historySize: 2},
+		"Remove entries older than history max age": {applied: []string{"first", "second", "third"}, historyMaxAge: 90 * time.Minute},
+		"Policies without GPOs are listed":          {applied: []string{"first", ""}},
+		"Unchanged policies are only listed once":   {applied: []string{"first", "second", "second"}},
+
+		"Error on history disabled": {applied: []string{"first", "second"}, historySize: -1, wantErr: true},
+		"Error on no history":       {wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.historySize == 0 {
+				tc.historySize = 10
+			}
+			if tc.historySize < 0 {
+				tc.historySize = 0
+			}
+
+			start := time.Date(2023, 6, 12, 10, 0, 0, 0, time.UTC)
+			now := start
+			m, err := newManagerInFakeRoot(t, bus,
+				policies.WithHistorySize(tc.historySize),
+				policies.WithHistoryMaxAge(tc.historyMaxAge),
+				policies.WithNow(func() time.Time { return now }))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for i, gpoName := range tc.applied {
+				now = start.Add(time.Duration(i) * time.Hour)
+				pols := historyPolicies(gpoName)
+				_, err := m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+			}
+
+			got, err := m.History(context.Background(), "hostname")
+			if tc.wantErr {
+				require.Error(t, err, "History should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "History should return no error but got one")
+
+			// Times are displayed in the local timezone.
+			for i := range tc.applied {
+				applied := start.Add(time.Duration(i) * time.Hour).Local().Format(time.DateTime)
+				got = strings.ReplaceAll(got, applied, fmt.Sprintf("<applied #%d>", i))
+			}
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "History returned unexpected entries")
+		})
+	}
+}
+
+func TestRollbackPolicies(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		to int
+
+		wantErr bool
+	}{
+		"Roll back to previous policies":      {to: 1},
+		"Roll back to oldest policies":        {to: 2},
+		"Roll back to current policies again": {to: 0},
+
+		"Error on history entry not found": {to: 3, wantErr: true},
+		"Error on negative history entry":  {to: -1, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Date(2023, 6, 12, 10, 0, 0, 0, time.UTC)
+			now := start
+			m, err := newManagerInFakeRoot(t, bus, policies.WithNow(func() time.Time { return now }))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for i, gpoName := range []string{"first", "second", "third"} {
+				now = start.Add(time.Duration(i) * time.Hour)
+				pols := historyPolicies(gpoName)
+				_, err := m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+			}
+			now = start.Add(3 * time.Hour)
+
+			_, err = m.RollbackPolicies(context.Background(), "hostname", true, tc.to)
+			if tc.wantErr {
+				require.Error(t, err, "RollbackPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "RollbackPolicies should return no error but got one")
+
+			// The rolled back policies are now the current ones, on top of the history.
+			got, err := m.History(context.Background(), "hostname")
+			require.NoError(t, err, "Setup: History should return no error but got one")
+			for i := 0; i < 4; i++ {
+				applied := start.Add(time.Duration(i) * time.Hour).Local().Format(time.DateTime)
+				got = strings.ReplaceAll(got, applied, fmt.Sprintf("<applied #%d>", i))
+			}
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "RollbackPolicies did not apply expected policies")
+		})
+	}
+}
+
+// historyPolicies returns policies with a single GPO named gpoName, or without GPOs if gpoName is empty.
+func historyPolicies(gpoName string) policies.Policies {
+	if gpoName == "" {
+		return policies.Policies{}
+	}
+	id := fmt.Sprintf("{%s}", gpoName)
+	return policies.Policies{
+		GPOs: []policies.GPO{{ID: id, Name: gpoName, Rules: map[string][]entry.Entry{
+			"unhandled": {{Key: "key", Value: gpoName}},
+		}}},
+		GPOVersions: map[string]int{id: len(gpoName)},
+	}
+}
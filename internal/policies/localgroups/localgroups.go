@@ -0,0 +1,443 @@
+// Package localgroups provides a manager to apply the membership of the local groups of the machine.
+//
+// Each entry key is a local group and its value lists the operations on its members, one per line, in the order
+// they are processed:
+// - add ACCOUNT: adds the account to the group;
+// - remove ACCOUNT: removes the account from the group;
+// - remove-all: removes all members of the group.
+//
+// Accounts are domain users and groups, written as DOMAIN\name or name@domain, or local users. As local groups can
+// only contain users, the members of group accounts are added or removed instead. Accounts are resolved through the
+// name service switch every time the policy is applied, so that the membership follows the domain groups.
+// Local groups are never created: groups which don't exist on the machine are skipped.
+//
+// Changes are applied with gpasswd and recorded in a state file. This way, the membership of every group the manager
+// changed is restored to what it was before, without policy, once the policy no longer applies. Members added or
+// removed by other means in the meantime are kept as they are.
+package localgroups
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// Operations on the members of a group.
+const (
+	opAdd       = "add"
+	opRemove    = "remove"
+	opRemoveAll = "remove-all"
+)
+
+// Manager prevents applying the membership of local groups concurrently.
+type Manager struct {
+	domain     string
+	stateFile  string
+	groupFile  string
+	gpasswdCmd []string
+	getentCmd  []string
+
+	mu sync.Mutex
+}
+
+type options struct {
+	groupFile  string
+	gpasswdCmd []string
+	getentCmd  []string
+}
+
+// Option reprents an optional function to change the local groups manager.
+type Option func(*options)
+
+// WithGroupFile specifies a personalized group file, listing the local groups and their members.
+func WithGroupFile(p string) Option {
+	return func(o *options) {
+		o.groupFile = p
+	}
+}
+
+// WithGpasswdCmd overrides the default gpasswd command.
+func WithGpasswdCmd(cmd []string) Option {
+	return func(o *options) {
+		o.gpasswdCmd = cmd
+	}
+}
+
+// WithGetentCmd overrides the default getent command.
+func WithGetentCmd(cmd []string) Option {
+	return func(o *options) {
+		o.getentCmd = cmd
+	}
+}
+
+// New creates a manager for a machine joined to domain, which records the changes it made in stateDir.
+func New(domain, stateDir string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		groupFile:  "/etc/group",
+		gpasswdCmd: []string{"gpasswd"},
+		getentCmd:  []string{"getent"},
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		domain:     domain,
+		stateFile:  filepath.Join(stateDir, "membership.yaml"),
+		groupFile:  args.groupFile,
+		gpasswdCmd: args.gpasswdCmd,
+		getentCmd:  args.getentCmd,
+	}
+}
+
+// changes are the members the manager added to and removed from a group, compared to its membership without policy.
+type changes struct {
+	Added   []string `yaml:"added,omitempty"`
+	Removed []string `yaml:"removed,omitempty"`
+}
+
+// ApplyPolicy applies the membership of the local groups listed in entries, and restores the membership of the
+// groups changed previously which are no longer listed.
+// All accounts are resolved before changing any group, so that an invalid policy does not leave the groups
+// partially changed. The changes which could be applied are recorded even if some of them failed.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't apply local groups policy to %s", objectName))
+
+	if !isComputer {
+		log.Debug(ctx, "Local groups policy is only supported for computers, skipping...")
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 && len(state) == 0 {
+		return nil
+	}
+
+	log.Debug(ctx, "ApplyPolicy local groups policy")
+
+	current, err := readGroups(m.groupFile)
+	if err != nil {
+		return err
+	}
+
+	ops := make(map[string][]string)
+	for _, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		if _, ok := current[e.Key]; !ok {
+			log.Warning(ctx, gotext.Get("Local group %q does not exist, skipping it", e.Key))
+			continue
+		}
+		ops[e.Key] = append(ops[e.Key], strings.Split(e.Value, "\n")...)
+	}
+
+	// Groups changed previously need to be restored when they are no longer listed.
+	for n := range state {
+		if _, ok := ops[n]; !ok {
+			ops[n] = nil
+		}
+	}
+
+	// Compute the membership of every group first.
+	r := resolver{m: m, cache: make(map[string][]string)}
+	original := make(map[string][]string)
+	desired := make(map[string][]string)
+	for _, n := range slices.Sorted(maps.Keys(ops)) {
+		members, ok := current[n]
+		if !ok {
+			log.Warning(ctx, gotext.Get("Local group %q no longer exists, its membership can't be restored", n))
+			continue
+		}
+		// Membership without policy, keeping the changes made by other means.
+		c := state[n]
+		original[n] = union(without(members, c.Added), without(c.Removed, members))
+
+		if desired[n], err = r.membership(ctx, original[n], ops[n]); err != nil {
+			return errors.New(gotext.Get("invalid membership for local group %q: %v", n, err))
+		}
+	}
+
+	// Apply and record the changes.
+	var errs error
+	newState := make(map[string]changes)
+	for _, n := range slices.Sorted(maps.Keys(desired)) {
+		members := current[n]
+		for _, u := range without(members, desired[n]) {
+			if err := m.gpasswd(ctx, "-d", u, n); err != nil {
+				errs = errors.Join(errs, err)
+				continue
+			}
+			members = without(members, []string{u})
+		}
+		for _, u := range without(desired[n], members) {
+			if err := m.gpasswd(ctx, "-a", u, n); err != nil {
+				errs = errors.Join(errs, err)
+				continue
+			}
+			members = append(members, u)
+		}
+
+		c := changes{
+			Added:   without(members, original[n]),
+			Removed: without(original[n], members),
+		}
+		if len(c.Added) > 0 || len(c.Removed) > 0 {
+			newState[n] = c
+		}
+	}
+
+	if err := m.saveState(newState); err != nil {
+		errs = errors.Join(errs, err)
+	}
+	return errs
+}
+
+// gpasswd runs gpasswd to add (-a) or remove (-d) user from group.
+func (m *Manager) gpasswd(ctx context.Context, flag, user, group string) error {
+	args := append(slices.Clone(m.gpasswdCmd[1:]), flag, user, group)
+	// #nosec G204 - We are in control of the arguments
+	cmd := exec.CommandContext(ctx, m.gpasswdCmd[0], args...)
+	smbsafe.WaitExec()
+	out, err := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if err != nil {
+		return errors.New(gotext.Get("failed to change members of local group %q: %v\n%s", group, err, string(out)))
+	}
+	return nil
+}
+
+// resolver resolves accounts to the users they refer to.
+type resolver struct {
+	m *Manager
+	// cache lists the users of the accounts already resolved.
+	cache map[string][]string
+}
+
+// membership returns the members of a group with members, once ops are processed.
+func (r resolver) membership(ctx context.Context, members, ops []string) ([]string, error) {
+	members = slices.Clone(members)
+	for _, op := range ops {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		if op == opRemoveAll {
+			members = nil
+			continue
+		}
+
+		action, account, _ := strings.Cut(op, " ")
+		account = strings.TrimSpace(account)
+		if (action != opAdd && action != opRemove) || account == "" {
+			return nil, errors.New(gotext.Get("unexpected operation %q", op))
+		}
+
+		users, err := r.resolve(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		if action == opAdd {
+			members = union(members, users)
+		} else {
+			members = without(members, users)
+		}
+	}
+	return members, nil
+}
+
+// resolve returns the local names of the users account refers to: the account itself if it is a user, or its
+// members if it is a group. Accounts which can't be found are ignored.
+func (r resolver) resolve(ctx context.Context, account string) ([]string, error) {
+	if users, ok := r.cache[account]; ok {
+		return users, nil
+	}
+
+	var users []string
+	var found bool
+	for _, name := range r.m.candidates(account) {
+		fields, err := r.m.getent(ctx, "passwd", name)
+		if err != nil {
+			return nil, err
+		}
+		if fields != nil {
+			users, found = []string{fields[0]}, true
+			break
+		}
+
+		if fields, err = r.m.getent(ctx, "group", name); err != nil {
+			return nil, err
+		}
+		if fields != nil {
+			users, found = splitMembers(fields[3]), true
+			break
+		}
+	}
+	if !found {
+		log.Warning(ctx, gotext.Get("Account %q can't be found, ignoring it", account))
+	}
+
+	r.cache[account] = users
+	return users, nil
+}
+
+// candidates returns the names account can have on the machine, in lookup order.
+// Domain accounts are tried with the domain of the machine first, as their name is qualified by default.
+func (m *Manager) candidates(account string) []string {
+	_, name, ok := strings.Cut(account, `\`)
+	if !ok {
+		return []string{account}
+	}
+	if m.domain == "" {
+		return []string{name}
+	}
+	return []string{fmt.Sprintf("%s@%s", name, m.domain), name}
+}
+
+// getent returns the fields of key in the name service switch database db, which are nil if key is not found.
+func (m *Manager) getent(ctx context.Context, db, key string) ([]string, error) {
+	args := append(slices.Clone(m.getentCmd[1:]), db, key)
+	// #nosec G204 - We are in control of the arguments
+	cmd := exec.CommandContext(ctx, m.getentCmd[0], args...)
+	smbsafe.WaitExec()
+	out, err := cmd.Output()
+	smbsafe.DoneExec()
+
+	// getent exits with 2 when the key is not found in the database.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New(gotext.Get("can't look up %q in %s database: %v", key, db, err))
+	}
+
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Split(line, ":")
+	if (db == "passwd" && len(fields) < 7) || (db == "group" && len(fields) < 4) {
+		return nil, errors.New(gotext.Get("unexpected %s entry for %q: %q", db, key, line))
+	}
+	return fields, nil
+}
+
+// readGroups returns the members of the local groups listed in the group file p, by group name.
+func readGroups(p string) (groups map[string][]string, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't read local groups"))
+
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	groups = make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 || fields[0] == "" {
+			continue
+		}
+		groups[fields[0]] = splitMembers(fields[3])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// loadState returns the changes previously made to the local groups, by group name.
+func (m *Manager) loadState() (state map[string]changes, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't load local groups state"))
+
+	d, err := os.ReadFile(m.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(d, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveState atomically records the changes made to the local groups, removing the state file if there is none.
+func (m *Manager) saveState(state map[string]changes) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't save local groups state"))
+
+	if len(state) == 0 {
+		if err := os.Remove(m.stateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0700); err != nil {
+		return err
+	}
+	d, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.stateFile+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(m.stateFile+".new", m.stateFile)
+}
+
+// splitMembers returns the members of a comma-separated list.
+func splitMembers(s string) []string {
+	var members []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			members = append(members, u)
+		}
+	}
+	return members
+}
+
+// union returns a followed by the elements of b which are not in a.
+func union(a, b []string) []string {
+	r := slices.Clone(a)
+	for _, e := range b {
+		if !slices.Contains(r, e) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// without returns the elements of a which are not in b.
+func without(a, b []string) []string {
+	var r []string
+	for _, e := range a {
+		if !slices.Contains(b, e) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
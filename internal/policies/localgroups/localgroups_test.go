@@ -0,0 +1,114 @@
+package localgroups_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/localgroups"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries    []entry.Entry
+		isUser     bool
+		groupFile  string
+		stateFile  string
+		noDomain   bool
+		getentFail bool
+		gpasswdErr bool
+
+		wantErr bool
+	}{
+		"Add domain users":                               {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`}}},
+		"Add members of domain groups":                   {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\Developers`}}},
+		"Add accounts by their qualified or local names": {entries: []entry.Entry{{Key: "dialout", Value: "add alice@example.com\nadd eve"}}},
+		"Domain accounts fall back to their short name":  {entries: []entry.Entry{{Key: "dialout", Value: `add EXAMPLE\eve`}}},
+		"Domain accounts without domain":                 {entries: []entry.Entry{{Key: "dialout", Value: `add EXAMPLE\eve`}}, noDomain: true},
+		"Remove members":                                 {entries: []entry.Entry{{Key: "lpadmin", Value: "remove eve"}}},
+		"Remove members of domain groups":                {entries: []entry.Entry{{Key: "docker", Value: "add EXAMPLE\\alice\nadd EXAMPLE\\bob\nremove EXAMPLE\\Developers"}}},
+		"Remove all members before adding new ones":      {entries: []entry.Entry{{Key: "lpadmin", Value: "remove-all\nadd EXAMPLE\\alice"}}},
+		"Operations are processed in order":              {entries: []entry.Entry{{Key: "docker", Value: "remove dave\nadd EXAMPLE\\Developers\nremove EXAMPLE\\bob\nadd dave"}}},
+		"Multiple groups":                                {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`}, {Key: "dialout", Value: `add EXAMPLE\bob`}}},
+		"Existing members are not added again":           {entries: []entry.Entry{{Key: "docker", Value: "add dave\nadd EXAMPLE\\dave"}}},
+		"Blank lines are ignored":                        {entries: []entry.Entry{{Key: "docker", Value: "\n add EXAMPLE\\alice \n\n"}}},
+		"Unknown accounts and empty groups are ignored":  {entries: []entry.Entry{{Key: "docker", Value: "add EXAMPLE\\ghost\nadd EXAMPLE\\Empty\nadd EXAMPLE\\alice"}}},
+		"Groups which do not exist are skipped":          {entries: []entry.Entry{{Key: "libvirt", Value: `add EXAMPLE\alice`}, {Key: "docker", Value: `add EXAMPLE\bob`}}},
+		"Disabled entries are ignored":                   {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`, Disabled: true}}},
+		"No entries and no previous changes":             {groupFile: "does-not-exist"},
+		"Users are skipped":                              {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`}}, isUser: true},
+
+		// Previous changes
+		"Previous changes are restored without entries":                {groupFile: "group-changed", stateFile: "changed.yaml"},
+		"Previous changes are restored for groups no longer listed":    {entries: []entry.Entry{{Key: "dialout", Value: `add EXAMPLE\bob`}}, groupFile: "group-changed", stateFile: "changed.yaml"},
+		"Previous changes are kept when entries are the same":          {entries: []entry.Entry{{Key: "docker", Value: "add EXAMPLE\\alice\nremove dave"}}, groupFile: "group-changed", stateFile: "changed.yaml"},
+		"Previous changes are updated with the entries":                {entries: []entry.Entry{{Key: "docker", Value: "add EXAMPLE\\bob\nremove eve"}}, groupFile: "group-changed", stateFile: "changed.yaml"},
+		"Previous changes of groups which no longer exist are dropped": {groupFile: "group-changed", stateFile: "removed-group.yaml"},
+
+		// Error cases
+		"Error on unexpected operation":                       {entries: []entry.Entry{{Key: "docker", Value: "set alice"}}, wantErr: true},
+		"Error on operation without account":                  {entries: []entry.Entry{{Key: "docker", Value: "add "}}, wantErr: true},
+		"Error on getent failing":                             {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`}}, getentFail: true, wantErr: true},
+		"Error on gpasswd failing, previous changes are kept": {groupFile: "group-changed", stateFile: "changed.yaml", gpasswdErr: true, wantErr: true},
+		"Error on missing group file":                         {entries: []entry.Entry{{Key: "docker", Value: `add EXAMPLE\alice`}}, groupFile: "does-not-exist", wantErr: true},
+		"Error on invalid state file":                         {stateFile: "invalid.yaml", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.groupFile == "" {
+				tc.groupFile = "group"
+			}
+			testdata, err := filepath.Abs("testdata")
+			require.NoError(t, err, "Setup: can't get testdata path")
+
+			out := t.TempDir()
+			stateDir := filepath.Join(out, "state")
+			if tc.stateFile != "" {
+				require.NoError(t, os.MkdirAll(stateDir, 0700), "Setup: can't create state directory")
+				testutils.Copy(t, filepath.Join(testdata, "state", tc.stateFile), filepath.Join(stateDir, "membership.yaml"))
+			}
+
+			// The mocks record the gpasswd calls, and look up accounts in the testdata databases, ignoring case as SSSD.
+			commandsLog := filepath.Join(out, "gpasswd")
+			gpasswd := fmt.Sprintf("#!/bin/sh\necho \"$*\" >> %q\n", commandsLog)
+			if tc.gpasswdErr {
+				gpasswd += "exit 1\n"
+			}
+			getent := fmt.Sprintf("#!/bin/sh\ngrep -i -m1 \"^$2:\" %q/\"$1\" || exit 2\n", filepath.Join(testdata, "nss"))
+			if tc.getentFail {
+				getent = "#!/bin/sh\nexit 1\n"
+			}
+			bin := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(bin, "gpasswd"), []byte(gpasswd), 0700), "Setup: can't write mock gpasswd")
+			require.NoError(t, os.WriteFile(filepath.Join(bin, "getent"), []byte(getent), 0700), "Setup: can't write mock getent")
+
+			domain := "example.com"
+			if tc.noDomain {
+				domain = ""
+			}
+			m := localgroups.New(domain, stateDir,
+				localgroups.WithGroupFile(filepath.Join(testdata, tc.groupFile)),
+				localgroups.WithGpasswdCmd([]string{filepath.Join(bin, "gpasswd")}),
+				localgroups.WithGetentCmd([]string{filepath.Join(bin, "getent")}))
+
+			err = m.ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries)
+			if tc.wantErr {
+				// We don't return here as we want to check what was changed and recorded even in error cases
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			testutils.CompareTreesWithFiltering(t, out, testutils.GoldenPath(t), testutils.UpdateEnabled())
+		})
+	}
+}
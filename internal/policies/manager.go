@@ -27,7 +27,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -39,25 +41,15 @@ import (
 	"github.com/ubuntu/adsys/internal/ad/backends"
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
-	"github.com/ubuntu/adsys/internal/policies/apparmor"
-	"github.com/ubuntu/adsys/internal/policies/certificate"
-	"github.com/ubuntu/adsys/internal/policies/dconf"
 	"github.com/ubuntu/adsys/internal/policies/dynamicvalues"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
-	"github.com/ubuntu/adsys/internal/policies/mount"
-	"github.com/ubuntu/adsys/internal/policies/privilege"
 	"github.com/ubuntu/adsys/internal/policies/proxy"
-	"github.com/ubuntu/adsys/internal/policies/scripts"
 	"github.com/ubuntu/adsys/internal/systemd"
 	"github.com/ubuntu/decorate"
 	"golang.org/x/sync/errgroup"
 )
 
-// ProOnlyRules are the rules that are only available for Pro subscribers. They
-// will be filtered otherwise.
-var ProOnlyRules = []string{"privilege", "scripts", "mount", "apparmor", "proxy", "certificate"}
-
 // Manager handles all managers for various policy handlers.
 type Manager struct {
 	policiesCacheDir string
@@ -65,23 +57,28 @@ type Manager struct {
 
 	backend backends.Backend
 
-	dconf       *dconf.Manager
-	privilege   *privilege.Manager
-	scripts     *scripts.Manager
-	mount       *mount.Manager
-	gdm         *gdm.Manager
-	apparmor    *apparmor.Manager
-	proxy       *proxy.Manager
-	certificate *certificate.Manager
+	managers
 
 	subscriptionDbus dbus.BusObject
 
+	// bus is used to create new policy managers, redirected to another root directory, when planning.
+	bus *dbus.Conn
+
 	// muMu protects the objectMu mutex.
 	muMu *sync.Mutex
 	// objectMu prevents applying multiple policies concurrently for the same object.
 	objectMu map[string]*sync.Mutex
 }
 
+// managers is the registry of all the policy managers that ApplyPolicies dispatches rules to.
+type managers struct {
+	// policyManagers are ordered by registration.
+	policyManagers []PolicyManager
+
+	// opts are the options the policy managers were created with.
+	opts options
+}
+
 // systemdCaller is the interface to interact with systemd.
 type systemdCaller interface {
 	StartUnit(context.Context, string) error
@@ -105,13 +102,76 @@ type options struct {
 	apparmorDir        string
 	apparmorFsDir      string
 	systemUnitDir      string
+	userUnitDir        string
 	globalTrustDir     string
+	environmentDir     string
 	proxyApplier       proxy.Caller
 	systemdCaller      systemdCaller
 	gdm                *gdm.Manager
+	policyManagers     []PolicyManager
+	pluginsDir         string
+	pluginsTimeout     time.Duration
+	pluginsOwner       int
+	historySize        int
+	historyMaxAge      time.Duration
+	// now returns the current time, used to timestamp history entries.
+	now func() time.Time
+	// userLookup resolves the users the policies are applied to on the machine.
+	userLookup func(string) (*user.User, error)
+	// userGroups returns the groups of the users and computers the policies are applied to on the machine.
+	userGroups func(string) ([]string, error)
+	// systemRoot is the root directory of the machine targeting facts are gathered from.
+	systemRoot string
+	// localGroupsStateDir is where the local groups manager records the membership changes it made.
+	localGroupsStateDir string
+	groupFile           string
+	// scheduledTasksStateDir is where the scheduled tasks manager copies the scripts of the tasks.
+	scheduledTasksStateDir string
+	// runtimeSystemUnitDir and runtimeUserUnitDir are where units removed on reboot are written.
+	runtimeSystemUnitDir string
+	runtimeUserUnitDir   string
+	// homeRoot is the directory the home directories of the users are relative to.
+	homeRoot string
+	// filesStateDir is where the files manager records the files and directories it created.
+	filesStateDir string
+	// filesRoot is the directory the machine targets of the files manager are relative to.
+	filesRoot string
+	// filesAllowedPrefixes are the directories machine targets of the files manager can be deployed to.
+	filesAllowedPrefixes []string
 
 	apparmorParserCmd []string
 	certAutoenrollCmd []string
+	dconfCmd          []string
+	gpasswdCmd        []string
+}
+
+// withDefaultDirs returns a copy of the options where directories left to the policy managers defaults are set.
+func (o options) withDefaultDirs() options {
+	if o.dconfDir == "" {
+		o.dconfDir = consts.DefaultDconfDir
+	}
+	if o.sudoersDir == "" {
+		o.sudoersDir = consts.DefaultSudoersDir
+	}
+	if o.policyKitDir == "" {
+		o.policyKitDir = consts.DefaultPolicyKitDir
+	}
+	if o.localGroupsStateDir == "" {
+		o.localGroupsStateDir = filepath.Join(o.stateDir, "localgroups")
+	}
+	if o.runtimeSystemUnitDir == "" {
+		o.runtimeSystemUnitDir = consts.DefaultRuntimeSystemUnitDir
+	}
+	if o.runtimeUserUnitDir == "" {
+		o.runtimeUserUnitDir = consts.DefaultRuntimeUserUnitDir
+	}
+	if o.scheduledTasksStateDir == "" {
+		o.scheduledTasksStateDir = filepath.Join(o.stateDir, "scheduledtasks")
+	}
+	if o.filesStateDir == "" {
+		o.filesStateDir = filepath.Join(o.stateDir, "files")
+	}
+	return o
 }
 
 // Option reprents an optional function to change Policies behavior.
@@ -214,6 +274,30 @@ func WithSystemUnitDir(p string) Option {
 	}
 }
 
+// WithUserUnitDir specifies a personalized unit directory for adsys user units.
+func WithUserUnitDir(p string) Option {
+	return func(o *options) error {
+		o.userUnitDir = p
+		return nil
+	}
+}
+
+// WithRuntimeSystemUnitDir specifies a personalized runtime unit directory for adsys units removed on reboot.
+func WithRuntimeSystemUnitDir(p string) Option {
+	return func(o *options) error {
+		o.runtimeSystemUnitDir = p
+		return nil
+	}
+}
+
+// WithRuntimeUserUnitDir specifies a personalized runtime unit directory for adsys user units removed on reboot.
+func WithRuntimeUserUnitDir(p string) Option {
+	return func(o *options) error {
+		o.runtimeUserUnitDir = p
+		return nil
+	}
+}
+
 // WithGlobalTrustDir specifies a personalized global trust directory for use
 // with the certificate manager.
 func WithGlobalTrustDir(p string) Option {
@@ -223,6 +307,30 @@ func WithGlobalTrustDir(p string) Option {
 	}
 }
 
+// WithGroupFile specifies a personalized group file, listing the local groups and their members.
+func WithGroupFile(p string) Option {
+	return func(o *options) error {
+		o.groupFile = p
+		return nil
+	}
+}
+
+// WithGpasswdCmd overrides the default gpasswd command, changing the members of local groups.
+func WithGpasswdCmd(cmd []string) Option {
+	return func(o *options) error {
+		o.gpasswdCmd = cmd
+		return nil
+	}
+}
+
+// WithEnvironmentDir specifies a personalized directory for the environment variables of the sessions.
+func WithEnvironmentDir(p string) Option {
+	return func(o *options) error {
+		o.environmentDir = p
+		return nil
+	}
+}
+
 // WithProxyApplier specifies a personalized proxy applier for the proxy policy manager.
 func WithProxyApplier(p proxy.Caller) Option {
 	return func(o *options) error {
@@ -247,6 +355,62 @@ func WithCertAutoenrollCmd(cmd []string) Option {
 	}
 }
 
+// WithPolicyManagers registers additional policy managers, applied after the built-in ones.
+func WithPolicyManagers(pms ...PolicyManager) Option {
+	return func(o *options) error {
+		o.policyManagers = append(o.policyManagers, pms...)
+		return nil
+	}
+}
+
+// WithPluginsDir specifies a personalized directory for policy manager plugins.
+func WithPluginsDir(p string) Option {
+	return func(o *options) error {
+		o.pluginsDir = p
+		return nil
+	}
+}
+
+// WithPluginsTimeout specifies the maximum time a policy manager plugin can run.
+func WithPluginsTimeout(t time.Duration) Option {
+	return func(o *options) error {
+		o.pluginsTimeout = t
+		return nil
+	}
+}
+
+// WithHistorySize specifies how many applied policies are kept in history per object.
+// 0 disables history.
+func WithHistorySize(n int) Option {
+	return func(o *options) error {
+		o.historySize = n
+		return nil
+	}
+}
+
+// WithHistoryMaxAge specifies after how long applied policies are removed from history.
+// 0 means that they are kept regardless of their age.
+func WithHistoryMaxAge(d time.Duration) Option {
+	return func(o *options) error {
+		o.historyMaxAge = d
+		return nil
+	}
+}
+
+// WithFilesAllowedPrefixes specifies the directories the machine files and folders can be deployed to.
+// They must be absolute and clean paths. Security-sensitive paths, like /etc/sudoers.d, are always denied.
+func WithFilesAllowedPrefixes(prefixes []string) Option {
+	return func(o *options) error {
+		for _, p := range prefixes {
+			if !filepath.IsAbs(p) || filepath.Clean(p) != p {
+				return errors.New(gotext.Get("allowed prefix of files %q is not an absolute and clean path", p))
+			}
+		}
+		o.filesAllowedPrefixes = prefixes
+		return nil
+	}
+}
+
 // NewManager returns a new manager with all default policy handlers.
 func NewManager(bus *dbus.Conn, hostname string, backend backends.Backend, opts ...Option) (m *Manager, err error) {
 	defer decorate.OnError(&err, gotext.Get("can't create a new policy handlers manager"))
@@ -263,9 +427,22 @@ func NewManager(bus *dbus.Conn, hostname string, backend backends.Backend, opts
 		runDir:             consts.DefaultRunDir,
 		shareDir:           consts.DefaultShareDir,
 		apparmorDir:        consts.DefaultApparmorDir,
+		apparmorFsDir:      consts.DefaultApparmorFsDir,
 		systemUnitDir:      consts.DefaultSystemUnitDir,
+		environmentDir:     consts.DefaultEnvironmentDir,
+		userUnitDir:        consts.DefaultUserUnitDir,
 		globalTrustDir:     consts.DefaultGlobalTrustDir,
 		policyKitSystemDir: consts.DefaultPolicyKitSystemDir,
+		pluginsDir:         consts.DefaultPluginsDir,
+		pluginsTimeout:     consts.DefaultPluginsTimeout * time.Second,
+		historySize:        consts.DefaultHistorySize,
+		historyMaxAge:      consts.DefaultHistoryMaxAge * 24 * time.Hour,
+		now:                time.Now,
+		userLookup:         user.Lookup,
+		userGroups:         localGroups,
+		systemRoot:         "/",
+		homeRoot:           "/",
+		filesRoot:          "/",
 		systemdCaller:      defaultSystemdCaller,
 		gdm:                nil,
 	}
@@ -275,63 +452,12 @@ func NewManager(bus *dbus.Conn, hostname string, backend backends.Backend, opts
 			return nil, err
 		}
 	}
-	// dconf manager
-	dconfManager := &dconf.Manager{}
-	if args.dconfDir != "" {
-		dconfManager = dconf.NewWithDconfDir(args.dconfDir)
-	}
-
-	// privilege manager
-	privilegeManager := privilege.NewWithDirs(args.sudoersDir, args.policyKitDir, args.policyKitSystemDir)
-
-	// scripts manager
-	scriptsManager, err := scripts.New(args.runDir, args.systemdCaller)
-	if err != nil {
-		return nil, err
-	}
 
-	// mount manager
-	mountManager, err := mount.New(args.runDir, args.systemUnitDir, args.systemdCaller)
+	ms, err := newManagers(bus, backend, args)
 	if err != nil {
 		return nil, err
 	}
 
-	// apparmor manager
-	var apparmorOptions []apparmor.Option
-	if args.apparmorParserCmd != nil {
-		apparmorOptions = append(apparmorOptions, apparmor.WithApparmorParserCmd(args.apparmorParserCmd))
-	}
-	if args.apparmorFsDir != "" {
-		apparmorOptions = append(apparmorOptions, apparmor.WithApparmorFsDir(args.apparmorFsDir))
-	}
-	apparmorManager := apparmor.New(args.apparmorDir, apparmorOptions...)
-
-	// proxy manager
-	var proxyOptions []proxy.Option
-	if args.proxyApplier != nil {
-		proxyOptions = append(proxyOptions, proxy.WithProxyApplier(args.proxyApplier))
-	}
-	proxyManager := proxy.New(bus, proxyOptions...)
-
-	// certificate manager
-	certificateOpts := []certificate.Option{
-		certificate.WithStateDir(args.stateDir),
-		certificate.WithRunDir(args.runDir),
-		certificate.WithShareDir(args.shareDir),
-		certificate.WithGlobalTrustDir(args.globalTrustDir),
-	}
-	if args.certAutoenrollCmd != nil {
-		certificateOpts = append(certificateOpts, certificate.WithCertAutoenrollCmd(args.certAutoenrollCmd))
-	}
-	certificateManager := certificate.New(backend.Domain(), certificateOpts...)
-
-	// inject applied dconf mangager if we need to build a gdm manager
-	if args.gdm == nil {
-		if args.gdm, err = gdm.New(gdm.WithDconf(dconfManager)); err != nil {
-			return nil, err
-		}
-	}
-
 	policiesCacheDir := filepath.Join(args.cacheDir, PoliciesCacheBaseName)
 	if err := os.MkdirAll(policiesCacheDir, 0700); err != nil {
 		return nil, err
@@ -344,25 +470,70 @@ func NewManager(bus *dbus.Conn, hostname string, backend backends.Backend, opts
 		backend:          backend,
 		policiesCacheDir: policiesCacheDir,
 		hostname:         hostname,
-		dconf:            dconfManager,
-		privilege:        privilegeManager,
-		scripts:          scriptsManager,
-		mount:            mountManager,
-		apparmor:         apparmorManager,
-		proxy:            proxyManager,
-		certificate:      certificateManager,
-		gdm:              args.gdm,
+		managers:         ms,
 
 		subscriptionDbus: subscriptionDbus,
 
+		bus: bus,
+
 		muMu:     &sync.Mutex{},
 		objectMu: make(map[string]*sync.Mutex),
 	}, nil
 }
 
+// newManagers creates all built-in policy managers from the given options and registers them with the additional ones
+// and the plugins.
+func newManagers(bus *dbus.Conn, backend backends.Backend, args options) (ms managers, err error) {
+	args = args.withDefaultDirs()
+
+	pms, err := newBuiltinManagers(bus, backend, args)
+	if err != nil {
+		return ms, err
+	}
+	pms = append(pms, args.policyManagers...)
+	plugins, err := newPluginManagers(args.pluginsDir, args.pluginsTimeout, args.pluginsOwner, pms)
+	if err != nil {
+		return ms, err
+	}
+	pms = append(pms, plugins...)
+	if err := validateRegistry(pms); err != nil {
+		return ms, err
+	}
+
+	return managers{
+		policyManagers: pms,
+		opts:           args,
+	}, nil
+}
+
+// PolicyTypes returns the types of rules handled by the registered policy managers, in registration order.
+func (m *Manager) PolicyTypes() []string {
+	var types []string
+	for _, pm := range m.policyManagers {
+		types = append(types, pm.Type())
+	}
+	return types
+}
+
+// ProOnlyRules returns the types of rules that are only applied when the machine is subscribed to Ubuntu Pro,
+// in registration order. They will be filtered otherwise.
+func (m *Manager) ProOnlyRules() []string {
+	var types []string
+	for _, pm := range m.policyManagers {
+		if pm.ProOnly() {
+			types = append(types, pm.Type())
+		}
+	}
+	return types
+}
+
 // ApplyPolicies generates a computer or user policy based on a list of entries
 // retrieved from a directory service.
-func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComputer bool, pols *Policies) (err error) {
+// It returns the outcome of each policy manager, even when applying policies fails.
+// If any policy manager fails, all of them are rolled back to their previous state, by applying the cached policies
+// again with the ones changing more than their files, and the policies cache is not updated.
+// Transient policies are applied without updating the policies cache nor history.
+func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComputer bool, pols *Policies) (results []ApplyResult, err error) {
 	defer decorate.OnError(&err, gotext.Get("failed to apply policy to %q", objectName))
 
 	// We have a lock per objectName to prevent multiple instances of ApplyPolicies for the same object.
@@ -374,7 +545,51 @@ func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComput
 	defer m.objectMu[objectName].Unlock()
 	m.muMu.Unlock()
 
-	rules := pols.GetUniqueRules()
+	rules, skipped, err := m.prepareRules(ctx, objectName, isComputer, pols)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := policySteps(m.managers, objectName, isComputer, rules, pols)
+	results, err = m.applyStepsWithRollback(ctx, objectName, isComputer, steps)
+	for i, r := range results {
+		if r.Status != StatusFailed && slices.Contains(skipped, r.Manager) {
+			results[i].Status = StatusSkippedNonPro
+		}
+	}
+	if err != nil {
+		return results, err
+	}
+
+	if pols.Transient {
+		log.Debugf(ctx, "Policies of %q are transient: not updating the cache", objectName)
+		return results, nil
+	}
+
+	// Write cache Policies only once all policies have been applied successfully
+	if err := pols.Save(filepath.Join(m.policiesCacheDir, objectName)); err != nil {
+		return results, err
+	}
+
+	// Policies are applied: failing to keep track of them should not prevent authentication.
+	if err := m.addToHistory(ctx, objectName); err != nil {
+		log.Warning(ctx, err)
+	}
+	return results, nil
+}
+
+// prepareRules returns the rules from pols to dispatch to each policy manager.
+// Entries whose targeting expression does not match are dropped, Ubuntu Pro-only rules are filtered out if the
+// machine is not subscribed and dynamic values are expanded.
+// The types of the rules which were filtered out are returned as skipped.
+func (m *Manager) prepareRules(ctx context.Context, objectName string, isComputer bool, pols *Policies) (rules map[string][]entry.Entry, skipped []string, err error) {
+	// Targeted out entries are dropped before computing the unique rules, so that they neither override nor are
+	// merged with the ones of other GPOs.
+	targeted, err := pols.withoutTargetedOut(ctx, m.targetFilter(objectName, isComputer))
+	if err != nil {
+		return nil, nil, err
+	}
+	rules = targeted.GetUniqueRules()
 	action := gotext.Get("Applying")
 	if len(rules) == 0 {
 		action = gotext.Get("Unloading")
@@ -385,82 +600,232 @@ func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComput
 	// dispatching to managers, so a bad template in a rule that will not be
 	// applied does not block a non-Pro machine.
 	if !m.GetSubscriptionState(ctx) {
-		if filteredRules := filterRules(ctx, rules); len(filteredRules) > 0 {
-			log.Warning(ctx, gotext.Get("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s", strings.Join(filteredRules, ", ")))
+		if skipped = filterRules(ctx, rules, m.ProOnlyRules()); len(skipped) > 0 {
+			log.Warning(ctx, gotext.Get("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s", strings.Join(skipped, ", ")))
 		}
 	}
 
 	// Expand dynamic values (${USER}, ${HOSTNAME}, ...) in the remaining rules
 	// before starting any manager goroutine, so an invalid template fails closed
 	// before any partial policy write can occur.
-	dynCtx, err := m.dynamicValuesContext(objectName, isComputer)
+	dynCtx, err := m.dynamicValuesContext(objectName, isComputer, pols)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if err := expandDynamicValues(rules, dynCtx); err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var g errgroup.Group
-	g.Go(func() error {
-		return m.dconf.ApplyPolicy(ctx, objectName, isComputer, rules["dconf"])
-	})
+	return rules, skipped, nil
+}
 
-	g.Go(func() error {
-		return m.privilege.ApplyPolicy(ctx, objectName, isComputer, rules["privilege"])
-	})
-	g.Go(func() error {
-		return m.scripts.ApplyPolicy(ctx, objectName, isComputer, rules["scripts"], pols.SaveAssetsTo)
-	})
-	g.Go(func() error {
-		return m.mount.ApplyPolicy(ctx, objectName, isComputer, rules["mount"])
-	})
-	g.Go(func() error {
-		return m.apparmor.ApplyPolicy(ctx, objectName, isComputer, rules["apparmor"], pols.SaveAssetsTo)
-	})
-	g.Go(func() error {
-		return m.proxy.ApplyPolicy(ctx, objectName, isComputer, rules["proxy"])
-	})
-	g.Go(func() error {
-		// Ignore error as we don't want to fail because of online status this late in the process
-		isOnline, _ := m.backend.IsOnline()
-		return m.certificate.ApplyPolicy(ctx, objectName, isComputer, isOnline, rules["certificate"])
-	})
-	if err := g.Wait(); err != nil {
-		return err
+// policyStep applies the rules of one policy type with its manager.
+type policyStep struct {
+	name string
+	// after are the names of the steps which need to be applied first.
+	after []string
+	apply func(context.Context) error
+	// paths are the files, as glob patterns, that the manager can replace when applying the rules.
+	paths []string
+	// hasRules is true if there are rules for the manager to apply.
+	hasRules bool
+}
+
+// policySteps returns the list of policy managers of ms to apply rules with, in registration order.
+// Policy managers not applying to this kind of object are skipped.
+func policySteps(ms managers, objectName string, isComputer bool, rules map[string][]entry.Entry, pols *Policies) []policyStep {
+	var steps []policyStep
+	for _, pm := range ms.policyManagers {
+		if (isComputer && pm.Scope() == ScopeUser) || (!isComputer && pm.Scope() == ScopeMachine) {
+			continue
+		}
+
+		var assetsDumper AssetsDumper
+		if pm.NeedsAssets() {
+			assetsDumper = pols.SaveAssetsTo
+		}
+		var paths []string
+		if s, ok := pm.(snapshotter); ok {
+			paths = s.SnapshotPaths(objectName, isComputer, rules[pm.Type()])
+		}
+
+		steps = append(steps, policyStep{
+			name:  pm.Type(),
+			after: pm.After(),
+			apply: func(ctx context.Context) error {
+				return pm.ApplyPolicy(ctx, objectName, isComputer, rules[pm.Type()], assetsDumper)
+			},
+			paths:    paths,
+			hasRules: len(rules[pm.Type()]) > 0,
+		})
+	}
+
+	return steps
+}
+
+// applySteps applies all policy steps and returns the outcome of the ones which ran, in the order of steps.
+// Steps are applied in parallel, except the ones which need to wait for others.
+// All steps applied in parallel are run to completion, even if one of them fails.
+func applySteps(ctx context.Context, steps []policyStep) (results []ApplyResult, err error) {
+	waves, err := orderSteps(steps)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	defer func() {
+		slices.SortFunc(results, func(a, b ApplyResult) int {
+			return slices.IndexFunc(steps, func(s policyStep) bool { return s.name == a.Manager }) -
+				slices.IndexFunc(steps, func(s policyStep) bool { return s.name == b.Manager })
+		})
+	}()
+	for _, wave := range waves {
+		var g errgroup.Group
+		for _, s := range wave {
+			g.Go(func() error {
+				start := time.Now()
+				err := s.apply(ctx)
+				r := ApplyResult{Manager: s.name, Status: StatusUnchanged, Duration: time.Since(start), Err: err}
+				if err != nil {
+					r.Status = StatusFailed
+				} else if s.hasRules {
+					r.Status = StatusChangesUnknown
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				results = append(results, r)
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// applyStepsWithRollback applies all policy steps as a single transaction.
+// Before applying, the files each manager can replace are snapshotted. If any manager fails, the managers changing
+// more than their files apply the previous rules again, then the files of every manager are restored to their
+// previous state, and the outcome of the rollback is reported to the client.
+// The returned results list the files each manager changed, even if they were rolled back since.
+func (m *Manager) applyStepsWithRollback(ctx context.Context, objectName string, isComputer bool, steps []policyStep) (results []ApplyResult, err error) {
+	snapshotsDir, err := os.MkdirTemp("", "adsys-snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(snapshotsDir); err != nil {
+			log.Warning(ctx, gotext.Get("Could not remove snapshot directory %q: %v", snapshotsDir, err))
+		}
+	}()
+
+	snapshots := make(map[string]snapshot)
+	for _, s := range steps {
+		snap, err := newSnapshot(filepath.Join(snapshotsDir, s.name), s.paths)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[s.name] = snap
+	}
+
+	results, errApply := applySteps(ctx, steps)
+	for i, r := range results {
+		results[i].Object = objectName
+		snap := snapshots[r.Manager]
+		if len(snap.patterns) == 0 {
+			continue
+		}
+		files, err := snap.changedFiles()
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Could not list files changed by %s policy manager: %v", r.Manager, err))
+			continue
+		}
+		results[i].Files = files
+		if r.Status == StatusFailed {
+			continue
+		}
+		results[i].Status = StatusUnchanged
+		if len(files) > 0 {
+			results[i].Status = StatusChanged
+		}
+	}
+	if errApply == nil {
+		return results, nil
 	}
 
+	log.Warning(ctx, gotext.Get("Failed to apply policies for %s, rolling back to the previous state", objectName))
+	errRollback := m.reapplyPrevious(ctx, objectName, isComputer, results)
+	for _, s := range steps {
+		errRollback = errors.Join(errRollback, snapshots[s.name].restore())
+	}
+	// Restored mount units need to be reloaded.
 	if isComputer {
-		// Apply GDM policy only now as we need dconf machine database to be ready first
-		if err := m.gdm.ApplyPolicy(ctx, rules["gdm"]); err != nil {
-			return err
+		errRollback = errors.Join(errRollback, m.opts.systemdCaller.DaemonReload(ctx))
+	}
+	if errRollback != nil {
+		log.Error(ctx, gotext.Get("Failed to roll back policies for %s: %v", objectName, errRollback))
+		return results, fmt.Errorf("%w\n%s", errApply, gotext.Get("rollback to the previous state failed: %v", errRollback))
+	}
+	log.Warning(ctx, gotext.Get("Policies for %s have been rolled back to the previous state", objectName))
+
+	return results, errApply
+}
+
+// reapplyPrevious applies again, with the policy managers of results changing more than the files they replace, the
+// rules of the policies cached for objectName, which are the ones applied previously.
+// If no policies were applied yet, the rules of those policy managers are unloaded.
+func (m *Manager) reapplyPrevious(ctx context.Context, objectName string, isComputer bool, results []ApplyResult) (err error) {
+	var pms []PolicyManager
+	for _, pm := range m.policyManagers {
+		if _, ok := pm.(rollbacker); !ok || !slices.ContainsFunc(results, func(r ApplyResult) bool { return r.Manager == pm.Type() }) {
+			continue
+		}
+		pms = append(pms, pm)
+	}
+	if len(pms) == 0 {
+		return nil
+	}
+
+	previous, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, previous.Close)
+
+	rules, _, err := m.prepareRules(ctx, objectName, isComputer, &previous)
+	if err != nil {
+		return err
+	}
+
+	for _, pm := range pms {
+		var assetsDumper AssetsDumper
+		if pm.NeedsAssets() {
+			assetsDumper = previous.SaveAssetsTo
 		}
+		err = errors.Join(err, pm.(rollbacker).Rollback(ctx, objectName, isComputer, rules[pm.Type()], assetsDumper))
 	}
 
-	// Write cache Policies
-	return pols.Save(filepath.Join(m.policiesCacheDir, objectName))
+	return err
 }
 
 // DumpPolicies displays the currently applied policies and rules (since last update) for objectName.
 // It can in addition show the rules and overridden content.
-func (m *Manager) DumpPolicies(ctx context.Context, objectName string, computerOnly, withRules, withOverridden bool) (msg string, err error) {
+// format is either text, the default, or a machine readable json or yaml version, listing each rule with the GPO
+// providing it.
+func (m *Manager) DumpPolicies(ctx context.Context, objectName string, computerOnly, withRules, withOverridden bool, format string) (msg string, err error) {
 	defer decorate.OnError(&err, gotext.Get("failed to dump policies for %q", objectName))
 
 	log.Infof(ctx, "Dumping policies for %s", objectName)
 
-	var out strings.Builder
-
-	var alreadyProcessedRules map[string]struct{}
+	var policiesHost Policies
 	if !computerOnly {
-		fmt.Fprintln(&out, gotext.Get("Policies from machine configuration:"))
-		policiesHost, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, m.hostname))
+		policiesHost, err = NewFromCache(ctx, filepath.Join(m.policiesCacheDir, m.hostname))
 		if err != nil {
 			return "", errors.New(gotext.Get("no policy applied for %q: %v", m.hostname, err))
 		}
-		for _, g := range policiesHost.GPOs {
-			alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules)
-		}
-		fmt.Fprintln(&out, gotext.Get("Policies from user configuration:"))
 	}
 
 	// Load target policies
@@ -469,8 +834,35 @@ func (m *Manager) DumpPolicies(ctx context.Context, objectName string, computerO
 		log.Info(ctx, gotext.Get("User %q not found on cache.", objectName))
 		return "", errors.New(gotext.Get("no policy applied for %q: %v", objectName, err))
 	}
+
+	// Machine GPOs are targeted with the machine facts, and the ones of objectName with its own.
+	hostFiltered := m.dumpFilter(ctx, m.hostname, true)
+	targetFiltered := m.dumpFilter(ctx, objectName, computerOnly)
+
+	if format != "" && format != "text" {
+		alreadyProcessedRules := make(map[string]struct{})
+		dump := dumpedPolicies{GPOs: []dumpedGPO{}}
+		targetConfiguration := "machine"
+		if !computerOnly {
+			dump.add(policiesHost.GPOs, "machine", withRules, withOverridden, alreadyProcessedRules, hostFiltered)
+			targetConfiguration = "user"
+		}
+		dump.add(policiesTarget.GPOs, targetConfiguration, withRules, withOverridden, alreadyProcessedRules, targetFiltered)
+		return dump.marshal(format)
+	}
+
+	var out strings.Builder
+
+	var alreadyProcessedRules map[string]struct{}
+	if !computerOnly {
+		fmt.Fprintln(&out, gotext.Get("Policies from machine configuration:"))
+		for _, g := range policiesHost.GPOs {
+			alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules, hostFiltered)
+		}
+		fmt.Fprintln(&out, gotext.Get("Policies from user configuration:"))
+	}
 	for _, g := range policiesTarget.GPOs {
-		alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules)
+		alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules, targetFiltered)
 	}
 
 	return out.String(), nil
@@ -493,6 +885,27 @@ func (m *Manager) LastUpdateFor(ctx context.Context, objectName string, isMachin
 	return info.ModTime(), nil
 }
 
+// LastOnlineRefreshFor returns when the cached policies of object or current machine were last downloaded from the
+// directory service, and the domain controller which served them, if known.
+func (m *Manager) LastOnlineRefreshFor(ctx context.Context, objectName string, isMachine bool) (t time.Time, server string, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to get policy last online refresh time %q (machine: %v)", objectName, isMachine))
+
+	if isMachine {
+		objectName = m.hostname
+	}
+
+	pols, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	if err != nil {
+		return time.Time{}, "", errors.New(gotext.Get("policies were not applied for %q: %v", objectName, err))
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, pols.Close)
+
+	if pols.OnlineRefresh.IsZero() {
+		return time.Time{}, "", errors.New(gotext.Get("policies of %q have no online refresh time", objectName))
+	}
+	return pols.OnlineRefresh, pols.Server, nil
+}
+
 // GetSubscriptionState returns the subscription status from Ubuntu Pro.
 func (m *Manager) GetSubscriptionState(ctx context.Context) (subscriptionEnabled bool) {
 	log.Debug(ctx, "Refresh subscription state")
@@ -527,22 +940,22 @@ func (m *Manager) GetSubscriptionState(ctx context.Context) (subscriptionEnabled
 
 // filterRules allows to filter any rules that are not eligible for the current device,
 // and returns the sorted list of filtered rules.
-func filterRules(ctx context.Context, rules map[string][]entry.Entry) []string {
+func filterRules(ctx context.Context, rules map[string][]entry.Entry, proOnlyRules []string) []string {
 	log.Debug(ctx, "Filtering Rules")
 
 	var filteredRules []string
 	for rule := range rules {
-		if !slices.Contains(ProOnlyRules, rule) {
+		if !slices.Contains(proOnlyRules, rule) {
 			continue
 		}
 		filteredRules = append(filteredRules, rule)
 		rules[rule] = nil
 	}
 
-	// Return the filtered rules in the same order as ProOnlyRules, which is the
+	// Return the filtered rules in the same order as proOnlyRules, which is the
 	// order of the rules to apply
 	slices.SortFunc(filteredRules, func(a, b string) int {
-		return slices.Index(ProOnlyRules, a) - slices.Index(ProOnlyRules, b)
+		return slices.Index(proOnlyRules, a) - slices.Index(proOnlyRules, b)
 	})
 
 	return filteredRules
@@ -556,7 +969,10 @@ func filterRules(ctx context.Context, rules map[string][]entry.Entry) []string {
 // it. ${HOSTNAME} and ${FULL_HOSTNAME} always refer to the local machine, with
 // ${FULL_HOSTNAME} using the machine domain so it stays correct for cross-domain
 // logons.
-func (m *Manager) dynamicValuesContext(objectName string, isComputer bool) (dynamicvalues.Context, error) {
+// ${SID} and the ${AD:attribute} values are the object attributes returned with
+// the GPO list of pols. ${UID}, ${GID} and ${HOME} are looked up on the machine
+// and left unresolved if the user is unknown.
+func (m *Manager) dynamicValuesContext(objectName string, isComputer bool, pols *Policies) (dynamicvalues.Context, error) {
 	machineDomain := m.backend.Domain()
 
 	fullHostname := m.hostname
@@ -569,6 +985,10 @@ func (m *Manager) dynamicValuesContext(objectName string, isComputer bool) (dyna
 		FullHostname: fullHostname,
 		IsComputer:   isComputer,
 	}
+	if pols != nil {
+		dynCtx.SID = pols.Attributes["objectsid"]
+		dynCtx.Attributes = pols.Attributes
+	}
 
 	if isComputer {
 		dynCtx.Domain = machineDomain
@@ -583,6 +1003,12 @@ func (m *Manager) dynamicValuesContext(objectName string, isComputer bool) (dyna
 	dynCtx.FullUser = objectName
 	dynCtx.Domain = objectName[at+1:]
 
+	if u, err := m.opts.userLookup(objectName); err == nil {
+		dynCtx.UID = u.Uid
+		dynCtx.GID = u.Gid
+		dynCtx.Home = u.HomeDir
+	}
+
 	return dynCtx, nil
 }
 
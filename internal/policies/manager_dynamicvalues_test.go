@@ -3,6 +3,7 @@ package policies_test
 import (
 	"context"
 	"os"
+	"os/user"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,10 +18,19 @@ func TestDynamicValuesContext(t *testing.T) {
 
 	bus := testutils.NewDbusConn(t)
 
+	// userLookup only knows bob@example.com on the machine.
+	userLookup := func(name string) (*user.User, error) {
+		if name != "bob@example.com" {
+			return nil, user.UnknownUserError(name)
+		}
+		return &user.User{Uid: "1234", Gid: "5678", HomeDir: "/home/bob@example.com"}, nil
+	}
+
 	tests := map[string]struct {
 		hostname   string
 		objectName string
 		isComputer bool
+		attributes map[string]string
 
 		want    dynamicvalues.Context
 		wantErr bool
@@ -42,6 +52,37 @@ func TestDynamicValuesContext(t *testing.T) {
 				Hostname:     "workstation01",
 				FullHostname: "workstation01.example.com",
 				Domain:       "example.com",
+				UID:          "1234",
+				GID:          "5678",
+				Home:         "/home/bob@example.com",
+			},
+		},
+		"User policy uses the object attributes": {
+			hostname: "workstation01", objectName: "bob@example.com",
+			attributes: map[string]string{"objectsid": "S-1-5-21-1-2-3-1103", "department": "R&D"},
+			want: dynamicvalues.Context{
+				User:         "bob",
+				FullUser:     "bob@example.com",
+				Hostname:     "workstation01",
+				FullHostname: "workstation01.example.com",
+				Domain:       "example.com",
+				UID:          "1234",
+				GID:          "5678",
+				Home:         "/home/bob@example.com",
+				SID:          "S-1-5-21-1-2-3-1103",
+				Attributes:   map[string]string{"objectsid": "S-1-5-21-1-2-3-1103", "department": "R&D"},
+			},
+		},
+		"Computer policy uses the object attributes": {
+			hostname: "workstation01", objectName: "workstation01", isComputer: true,
+			attributes: map[string]string{"objectsid": "S-1-5-21-1-2-3-1104"},
+			want: dynamicvalues.Context{
+				Hostname:     "workstation01",
+				FullHostname: "workstation01.example.com",
+				Domain:       "example.com",
+				SID:          "S-1-5-21-1-2-3-1104",
+				Attributes:   map[string]string{"objectsid": "S-1-5-21-1-2-3-1104"},
+				IsComputer:   true,
 			},
 		},
 		"User from a child domain keeps the user domain but machine full hostname": {
@@ -76,10 +117,10 @@ func TestDynamicValuesContext(t *testing.T) {
 			cacheDir := t.TempDir()
 			runDir := t.TempDir()
 			m, err := policies.NewManager(bus, tc.hostname, mockBackend{},
-				policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+				policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithUserLookup(userLookup))
 			require.NoError(t, err, "Setup: couldn't create manager")
 
-			got, err := m.DynamicValuesContext(tc.objectName, tc.isComputer)
+			got, err := m.DynamicValuesContext(tc.objectName, tc.isComputer, &policies.Policies{Attributes: tc.attributes})
 			if tc.wantErr {
 				require.Error(t, err, "DynamicValuesContext should have errored but didn't")
 				return
@@ -185,6 +226,6 @@ func TestApplyPoliciesFailsOnUnknownDynamicValue(t *testing.T) {
 	require.NoError(t, err, "Setup: can't create policies")
 	defer pols.Close()
 
-	err = m.ApplyPolicies(context.Background(), hostname, true, &pols)
+	_, err = m.ApplyPolicies(context.Background(), hostname, true, &pols)
 	require.Error(t, err, "ApplyPolicies should fail when an entry has an unknown dynamic value")
 }
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/user"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -40,6 +43,8 @@ func TestApplyPolicies(t *testing.T) {
 		makeDirReadOnly                 string
 		isNotSubscribed                 bool
 		secondCallWithNoSubscription    bool
+		secondCallWithFailingPolicies   string
+		secondCallTransient             bool
 		noUbuntuProxyManager            bool
 		backendOfflineError             bool
 
@@ -58,6 +63,15 @@ func TestApplyPolicies(t *testing.T) {
 		// dynamic values
 		"Dynamic values are expanded before applying": {policiesDir: "dynamic_values"},
 
+		// targeting
+		"Entries not matching their target are not applied": {policiesDir: "targeting"},
+
+		// rollback
+		"Second call failing rolls back to the previous state": {policiesDir: "all_entry_types", secondCallWithFailingPolicies: "dconf_failing"},
+
+		// transient policies
+		"Second call with transient policies does not update the cache": {policiesDir: "all_entry_types", secondCallWithNoRules: true, secondCallTransient: true, scriptSessionEndedForSecondCall: true},
+
 		// Error cases
 		"Error when applying dconf policy":       {policiesDir: "dconf_failing", wantErr: true},
 		"Error when applying privilege policy":   {makeDirReadOnly: "etc/sudoers.d", policiesDir: "all_entry_types", wantErr: true},
@@ -70,6 +84,9 @@ func TestApplyPolicies(t *testing.T) {
 		// dynamic values error cases
 		"Error on unknown dynamic value":                {policiesDir: "dynamic_values_unknown", wantErr: true},
 		"Error on user dynamic value in machine policy": {policiesDir: "dynamic_values_user_in_machine", wantErr: true},
+
+		// targeting error cases
+		"Error on invalid targeting expression": {policiesDir: "targeting_invalid", wantErr: true},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -104,6 +121,8 @@ func TestApplyPolicies(t *testing.T) {
 				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
 			}()
 
+			proxyApplier := &mockProxyApplier{wantApplyError: tc.noUbuntuProxyManager}
+			systemdCaller := &recordingSystemdCaller{}
 			m, err := policies.NewManager(bus,
 				hostname,
 				mockBackend{},
@@ -119,9 +138,20 @@ func TestApplyPolicies(t *testing.T) {
 				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
 				policies.WithApparmorParserCmd([]string{"/bin/true"}),
 				policies.WithCertAutoenrollCmd([]string{"/bin/true"}),
+				policies.WithGroupFile(filepath.Join("testdata", "localgroups", "group")),
+				policies.WithGpasswdCmd([]string{"/bin/true"}),
 				policies.WithSystemUnitDir(systemUnitDir),
-				policies.WithProxyApplier(&mockProxyApplier{wantApplyError: tc.noUbuntuProxyManager}),
-				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithEnvironmentDir(filepath.Join(fakeRootDir, "etc", "environment.d")),
+				policies.WithUserUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "user")),
+				policies.WithRuntimeSystemUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "system")),
+				policies.WithRuntimeUserUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "user")),
+				policies.WithFilesRoot(fakeRootDir),
+				policies.WithProxyApplier(proxyApplier),
+				policies.WithSystemdCaller(systemdCaller),
+				policies.WithSystemRoot(filepath.Join("testdata", "targeting", "root")),
+				policies.WithUserGroups(mockUserGroups),
+				// Policies history is timestamped and covered by TestHistory.
+				policies.WithHistorySize(0),
 			)
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
@@ -139,7 +169,7 @@ func TestApplyPolicies(t *testing.T) {
 			orig := logrus.StandardLogger().Out
 			logrus.StandardLogger().SetOutput(w)
 
-			err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			_, err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
 
 			logrus.StandardLogger().SetOutput(orig)
 			w.Close()
@@ -149,7 +179,7 @@ func TestApplyPolicies(t *testing.T) {
 			require.NoError(t, errCopy, "Setup: Couldn't copy logs to buffer")
 
 			if tc.isNotSubscribed {
-				want := fmt.Sprintf("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s", strings.Join(policies.ProOnlyRules, ", "))
+				want := fmt.Sprintf("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s", strings.Join(m.ProOnlyRules(), ", "))
 				require.Contains(t, out.String(), want, "ApplyPolicy should have logged the filtered rules")
 			}
 
@@ -179,10 +209,47 @@ func TestApplyPolicies(t *testing.T) {
 				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Setup: can not set subscription status for second call to disabled")
 			}
 			if runSecondCall {
-				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				pols.Transient = tc.secondCallTransient
+				cachePath := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "hostname")
+				cacheBefore := treeContent(t, cachePath)
+
+				_, err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
 				require.NoError(t, err, "ApplyPolicy should return no error but got one")
+
+				if tc.secondCallTransient {
+					require.Equal(t, cacheBefore, treeContent(t, cachePath), "ApplyPolicy should not update the cache with transient policies")
+				}
+			}
+
+			if tc.secondCallWithFailingPolicies != "" {
+				failingPols, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", tc.secondCallWithFailingPolicies))
+				require.NoError(t, err, "Setup: can not load failing policies list")
+				defer failingPols.Close()
+
+				before := treeContent(t, fakeRootDir)
+				mountUnits, err := filepath.Glob(filepath.Join(systemUnitDir, "adsys-*.mount"))
+				require.NoError(t, err, "Setup: can't list mount units")
+				require.NotEmpty(t, mountUnits, "Setup: mount units should have been created")
+				systemdCaller.reset()
+
+				_, err = m.ApplyPolicies(context.Background(), "hostname", true, &failingPols)
+				require.Error(t, err, "ApplyPolicy should return an error but got none")
+				require.Equal(t, before, treeContent(t, fakeRootDir), "ApplyPolicy should have restored the previous state")
+				require.Len(t, proxyApplier.calls, 2, "ApplyPolicy should have applied the previous proxy settings again")
+				require.Equal(t, proxyApplier.calls[0], proxyApplier.calls[1], "ApplyPolicy should have applied the previous proxy settings again")
+
+				calls := systemdCaller.recorded()
+				for _, u := range mountUnits {
+					u = filepath.Base(u)
+					stopped := slices.Index(calls, "stop "+u)
+					require.NotEqual(t, -1, stopped, "ApplyPolicy should have stopped mount unit %q no longer set", u)
+					require.Contains(t, calls[stopped:], "enable "+u, "ApplyPolicy should have enabled the previous mount unit %q again", u)
+					require.Contains(t, calls[stopped:], "start "+u, "ApplyPolicy should have started the previous mount unit %q again", u)
+				}
+				require.NotContains(t, calls, "start "+consts.AdysMachineScriptsServiceName, "ApplyPolicy should not run the machine scripts again")
 			}
 
+			makeIndependentOfFakeRoot(t, systemUnitDir, fakeRootDir)
 			testutils.CompareTreesWithFiltering(t, fakeRootDir, testutils.GoldenPath(t), testutils.UpdateEnabled())
 		})
 	}
@@ -203,6 +270,7 @@ func TestDumpPolicies(t *testing.T) {
 		computerOnly       bool
 		withRules          bool
 		withOverridden     bool
+		format             string
 
 		wantErr bool
 	}{
@@ -260,6 +328,73 @@ func TestDumpPolicies(t *testing.T) {
 			withOverridden:     true,
 		},
 
+		// Machine readable formats
+		"JSON format lists GPOs": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo_other",
+			format:             "json",
+		},
+		"JSON format lists rules with their GPO, override hidden": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			withRules:          true,
+			format:             "json",
+		},
+		"JSON format lists rules with their GPO, override shown": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			withRules:          true,
+			withOverridden:     true,
+			format:             "json",
+		},
+		"JSON format for machine only": {
+			cachePolicyMachine: "one_gpo",
+			target:             hostname,
+			computerOnly:       true,
+			withRules:          true,
+			format:             "json",
+		},
+		"YAML format lists rules with their GPO": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			withRules:          true,
+			withOverridden:     true,
+			format:             "yaml",
+		},
+		"Text format is the default one": {
+			cachePoliciesUser: "one_gpo",
+			withRules:         true,
+			format:            "text",
+		},
+
+		// Targeting
+		"Rules filtered by targeting are shown": {
+			cachePoliciesUser: "targeted",
+			withRules:         true,
+		},
+		"Rules filtered by targeting are shown with overrides": {
+			cachePoliciesUser:  "targeted",
+			cachePolicyMachine: "targeted",
+			withRules:          true,
+			withOverridden:     true,
+		},
+		"JSON format lists rules filtered by targeting": {
+			cachePoliciesUser: "targeted",
+			withRules:         true,
+			format:            "json",
+		},
+
+		// Preferences
+		"Rules from preferences are shown with their source": {
+			cachePoliciesUser: "preferences",
+			withRules:         true,
+		},
+		"JSON format lists rules with their source": {
+			cachePoliciesUser: "preferences",
+			withRules:         true,
+			format:            "json",
+		},
+
 		// Edge cases
 		"Same GPO Machine and User": {
 			cachePoliciesUser:  "one_gpo",
@@ -286,6 +421,11 @@ func TestDumpPolicies(t *testing.T) {
 			cachePolicyMachine: "-",
 			wantErr:            true,
 		},
+		"Error on unknown format": {
+			cachePoliciesUser: "one_gpo",
+			format:            "xml",
+			wantErr:           true,
+		},
 	}
 
 	for name, tc := range tests {
@@ -293,7 +433,8 @@ func TestDumpPolicies(t *testing.T) {
 			t.Parallel()
 
 			cacheDir, runDir := t.TempDir(), t.TempDir()
-			m, err := policies.NewManager(bus, hostname, mockBackend{}, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			m, err := policies.NewManager(bus, hostname, mockBackend{}, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir),
+				policies.WithSystemRoot(filepath.Join("testdata", "targeting", "root")), policies.WithUserGroups(mockUserGroups))
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
 			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
@@ -318,7 +459,7 @@ func TestDumpPolicies(t *testing.T) {
 			if tc.target == "" {
 				tc.target = "user"
 			}
-			got, err := m.DumpPolicies(context.Background(), tc.target, tc.computerOnly, tc.withRules, tc.withOverridden)
+			got, err := m.DumpPolicies(context.Background(), tc.target, tc.computerOnly, tc.withRules, tc.withOverridden, tc.format)
 			if tc.wantErr {
 				require.Error(t, err, "DumpPolicies should return an error but got none")
 				return
@@ -388,6 +529,58 @@ func TestLastUpdateFor(t *testing.T) {
 	}
 }
 
+func TestLastOnlineRefreshFor(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	onlineRefresh := time.Date(2024, time.May, 25, 14, 55, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		target    string
+		isMachine bool
+
+		wantErr bool
+	}{
+		"Returns user's last online refresh time":    {target: "user"},
+		"Returns machine's last online refresh time": {target: hostname, isMachine: true},
+		"Target is ignored for machine request":      {target: "does_not_exit", isMachine: true},
+
+		// Error cases
+		"Error when target does not exist":          {target: "does_not_exit", wantErr: true},
+		"Error when online refresh time is unknown": {target: "user-without-online-refresh", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, mockBackend{}, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for _, n := range []string{"user", hostname} {
+				pols := policies.Policies{OnlineRefresh: onlineRefresh, Server: "dc1.example.com"}
+				require.NoError(t, pols.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, n)), "Setup: couldn’t save policies cache")
+			}
+			pols := policies.Policies{}
+			require.NoError(t, pols.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user-without-online-refresh")), "Setup: couldn’t save policies cache")
+
+			got, gotServer, err := m.LastOnlineRefreshFor(context.Background(), tc.target, tc.isMachine)
+			if tc.wantErr {
+				require.Error(t, err, "LastOnlineRefreshFor should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "LastOnlineRefreshFor should return no error but got one")
+			require.True(t, onlineRefresh.Equal(got), "LastOnlineRefreshFor should return the online refresh time of the cache")
+			require.Equal(t, "dc1.example.com", gotServer, "LastOnlineRefreshFor should return the domain controller of the cache")
+		})
+	}
+}
+
 func TestGetSubscriptionState(t *testing.T) {
 	//t.Parallel()
 
@@ -427,14 +620,139 @@ func TestGetSubscriptionState(t *testing.T) {
 	}
 }
 
+func TestWithFilesAllowedPrefixes(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		prefixes []string
+
+		wantErr bool
+	}{
+		"Absolute prefixes":                  {prefixes: []string{"/etc/app", "/opt"}},
+		"No prefixes keeps the default ones": {},
+
+		"Error on relative prefix": {prefixes: []string{"/opt", "etc/app"}, wantErr: true},
+		"Error on unclean prefix":  {prefixes: []string{"/opt/../etc"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newManagerInFakeRoot(t, bus, policies.WithFilesAllowedPrefixes(tc.prefixes))
+			if tc.wantErr {
+				require.Error(t, err, "NewManager should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "NewManager should return no error but got one")
+		})
+	}
+}
+
+func TestSnapshotPathsOfUsers(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		objectName string
+
+		wantScripts []string
+		wantMounts  []string
+	}{
+		"Paths are in the run directory of the user": {
+			objectName:  "bob@example.com",
+			wantScripts: []string{filepath.Join("users", "4242", "scripts")},
+			wantMounts:  []string{filepath.Join("users", "4242", "mounts")},
+		},
+
+		"No paths for an unknown user": {objectName: "unknown@example.com"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			userLookup := func(name string) (*user.User, error) {
+				if name != "bob@example.com" {
+					return nil, user.UnknownUserError(name)
+				}
+				return &user.User{Username: name, Uid: "4242", Gid: "4242", HomeDir: "/home/bob"}, nil
+			}
+			m, err := newManagerInFakeRoot(t, bus, policies.WithUserLookup(userLookup))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			paths := m.SnapshotPaths(tc.objectName, false)
+			requireRelPaths(t, tc.wantScripts, paths["scripts"], "SnapshotPaths returned unexpected scripts paths")
+			requireRelPaths(t, tc.wantMounts, paths["mount"], "SnapshotPaths returned unexpected mount paths")
+		})
+	}
+}
+
+// requireRelPaths checks that got are the paths of want, relative to the run directory.
+func requireRelPaths(t *testing.T, want, got []string, msg string) {
+	t.Helper()
+
+	require.Len(t, got, len(want), msg)
+	for i, p := range want {
+		require.True(t, strings.HasSuffix(got[i], filepath.Join("run", "adsys", p)), "%s: got %q", msg, got[i])
+	}
+}
+
+// recordingSystemdCaller records the systemd calls on units, as "<action> <unit>".
+type recordingSystemdCaller struct {
+	testutils.MockSystemdCaller
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *recordingSystemdCaller) record(action, unit string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, action+" "+unit)
+	return nil
+}
+
+func (s *recordingSystemdCaller) StartUnit(_ context.Context, unit string) error {
+	return s.record("start", unit)
+}
+func (s *recordingSystemdCaller) StopUnit(_ context.Context, unit string) error {
+	return s.record("stop", unit)
+}
+func (s *recordingSystemdCaller) EnableUnit(_ context.Context, unit string) error {
+	return s.record("enable", unit)
+}
+func (s *recordingSystemdCaller) DisableUnit(_ context.Context, unit string) error {
+	return s.record("disable", unit)
+}
+
+// recorded returns the calls recorded since the last reset.
+func (s *recordingSystemdCaller) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// reset forgets the recorded calls.
+func (s *recordingSystemdCaller) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
 // mockProxyApplier is a mock for the proxy apply object.
 type mockProxyApplier struct {
 	wantApplyError bool
+
+	// calls are the arguments of each proxy apply call.
+	calls [][]interface{}
 }
 
 // Call mocks the proxy apply call.
-func (d *mockProxyApplier) Call(_ string, _ dbus.Flags, _ ...interface{}) *dbus.Call {
+func (d *mockProxyApplier) Call(_ string, _ dbus.Flags, args ...interface{}) *dbus.Call {
 	var errApply error
+	d.calls = append(d.calls, args)
 
 	if d.wantApplyError {
 		errApply = errors.New("proxy apply error")
@@ -459,3 +777,22 @@ func (m mockBackend) IsOnline() (bool, error) {
 	return true, nil
 }
 func (m mockBackend) Config() string { return "mock config" }
+
+// mockUserGroups returns the same groups for every user and computer, none of them being an admin one.
+func mockUserGroups(string) ([]string, error) {
+	return []string{"Developers", "domain users@example.com"}, nil
+}
+
+// makeIndependentOfFakeRoot rewrites the scheduled tasks units of unitDir to run the scripts from the root of the system.
+func makeIndependentOfFakeRoot(t *testing.T, unitDir, fakeRootDir string) {
+	t.Helper()
+
+	units, err := filepath.Glob(filepath.Join(unitDir, "adsys-task-*.service"))
+	require.NoError(t, err, "Setup: can't list scheduled tasks units")
+	for _, p := range units {
+		content, err := os.ReadFile(p)
+		require.NoError(t, err, "Setup: can't read scheduled task unit")
+		err = os.WriteFile(p, []byte(strings.ReplaceAll(string(content), fakeRootDir, "")), 0600)
+		require.NoError(t, err, "Setup: can't make scheduled task unit independent of the fake root directory")
+	}
+}
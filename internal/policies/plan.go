@@ -0,0 +1,472 @@
+package policies
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leonelquinteros/gotext"
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/policies/scheduledtasks"
+	"github.com/ubuntu/decorate"
+)
+
+// ManagerPlan is the list of changes that a policy manager would do on the system when applying policies.
+type ManagerPlan struct {
+	Name string
+	// Diff is an unified diff of the files the manager would write or delete.
+	Diff string
+	// Created, Modified and Removed are the files the manager would create, modify or remove, sorted by path.
+	Created, Modified, Removed []string
+	// Units are the systemd unit operations the manager would request.
+	Units []string
+	// Commands are the external commands the manager would run.
+	Commands []string
+}
+
+// PlanPolicies returns what ApplyPolicies would change on the system for objectName with pols, without modifying it.
+//
+// Each policy manager is applied on a scratch copy of the directories it is managing, with systemd, D-Bus
+// and external commands recorded instead of being called. Managers are applied one after the other, in the same
+// order as ApplyPolicies, so that every change can be attributed to the manager responsible for it.
+// The policies cache is not updated.
+func (m *Manager) PlanPolicies(ctx context.Context, objectName string, isComputer bool, pols *Policies) (plans []ManagerPlan, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to plan policy for %q", objectName))
+
+	// Prevent planning while policies are being applied for the same object, as we read the current state from disk.
+	m.muMu.Lock()
+	if _, ok := m.objectMu[objectName]; !ok {
+		m.objectMu[objectName] = &sync.Mutex{}
+	}
+	m.objectMu[objectName].Lock()
+	defer m.objectMu[objectName].Unlock()
+	m.muMu.Unlock()
+
+	rules, _, err := m.prepareRules(ctx, objectName, isComputer, pols)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := os.MkdirTemp("", "adsys-plan-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(scratch); err != nil {
+			log.Warning(ctx, gotext.Get("Could not remove plan directory %q: %v", scratch, err))
+		}
+	}()
+
+	managedPaths := m.opts.managedPaths()
+	if !isComputer {
+		// Only the files of the planned user are copied from its home directory, which is created in the plan
+		// directory for the files to be written in.
+		if home := m.opts.userHome(objectName); home != "" {
+			if _, err := os.Stat(home); err == nil {
+				if err := os.MkdirAll(filepath.Join(scratch, home), 0700); err != nil {
+					return nil, err
+				}
+			}
+			managedPaths = append(managedPaths, environment.UserFile(home))
+		}
+	}
+	// The files manager can write anywhere in its allowed directories, so only its targets are copied.
+	managedPaths = append(managedPaths, m.opts.filesManager().Paths(objectName, isComputer, rules["files"])...)
+	for _, p := range append(slices.Clone(managedPaths), m.opts.readPaths()...) {
+		if err := copyUnder(p, scratch); err != nil {
+			return nil, err
+		}
+	}
+	// The certificate manager only checks if the machine was enrolled: the Samba state, with its keys, is not copied.
+	if _, err := os.Stat(filepath.Join(m.opts.stateDir, "samba")); err == nil {
+		if err := os.MkdirAll(filepath.Join(scratch, m.opts.stateDir, "samba"), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	recorder := &planRecorder{commandsLog: filepath.Join(scratch, "commands")}
+	planArgs := m.opts.rootedAt(scratch)
+	planArgs.systemdCaller = recorder
+	planArgs.proxyApplier = recorder
+	planArgs.dconfCmd = recorder.cmd("dconf")
+	planArgs.apparmorParserCmd = recorder.cmd("apparmor_parser")
+	planArgs.certAutoenrollCmd = recorder.cmd("cert-autoenroll")
+	planArgs.gpasswdCmd = recorder.cmd("gpasswd")
+	// The gdm manager needs to use the dconf manager redirected to the scratch directory.
+	planArgs.gdm = nil
+	// Additional policy managers and plugins can't be redirected to the scratch directory.
+	planArgs.policyManagers = nil
+	planArgs.pluginsDir = ""
+	for _, pm := range m.policyManagers {
+		if _, ok := pm.(builtinManager); ok {
+			continue
+		}
+		log.Warning(ctx, gotext.Get("Changes from policy manager %q can't be planned and are not shown", pm.Type()))
+	}
+
+	ms, err := newManagers(m.bus, m.backend, planArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := readTree(managedPaths, scratch)
+	if err != nil {
+		return nil, err
+	}
+	waves, err := orderSteps(policySteps(ms, objectName, isComputer, rules, pols))
+	if err != nil {
+		return nil, err
+	}
+	// Steps are applied one after the other to attribute the changes to each of them.
+	for _, s := range slices.Concat(waves...) {
+		log.Debugf(ctx, "Planning %s policy for %s", s.name, objectName)
+		if err := s.apply(ctx); err != nil {
+			return nil, err
+		}
+
+		after, err := readTree(managedPaths, scratch)
+		if err != nil {
+			return nil, err
+		}
+		units, commands, err := recorder.take()
+		if err != nil {
+			return nil, err
+		}
+
+		// Show paths from the system point of view.
+		for i := range commands {
+			commands[i] = strings.ReplaceAll(commands[i], scratch, "")
+		}
+
+		created, modified, removed := changedPaths(before, after)
+		plans = append(plans, ManagerPlan{
+			Name:     s.name,
+			Diff:     unifiedDiff(before, after),
+			Created:  created,
+			Modified: modified,
+			Removed:  removed,
+			Units:    units,
+			Commands: commands,
+		})
+		before = after
+	}
+
+	return plans, nil
+}
+
+// FormatPlans returns a human readable version of plans.
+func FormatPlans(plans []ManagerPlan) string {
+	var out strings.Builder
+	for _, p := range plans {
+		fmt.Fprintf(&out, "* %s\n", p.Name)
+		if p.Diff == "" && len(p.Units) == 0 && len(p.Commands) == 0 {
+			fmt.Fprintln(&out, gotext.Get("No changes."))
+			continue
+		}
+		out.WriteString(p.Diff)
+		if len(p.Units) > 0 {
+			fmt.Fprintln(&out, gotext.Get("Units:"))
+			for _, u := range p.Units {
+				fmt.Fprintf(&out, "  %s\n", u)
+			}
+		}
+		if len(p.Commands) > 0 {
+			fmt.Fprintln(&out, gotext.Get("Commands:"))
+			for _, c := range p.Commands {
+				fmt.Fprintf(&out, "  %s\n", c)
+			}
+		}
+	}
+
+	return out.String()
+}
+
+// rootedAt returns a copy of the options where all directories policy managers are reading from or writing to are
+// moved under root.
+func (o options) rootedAt(root string) options {
+	o.cacheDir = filepath.Join(root, o.cacheDir)
+	o.stateDir = filepath.Join(root, o.stateDir)
+	o.shareDir = filepath.Join(root, o.shareDir)
+	o.globalTrustDir = filepath.Join(root, o.globalTrustDir)
+	o.policyKitSystemDir = filepath.Join(root, o.policyKitSystemDir)
+	o.apparmorFsDir = filepath.Join(root, o.apparmorFsDir)
+	o.systemRoot = filepath.Join(root, o.systemRoot)
+	o.pluginsDir = filepath.Join(root, o.pluginsDir)
+	o.dconfDir = filepath.Join(root, o.dconfDir)
+	o.sudoersDir = filepath.Join(root, o.sudoersDir)
+	o.policyKitDir = filepath.Join(root, o.policyKitDir)
+	o.runDir = filepath.Join(root, o.runDir)
+	o.apparmorDir = filepath.Join(root, o.apparmorDir)
+	o.systemUnitDir = filepath.Join(root, o.systemUnitDir)
+	o.userUnitDir = filepath.Join(root, o.userUnitDir)
+	o.runtimeSystemUnitDir = filepath.Join(root, o.runtimeSystemUnitDir)
+	o.runtimeUserUnitDir = filepath.Join(root, o.runtimeUserUnitDir)
+	o.localGroupsStateDir = filepath.Join(root, o.localGroupsStateDir)
+	o.scheduledTasksStateDir = filepath.Join(root, o.scheduledTasksStateDir)
+	o.environmentDir = filepath.Join(root, o.environmentDir)
+	o.homeRoot = filepath.Join(root, o.homeRoot)
+	o.filesStateDir = filepath.Join(root, o.filesStateDir)
+	o.filesRoot = filepath.Join(root, o.filesRoot)
+	return o
+}
+
+// managedPaths returns the list of paths, which can contain glob patterns, that policy managers can change.
+func (o options) managedPaths() []string {
+	return []string{
+		o.dconfDir,
+		o.sudoersDir,
+		o.policyKitDir,
+		filepath.Join(o.runDir, "machine"),
+		filepath.Join(o.runDir, "users"),
+		o.apparmorDir,
+		filepath.Join(o.systemUnitDir, "adsys-*.mount"),
+		o.localGroupsStateDir,
+		filepath.Join(o.environmentDir, environment.FileName),
+		filepath.Join(o.systemUnitDir, scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.systemUnitDir, "timers.target.wants", scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.userUnitDir, scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.userUnitDir, "timers.target.wants", scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.runtimeSystemUnitDir, scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.runtimeUserUnitDir, scheduledtasks.UnitsPrefix("")+"*"),
+		filepath.Join(o.runtimeUserUnitDir, "timers.target.wants", scheduledtasks.UnitsPrefix("")+"*"),
+		o.scheduledTasksStateDir,
+		o.filesStateDir,
+	}
+}
+
+// readPaths returns the list of paths, which can contain glob patterns, that policy managers only read the system
+// state from.
+func (o options) readPaths() []string {
+	return []string{
+		filepath.Join(o.policyKitSystemDir, "rules.d"),
+		filepath.Join(o.apparmorFsDir, "profiles"),
+	}
+}
+
+// readTree returns the content of every regular file matching patterns under the scratch directory,
+// indexed by their path on the system. An empty scratch directory reads the files from the system itself.
+func readTree(patterns []string, scratch string) (tree map[string][]byte, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't read plan directory"))
+
+	tree = make(map[string][]byte)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(scratch, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			err := filepath.WalkDir(m, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+				// #nosec G122 -- This is a path controlled by us
+				content, err := os.ReadFile(p)
+				if err != nil {
+					return err
+				}
+				if scratch != "" {
+					// Files written in the plan directory reference it instead of the root of the system.
+					content = bytes.ReplaceAll(content, []byte(scratch), nil)
+				}
+				tree[strings.TrimPrefix(p, scratch)] = content
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tree, nil
+}
+
+// changedPaths returns the sorted lists of files created, modified and removed between before and after.
+func changedPaths(before, after map[string][]byte) (created, modified, removed []string) {
+	for p, cur := range after {
+		old, ok := before[p]
+		if !ok {
+			created = append(created, p)
+		} else if !bytes.Equal(old, cur) {
+			modified = append(modified, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	slices.Sort(created)
+	slices.Sort(modified)
+	slices.Sort(removed)
+
+	return created, modified, removed
+}
+
+// unifiedDiff returns an unified diff of all files that changed between before and after.
+func unifiedDiff(before, after map[string][]byte) string {
+	var paths []string
+	for p := range before {
+		paths = append(paths, p)
+	}
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+
+	var out strings.Builder
+	for _, p := range paths {
+		old, oldExists := before[p]
+		cur, curExists := after[p]
+		if oldExists && curExists && bytes.Equal(old, cur) {
+			continue
+		}
+
+		from, to := p, p
+		if !oldExists {
+			from = "/dev/null"
+		}
+		if !curExists {
+			to = "/dev/null"
+		}
+
+		if bytes.IndexByte(old, 0) != -1 || bytes.IndexByte(cur, 0) != -1 {
+			fmt.Fprintf(&out, "Binary files %s and %s differ\n", from, to)
+			continue
+		}
+
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(string(old)),
+			B:        splitLines(string(cur)),
+			FromFile: from,
+			ToFile:   to,
+			Context:  3,
+		})
+		if err != nil {
+			fmt.Fprintf(&out, "Files %s and %s differ\n", from, to)
+			continue
+		}
+		out.WriteString(diff)
+	}
+
+	return out.String()
+}
+
+// splitLines splits s into lines, all ending with a newline, as expected by difflib.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// planRecorder records the calls policy managers would do to systemd, D-Bus services and external commands.
+type planRecorder struct {
+	mu sync.Mutex
+
+	units []string
+	calls []string
+	// commandsLog is the file where recorded external commands are logged.
+	commandsLog string
+}
+
+// cmd returns a command line which logs the command name and its arguments instead of running it.
+func (r *planRecorder) cmd(name string) []string {
+	return []string{"/bin/sh", "-c", `printf '%s\n' "$*" >> "$0"`, r.commandsLog, name}
+}
+
+// take returns and resets what has been recorded so far.
+func (r *planRecorder) take() (units, commands []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	units, commands = r.units, r.calls
+	r.units, r.calls = nil, nil
+
+	// Managers can request units in any order: sort them by unit name for a stable plan,
+	// keeping the order of the requests for the same unit.
+	slices.SortStableFunc(units, func(a, b string) int {
+		_, unitA, _ := strings.Cut(a, " ")
+		_, unitB, _ := strings.Cut(b, " ")
+		return strings.Compare(unitA, unitB)
+	})
+
+	content, err := os.ReadFile(r.commandsLog)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	for _, l := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if l == "" {
+			continue
+		}
+		commands = append(commands, l)
+	}
+	if err := os.Remove(r.commandsLog); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	return units, commands, nil
+}
+
+func (r *planRecorder) record(unit string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.units = append(r.units, unit)
+	return nil
+}
+
+// StartUnit records a start request of unit.
+func (r *planRecorder) StartUnit(_ context.Context, unit string) error {
+	return r.record("start " + unit)
+}
+
+// StopUnit records a stop request of unit.
+func (r *planRecorder) StopUnit(_ context.Context, unit string) error {
+	return r.record("stop " + unit)
+}
+
+// EnableUnit records an enable request of unit.
+func (r *planRecorder) EnableUnit(_ context.Context, unit string) error {
+	return r.record("enable " + unit)
+}
+
+// DisableUnit records a disable request of unit.
+func (r *planRecorder) DisableUnit(_ context.Context, unit string) error {
+	return r.record("disable " + unit)
+}
+
+// DaemonReload records a systemd daemon reload request.
+func (r *planRecorder) DaemonReload(_ context.Context) error { return r.record("daemon-reload") }
+
+// Call records a D-Bus method call.
+func (r *planRecorder) Call(method string, _ dbus.Flags, args ...interface{}) *dbus.Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var s []string
+	for _, a := range args {
+		s = append(s, fmt.Sprintf("%q", a))
+	}
+	r.calls = append(r.calls, strings.TrimSpace(fmt.Sprintf("%s %s", method, strings.Join(s, " "))))
+
+	return &dbus.Call{}
+}
@@ -0,0 +1,193 @@
+package policies_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestPlanPolicies(t *testing.T) {
+	//t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	bus := testutils.NewDbusConn(t)
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
+		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+
+	tests := map[string]struct {
+		policiesDir string
+		// appliedPoliciesDir is applied to the machine before planning.
+		appliedPoliciesDir string
+		isNotSubscribed    bool
+		// enrolled is whether the machine was enrolled to certificate autoenrollment.
+		enrolled bool
+
+		wantErr bool
+	}{
+		"Plan all entry types on machine":                            {policiesDir: "all_entry_types"},
+		"Plan only shows changes to already applied policies":        {policiesDir: "all_entry_types", appliedPoliciesDir: "dynamic_values"},
+		"Plan is empty when policies are already applied":            {policiesDir: "dynamic_values", appliedPoliciesDir: "dynamic_values"},
+		"Plan unloading already applied policies":                    {appliedPoliciesDir: "all_entry_types"},
+		"Plan filters Pro-only rules when machine is not subscribed": {policiesDir: "all_entry_types", isNotSubscribed: true},
+		"Plan unenrolling an enrolled machine":                       {enrolled: true},
+
+		"Error on unknown dynamic value": {policiesDir: "dynamic_values_unknown", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			runDir := filepath.Join(fakeRootDir, "run", "adsys")
+			dconfDir := filepath.Join(fakeRootDir, "etc", "dconf")
+			policyKitDir := filepath.Join(fakeRootDir, "etc", "polkit-1")
+			policyKitReservedDir := filepath.Join(fakeRootDir, "usr", "share", "polkit-1")
+			sudoersDir := filepath.Join(fakeRootDir, "etc", "sudoers.d")
+			apparmorDir := filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")
+			systemUnitDir := filepath.Join(fakeRootDir, "etc", "systemd", "system")
+			stateDir := filepath.Join(fakeRootDir, "var", "lib", "adsys")
+			shareDir := filepath.Join(fakeRootDir, "usr", "share", "adsys")
+			loadedPoliciesFile := filepath.Join(fakeRootDir, "sys", "kernel", "security", "apparmor", "profiles")
+
+			err = os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile dir")
+			err = os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile")
+
+			if tc.enrolled {
+				err = os.MkdirAll(filepath.Join(stateDir, "samba", "private"), 0700)
+				require.NoError(t, err, "Setup: can not create samba state dir")
+				err = os.WriteFile(filepath.Join(stateDir, "samba", "private", "secrets.tdb"), []byte("secret"), 0600)
+				require.NoError(t, err, "Setup: can not create samba secrets")
+			}
+
+			status := !tc.isNotSubscribed
+			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", status), "Setup: can not set subscription status to %q", status)
+			defer func() {
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
+			}()
+
+			m, err := policies.NewManager(bus,
+				hostname,
+				mockBackend{},
+				policies.WithCacheDir(cacheDir),
+				policies.WithStateDir(stateDir),
+				policies.WithRunDir(runDir),
+				policies.WithShareDir(shareDir),
+				policies.WithDconfDir(dconfDir),
+				policies.WithPolicyKitDir(policyKitDir),
+				policies.WithPolicyKitSystemDir(policyKitReservedDir),
+				policies.WithSudoersDir(sudoersDir),
+				policies.WithApparmorDir(apparmorDir),
+				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+				policies.WithApparmorParserCmd([]string{"/bin/true"}),
+				policies.WithCertAutoenrollCmd([]string{"/bin/true"}),
+				policies.WithGroupFile(filepath.Join("testdata", "localgroups", "group")),
+				policies.WithGpasswdCmd([]string{"/bin/true"}),
+				policies.WithSystemUnitDir(systemUnitDir),
+				policies.WithEnvironmentDir(filepath.Join(fakeRootDir, "etc", "environment.d")),
+				policies.WithUserUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "user")),
+				policies.WithRuntimeSystemUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "system")),
+				policies.WithRuntimeUserUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "user")),
+				policies.WithFilesRoot(fakeRootDir),
+				policies.WithProxyApplier(&mockProxyApplier{}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if tc.appliedPoliciesDir != "" {
+				applied, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", tc.appliedPoliciesDir))
+				require.NoError(t, err, "Setup: can not load applied policies list")
+				defer applied.Close()
+				// Applied policies are always for the machine, as it is required before applying any user policy.
+				_, err = m.ApplyPolicies(context.Background(), "hostname", true, &applied)
+				require.NoError(t, err, "Setup: can not apply policies before planning")
+			}
+
+			pols, err := policies.New(context.Background(), nil, "")
+			require.NoError(t, err, "Setup: can not create empty policies")
+			if tc.policiesDir != "" {
+				pols, err = policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", tc.policiesDir))
+				require.NoError(t, err, "Setup: can not load policies list")
+				defer pols.Close()
+			}
+
+			before := treeContent(t, fakeRootDir)
+
+			plans, err := m.PlanPolicies(context.Background(), "hostname", true, &pols)
+			if tc.wantErr {
+				require.Error(t, err, "PlanPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "PlanPolicies should return no error but got one")
+
+			// Planning should never change the system.
+			require.Equal(t, before, treeContent(t, fakeRootDir), "PlanPolicies should not change the system")
+
+			got := strings.ReplaceAll(policies.FormatPlans(plans), fakeRootDir, "/FAKEROOT")
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "PlanPolicies returned an unexpected plan")
+		})
+	}
+}
+
+func TestPlanPoliciesRedirectsEveryDirectory(t *testing.T) {
+	t.Parallel()
+
+	fakeRootDir := t.TempDir()
+	m, err := policies.NewManager(testutils.NewDbusConn(t),
+		"hostname",
+		mockBackend{},
+		policies.WithCacheDir(filepath.Join(fakeRootDir, "var", "cache", "adsys")),
+		policies.WithStateDir(filepath.Join(fakeRootDir, "var", "lib", "adsys")),
+		policies.WithRunDir(filepath.Join(fakeRootDir, "run", "adsys")),
+		policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+	)
+	require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+	planDir := t.TempDir()
+	for name, dir := range m.PlanDirs(planDir) {
+		rel, err := filepath.Rel(planDir, dir)
+		require.NoError(t, err, "Setup: can not get relative path of %s", name)
+		require.False(t, strings.HasPrefix(rel, ".."), "%s should be in the plan directory, got %q", name, dir)
+	}
+}
+
+// treeContent returns the content of all files under dir, indexed by their path.
+func treeContent(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	r := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			r[p] = ""
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		r[p] = string(content)
+		return nil
+	})
+	require.NoError(t, err, "Setup: can not read directory content")
+
+	return r
+}
@@ -0,0 +1,84 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/plugins"
+)
+
+// pluginManager adapts a policy manager plugin to the PolicyManager interface.
+type pluginManager struct {
+	*plugins.Manager
+}
+
+// ApplyPolicy copies the plugin assets, stored in the directory named after the rule type, and runs the plugin.
+func (p pluginManager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) (err error) {
+	var assetsPath string
+	if len(entries) > 0 && assetsDumper != nil {
+		dir, err := os.MkdirTemp("", "adsys-plugin-assets-*")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Warning(ctx, gotext.Get("Could not remove plugin assets directory %q: %v", dir, err))
+			}
+		}()
+
+		assetsPath = filepath.Join(dir, p.Type())
+		if err := assetsDumper(ctx, p.Type()+"/", assetsPath, -1, -1); err != nil {
+			// GPOs don't have any asset for this plugin.
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			assetsPath = ""
+		}
+	}
+
+	return p.Manager.ApplyPolicy(ctx, objectName, isComputer, entries, assetsPath)
+}
+
+// ProOnly returns false as plugins are not part of Ubuntu Pro.
+func (p pluginManager) ProOnly() bool { return false }
+
+// Scope returns ScopeAll as plugins decide themselves what to do for the machine and users.
+func (p pluginManager) Scope() Scope { return ScopeAll }
+
+// After returns no dependencies: plugins are applied in parallel of the other policy managers.
+func (p pluginManager) After() []string { return nil }
+
+// NeedsAssets returns true as plugins can have assets attached to their GPOs.
+func (p pluginManager) NeedsAssets() bool { return true }
+
+// newPluginManagers returns policy managers for all plugins in pluginsDir.
+// Plugins for types of rules that registered policy managers already handle are ignored.
+func newPluginManagers(pluginsDir string, timeout time.Duration, owner int, registered []PolicyManager) (pms []PolicyManager, err error) {
+	if pluginsDir == "" {
+		return nil, nil
+	}
+
+	paths, err := plugins.List(pluginsDir, plugins.WithOwner(owner))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		pm := pluginManager{plugins.New(p, plugins.WithTimeout(timeout))}
+		if slices.ContainsFunc(registered, func(r PolicyManager) bool { return r.Type() == pm.Type() }) {
+			log.Warning(context.Background(), gotext.Get("Ignoring plugin %q: %s rules are already handled by adsys", p, pm.Type()))
+			continue
+		}
+		pms = append(pms, pm)
+	}
+
+	return pms, nil
+}
@@ -0,0 +1,247 @@
+// Package plugins runs policy managers shipped outside of adsys.
+//
+// A plugin is an executable dropped in the plugins directory (/usr/lib/adsys/managers by default), named after the
+// type of rules it handles. It is executed every time policies are applied to an object, even without any entries,
+// so that it can revert what it previously set up.
+// As plugins are run as root, the plugins directory and the plugins must be owned by root and not writable by other
+// users. Symlinks are not followed.
+//
+// The plugin receives on stdin a JSON object with:
+//   - objectName: the name of the user or computer the policies are applied to;
+//   - isComputer: true if policies are applied to the computer;
+//   - assetsPath: the directory where the plugin assets from the GPOs were copied, if any;
+//   - entries: the list of entries of its rule type to apply, with key, value, disabled, meta and strategy fields.
+//
+// Following the policy manager guidelines, the exit code of the plugin decides on the outcome:
+//   - 0: all entries were applied;
+//   - 2 (ExitWarning): some entries failed to apply, but the user should only be warned;
+//   - any other code: applying the entries failed and authentication is prevented.
+//
+// The plugin output is forwarded to the daemon logs.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/decorate"
+)
+
+// ExitWarning is the exit code of a plugin which failed to apply some entries without preventing authentication.
+const ExitWarning = 2
+
+// Manager runs a policy manager plugin.
+type Manager struct {
+	ruleType string
+	path     string
+	timeout  time.Duration
+}
+
+type options struct {
+	timeout time.Duration
+	owner   int
+}
+
+// Option reprents an optional function to change the plugin manager.
+type Option func(*options)
+
+// WithTimeout sets the maximum time the plugin can run before being killed.
+// 0 means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithOwner sets the user who must own the plugins directory and the plugins listed, instead of root.
+func WithOwner(uid int) Option {
+	return func(o *options) {
+		o.owner = uid
+	}
+}
+
+// New returns a new manager running the plugin at path.
+// The type of rules handled by the plugin is its file name.
+func New(path string, opts ...Option) *Manager {
+	// defaults
+	args := options{}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		ruleType: filepath.Base(path),
+		path:     path,
+		timeout:  args.timeout,
+	}
+}
+
+// List returns the path of all plugins in dir, sorted by name.
+// There are no plugins if dir does not exist.
+// As plugins are run as root, dir must be owned by root and only writable by its owner. Plugins which are symlinks,
+// or which are not owned by root or writable by other users are ignored.
+func List(dir string, opts ...Option) (paths []string, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't list policy manager plugins in %q", dir))
+
+	// defaults
+	args := options{}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOwnership(info, args.owner); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		p := filepath.Join(dir, f.Name())
+		info, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			log.Warning(context.Background(), gotext.Get("Ignoring plugin %q: symlinks are not supported", p))
+			continue
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		if err := checkOwnership(info, args.owner); err != nil {
+			log.Warning(context.Background(), gotext.Get("Ignoring plugin %q: %v", p, err))
+			continue
+		}
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	return paths, nil
+}
+
+// checkOwnership returns an error if the file of info is not owned by owner, or can be written by other users.
+func checkOwnership(info fs.FileInfo, owner int) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New(gotext.Get("can't get owner of %q", info.Name()))
+	}
+	if int(st.Uid) != owner {
+		return errors.New(gotext.Get("%q is owned by %d instead of %d", info.Name(), st.Uid, owner))
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return errors.New(gotext.Get("%q is writable by other users than its owner", info.Name()))
+	}
+	return nil
+}
+
+// Type returns the type of rules handled by the plugin.
+func (m *Manager) Type() string {
+	return m.ruleType
+}
+
+// request is the JSON object sent to the plugin on stdin.
+type request struct {
+	ObjectName string         `json:"objectName"`
+	IsComputer bool           `json:"isComputer"`
+	AssetsPath string         `json:"assetsPath"`
+	Entries    []requestEntry `json:"entries"`
+}
+
+// requestEntry is the JSON representation of an entry.
+type requestEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled"`
+	Meta     string `json:"meta,omitempty"`
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// ApplyPolicy runs the plugin to apply entries to objectName.
+// assetsPath is the directory where the plugin assets were copied, empty if there are none.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsPath string) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't apply %s policy to %s", m.ruleType, objectName))
+
+	log.Debugf(ctx, "Applying %s policy to %s with plugin %q", m.ruleType, objectName, m.path)
+
+	r := request{
+		ObjectName: objectName,
+		IsComputer: isComputer,
+		AssetsPath: assetsPath,
+		Entries:    make([]requestEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		r.Entries = append(r.Entries, requestEntry{
+			Key:      e.Key,
+			Value:    e.Value,
+			Disabled: e.Disabled,
+			Meta:     e.Meta,
+			Strategy: e.Strategy,
+		})
+	}
+	input, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	cmdCtx := ctx
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	// #nosec G204 - plugins and their directory are owned and only writable by root, as checked by List
+	cmd := exec.CommandContext(cmdCtx, m.path)
+	cmd.Stdin = bytes.NewReader(input)
+	// Don't wait for processes started by the plugin and still holding its output once it is killed.
+	cmd.WaitDelay = time.Second
+
+	smbsafe.WaitExec()
+	output, err := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+
+	out := strings.TrimSpace(string(output))
+	if out != "" {
+		log.Debugf(ctx, "Output of %s policy plugin:\n%s", m.ruleType, out)
+	}
+
+	if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+		return errors.New(gotext.Get("plugin %q did not finish within %s", m.path, m.timeout))
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == ExitWarning {
+			log.Warning(ctx, gotext.Get("Some %s policies failed to apply to %s:\n%s", m.ruleType, objectName, out))
+			return nil
+		}
+		return errors.New(gotext.Get("plugin %q failed: %v\n%s", m.path, err, out))
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package plugins_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/plugins"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	// Plugins are written by the test before executing them, which can fail with "text file busy" if
+	// other tests are forking in parallel.
+	//t.Parallel()
+
+	defaultEntries := []entry.Entry{
+		{Key: "server", Value: "license.example.com"},
+		{Key: "port", Value: "1234", Meta: "int"},
+		{Key: "disabled", Disabled: true},
+		{Key: "list", Value: "a\nb", Strategy: entry.StrategyAppend},
+	}
+
+	tests := map[string]struct {
+		entries    []entry.Entry
+		isUser     bool
+		assetsPath string
+		exitCode   int
+		sleep      bool
+
+		wantErr bool
+	}{
+		"Plugin receives entries for computer": {},
+		"Plugin receives entries for user":     {isUser: true},
+		"Plugin receives assets path":          {assetsPath: "/some/assets/path"},
+		"Plugin receives no entries":           {entries: []entry.Entry{}},
+		"Plugin warning does not fail":         {exitCode: plugins.ExitWarning},
+
+		// Error cases
+		"Error on plugin failing":   {exitCode: 1, wantErr: true},
+		"Error on plugin timed out": {sleep: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//t.Parallel()
+
+			if tc.entries == nil {
+				tc.entries = defaultEntries
+			}
+
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input")
+			script := fmt.Sprintf("#!/bin/sh\ncat > %q\necho some output\n", input)
+			if tc.sleep {
+				script += "sleep 10\n"
+			}
+			script += fmt.Sprintf("exit %d\n", tc.exitCode)
+			plugin := filepath.Join(dir, "myplugin")
+			err := os.WriteFile(plugin, []byte(script), 0700)
+			require.NoError(t, err, "Setup: can't write plugin")
+
+			objectName := "hostname"
+			if tc.isUser {
+				objectName = "user@example.com"
+			}
+
+			m := plugins.New(plugin, plugins.WithTimeout(time.Second))
+			require.Equal(t, "myplugin", m.Type(), "Plugin type is its file name")
+
+			err = m.ApplyPolicy(context.Background(), objectName, !tc.isUser, tc.entries, tc.assetsPath)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy should return no error but got one")
+
+			got, err := os.ReadFile(input)
+			require.NoError(t, err, "Setup: plugin should have written its input")
+			want := testutils.LoadWithUpdateFromGolden(t, string(got))
+			require.Equal(t, want, string(got), "Plugin received unexpected input")
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		files        map[string]os.FileMode
+		dirs         []string
+		symlinks     map[string]string
+		dirMode      os.FileMode
+		chown        string
+		otherOwner   bool
+		noPluginsDir bool
+
+		want    []string
+		wantErr bool
+	}{
+		"Executables are listed in order":            {files: map[string]os.FileMode{"vpn": 0700, "license": 0755}, want: []string{"license", "vpn"}},
+		"Non executables are ignored":                {files: map[string]os.FileMode{"vpn": 0700, "README": 0644}, want: []string{"vpn"}},
+		"Directories are ignored":                    {files: map[string]os.FileMode{"vpn": 0700}, dirs: []string{"subdir"}, want: []string{"vpn"}},
+		"Symlinks are ignored":                       {files: map[string]os.FileMode{"vpn": 0700}, symlinks: map[string]string{"license": "vpn"}, want: []string{"vpn"}},
+		"Plugins not owned by the owner are ignored": {files: map[string]os.FileMode{"vpn": 0700, "license": 0700}, chown: "license", want: []string{"vpn"}},
+		"Plugins writable by the group are ignored":  {files: map[string]os.FileMode{"vpn": 0700, "license": 0770}, want: []string{"vpn"}},
+		"Plugins writable by others are ignored":     {files: map[string]os.FileMode{"vpn": 0700, "license": 0707}, want: []string{"vpn"}},
+		"No plugins in empty directory":              {},
+		"No plugins if directory is missing":         {noPluginsDir: true},
+
+		// Error cases
+		"Error on directory not owned by the owner":  {files: map[string]os.FileMode{"vpn": 0700}, otherOwner: true, wantErr: true},
+		"Error on directory writable by the group":   {files: map[string]os.FileMode{"vpn": 0700}, dirMode: 0770, wantErr: true},
+		"Error on directory writable by other users": {files: map[string]os.FileMode{"vpn": 0700}, dirMode: 0757, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := filepath.Join(t.TempDir(), "managers")
+			if !tc.noPluginsDir {
+				require.NoError(t, os.MkdirAll(dir, 0700), "Setup: can't create plugins directory")
+			}
+			for f, mode := range tc.files {
+				err := os.WriteFile(filepath.Join(dir, f), []byte("#!/bin/sh\n"), mode)
+				require.NoError(t, err, "Setup: can't write plugin")
+				// Ignore the umask.
+				require.NoError(t, os.Chmod(filepath.Join(dir, f), mode), "Setup: can't change plugin mode")
+			}
+			for _, d := range tc.dirs {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0700), "Setup: can't create directory in plugins directory")
+			}
+			for link, target := range tc.symlinks {
+				require.NoError(t, os.Symlink(target, filepath.Join(dir, link)), "Setup: can't create symlink in plugins directory")
+			}
+			if tc.chown != "" {
+				if os.Getuid() != 0 {
+					t.Skip("Changing the owner of a plugin requires root")
+				}
+				require.NoError(t, os.Chown(filepath.Join(dir, tc.chown), 4242, 4242), "Setup: can't change plugin owner")
+			}
+			if tc.dirMode != 0 {
+				require.NoError(t, os.Chmod(dir, tc.dirMode), "Setup: can't change plugins directory mode")
+			}
+
+			// Files created by the test are owned by the current user.
+			owner := os.Getuid()
+			if tc.otherOwner {
+				owner++
+			}
+
+			got, err := plugins.List(dir, plugins.WithOwner(owner))
+			if tc.wantErr {
+				require.Error(t, err, "List should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "List should return no error but got one")
+
+			var want []string
+			for _, p := range tc.want {
+				want = append(want, filepath.Join(dir, p))
+			}
+			require.Equal(t, want, got, "List returned unexpected plugins")
+		})
+	}
+}
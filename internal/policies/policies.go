@@ -9,12 +9,15 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/leonelquinteros/gotext"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
@@ -31,6 +34,15 @@ const (
 	policiesAssetsFileName = "assets.db"
 )
 
+const (
+	// LoopbackMerge is the loopback processing mode where the user settings of the computer GPOs are applied to
+	// users in addition to their own GPOs, taking precedence over them.
+	LoopbackMerge = "merge"
+	// LoopbackReplace is the loopback processing mode where the user settings of the computer GPOs are applied to
+	// users instead of their own GPOs.
+	LoopbackReplace = "replace"
+)
+
 type assetsFromMMAP struct {
 	*zip.Reader
 	filemmap   *mmap.ReaderAt
@@ -39,8 +51,24 @@ type assetsFromMMAP struct {
 
 // Policies is the list of GPOs applied to a particular object, with the global data cache.
 type Policies struct {
-	GPOs   []GPO
-	assets *assetsFromMMAP `yaml:"-"`
+	GPOs []GPO
+	// GPOVersions are the versions of the GPOs, by ID, when they were downloaded.
+	GPOVersions map[string]int `yaml:",omitempty"`
+	// Attributes are the directory attributes of the object, by lower-cased name, including its objectsid.
+	// They are resolved with the GPO list and expanded as dynamic values.
+	Attributes map[string]string `yaml:",omitempty"`
+	// Loopback is the user policy loopback processing mode, LoopbackMerge or LoopbackReplace. For the computer, it is
+	// the mode configured by its GPOs, and for a user, the mode which was applied to get its GPOs.
+	Loopback string `yaml:",omitempty"`
+	// OnlineRefresh is when the GPOs were last downloaded from the directory service. Applying the cached
+	// policies again while offline keeps it unchanged.
+	OnlineRefresh time.Time `yaml:",omitempty"`
+	// Server is the domain controller which served the GPOs during the last online refresh.
+	Server string `yaml:",omitempty"`
+	// Transient is true for policies which are applied without replacing the cached ones nor being added to
+	// history, like the fallback rules applied while offline.
+	Transient bool            `yaml:"-"`
+	assets    *assetsFromMMAP `yaml:"-"`
 }
 
 // New returns new policies with GPOs and assets loaded from DB.
@@ -223,7 +251,7 @@ func (pols *Policies) SaveAssetsTo(ctx context.Context, relSrc, dest string, uid
 	log.Debugf(ctx, "export assets %q to %q", relSrc, dest)
 
 	if pols.assets == nil {
-		return errors.New(gotext.Get("no assets attached"))
+		return fmt.Errorf("%s: %w", gotext.Get("no assets attached"), fs.ErrNotExist)
 	}
 
 	// error out if dest exists
@@ -449,50 +477,63 @@ func (pols Policies) GetUniqueRules() map[string][]entry.Entry {
 
 	// Dedup entries, first GPO wins for a given type + key
 	dedup := make(map[string]map[string]entry.Entry)
+	// merges are, from closest to furthest, the values to merge for a given type + key.
+	merges := make(map[string]map[string][]entry.Entry)
 	seen := make(map[string]struct{})
 	for _, gpo := range pols.GPOs {
 		for t, entries := range gpo.Rules {
 			if dedup[t] == nil {
 				dedup[t] = make(map[string]entry.Entry)
+				merges[t] = make(map[string][]entry.Entry)
 			}
 			for _, e := range entries {
-				switch e.Strategy {
-				case entry.StrategyAppend:
-					// We skip disabled keys as we only append enabled one.
+				if entry.MergesValues(e.Strategy) {
+					// We skip disabled keys as we only merge enabled one.
 					if e.Disabled {
 						continue
 					}
-					var keyAlreadySeen bool
-					// If there is an existing value, prepend new value to it. We are analyzing GPOs in reverse order (closest first).
-					if _, exists := seen[t+e.Key]; exists {
-						keyAlreadySeen = true
-						// We have seen a closest key which is an override. We don’t append furthest append values.
-						if dedup[t][e.Key].Strategy != entry.StrategyAppend {
-							continue
-						}
-						e.Value = e.Value + "\n" + dedup[t][e.Key].Value
-						// Keep closest meta value.
-						e.Meta = dedup[t][e.Key].Meta
-					}
-					dedup[t][e.Key] = e
-					if keyAlreadySeen {
+					// We are analyzing GPOs in reverse order (closest first).
+					_, exists := seen[t+e.Key]
+					// We have seen a closest key which is an override. We don’t merge furthest values.
+					if exists && !entry.MergesValues(dedup[t][e.Key].Strategy) {
 						continue
 					}
-
-				default:
-					// override case
-					if _, exists := seen[t+e.Key]; exists {
+					merges[t][e.Key] = append(merges[t][e.Key], e)
+					if exists {
 						continue
 					}
-					dedup[t][e.Key] = e
+				} else if _, exists := seen[t+e.Key]; exists {
+					// override case
+					continue
 				}
 
+				// Keep closest meta and strategy value.
+				dedup[t][e.Key] = e
 				keys[t] = append(keys[t], e.Key)
 				seen[t+e.Key] = struct{}{}
 			}
 		}
 	}
 
+	// Merge values, from the furthest GPO to the closest one.
+	for t := range merges {
+		for k, entries := range merges[t] {
+			var lines []string
+			for i := len(entries) - 1; i >= 0; i-- {
+				lines = mergeValues(lines, entries[i])
+			}
+			// Everything was removed: the key is not set anymore.
+			if lines == nil {
+				delete(dedup[t], k)
+				keys[t] = slices.DeleteFunc(keys[t], func(key string) bool { return key == k })
+				continue
+			}
+			e := dedup[t][k]
+			e.Value = strings.Join(lines, "\n")
+			dedup[t][k] = e
+		}
+	}
+
 	// For each t, order entries by ascii order
 	for t := range dedup {
 		var entries []entry.Entry
@@ -506,6 +547,38 @@ func (pols Policies) GetUniqueRules() map[string][]entry.Entry {
 	return r
 }
 
+// mergeValues returns the lines of values merged with the value of e, depending on its strategy.
+// A nil slice means that no value is set.
+func mergeValues(values []string, e entry.Entry) []string {
+	lines := strings.Split(e.Value, "\n")
+
+	switch e.Strategy {
+	case entry.StrategyPrepend:
+		return append(lines, values...)
+	case entry.StrategyUnion:
+		var r []string
+		for _, l := range append(values, lines...) {
+			if slices.Contains(r, l) {
+				continue
+			}
+			r = append(r, l)
+		}
+		return r
+	case entry.StrategyRemove:
+		var r []string
+		for _, v := range values {
+			if slices.Contains(lines, v) {
+				continue
+			}
+			r = append(r, v)
+		}
+		return r
+	}
+
+	// append
+	return append(values, lines...)
+}
+
 // chown either chown the file descriptor attached, or the path if this one is null to uid and gid.
 // It will know if we should skip chown for tests.
 func chown(p string, f *os.File, uid, gid int) (err error) {
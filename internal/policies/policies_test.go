@@ -971,6 +971,134 @@ func TestGetUniqueRules(t *testing.T) {
 				},
 			}},
 
+		// prepend, union and remove cases
+		"Prepend policy entry, multiple GPOs": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "closest value", Strategy: entry.StrategyPrepend},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "furthest value", Strategy: entry.StrategyPrepend},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "closest value\nfurthest value", Strategy: entry.StrategyPrepend},
+				},
+			}},
+		"Prepend policy entry, closest prepend on furthest append": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "closest value", Strategy: entry.StrategyPrepend},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "furthest value", Strategy: entry.StrategyAppend},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "closest value\nfurthest value", Strategy: entry.StrategyPrepend},
+				},
+			}},
+		"Union policy entry, multiple GPOs, duplicated values are listed once": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "b\nc\nd", Strategy: entry.StrategyUnion},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb\nc", Strategy: entry.StrategyUnion},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "a\nb\nc\nd", Strategy: entry.StrategyUnion},
+				},
+			}},
+		"Union policy entry, duplicated values in one GPO are listed once": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "closest value", Strategy: entry.StrategyUnion},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb\na", Strategy: entry.StrategyUnion},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "a\nb\nclosest value", Strategy: entry.StrategyUnion},
+				},
+			}},
+		"Remove policy entry, closest removes furthest values": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "b\nunknown", Strategy: entry.StrategyRemove},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb\nc", Strategy: entry.StrategyAppend},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "a\nc", Strategy: entry.StrategyRemove},
+				},
+			}},
+		"Remove policy entry, removing every values unsets the key": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb", Strategy: entry.StrategyRemove},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb", Strategy: entry.StrategyAppend},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": nil,
+			}},
+		"Remove policy entry, only removes values from further GPOs": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "b", Strategy: entry.StrategyAppend},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "b", Strategy: entry.StrategyRemove},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "b", Strategy: entry.StrategyAppend},
+				},
+			}},
+		"Remove policy entry, closest override ignores furthest values to remove": {
+			gpos: []policies.GPO{
+				{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a\nb"},
+					}}},
+				{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+					"domain": {
+						{Key: "A", Value: "a", Strategy: entry.StrategyRemove},
+					}}},
+			},
+			want: map[string][]entry.Entry{
+				"domain": {
+					{Key: "A", Value: "a\nb"},
+				},
+			}},
+
 		// Mix append and override: closest win
 		"Mix meta on GPOs, furthest policy entry is append, closest is override": {
 			gpos: []policies.GPO{
@@ -0,0 +1,115 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// Scope is the kind of objects a policy manager applies rules to.
+type Scope int
+
+const (
+	// ScopeAll is for policy managers applying rules to both the machine and users.
+	ScopeAll Scope = iota
+	// ScopeMachine is for policy managers applying rules to the machine only.
+	ScopeMachine
+	// ScopeUser is for policy managers applying rules to users only.
+	ScopeUser
+)
+
+// AssetsDumper is the function policy managers use to copy GPO assets to the system.
+type AssetsDumper func(ctx context.Context, relSrc, dest string, uid int, gid int) (err error)
+
+// PolicyManager applies all rules of a given type.
+type PolicyManager interface {
+	// Type is the type of rules handled by the policy manager, like "dconf".
+	Type() string
+	// ApplyPolicy applies entries to objectName.
+	// assetsDumper is only set for policy managers needing assets.
+	ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error
+
+	// ProOnly returns true if rules are only applied when the machine is subscribed to Ubuntu Pro.
+	ProOnly() bool
+	// Scope returns the kind of objects the policy manager applies rules to.
+	Scope() Scope
+	// After returns the types of the policy managers that must be applied before this one.
+	After() []string
+	// NeedsAssets returns true if the policy manager copies GPO assets to the system.
+	NeedsAssets() bool
+}
+
+// snapshotter is implemented by policy managers which can list the files they replace when applying rules,
+// so that they can be restored if applying policies fails.
+type snapshotter interface {
+	SnapshotPaths(objectName string, isComputer bool, entries []entry.Entry) []string
+}
+
+// rollbacker is implemented by policy managers whose changes are not limited to the files they replace, like loaded
+// apparmor profiles or started timers. If applying policies fails, Rollback brings the system back to entries, the
+// rules which were applied previously, before the files of every policy manager are restored.
+type rollbacker interface {
+	Rollback(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) error
+}
+
+// validateRegistry checks that policy managers types are unique and that their dependencies can be satisfied.
+func validateRegistry(pms []PolicyManager) error {
+	types := make(map[string]struct{})
+	var steps []policyStep
+	for _, pm := range pms {
+		if _, exists := types[pm.Type()]; exists {
+			return errors.New(gotext.Get("policy manager %q is registered multiple times", pm.Type()))
+		}
+		types[pm.Type()] = struct{}{}
+		steps = append(steps, policyStep{name: pm.Type(), after: pm.After()})
+	}
+
+	_, err := orderSteps(steps)
+	return err
+}
+
+// orderSteps groups steps in successive waves. Steps of a wave only depend on steps of the previous waves and can be
+// applied in parallel. Dependencies on steps that are not part of the list are ignored.
+func orderSteps(steps []policyStep) (waves [][]policyStep, err error) {
+	remaining := make(map[string]struct{})
+	for _, s := range steps {
+		remaining[s.name] = struct{}{}
+	}
+
+	for len(remaining) > 0 {
+		var wave []policyStep
+		for _, s := range steps {
+			if _, ok := remaining[s.name]; !ok {
+				continue
+			}
+			if slices.ContainsFunc(s.after, func(dep string) bool {
+				_, ok := remaining[dep]
+				return ok
+			}) {
+				continue
+			}
+			wave = append(wave, s)
+		}
+
+		if len(wave) == 0 {
+			var names []string
+			for _, s := range steps {
+				if _, ok := remaining[s.name]; ok {
+					names = append(names, s.name)
+				}
+			}
+			return nil, errors.New(gotext.Get("circular dependency between policy managers: %s", strings.Join(names, ", ")))
+		}
+
+		for _, s := range wave {
+			delete(remaining, s.name)
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
@@ -0,0 +1,285 @@
+package policies_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestPolicyManagersRegistry(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
+		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+
+	tests := map[string]struct {
+		managers        []*mockPolicyManager
+		isNotSubscribed bool
+
+		wantApplied []string
+		// wantOrdered is true if wantApplied order is deterministic.
+		wantOrdered          bool
+		wantEntries          map[string][]entry.Entry
+		wantProOnlyRules     []string
+		wantNewManagerErr    bool
+		wantApplyPoliciesErr bool
+	}{
+		"Registered policy manager receives its entries": {
+			managers:    []*mockPolicyManager{{ruleType: "custom"}},
+			wantApplied: []string{"custom"},
+			wantEntries: map[string][]entry.Entry{"custom": {{Key: "custom-key", Value: "custom-value"}}},
+		},
+		"Policy managers are applied after their dependencies": {
+			managers:    []*mockPolicyManager{{ruleType: "custom", after: []string{"other"}}, {ruleType: "other"}},
+			wantApplied: []string{"other", "custom"},
+			wantOrdered: true,
+		},
+		"Dependencies on unregistered policy managers are ignored": {
+			managers:    []*mockPolicyManager{{ruleType: "custom", after: []string{"doesnotexist"}}},
+			wantApplied: []string{"custom"},
+		},
+		"Policy managers out of scope are not applied": {
+			managers:    []*mockPolicyManager{{ruleType: "custom", scope: policies.ScopeUser}, {ruleType: "other", scope: policies.ScopeMachine}},
+			wantApplied: []string{"other"},
+		},
+		"Assets are only given to policy managers needing them": {
+			managers:    []*mockPolicyManager{{ruleType: "custom", needsAssets: true}, {ruleType: "other"}},
+			wantApplied: []string{"custom", "other"},
+		},
+		"Pro only policy managers are listed after built-in ones": {
+			managers:         []*mockPolicyManager{{ruleType: "custom", proOnly: true}, {ruleType: "other"}},
+			wantApplied:      []string{"custom", "other"},
+			wantProOnlyRules: []string{"privilege", "scripts", "mount", "apparmor", "proxy", "certificate", "localgroups", "environment", "scheduledtasks", "files", "custom"},
+		},
+		"Pro only policy managers get no entries when machine is not subscribed": {
+			managers:         []*mockPolicyManager{{ruleType: "custom", proOnly: true}},
+			isNotSubscribed:  true,
+			wantApplied:      []string{"custom"},
+			wantEntries:      map[string][]entry.Entry{"custom": nil},
+			wantProOnlyRules: []string{"privilege", "scripts", "mount", "apparmor", "proxy", "certificate", "localgroups", "environment", "scheduledtasks", "files", "custom"},
+		},
+
+		// Error cases
+		"Error on policy manager registered twice":         {managers: []*mockPolicyManager{{ruleType: "custom"}, {ruleType: "custom"}}, wantNewManagerErr: true},
+		"Error on policy manager overriding a built-in":    {managers: []*mockPolicyManager{{ruleType: "dconf"}}, wantNewManagerErr: true},
+		"Error on circular dependency between managers":    {managers: []*mockPolicyManager{{ruleType: "custom", after: []string{"other"}}, {ruleType: "other", after: []string{"custom"}}}, wantNewManagerErr: true},
+		"Error on policy manager failing to apply entries": {managers: []*mockPolicyManager{{ruleType: "custom", wantErr: true}}, wantApplyPoliciesErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			status := !tc.isNotSubscribed
+			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", status), "Setup: can not set subscription status to %q", status)
+			defer func() {
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
+			}()
+
+			var applied []string
+			var mu sync.Mutex
+			var pms []policies.PolicyManager
+			for _, pm := range tc.managers {
+				pm.applied = &applied
+				pm.mu = &mu
+				pms = append(pms, pm)
+			}
+
+			m, err := newManagerInFakeRoot(t, bus, policies.WithPolicyManagers(pms...))
+			if tc.wantNewManagerErr {
+				require.Error(t, err, "NewManager should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "NewManager should return no error but got one")
+
+			if tc.wantProOnlyRules == nil {
+				tc.wantProOnlyRules = []string{"privilege", "scripts", "mount", "apparmor", "proxy", "certificate", "localgroups", "environment", "scheduledtasks", "files"}
+			}
+			require.Equal(t, tc.wantProOnlyRules, m.ProOnlyRules(), "ProOnlyRules should list Pro only policy managers")
+
+			pols := policies.Policies{GPOs: []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{
+				"custom": {{Key: "custom-key", Value: "custom-value"}},
+			}}}}
+			_, err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			if tc.wantApplyPoliciesErr {
+				require.Error(t, err, "ApplyPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicies should return no error but got one")
+
+			if tc.wantOrdered {
+				require.Equal(t, tc.wantApplied, applied, "Policy managers should have been applied in order")
+			} else {
+				// Independent policy managers are applied in parallel.
+				require.ElementsMatch(t, tc.wantApplied, applied, "Policy managers should have been applied")
+			}
+			for _, pm := range tc.managers {
+				if want, ok := tc.wantEntries[pm.ruleType]; ok {
+					require.Equal(t, want, pm.entries, "Policy manager %q should have received its entries", pm.ruleType)
+				}
+				require.Equal(t, pm.withAssets, pm.needsAssets, "Policy manager %q should only get assets if needed", pm.ruleType)
+			}
+		})
+	}
+}
+
+func TestPluginManagers(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		plugins []string
+
+		wantApplied []string
+	}{
+		"Plugins are registered after built-in policy managers": {plugins: []string{"vpn", "license"}, wantApplied: []string{"license", "vpn"}},
+		"Plugins for built-in rule types are ignored":           {plugins: []string{"vpn", "dconf"}, wantApplied: []string{"vpn"}},
+		"No plugins to register":                                {},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Plugins are written by the test before executing them.
+			//t.Parallel()
+
+			pluginsDir := t.TempDir()
+			outputDir := t.TempDir()
+			for _, p := range tc.plugins {
+				script := fmt.Sprintf("#!/bin/sh\ncat > %q\n", filepath.Join(outputDir, p))
+				err := os.WriteFile(filepath.Join(pluginsDir, p), []byte(script), 0700)
+				require.NoError(t, err, "Setup: can't write plugin")
+			}
+
+			m, err := newManagerInFakeRoot(t, bus, policies.WithPluginsDir(pluginsDir), policies.WithPluginsOwner(os.Getuid()))
+			require.NoError(t, err, "NewManager should return no error but got one")
+
+			wantTypes := append([]string{"dconf", "privilege", "scripts", "mount", "apparmor", "proxy", "certificate", "localgroups", "environment", "scheduledtasks", "files", "gdm"}, tc.wantApplied...)
+			require.Equal(t, wantTypes, m.PolicyTypes(), "Plugins should be registered after built-in policy managers")
+
+			pols := policies.Policies{GPOs: []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{
+				"vpn": {{Key: "server", Value: "vpn.example.com"}},
+			}}}}
+			_, err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			require.NoError(t, err, "ApplyPolicies should return no error but got one")
+
+			var applied []string
+			for _, p := range tc.plugins {
+				if _, err := os.Stat(filepath.Join(outputDir, p)); err == nil {
+					applied = append(applied, p)
+				}
+			}
+			require.ElementsMatch(t, tc.wantApplied, applied, "Only plugins for unhandled rule types should be run")
+		})
+	}
+}
+
+// newManagerInFakeRoot returns a new policy manager with all directories redirected to a temporary directory.
+func newManagerInFakeRoot(t *testing.T, bus *dbus.Conn, opts ...policies.Option) (*policies.Manager, error) {
+	t.Helper()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	fakeRootDir := t.TempDir()
+	loadedPoliciesFile := filepath.Join(fakeRootDir, "sys", "kernel", "security", "apparmor", "profiles")
+	err = os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700)
+	require.NoError(t, err, "Setup: can not create loadedPoliciesFile dir")
+	err = os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600)
+	require.NoError(t, err, "Setup: can not create loadedPoliciesFile")
+
+	opts = append([]policies.Option{
+		policies.WithCacheDir(filepath.Join(fakeRootDir, "var", "cache", "adsys")),
+		policies.WithStateDir(filepath.Join(fakeRootDir, "var", "lib", "adsys")),
+		policies.WithRunDir(filepath.Join(fakeRootDir, "run", "adsys")),
+		policies.WithShareDir(filepath.Join(fakeRootDir, "usr", "share", "adsys")),
+		policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+		policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+		policies.WithPolicyKitSystemDir(filepath.Join(fakeRootDir, "usr", "share", "polkit-1")),
+		policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+		policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+		policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+		policies.WithApparmorParserCmd([]string{"/bin/true"}),
+		policies.WithCertAutoenrollCmd([]string{"/bin/true"}),
+		policies.WithGroupFile(filepath.Join("testdata", "localgroups", "group")),
+		policies.WithGpasswdCmd([]string{"/bin/true"}),
+		policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+		policies.WithEnvironmentDir(filepath.Join(fakeRootDir, "etc", "environment.d")),
+		policies.WithUserUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "user")),
+		policies.WithRuntimeSystemUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "system")),
+		policies.WithRuntimeUserUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "user")),
+		policies.WithFilesRoot(fakeRootDir),
+		policies.WithProxyApplier(&mockProxyApplier{}),
+		policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+	}, opts...)
+
+	return policies.NewManager(bus, hostname, mockBackend{}, opts...)
+}
+
+// mockPolicyManager is a policy manager recording how it is applied.
+type mockPolicyManager struct {
+	ruleType    string
+	proOnly     bool
+	scope       policies.Scope
+	after       []string
+	needsAssets bool
+	wantErr     bool
+	// file, if set, is where the policy manager writes the value of its entries.
+	file string
+
+	entries    []entry.Entry
+	withAssets bool
+
+	// applied is the list of policy managers applied, in order.
+	applied *[]string
+	mu      *sync.Mutex
+}
+
+func (m *mockPolicyManager) Type() string          { return m.ruleType }
+func (m *mockPolicyManager) ProOnly() bool         { return m.proOnly }
+func (m *mockPolicyManager) Scope() policies.Scope { return m.scope }
+func (m *mockPolicyManager) After() []string       { return m.after }
+func (m *mockPolicyManager) NeedsAssets() bool     { return m.needsAssets }
+
+func (m *mockPolicyManager) SnapshotPaths(string, bool, []entry.Entry) []string {
+	if m.file == "" {
+		return nil
+	}
+	return []string{m.file}
+}
+
+func (m *mockPolicyManager) ApplyPolicy(_ context.Context, _ string, _ bool, entries []entry.Entry, assetsDumper policies.AssetsDumper) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	*m.applied = append(*m.applied, m.ruleType)
+	m.entries = entries
+	m.withAssets = assetsDumper != nil
+
+	if m.file != "" {
+		var content string
+		for _, e := range entries {
+			content += e.Value + "\n"
+		}
+		if err := os.WriteFile(m.file, []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+
+	if m.wantErr {
+		return errors.New("mock policy manager error")
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package policies
+
+import (
+	"time"
+
+	"github.com/leonelquinteros/gotext"
+)
+
+// ApplyStatus is the outcome of applying rules with a policy manager.
+type ApplyStatus int
+
+const (
+	// StatusChanged means that the policy manager modified the system.
+	StatusChanged ApplyStatus = iota
+	// StatusUnchanged means that the system was already in the expected state.
+	StatusUnchanged
+	// StatusChangesUnknown means that the rules were applied by a policy manager which can't tell if the system changed.
+	StatusChangesUnknown
+	// StatusSkippedNonPro means that the rules were filtered out as the machine is not subscribed to Ubuntu Pro.
+	StatusSkippedNonPro
+	// StatusFailed means that the policy manager failed to apply the rules.
+	StatusFailed
+)
+
+// String returns a human readable version of the status.
+func (s ApplyStatus) String() string {
+	switch s {
+	case StatusChanged:
+		return gotext.Get("changed")
+	case StatusUnchanged:
+		return gotext.Get("unchanged")
+	case StatusChangesUnknown:
+		return gotext.Get("applied, changes unknown")
+	case StatusSkippedNonPro:
+		return gotext.Get("skipped (not Ubuntu Pro)")
+	case StatusFailed:
+		return gotext.Get("failed")
+	}
+	return gotext.Get("unknown")
+}
+
+// ApplyResult is the outcome of applying the rules of one policy manager to an object.
+type ApplyResult struct {
+	// Manager is the type of rules the policy manager handles.
+	Manager string
+	// Object is the user or computer the rules were applied to.
+	Object string
+	Status ApplyStatus
+	// Files are the files the policy manager created, modified or removed.
+	// Policy managers which can't list the files they replace never report any, and can't tell if they changed the
+	// system whenever they have rules to apply.
+	Files    []string
+	Duration time.Duration
+	// Err is the error returned by the policy manager if it failed.
+	Err error
+}
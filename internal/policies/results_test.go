@@ -0,0 +1,121 @@
+package policies_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPoliciesResults(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
+		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+
+	// result is the part of policies.ApplyResult which does not depend on the test run.
+	type result struct {
+		status policies.ApplyStatus
+		files  []string
+	}
+
+	tests := map[string]struct {
+		manager         mockPolicyManager
+		withFile        bool
+		noRules         bool
+		applyTwice      bool
+		isNotSubscribed bool
+
+		want    result
+		wantErr bool
+	}{
+		"Manager writing files is changed":                           {withFile: true, want: result{status: policies.StatusChanged, files: []string{"custom"}}},
+		"Manager writing the same content is unchanged":              {withFile: true, applyTwice: true, want: result{status: policies.StatusUnchanged}},
+		"Manager not listing its files has unknown changes on rules": {want: result{status: policies.StatusChangesUnknown}},
+		"Manager not listing its files without rules is unchanged":   {noRules: true, want: result{status: policies.StatusUnchanged}},
+		"Pro only manager on machine not subscribed is skipped": {
+			manager:         mockPolicyManager{proOnly: true},
+			isNotSubscribed: true,
+			want:            result{status: policies.StatusSkippedNonPro}},
+
+		// Error cases
+		"Error on manager failing lists the files it changed": {
+			manager:  mockPolicyManager{wantErr: true},
+			withFile: true,
+			want:     result{status: policies.StatusFailed, files: []string{"custom"}},
+			wantErr:  true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			status := !tc.isNotSubscribed
+			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", status), "Setup: can not set subscription status to %q", status)
+			defer func() {
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
+			}()
+
+			pm := tc.manager
+			pm.ruleType = "custom"
+			pm.applied = &[]string{}
+			pm.mu = &sync.Mutex{}
+			dir := t.TempDir()
+			if tc.withFile {
+				pm.file = filepath.Join(dir, "custom")
+			}
+
+			m, err := newManagerInFakeRoot(t, bus, policies.WithPolicyManagers(&pm))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			rules := map[string][]entry.Entry{"custom": {{Key: "custom-key", Value: "custom-value"}}}
+			if tc.noRules {
+				rules = nil
+			}
+			pols := policies.Policies{GPOs: []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: rules}}}
+			if tc.applyTwice {
+				_, err := m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+			}
+
+			results, err := m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicies should return an error but got none")
+			} else {
+				require.NoError(t, err, "ApplyPolicies should return no error but got one")
+			}
+
+			var types []string
+			var got *policies.ApplyResult
+			for _, r := range results {
+				require.Equal(t, "hostname", r.Object, "Results should be for the object policies are applied to")
+				types = append(types, r.Manager)
+				if r.Manager == "custom" {
+					got = &r
+				}
+			}
+			// Policy managers waiting for failing ones are not applied.
+			if !tc.wantErr {
+				require.Equal(t, m.PolicyTypes(), types, "There should be a result for each policy manager, in order")
+			}
+			require.NotNil(t, got, "There should be a result for the custom policy manager")
+
+			var wantFiles []string
+			for _, f := range tc.want.files {
+				wantFiles = append(wantFiles, filepath.Join(dir, f))
+			}
+			require.Equal(t, tc.want.status.String(), got.Status.String(), "Policy manager status is not the expected one")
+			require.Equal(t, wantFiles, got.Files, "Policy manager changed files are not the expected ones")
+			require.Equal(t, tc.wantErr, got.Err != nil, "Policy manager error should only be set when it fails")
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package rootfs provides helpers to change the files of a directory opened as an os.Root, so that the symlinks
+// planted in it, like in the home directory of a user, can't lead out of it.
+package rootfs
+
+import (
+	"os"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+)
+
+// Chown changes the owner of the file p in root to uid and gid, without following symlinks.
+// It will know if we should skip chown for tests.
+func Chown(root *os.Root, p string, uid, gid int) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't chown %q", p))
+
+	if os.Getenv("ADSYS_SKIP_ROOT_CALLS") != "" {
+		uid = -1
+		gid = -1
+	}
+
+	return root.Lchown(p, uid, gid)
+}
@@ -0,0 +1,50 @@
+package rootfs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/rootfs"
+)
+
+func TestChown(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		path string
+
+		wantErr bool
+	}{
+		"Change owner of a file":                    {path: "file"},
+		"Change owner of a directory":               {path: "dir"},
+		"Change owner of a symlink, not its target": {path: "dangling"},
+
+		"Error on missing file":                         {path: "does-not-exist", wantErr: true},
+		"Error on path out of the root":                 {path: "../file", wantErr: true},
+		"Error on path through symlink out of the root": {path: "escape/file", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0600), "Setup: can't create file")
+			require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0700), "Setup: can't create directory")
+			require.NoError(t, os.Symlink("/does-not-exist", filepath.Join(dir, "dangling")), "Setup: can't create symlink")
+			require.NoError(t, os.Symlink(dir, filepath.Join(dir, "escape")), "Setup: can't create symlink")
+
+			root, err := os.OpenRoot(dir)
+			require.NoError(t, err, "Setup: can't open root")
+			defer root.Close()
+
+			err = rootfs.Chown(root, tc.path, os.Getuid(), os.Getgid())
+			if tc.wantErr {
+				require.Error(t, err, "Chown should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Chown should not have failed")
+		})
+	}
+}
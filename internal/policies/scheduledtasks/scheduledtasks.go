@@ -0,0 +1,602 @@
+// Package scheduledtasks provides the policy manager to run scripts of the GPO assets on a schedule.
+//
+// Each entry is a task, keyed by its name, whose value lists its settings, one per line:
+//   - OnCalendar=<event>: a calendar event, in the systemd.time(7) format, the task is run on;
+//   - Persistent=true: the task is run when the machine starts if its last run was missed while it was off;
+//   - Transient=true: the task is run once when it is applied, instead of on calendar events;
+//   - User=<name>: the user running a task of the machine, which is root if not set;
+//   - ExecStart=<script> [arguments]: a script to run with its arguments, relative to the scripts/ directory of the
+//     GPO assets. Scripts of a task are run one after the other.
+//
+// Each task is a pair of systemd .timer and .service units:
+//   - Machine tasks: system units, enabled and started by the manager;
+//   - User tasks: user units of every user, only starting for the user they are set for. They are started by the
+//     systemd instance of the user, the next time it starts.
+//
+// Transient tasks are written to the runtime unit directories, so that they are removed on reboot. They run again
+// once the policy is applied after a reboot, or when they are modified.
+//
+// Scripts are copied from the GPO assets to the state directory of the manager, owned by the user for user tasks.
+// The units and scripts of the tasks which are no longer set are removed.
+package scheduledtasks
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/adsys/internal/consts"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+//go:embed adsys-task-template.timer
+var timerTemplate string
+
+//go:embed adsys-task-template.service
+var serviceTemplate string
+
+// unitPrefix is the prefix of the names of the units of the tasks.
+const unitPrefix = "adsys-task-"
+
+// Manager holds information needed for handling the scheduled tasks policies.
+type Manager struct {
+	stateDir             string
+	systemUnitDir        string
+	userUnitDir          string
+	runtimeSystemUnitDir string
+	runtimeUserUnitDir   string
+	systemdCaller        systemdCaller
+
+	userLookup func(string) (*user.User, error)
+}
+
+type systemdCaller interface {
+	StartUnit(context.Context, string) error
+	StopUnit(context.Context, string) error
+	EnableUnit(context.Context, string) error
+	DisableUnit(context.Context, string) error
+	DaemonReload(context.Context) error
+}
+
+type options struct {
+	systemUnitDir        string
+	userUnitDir          string
+	runtimeSystemUnitDir string
+	runtimeUserUnitDir   string
+	userLookup           func(string) (*user.User, error)
+}
+
+// Option represents an optional function to change the scheduled tasks manager.
+type Option func(*options)
+
+// WithSystemUnitDir specifies a personalized directory for the units of the machine tasks.
+func WithSystemUnitDir(p string) Option {
+	return func(o *options) {
+		o.systemUnitDir = p
+	}
+}
+
+// WithUserUnitDir specifies a personalized directory for the units of the user tasks.
+func WithUserUnitDir(p string) Option {
+	return func(o *options) {
+		o.userUnitDir = p
+	}
+}
+
+// WithRuntimeSystemUnitDir specifies a personalized directory for the units of the transient machine tasks.
+func WithRuntimeSystemUnitDir(p string) Option {
+	return func(o *options) {
+		o.runtimeSystemUnitDir = p
+	}
+}
+
+// WithRuntimeUserUnitDir specifies a personalized directory for the units of the transient user tasks.
+func WithRuntimeUserUnitDir(p string) Option {
+	return func(o *options) {
+		o.runtimeUserUnitDir = p
+	}
+}
+
+// WithUserLookup specifies a personalized function to retrieve the users.
+func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
+	return func(o *options) {
+		o.userLookup = userLookup
+	}
+}
+
+// New returns a new scheduled tasks policy manager, copying the scripts of the tasks to stateDir.
+func New(stateDir string, systemdCaller systemdCaller, opts ...Option) *Manager {
+	o := options{
+		systemUnitDir:        consts.DefaultSystemUnitDir,
+		userUnitDir:          consts.DefaultUserUnitDir,
+		runtimeSystemUnitDir: consts.DefaultRuntimeSystemUnitDir,
+		runtimeUserUnitDir:   consts.DefaultRuntimeUserUnitDir,
+		userLookup:           user.Lookup,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Manager{
+		stateDir:             stateDir,
+		systemUnitDir:        o.systemUnitDir,
+		userUnitDir:          o.userUnitDir,
+		runtimeSystemUnitDir: o.runtimeSystemUnitDir,
+		runtimeUserUnitDir:   o.runtimeUserUnitDir,
+		systemdCaller:        systemdCaller,
+
+		userLookup: o.userLookup,
+	}
+}
+
+// UnitsPrefix returns the prefix of the names of the units of the tasks of the user with uid, or of the machine if
+// uid is empty.
+func UnitsPrefix(uid string) string {
+	if uid == "" {
+		return unitPrefix
+	}
+	return unitPrefix + uid + "-"
+}
+
+// AssetsDumper is a function which uncompress policies assets to a directory.
+type AssetsDumper func(ctx context.Context, relSrc, dest string, uid int, gid int) (err error)
+
+// task is a scheduled task, parsed from an entry.
+type task struct {
+	name       string
+	calendar   []string
+	persistent bool
+	transient  bool
+	user       string
+	commands   []command
+}
+
+// command is a script of the assets run by a task.
+type command struct {
+	script string
+	args   string
+}
+
+// unitSet are the units of the tasks, by name, written to a unit directory.
+type unitSet struct {
+	dir string
+	// persistent is true if the units are kept on reboot.
+	persistent bool
+	units      map[string]string
+}
+
+// ApplyPolicy writes the units and copies the scripts of the tasks of entries for objectName.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't apply scheduled tasks policy to %s", objectName))
+
+	log.Debugf(ctx, "Applying scheduled tasks policy to %s", objectName)
+
+	objectDir := "machine"
+	var uid string
+	uidNum, gidNum := -1, -1
+	sets := []*unitSet{
+		{dir: m.systemUnitDir, persistent: true, units: make(map[string]string)},
+		{dir: m.runtimeSystemUnitDir, units: make(map[string]string)},
+	}
+	if !isComputer {
+		u, err := m.userLookup(objectName)
+		if err != nil {
+			return errors.New(gotext.Get("could not retrieve user for %q: %v", objectName, err))
+		}
+		if uidNum, err = strconv.Atoi(u.Uid); err != nil {
+			return errors.New(gotext.Get("couldn't convert %q to a valid uid for %q", u.Uid, objectName))
+		}
+		if gidNum, err = strconv.Atoi(u.Gid); err != nil {
+			return errors.New(gotext.Get("couldn't convert %q to a valid gid for %q", u.Gid, objectName))
+		}
+		uid = u.Uid
+		objectDir = filepath.Join("users", u.Uid)
+		sets = []*unitSet{
+			{dir: m.userUnitDir, persistent: true, units: make(map[string]string)},
+			{dir: m.runtimeUserUnitDir, units: make(map[string]string)},
+		}
+	}
+	scriptsDir := filepath.Join(m.stateDir, objectDir)
+
+	var tasks []task
+	for _, e := range entries {
+		if e.Disabled {
+			log.Debug(ctx, gotext.Get("The entry %q is disabled and will be skipped", e.Key))
+			continue
+		}
+		t, err := parseTask(e)
+		if err != nil {
+			return err
+		}
+		if t.user != "" {
+			if !isComputer {
+				return errors.New(gotext.Get("task %q of a user can't run as another user", t.name))
+			}
+			if _, err := m.userLookup(t.user); err != nil {
+				log.Warning(ctx, gotext.Get("Skipping scheduled task %q: could not retrieve user %q: %v", t.name, t.user, err))
+				continue
+			}
+		}
+		tasks = append(tasks, t)
+	}
+
+	// Scripts are always copied again, as they may have changed in the assets.
+	if err := os.RemoveAll(scriptsDir); err != nil {
+		return err
+	}
+	if len(tasks) > 0 {
+		if err := copyScripts(ctx, tasks, scriptsDir, isComputer, uidNum, gidNum, assetsDumper); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tasks {
+		s := sets[0]
+		if t.transient {
+			s = sets[1]
+		}
+		timer, service := t.units(scriptsDir, uid)
+		name := UnitsPrefix(uid) + unit.UnitNameEscape(t.name)
+		s.units[name+".timer"] = timer
+		s.units[name+".service"] = service
+	}
+
+	if isComputer {
+		return m.applySystemUnits(ctx, sets)
+	}
+	return applyUserUnits(ctx, UnitsPrefix(uid), sets)
+}
+
+// parseTask returns the task set by e.
+func parseTask(e entry.Entry) (t task, err error) {
+	defer decorate.OnError(&err, gotext.Get("invalid scheduled task %q", e.Key))
+
+	if e.Err != nil {
+		return task{}, errors.New(gotext.Get("entry is errored: %v", e.Err))
+	}
+	if strings.TrimSpace(e.Key) == "" || strings.ContainsAny(e.Key, "\r\n") {
+		return task{}, errors.New(gotext.Get("invalid name"))
+	}
+	t.name = e.Key
+
+	for _, l := range strings.Split(e.Value, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		k, v, found := strings.Cut(l, "=")
+		v = strings.TrimSpace(v)
+		if !found || v == "" {
+			return task{}, errors.New(gotext.Get("invalid setting %q", l))
+		}
+		switch k {
+		case "OnCalendar":
+			t.calendar = append(t.calendar, v)
+		case "Persistent":
+			if t.persistent, err = strconv.ParseBool(v); err != nil {
+				return task{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+		case "Transient":
+			if t.transient, err = strconv.ParseBool(v); err != nil {
+				return task{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+		case "User":
+			if strings.ContainsAny(v, " \t") {
+				return task{}, errors.New(gotext.Get("invalid setting %q", l))
+			}
+			t.user = v
+		case "ExecStart":
+			script, args, _ := strings.Cut(v, " ")
+			if !filepath.IsLocal(script) {
+				return task{}, errors.New(gotext.Get("script %q is not relative to the scripts directory", script))
+			}
+			t.commands = append(t.commands, command{script: script, args: strings.TrimSpace(args)})
+		default:
+			return task{}, errors.New(gotext.Get("unsupported setting %q", k))
+		}
+	}
+
+	if len(t.commands) == 0 {
+		return task{}, errors.New(gotext.Get("no script to run"))
+	}
+	if t.transient && len(t.calendar) > 0 {
+		return task{}, errors.New(gotext.Get("transient tasks can't run on calendar events"))
+	}
+	if !t.transient && len(t.calendar) == 0 {
+		return task{}, errors.New(gotext.Get("no calendar event to run on"))
+	}
+
+	return t, nil
+}
+
+// units returns the content of the timer and service units of the task, running the scripts copied to scriptsDir.
+// The units of a user only start for the user with uid.
+func (t task) units(scriptsDir, uid string) (timer, service string) {
+	var conditions string
+	if uid != "" {
+		conditions = fmt.Sprintf("ConditionUser=%s\n", uid)
+	}
+
+	var timerSettings []string
+	if t.transient {
+		timerSettings = append(timerSettings, "OnActiveSec=0")
+	}
+	for _, c := range t.calendar {
+		timerSettings = append(timerSettings, "OnCalendar="+c)
+	}
+	if t.persistent {
+		timerSettings = append(timerSettings, "Persistent=true")
+	}
+
+	var serviceSettings []string
+	if t.user != "" {
+		serviceSettings = append(serviceSettings, "User="+escapeSpecifiers(t.user))
+	}
+	for _, c := range t.commands {
+		serviceSettings = append(serviceSettings,
+			strings.TrimSpace("ExecStart="+escapeSpecifiers(filepath.Join(scriptsDir, c.script))+" "+escapeSpecifiers(c.args)))
+	}
+
+	name := escapeSpecifiers(t.name)
+	timer = fmt.Sprintf(timerTemplate, name, conditions, strings.Join(timerSettings, "\n")+"\n")
+	service = fmt.Sprintf(serviceTemplate, name, conditions, strings.Join(serviceSettings, "\n")+"\n")
+	return timer, service
+}
+
+// escapeSpecifiers escapes the % characters of s, which would otherwise be systemd specifiers.
+func escapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// copyScripts copies the scripts/ directory of the assets to scriptsDir, with uid and gid as owner, and makes the
+// scripts of the tasks executable.
+// Scripts of the machine can be run as any user.
+func copyScripts(ctx context.Context, tasks []task, scriptsDir string, isComputer bool, uid, gid int, assetsDumper AssetsDumper) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't copy scripts to %q", scriptsDir))
+
+	// Parent directories are traversable by every user, to reach the scripts they can run.
+	//nolint:gosec // G301 - The user scripts directories are only accessible by their owner.
+	if err := os.MkdirAll(filepath.Dir(scriptsDir), 0755); err != nil {
+		return err
+	}
+	if err := assetsDumper(ctx, "scripts/", scriptsDir, uid, gid); err != nil {
+		return err
+	}
+
+	dirMode, scriptMode := fs.FileMode(0700), fs.FileMode(0500)
+	if isComputer {
+		dirMode, scriptMode = 0755, 0555
+	}
+	for _, t := range tasks {
+		for _, c := range t.commands {
+			p := filepath.Join(scriptsDir, c.script)
+			info, err := os.Stat(p)
+			if errors.Is(err, fs.ErrNotExist) {
+				return errors.New(gotext.Get("script %q of task %q doesn't exist in SYSVOL scripts/ subdirectory", c.script, t.name))
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return errors.New(gotext.Get("script %q of task %q is a directory and not a file to execute", c.script, t.name))
+			}
+
+			for d := filepath.Dir(p); ; d = filepath.Dir(d) {
+				if err := os.Chmod(d, dirMode); err != nil {
+					return err
+				}
+				if d == scriptsDir {
+					break
+				}
+			}
+			if err := os.Chmod(p, scriptMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applySystemUnits writes the units of the machine tasks and removes the ones which are no longer set.
+// The timers written are enabled, if they are persistent, and started.
+func (m *Manager) applySystemUnits(ctx context.Context, sets []*unitSet) (err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to apply system units"))
+
+	type timer struct {
+		name       string
+		persistent bool
+	}
+	var timersToStart []timer
+	needsReload := false
+
+	for _, s := range sets {
+		stale, err := staleUnits(s, UnitsPrefix(""))
+		if err != nil {
+			return err
+		}
+		for _, name := range stale {
+			// Tries to stop the unit before disabling and removing it.
+			if err := m.systemdCaller.StopUnit(ctx, name); err != nil {
+				log.Warning(ctx, gotext.Get("Failed to stop unit %q: %v", name, err))
+			}
+			if s.persistent && strings.HasSuffix(name, ".timer") {
+				if err := m.systemdCaller.DisableUnit(ctx, name); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return errors.New(gotext.Get("could not remove file %q: %v", name, err))
+			}
+			needsReload = true
+		}
+
+		written, err := writeUnits(s)
+		if err != nil {
+			return err
+		}
+		for _, name := range written {
+			if strings.HasSuffix(name, ".timer") {
+				timersToStart = append(timersToStart, timer{name: name, persistent: s.persistent})
+			}
+			needsReload = true
+		}
+	}
+
+	if !needsReload {
+		return nil
+	}
+
+	// Trigger a daemon reload
+	if err := m.systemdCaller.DaemonReload(ctx); err != nil {
+		return err
+	}
+
+	// Enables and starts new timers.
+	for _, t := range timersToStart {
+		if t.persistent {
+			if err := m.systemdCaller.EnableUnit(ctx, t.name); err != nil {
+				return err
+			}
+		} else if err := m.systemdCaller.StopUnit(ctx, t.name); err != nil {
+			// Transient timers are restarted, so that modified tasks run again.
+			log.Warning(ctx, gotext.Get("Failed to stop unit %q: %v", t.name, err))
+		}
+		if err := m.systemdCaller.StartUnit(ctx, t.name); err != nil {
+			log.Warning(ctx, gotext.Get("failed to start unit %q: %v", t.name, err))
+		}
+	}
+
+	return nil
+}
+
+// applyUserUnits writes the units of the user tasks, whose names start with prefix, and removes the ones which are
+// no longer set.
+// Timers are enabled for every user, like systemctl --global enable would, as the systemd instance of the user can't
+// be reached. They only start for the user they are set for.
+func applyUserUnits(ctx context.Context, prefix string, sets []*unitSet) (err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to apply user units"))
+
+	for _, s := range sets {
+		wantsDir := filepath.Join(s.dir, "timers.target.wants")
+
+		stale, err := staleUnits(s, prefix)
+		if err != nil {
+			return err
+		}
+		for _, name := range stale {
+			if err := os.Remove(filepath.Join(wantsDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return errors.New(gotext.Get("could not remove file %q: %v", name, err))
+			}
+		}
+
+		written, err := writeUnits(s)
+		if err != nil {
+			return err
+		}
+		if len(written) > 0 {
+			log.Infof(ctx, "Scheduled tasks of the user will be updated on next start of its systemd instance")
+		}
+
+		for name := range s.units {
+			if !strings.HasSuffix(name, ".timer") {
+				continue
+			}
+			//nolint:gosec // G301 - The systemd unit directories permissions are 0755, so we should keep the same pattern.
+			if err := os.MkdirAll(wantsDir, 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(filepath.Join("..", name), filepath.Join(wantsDir, name)); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// staleUnits returns the names of the units of s.dir starting with prefix which are not part of s.
+func staleUnits(s *unitSet, prefix string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, p := range paths {
+		name := filepath.Base(p)
+		if !strings.HasSuffix(name, ".timer") && !strings.HasSuffix(name, ".service") {
+			continue
+		}
+		if _, ok := s.units[name]; ok {
+			continue
+		}
+		stale = append(stale, name)
+	}
+	return stale, nil
+}
+
+// writeUnits writes the units of s which changed, and returns their names in order.
+func writeUnits(s *unitSet) (written []string, err error) {
+	if len(s.units) == 0 {
+		return nil, nil
+	}
+
+	// This creates the unit directory, mostly when setting up a custom one or for the runtime directories.
+	//nolint:gosec // G301 - The systemd unit directories permissions are 0755, so we should keep the same pattern.
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(s.units))
+	for name := range s.units {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		done, err := writeIfChanged(filepath.Join(s.dir, name), s.units[name])
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			written = append(written, name)
+		}
+	}
+	return written, nil
+}
+
+// writeIfChanged will only write to path if content is different from current content.
+func writeIfChanged(path string, content string) (done bool, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't save %s", path))
+
+	if oldContent, err := os.ReadFile(path); err == nil && string(oldContent) == content {
+		return false, nil
+	}
+
+	//nolint:gosec // G306 - This asset needs to be world-readable.
+	if err := os.WriteFile(path+".new", []byte(content), 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
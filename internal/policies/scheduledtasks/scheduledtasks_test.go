@@ -0,0 +1,200 @@
+package scheduledtasks_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/scheduledtasks"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	cleanup := entry.Entry{Key: "Cleanup", Value: "OnCalendar=*-*-* 02:30:00\nPersistent=true\nExecStart=cleanup.sh"}
+	backup := entry.Entry{Key: "Backup", Value: "OnCalendar=Mon,Fri *-*-* 22:00:00\nUser=backup@example.com\nExecStart=backup/run.sh --full --label 100%\nExecStart=backup/notify.sh"}
+	welcome := entry.Entry{Key: "Welcome", Value: "Transient=true\nExecStart=welcome.sh"}
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		previous []entry.Entry
+		isUser   bool
+		existing bool
+
+		uid           string
+		userLookupErr bool
+		assetsErr     bool
+		failOn        string
+
+		wantErr bool
+	}{
+		"Machine tasks":                        {entries: []entry.Entry{cleanup, backup}},
+		"Transient machine tasks":              {entries: []entry.Entry{welcome}},
+		"User tasks":                           {entries: []entry.Entry{{Key: "Sync", Value: "OnCalendar=Mon..Fri *-*-* 09:15:00\nExecStart=backup/run.sh --home /home/bob"}, welcome}, isUser: true},
+		"Task names are escaped":               {entries: []entry.Entry{{Key: "Clean up/tmp", Value: "OnCalendar=daily\nExecStart=cleanup.sh"}}},
+		"Blank lines and comments are ignored": {entries: []entry.Entry{{Key: "Cleanup", Value: "\n# Daily cleanup\n OnCalendar=daily \n\nExecStart=cleanup.sh\n"}}},
+		"Disabled entries are ignored":         {entries: []entry.Entry{cleanup, {Key: "Backup", Disabled: true}}},
+		"Tasks run as unknown users are skipped": {entries: []entry.Entry{cleanup,
+			{Key: "Ghost", Value: "OnCalendar=daily\nUser=ghost\nExecStart=cleanup.sh"}}},
+		"No entries": {},
+
+		// Previous tasks
+		"Tasks no longer set are removed":               {previous: []entry.Entry{cleanup, backup, welcome}, entries: []entry.Entry{cleanup}},
+		"All tasks are removed without entries":         {previous: []entry.Entry{cleanup, backup, welcome}},
+		"Unchanged tasks are not started again":         {previous: []entry.Entry{cleanup, welcome}, entries: []entry.Entry{cleanup, welcome}},
+		"Modified transient tasks are run again":        {previous: []entry.Entry{welcome}, entries: []entry.Entry{{Key: "Welcome", Value: "Transient=true\nExecStart=welcome.sh --again"}}},
+		"User tasks no longer set are removed":          {previous: []entry.Entry{cleanup, welcome}, entries: []entry.Entry{welcome}, isUser: true},
+		"Other units are kept":                          {previous: []entry.Entry{cleanup}, existing: true},
+		"Units of other users are kept":                 {previous: []entry.Entry{cleanup}, existing: true, isUser: true},
+		"Only emit a warning when starting units fails": {entries: []entry.Entry{cleanup, welcome}, failOn: "start"},
+		"Only emit a warning when stopping units fails": {previous: []entry.Entry{cleanup, welcome}, entries: []entry.Entry{{Key: "Welcome", Value: "Transient=true\nExecStart=welcome.sh --again"}}, failOn: "stop"},
+
+		// Error cases
+		"Error on errored entry":                    {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStart=cleanup.sh", Err: errors.New("some error")}}, wantErr: true},
+		"Error on invalid task name":                {entries: []entry.Entry{{Key: "Clean\nup", Value: "OnCalendar=daily\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on invalid setting":                  {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar daily\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on setting without value":            {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on invalid boolean setting":          {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nPersistent=maybe\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on unsupported setting":              {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStartPre=cleanup.sh\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on task without script":              {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily"}}, wantErr: true},
+		"Error on task without calendar event":      {entries: []entry.Entry{{Key: "Cleanup", Value: "ExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on transient task with calendar":     {entries: []entry.Entry{{Key: "Cleanup", Value: "Transient=true\nOnCalendar=daily\nExecStart=cleanup.sh"}}, wantErr: true},
+		"Error on script out of the scripts":        {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStart=../cleanup.sh"}}, wantErr: true},
+		"Error on absolute script":                  {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStart=/usr/bin/true"}}, wantErr: true},
+		"Error on script which does not exist":      {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStart=doesnotexist.sh"}}, wantErr: true},
+		"Error on script being a directory":         {entries: []entry.Entry{{Key: "Cleanup", Value: "OnCalendar=daily\nExecStart=folder"}}, wantErr: true},
+		"Error on user task run as another user":    {entries: []entry.Entry{backup}, isUser: true, wantErr: true},
+		"Error on assets dumping failing":           {entries: []entry.Entry{cleanup}, assetsErr: true, wantErr: true},
+		"Error on user lookup failing":              {entries: []entry.Entry{welcome}, isUser: true, userLookupErr: true, wantErr: true},
+		"Error on invalid uid":                      {entries: []entry.Entry{welcome}, isUser: true, uid: "invalid", wantErr: true},
+		"Error on daemon-reload failing":            {entries: []entry.Entry{cleanup}, failOn: "daemon-reload", wantErr: true},
+		"Error on enabling units failing":           {entries: []entry.Entry{cleanup}, failOn: "enable", wantErr: true},
+		"Error on disabling units failing":          {previous: []entry.Entry{cleanup}, failOn: "disable", wantErr: true},
+		"Error on user unit directory being a file": {entries: []entry.Entry{welcome}, isUser: true, failOn: "user-unit-dir", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			out := t.TempDir()
+			if tc.existing {
+				testutils.Copy(t, filepath.Join("testdata", "existing", "etc"), filepath.Join(out, "etc"))
+			}
+			if tc.failOn == "user-unit-dir" {
+				require.NoError(t, os.MkdirAll(filepath.Join(out, "run", "systemd"), 0755), "Setup: can't create runtime systemd directory")
+				require.NoError(t, os.WriteFile(filepath.Join(out, "run", "systemd", "user"), nil, 0600), "Setup: can't create file in place of runtime user unit directory")
+			}
+
+			if tc.uid == "" {
+				tc.uid = "4242"
+			}
+			userLookup := func(name string) (*user.User, error) {
+				if tc.userLookupErr || name == "ghost" {
+					return nil, errors.New("user not found")
+				}
+				return &user.User{Uid: tc.uid, Gid: "4242"}, nil
+			}
+
+			systemdLog := filepath.Join(out, "systemd")
+			newManager := func(failOn string) *scheduledtasks.Manager {
+				return scheduledtasks.New(filepath.Join(out, "var", "lib", "adsys", "scheduledtasks"),
+					mockSystemdCaller{log: systemdLog, failOn: failOn},
+					scheduledtasks.WithSystemUnitDir(filepath.Join(out, "etc", "systemd", "system")),
+					scheduledtasks.WithUserUnitDir(filepath.Join(out, "etc", "systemd", "user")),
+					scheduledtasks.WithRuntimeSystemUnitDir(filepath.Join(out, "run", "systemd", "system")),
+					scheduledtasks.WithRuntimeUserUnitDir(filepath.Join(out, "run", "systemd", "user")),
+					scheduledtasks.WithUserLookup(userLookup))
+			}
+			assetsDumper := testutils.MockAssetsDumper{T: t, Err: tc.assetsErr, Path: "scripts/"}
+
+			if tc.previous != nil {
+				err := newManager("").ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.previous, assetsDumper.SaveAssetsTo)
+				require.NoError(t, err, "Setup: can't apply previous tasks")
+				// Only record the calls of the policy we check.
+				if err := os.Remove(systemdLog); err != nil {
+					require.ErrorIs(t, err, fs.ErrNotExist, "Setup: can't remove systemd calls of the previous tasks")
+				}
+			}
+
+			err := newManager(tc.failOn).ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries, assetsDumper.SaveAssetsTo)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			makeIndependentOfOutputDir(t, out)
+			testutils.CompareTreesWithFiltering(t, out, testutils.GoldenPath(t), testutils.UpdateEnabled())
+		})
+	}
+}
+
+// makeIndependentOfOutputDir rewrites the units under out to reference the scripts from the root of the system.
+func makeIndependentOfOutputDir(t *testing.T, out string) {
+	t.Helper()
+
+	err := filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || (!strings.HasSuffix(p, ".service") && !strings.HasSuffix(p, ".timer")) {
+			return nil
+		}
+		// #nosec G122 -- This is a path controlled by the test
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		// #nosec G122 -- This is a path controlled by the test
+		return os.WriteFile(p, []byte(strings.ReplaceAll(string(content), out, "")), 0644)
+	})
+	require.NoError(t, err, "Setup: can't make units independent of the output directory")
+}
+
+// mockSystemdCaller records the calls to systemd in log, and fails on the failOn one.
+type mockSystemdCaller struct {
+	log    string
+	failOn string
+}
+
+func (s mockSystemdCaller) record(call, unit string) error {
+	if s.failOn == call {
+		return fmt.Errorf("failed to %s %s", call, unit)
+	}
+	f, err := os.OpenFile(s.log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, strings.TrimSpace(call+" "+unit))
+	return err
+}
+
+func (s mockSystemdCaller) StartUnit(_ context.Context, unit string) error {
+	return s.record("start", unit)
+}
+
+func (s mockSystemdCaller) StopUnit(_ context.Context, unit string) error {
+	return s.record("stop", unit)
+}
+
+func (s mockSystemdCaller) EnableUnit(_ context.Context, unit string) error {
+	return s.record("enable", unit)
+}
+
+func (s mockSystemdCaller) DisableUnit(_ context.Context, unit string) error {
+	return s.record("disable", unit)
+}
+
+func (s mockSystemdCaller) DaemonReload(_ context.Context) error {
+	return s.record("daemon-reload", "")
+}
@@ -0,0 +1,2 @@
+#!/bin/sh
+notify-send "Welcome"
@@ -0,0 +1,193 @@
+package policies
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+)
+
+// snapshot is a copy of the files a policy manager is about to replace.
+// It is used to restore the previous state of the system if applying policies fails.
+type snapshot struct {
+	dir      string
+	patterns []string
+}
+
+// newSnapshot copies under dir all files matching patterns.
+func newSnapshot(dir string, patterns []string) (s snapshot, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't snapshot current policies"))
+
+	for _, p := range patterns {
+		if err := copyUnder(p, dir); err != nil {
+			return s, err
+		}
+	}
+
+	return snapshot{dir: dir, patterns: patterns}, nil
+}
+
+// restore replaces all files matching the snapshot patterns with the version they had when the snapshot was taken.
+// Files that did not exist at that time are removed.
+func (s snapshot) restore() (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't restore policies snapshot"))
+
+	for _, p := range s.patterns {
+		current, err := filepath.Glob(p)
+		if err != nil {
+			return err
+		}
+		for _, c := range current {
+			if err := os.RemoveAll(c); err != nil {
+				return err
+			}
+		}
+
+		saved, err := filepath.Glob(filepath.Join(s.dir, p))
+		if err != nil {
+			return err
+		}
+		for _, src := range saved {
+			if err := copyTree(src, strings.TrimPrefix(src, s.dir)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// changedFiles returns the sorted list of files matching the snapshot patterns which were created, modified or
+// removed since the snapshot was taken.
+func (s snapshot) changedFiles() (files []string, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't compare policies snapshot"))
+
+	before, err := readTree(s.patterns, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	after, err := readTree(s.patterns, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for p, content := range after {
+		if old, ok := before[p]; !ok || !bytes.Equal(old, content) {
+			files = append(files, p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			files = append(files, p)
+		}
+	}
+	slices.Sort(files)
+
+	return files, nil
+}
+
+// copyUnder copies every file matching pattern under root, with the same absolute path.
+func copyUnder(pattern, root string) (err error) {
+	defer decorate.OnError(&err, gotext.Get("can't copy %q to %q", pattern, root))
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := copyTree(m, filepath.Join(root, m)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyTree recursively copies src to dest, keeping permissions and ownership.
+// Parent directories of dest are created if needed, and files already copied to dest are kept.
+func copyTree(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, strings.TrimPrefix(p, src))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			// MkdirAll is subject to umask.
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			// Files matched by several patterns are only copied once.
+			if err := os.Symlink(link, target); errors.Is(err, fs.ErrExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if err := copyFile(p, target, info.Mode().Perm()); errors.Is(err, fs.ErrExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dest with perm permissions.
+func copyFile(src, dest string, perm fs.FileMode) (err error) {
+	// #nosec G304 -- This is a path controlled by us
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile is subject to umask.
+	return os.Chmod(dest, perm)
+}
@@ -0,0 +1,109 @@
+package policies
+
+import (
+	"context"
+	"os/user"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/targeting"
+)
+
+// targetFilter returns if entry e matches its targeting expression and should be kept.
+type targetFilter func(e entry.Entry) (bool, error)
+
+// targetFilter returns the filter of the entries applied to objectName by their targeting expression.
+// The facts of the machine and object are only gathered once, when the first entry with an expression is met,
+// so that policies without targeting don't pay for it.
+func (m *Manager) targetFilter(objectName string, isComputer bool) targetFilter {
+	var facts *targeting.Facts
+	return func(e entry.Entry) (bool, error) {
+		if e.Target == "" {
+			return true, nil
+		}
+
+		if facts == nil {
+			lookupName := objectName
+			if isComputer {
+				// The computer account is the short hostname followed by $.
+				lookupName = m.hostname + "$"
+			}
+			// An object unknown on the machine is a member of no group.
+			groups, _ := m.opts.userGroups(lookupName)
+
+			f, err := targeting.LocalFacts(m.opts.systemRoot, m.hostname, groups)
+			if err != nil {
+				return false, err
+			}
+			facts = &f
+		}
+
+		return targeting.Evaluate(e.Target, *facts)
+	}
+}
+
+// dumpFilter returns, for displaying purpose, if entry e is filtered out by its targeting expression for
+// objectName. Entries whose expression can't be evaluated are reported as filtered, with a warning.
+func (m *Manager) dumpFilter(ctx context.Context, objectName string, isComputer bool) func(e entry.Entry) bool {
+	keep := m.targetFilter(objectName, isComputer)
+	return func(e entry.Entry) bool {
+		ok, err := keep(e)
+		if err != nil {
+			log.Warning(ctx, gotext.Get("Can't evaluate targeting of %s: %v", e.Key, err))
+			return true
+		}
+		return !ok
+	}
+}
+
+// withoutTargetedOut returns a copy of pols without the entries filtered out by keep.
+// The GPOs of pols are not modified.
+func (pols Policies) withoutTargetedOut(ctx context.Context, keep targetFilter) (Policies, error) {
+	filtered := pols
+	filtered.GPOs = make([]GPO, 0, len(pols.GPOs))
+	for _, g := range pols.GPOs {
+		rules := make(map[string][]entry.Entry, len(g.Rules))
+		for ruleType, entries := range g.Rules {
+			var kept []entry.Entry
+			for _, e := range entries {
+				ok, err := keep(e)
+				if err != nil {
+					return Policies{}, err
+				}
+				if !ok {
+					log.Debugf(ctx, "%s %s from %s is filtered by targeting", ruleType, e.Key, g.Name)
+					continue
+				}
+				kept = append(kept, e)
+			}
+			rules[ruleType] = kept
+		}
+		g.Rules = rules
+		filtered.GPOs = append(filtered.GPOs, g)
+	}
+
+	return filtered, nil
+}
+
+// localGroups returns the names of the groups name is a member of on the machine.
+func localGroups(name string) ([]string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, id := range ids {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			continue
+		}
+		groups = append(groups, g.Name)
+	}
+	return groups, nil
+}
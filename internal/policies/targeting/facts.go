@@ -0,0 +1,75 @@
+package targeting
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	adcommon "github.com/ubuntu/adsys/internal/ad/common"
+	"github.com/ubuntu/decorate"
+)
+
+// LocalFacts returns the facts of the machine for a user or computer member of groups.
+// root is the root directory of the machine, which is / except for tests.
+func LocalFacts(root, hostname string, groups []string) (facts Facts, err error) {
+	defer decorate.OnError(&err, gotext.Get("can't get targeting facts"))
+
+	release, err := adcommon.GetVersionID(root)
+	if err != nil {
+		return facts, err
+	}
+
+	hasBattery, err := hasBattery(root)
+	if err != nil {
+		return facts, err
+	}
+
+	var addresses []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return facts, err
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			addresses = append(addresses, ipNet.IP)
+		}
+	}
+
+	return Facts{
+		Groups:     groups,
+		Hostname:   strings.Split(hostname, ".")[0],
+		Release:    release,
+		Addresses:  addresses,
+		HasBattery: hasBattery,
+		FileExists: func(p string) bool {
+			_, err := os.Stat(filepath.Join(root, p))
+			return err == nil
+		},
+	}, nil
+}
+
+// hasBattery returns if one of the power supplies of the machine is a battery.
+func hasBattery(root string) (bool, error) {
+	powerSupplies := filepath.Join(root, "sys", "class", "power_supply")
+	entries, err := os.ReadDir(powerSupplies)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		t, err := os.ReadFile(filepath.Join(powerSupplies, e.Name(), "type"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(t)) == "Battery" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
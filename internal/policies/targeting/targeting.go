@@ -0,0 +1,411 @@
+// Package targeting evaluates item-level targeting expressions attached to policy entries.
+//
+// A targeting expression restricts a single entry to the machines or users it matches, in addition to the
+// GPO link. Predicates are combined with "and", "or", "not" and parentheses, "not" binding tighter than "and",
+// itself binding tighter than "or". For instance:
+//
+//	group("Domain Admins") and not hostname("kiosk-*")
+//	release(">=24.04") or ip("10.0.0.0/8")
+//
+// The supported predicates are:
+//   - group(name): the user or computer is a member of the group name, with or without its domain.
+//   - hostname(glob): the machine short hostname matches the shell pattern glob.
+//   - release([operator]version): the Ubuntu release compares to version with =, !=, <, <=, > or >=.
+//     The default operator is =.
+//   - ip(cidr): one of the machine addresses is in the network cidr.
+//   - laptop(): the machine has a battery.
+//   - file(path): the file or directory path exists on the machine.
+//
+// Keywords and predicate names are case-insensitive. Arguments are double-quoted strings, in which \" and \\
+// are the escapes for a double quote and a backslash.
+// Expressions are fully validated before being evaluated so that typos surface even in branches which would
+// not have been evaluated.
+package targeting
+
+import (
+	"errors"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	"github.com/ubuntu/decorate"
+)
+
+// Facts are what the targeting expressions are evaluated against.
+type Facts struct {
+	// Groups are the names of the groups the user or computer is a member of.
+	Groups []string
+	// Hostname is the machine short hostname.
+	Hostname string
+	// Release is the Ubuntu release, e.g. "24.04".
+	Release string
+	// Addresses are the machine IP addresses.
+	Addresses []net.IP
+	// HasBattery is true if the machine has a battery.
+	HasBattery bool
+	// FileExists returns if path exists on the machine.
+	FileExists func(path string) bool
+}
+
+// Evaluate returns if the targeting expression matches facts.
+// It returns an error if the expression is invalid.
+func Evaluate(expression string, facts Facts) (match bool, err error) {
+	n, err := parse(expression)
+	if err != nil {
+		return false, err
+	}
+	return n.eval(facts), nil
+}
+
+// Validate returns an error if the targeting expression is invalid.
+func Validate(expression string) error {
+	_, err := parse(expression)
+	return err
+}
+
+// node is an element of a parsed expression.
+type node interface {
+	eval(Facts) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(f Facts) bool { return n.left.eval(f) && n.right.eval(f) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(f Facts) bool { return n.left.eval(f) || n.right.eval(f) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(f Facts) bool { return !n.operand.eval(f) }
+
+// predicateNode is a predicate with its validated argument.
+type predicateNode func(Facts) bool
+
+func (n predicateNode) eval(f Facts) bool { return n(f) }
+
+// predicates are the supported predicates, by name, with the number of arguments they take.
+// Each one returns the node evaluating it with arg, or an error if arg is invalid.
+var predicates = map[string]struct {
+	nArgs int
+	node  func(arg string) (node, error)
+}{
+	"group":    {1, groupPredicate},
+	"hostname": {1, hostnamePredicate},
+	"release":  {1, releasePredicate},
+	"ip":       {1, ipPredicate},
+	"laptop":   {0, func(string) (node, error) { return predicateNode(func(f Facts) bool { return f.HasBattery }), nil }},
+	"file":     {1, filePredicate},
+}
+
+func groupPredicate(name string) (node, error) {
+	if name == "" {
+		return nil, errors.New(gotext.Get("group name can't be empty"))
+	}
+	return predicateNode(func(f Facts) bool {
+		return slices.ContainsFunc(f.Groups, func(g string) bool {
+			if strings.EqualFold(g, name) {
+				return true
+			}
+			// Groups can be listed with their domain, while the expression does not mention it.
+			short, _, found := strings.Cut(g, "@")
+			return found && strings.EqualFold(short, name)
+		})
+	}), nil
+}
+
+func hostnamePredicate(glob string) (node, error) {
+	glob = strings.ToLower(glob)
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, errors.New(gotext.Get("invalid hostname pattern %q: %v", glob, err))
+	}
+	return predicateNode(func(f Facts) bool {
+		match, _ := path.Match(glob, strings.ToLower(f.Hostname))
+		return match
+	}), nil
+}
+
+func releasePredicate(arg string) (node, error) {
+	version := strings.TrimLeft(arg, "=!<>")
+	op := arg[:len(arg)-len(version)]
+	want, err := parseVersion(strings.TrimSpace(version))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches func(int) bool
+	switch op {
+	case "", "=", "==":
+		matches = func(c int) bool { return c == 0 }
+	case "!=":
+		matches = func(c int) bool { return c != 0 }
+	case "<":
+		matches = func(c int) bool { return c < 0 }
+	case "<=":
+		matches = func(c int) bool { return c <= 0 }
+	case ">":
+		matches = func(c int) bool { return c > 0 }
+	case ">=":
+		matches = func(c int) bool { return c >= 0 }
+	default:
+		return nil, errors.New(gotext.Get("invalid release operator %q", op))
+	}
+
+	return predicateNode(func(f Facts) bool {
+		got, err := parseVersion(f.Release)
+		if err != nil {
+			return false
+		}
+		return matches(slices.Compare(got, want))
+	}), nil
+}
+
+// parseVersion returns the numeric components of a dotted version, e.g. [24 4] for "24.04".
+func parseVersion(version string) ([]int, error) {
+	var r []int
+	for _, c := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 {
+			return nil, errors.New(gotext.Get("invalid release %q", version))
+		}
+		r = append(r, n)
+	}
+	return r, nil
+}
+
+func ipPredicate(cidr string) (node, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.New(gotext.Get("invalid network %q: %v", cidr, err))
+	}
+	return predicateNode(func(f Facts) bool {
+		return slices.ContainsFunc(f.Addresses, network.Contains)
+	}), nil
+}
+
+func filePredicate(p string) (node, error) {
+	if !path.IsAbs(p) {
+		return nil, errors.New(gotext.Get("file path %q must be absolute", p))
+	}
+	return predicateNode(func(f Facts) bool {
+		return f.FileExists != nil && f.FileExists(p)
+	}), nil
+}
+
+// parser is a recursive descent parser of targeting expressions.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenComma
+	tokenEnd
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// parse returns the root node of expression.
+func parse(expression string) (n node, err error) {
+	defer decorate.OnError(&err, gotext.Get("invalid targeting expression %q", expression))
+
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+
+	if n, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, errors.New(gotext.Get("unexpected %q", t.value))
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword returns if the next token is the keyword k.
+func (p *parser) isKeyword(k string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.value, k)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenOpen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenClose {
+			return nil, errors.New(gotext.Get("missing closing parenthesis"))
+		}
+		return n, nil
+	case tokenIdent:
+		return p.parsePredicate(strings.ToLower(t.value))
+	case tokenEnd:
+		return nil, errors.New(gotext.Get("unexpected end of expression"))
+	}
+	return nil, errors.New(gotext.Get("unexpected %q", t.value))
+}
+
+func (p *parser) parsePredicate(name string) (node, error) {
+	predicate, ok := predicates[name]
+	if !ok {
+		return nil, errors.New(gotext.Get("unknown predicate %q", name))
+	}
+	if t := p.next(); t.kind != tokenOpen {
+		return nil, errors.New(gotext.Get("missing arguments of %q", name))
+	}
+
+	var args []string
+	for p.peek().kind != tokenClose {
+		if len(args) > 0 {
+			if t := p.next(); t.kind != tokenComma {
+				return nil, errors.New(gotext.Get("arguments of %q must be separated by commas", name))
+			}
+		}
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, errors.New(gotext.Get("arguments of %q must be double-quoted strings", name))
+		}
+		args = append(args, t.value)
+	}
+	p.next()
+
+	if len(args) != predicate.nArgs {
+		return nil, errors.New(gotext.Get("%q takes %d argument(s), got %d", name, predicate.nArgs, len(args)))
+	}
+	var arg string
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	return predicate.node(arg)
+}
+
+// tokenize splits expression into tokens, ending with a tokenEnd one.
+func tokenize(expression string) (tokens []token, err error) {
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, value: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, value: ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, value: ","})
+			i++
+		case c == '"':
+			var s strings.Builder
+			i++
+			for {
+				if i >= len(expression) {
+					return nil, errors.New(gotext.Get("unterminated string"))
+				}
+				c := expression[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(expression) && (expression[i+1] == '"' || expression[i+1] == '\\') {
+					i++
+					c = expression[i]
+				}
+				s.WriteByte(c)
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenString, value: s.String()})
+		case isIdentChar(c):
+			start := i
+			for i < len(expression) && isIdentChar(expression[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, value: expression[start:i]})
+		default:
+			return nil, errors.New(gotext.Get("unexpected character %q", c))
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(gotext.Get("empty expression"))
+	}
+
+	return append(tokens, token{kind: tokenEnd}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,168 @@
+package targeting_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/targeting"
+)
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	facts := targeting.Facts{
+		Groups:     []string{"domain users@example.com", "Developers"},
+		Hostname:   "ws-01",
+		Release:    "24.04",
+		Addresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.1.2.3"), net.ParseIP("fd00::1")},
+		HasBattery: true,
+		FileExists: func(p string) bool { return p == "/etc/exists" },
+	}
+
+	tests := map[string]struct {
+		expression string
+		facts      *targeting.Facts
+
+		want    bool
+		wantErr bool
+	}{
+		// Predicates
+		"Group membership":                       {expression: `group("Developers")`, want: true},
+		"Group membership is case-insensitive":   {expression: `group("developers")`, want: true},
+		"Group membership without domain":        {expression: `group("Domain Users")`, want: true},
+		"Group membership with domain":           {expression: `group("domain users@EXAMPLE.COM")`, want: true},
+		"Not a group member":                     {expression: `group("Domain Admins")`, want: false},
+		"Hostname pattern":                       {expression: `hostname("ws-*")`, want: true},
+		"Hostname pattern is case-insensitive":   {expression: `hostname("WS-0?")`, want: true},
+		"Hostname does not match":                {expression: `hostname("kiosk-*")`, want: false},
+		"Release equals":                         {expression: `release("24.04")`, want: true},
+		"Release equals with operator":           {expression: `release("=24.04")`, want: true},
+		"Release different":                      {expression: `release("!=22.04")`, want: true},
+		"Release greater or equal":               {expression: `release(">=22.04")`, want: true},
+		"Release greater":                        {expression: `release(">24.04")`, want: false},
+		"Release lower":                          {expression: `release("<24.10")`, want: true},
+		"Release lower or equal":                 {expression: `release("<= 23.10")`, want: false},
+		"Release of the machine can't be parsed": {expression: `release(">=22.04")`, facts: &targeting.Facts{Release: "unknown"}, want: false},
+		"IPv4 network":                           {expression: `ip("10.0.0.0/8")`, want: true},
+		"IPv6 network":                           {expression: `ip("fd00::/8")`, want: true},
+		"Not in network":                         {expression: `ip("192.168.0.0/16")`, want: false},
+		"Laptop":                                 {expression: `laptop()`, want: true},
+		"Not a laptop":                           {expression: `laptop()`, facts: &targeting.Facts{}, want: false},
+		"File exists":                            {expression: `file("/etc/exists")`, want: true},
+		"File does not exist":                    {expression: `file("/etc/missing")`, want: false},
+		"No file exists without lookup":          {expression: `file("/etc/exists")`, facts: &targeting.Facts{}, want: false},
+
+		// Operators
+		"And":                           {expression: `group("Developers") and hostname("ws-*")`, want: true},
+		"And with one false operand":    {expression: `group("Developers") and hostname("kiosk-*")`, want: false},
+		"Or":                            {expression: `hostname("kiosk-*") or laptop()`, want: true},
+		"Or with both false operands":   {expression: `hostname("kiosk-*") or group("Domain Admins")`, want: false},
+		"Not":                           {expression: `not hostname("kiosk-*")`, want: true},
+		"Double not":                    {expression: `not not laptop()`, want: true},
+		"And binds tighter than or":     {expression: `laptop() or laptop() and hostname("kiosk-*")`, want: true},
+		"Not binds tighter than and":    {expression: `not laptop() and hostname("kiosk-*")`, want: false},
+		"Parentheses":                   {expression: `(laptop() or laptop()) and hostname("kiosk-*")`, want: false},
+		"Keywords are case-insensitive": {expression: `NOT Hostname("kiosk-*") AND Laptop()`, want: true},
+		"Escaped quotes in arguments":   {expression: `group("dev \"ops\"") or group("back\\slash")`, facts: &targeting.Facts{Groups: []string{`back\slash`}}, want: true},
+		"Spaces and new lines":          {expression: "\tlaptop()\n and\r\nlaptop() ", want: true},
+
+		// Error cases
+		"Error on empty expression":               {expression: " ", wantErr: true},
+		"Error on unknown predicate":              {expression: `domain("example.com")`, wantErr: true},
+		"Error on missing arguments":              {expression: `laptop`, wantErr: true},
+		"Error on missing argument":               {expression: `group()`, wantErr: true},
+		"Error on too many arguments":             {expression: `group("a", "b")`, wantErr: true},
+		"Error on arguments given to laptop":      {expression: `laptop("yes")`, wantErr: true},
+		"Error on arguments without commas":       {expression: `group("a" "b")`, wantErr: true},
+		"Error on unquoted argument":              {expression: `group(Developers)`, wantErr: true},
+		"Error on unterminated string":            {expression: `group("Developers)`, wantErr: true},
+		"Error on missing closing parenthesis":    {expression: `(laptop() or laptop()`, wantErr: true},
+		"Error on unexpected closing parenthesis": {expression: `laptop())`, wantErr: true},
+		"Error on missing operand":                {expression: `laptop() and`, wantErr: true},
+		"Error on missing operator":               {expression: `laptop() laptop()`, wantErr: true},
+		"Error on unexpected character":           {expression: `laptop() && laptop()`, wantErr: true},
+		"Error on empty group":                    {expression: `group("")`, wantErr: true},
+		"Error on invalid hostname pattern":       {expression: `hostname("ws-[")`, wantErr: true},
+		"Error on invalid release":                {expression: `release(">=noble")`, wantErr: true},
+		"Error on invalid release operator":       {expression: `release("=>24.04")`, wantErr: true},
+		"Error on invalid network":                {expression: `ip("10.0.0.0")`, wantErr: true},
+		"Error on relative file":                  {expression: `file("etc/exists")`, wantErr: true},
+		"Error even in branch not evaluated":      {expression: `laptop() or unknown()`, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := facts
+			if tc.facts != nil {
+				f = *tc.facts
+			}
+
+			got, err := targeting.Evaluate(tc.expression, f)
+			if tc.wantErr {
+				require.Error(t, err, "Evaluate should return an error but got none")
+				require.Error(t, targeting.Validate(tc.expression), "Validate should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Evaluate should return no error but got one")
+			require.NoError(t, targeting.Validate(tc.expression), "Validate should return no error but got one")
+			require.Equal(t, tc.want, got, "Evaluate returned an unexpected result")
+		})
+	}
+}
+
+func TestLocalFacts(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		powerSupplies map[string]string
+		noOSRelease   bool
+
+		wantBattery bool
+		wantErr     bool
+	}{
+		"Desktop without power supply":  {},
+		"Desktop on mains power supply": {powerSupplies: map[string]string{"AC": "Mains"}},
+		"Laptop with a battery":         {powerSupplies: map[string]string{"AC": "Mains", "BAT0": "Battery\n"}, wantBattery: true},
+		"Power supply without type":     {powerSupplies: map[string]string{"hid-0": ""}},
+
+		"Error on missing os-release": {noOSRelease: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			if !tc.noOSRelease {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0700), "Setup: can't create etc directory")
+				require.NoError(t, os.WriteFile(filepath.Join(root, "etc", "os-release"), []byte("NAME=\"Ubuntu\"\nVERSION_ID=\"24.04\"\n"), 0600), "Setup: can't create os-release")
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(root, "etc-exists"), nil, 0600), "Setup: can't create file")
+			for n, typ := range tc.powerSupplies {
+				d := filepath.Join(root, "sys", "class", "power_supply", n)
+				require.NoError(t, os.MkdirAll(d, 0700), "Setup: can't create power supply")
+				if typ == "" {
+					continue
+				}
+				require.NoError(t, os.WriteFile(filepath.Join(d, "type"), []byte(typ), 0600), "Setup: can't create power supply type")
+			}
+
+			got, err := targeting.LocalFacts(root, "ws-01.example.com", []string{"Developers"})
+			if tc.wantErr {
+				require.Error(t, err, "LocalFacts should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "LocalFacts should return no error but got one")
+
+			require.Equal(t, []string{"Developers"}, got.Groups, "LocalFacts should return the groups of the object")
+			require.Equal(t, "ws-01", got.Hostname, "LocalFacts should return the short hostname")
+			require.Equal(t, "24.04", got.Release, "LocalFacts should return the release of the machine")
+			require.Equal(t, tc.wantBattery, got.HasBattery, "LocalFacts should detect batteries")
+			require.True(t, got.FileExists("/etc-exists"), "FileExists should find files under root")
+			require.False(t, got.FileExists("/etc-missing"), "FileExists should not find missing files")
+		})
+	}
+}
@@ -0,0 +1 @@
+script machine startup
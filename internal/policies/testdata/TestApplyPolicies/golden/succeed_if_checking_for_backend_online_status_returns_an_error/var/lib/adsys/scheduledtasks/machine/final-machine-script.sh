@@ -0,0 +1 @@
+final machine script
@@ -0,0 +1,143 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/leonelquinteros/gotext"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/decorate"
+)
+
+// DriftKind is how the system drifted from the state set up by a policy manager.
+type DriftKind int
+
+const (
+	// DriftModified means that the content of a file written by the policy manager changed.
+	DriftModified DriftKind = iota
+	// DriftMissing means that a file written by the policy manager was removed.
+	DriftMissing
+	// DriftUnexpected means that a file the policy manager did not write, or removed, is present.
+	DriftUnexpected
+	// DriftNotLoaded means that a policy set up by the policy manager is not loaded on the system.
+	DriftNotLoaded
+)
+
+// String returns a human readable version of the drift kind.
+func (k DriftKind) String() string {
+	switch k {
+	case DriftModified:
+		return gotext.Get("modified")
+	case DriftMissing:
+		return gotext.Get("missing")
+	case DriftUnexpected:
+		return gotext.Get("unexpected")
+	case DriftNotLoaded:
+		return gotext.Get("not loaded")
+	}
+	return gotext.Get("unknown")
+}
+
+// Drift is a difference between the system and the state a policy manager set up when applying policies.
+type Drift struct {
+	Manager string
+	// Path is the file, or the name of the resource, which drifted.
+	Path string
+	Kind DriftKind
+}
+
+// verifier is implemented by policy managers which can check that the system, beyond the files they write,
+// is still in the state they set up.
+type verifier interface {
+	Verify(ctx context.Context, objectName string, isComputer bool) ([]Drift, error)
+}
+
+// VerifyPolicies compares the system with the state expected from the policies last applied to objectName,
+// and returns all drifts found, ordered by policy manager.
+//
+// The expected files are derived from the cached policies by planning them: any file the plan would change drifted.
+func (m *Manager) VerifyPolicies(ctx context.Context, objectName string, isComputer bool) (drifts []Drift, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to verify policies of %q", objectName))
+
+	log.Infof(ctx, "Verifying policies for %s", objectName)
+
+	pols, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	if err != nil {
+		return nil, errors.New(gotext.Get("no policy applied for %q: %v", objectName, err))
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, pols.Close)
+
+	plans, err := m.PlanPolicies(ctx, objectName, isComputer, &pols)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plans {
+		for _, f := range p.Modified {
+			drifts = append(drifts, Drift{Manager: p.Name, Path: f, Kind: DriftModified})
+		}
+		// Files that applying the policies would create are missing, and the ones it would remove are unexpected.
+		for _, f := range p.Created {
+			drifts = append(drifts, Drift{Manager: p.Name, Path: f, Kind: DriftMissing})
+		}
+		for _, f := range p.Removed {
+			drifts = append(drifts, Drift{Manager: p.Name, Path: f, Kind: DriftUnexpected})
+		}
+	}
+
+	for _, pm := range m.policyManagers {
+		v, ok := pm.(verifier)
+		if !ok || (isComputer && pm.Scope() == ScopeUser) || (!isComputer && pm.Scope() == ScopeMachine) {
+			continue
+		}
+		d, err := v.Verify(ctx, objectName, isComputer)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, d...)
+	}
+
+	types := m.PolicyTypes()
+	slices.SortStableFunc(drifts, func(a, b Drift) int {
+		return slices.Index(types, a.Manager) - slices.Index(types, b.Manager)
+	})
+
+	return drifts, nil
+}
+
+// RepairPolicies applies again the policies last applied to objectName, reverting any drift of the system.
+func (m *Manager) RepairPolicies(ctx context.Context, objectName string, isComputer bool) (results []ApplyResult, err error) {
+	defer decorate.OnError(&err, gotext.Get("failed to repair policies of %q", objectName))
+
+	log.Infof(ctx, "Repairing policies for %s", objectName)
+
+	pols, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	if err != nil {
+		return nil, errors.New(gotext.Get("no policy applied for %q: %v", objectName, err))
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, pols.Close)
+
+	return m.ApplyPolicies(ctx, objectName, isComputer, &pols)
+}
+
+// FormatDrifts returns a human readable version of drifts, grouped by policy manager.
+func FormatDrifts(drifts []Drift) string {
+	if len(drifts) == 0 {
+		return gotext.Get("No drift detected.") + "\n"
+	}
+
+	var out strings.Builder
+	var manager string
+	for _, d := range drifts {
+		if d.Manager != manager {
+			manager = d.Manager
+			fmt.Fprintf(&out, "* %s\n", manager)
+		}
+		fmt.Fprintf(&out, "  %s: %s\n", d.Kind, d.Path)
+	}
+
+	return out.String()
+}
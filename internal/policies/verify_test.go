@@ -0,0 +1,165 @@
+package policies_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestVerifyPolicies(t *testing.T) {
+	//t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	bus := testutils.NewDbusConn(t)
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
+		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+
+	tests := map[string]struct {
+		// tamper are the files, relative to the fake root, changed after applying policies.
+		// An empty content removes the file.
+		tamper map[string]string
+		repair bool
+		// detach detaches the machine from Ubuntu Pro before repairing.
+		detach     bool
+		objectName string
+
+		wantErr bool
+	}{
+		"No drift after applying policies": {},
+		"Drift on modified files": {tamper: map[string]string{
+			"etc/sudoers.d/99-adsys-privilege-enforcement": "ALL ALL=(ALL:ALL) NOPASSWD: ALL\n",
+			"run/adsys/machine/scripts/logon":              "scripts/other-script\n",
+		}},
+		"Drift on removed files": {tamper: map[string]string{
+			"etc/dconf/db/machine.d/locks/adsys": "",
+		}},
+		"Drift on unexpected files": {tamper: map[string]string{
+			"etc/apparmor.d/adsys/machine/unexpected": "profile unexpected {}\n",
+		}},
+		"Repair reverts drift": {tamper: map[string]string{
+			"etc/sudoers.d/99-adsys-privilege-enforcement": "ALL ALL=(ALL:ALL) NOPASSWD: ALL\n",
+			"etc/dconf/db/machine.d/locks/adsys":           "",
+		}, repair: true},
+		"Repair after detaching removes Ubuntu Pro-only rules": {detach: true, repair: true},
+
+		"Error on no policies applied": {objectName: "otherhost", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			if tc.objectName == "" {
+				tc.objectName = "hostname"
+			}
+
+			fakeRootDir := t.TempDir()
+			loadedPoliciesFile := filepath.Join(fakeRootDir, "sys", "kernel", "security", "apparmor", "profiles")
+			err = os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile dir")
+			err = os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile")
+
+			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", true), "Setup: can not set subscription status to true")
+			defer func() {
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
+			}()
+
+			m, err := policies.NewManager(bus,
+				hostname,
+				mockBackend{},
+				policies.WithCacheDir(filepath.Join(fakeRootDir, "var", "cache", "adsys")),
+				policies.WithStateDir(filepath.Join(fakeRootDir, "var", "lib", "adsys")),
+				policies.WithRunDir(filepath.Join(fakeRootDir, "run", "adsys")),
+				policies.WithShareDir(filepath.Join(fakeRootDir, "usr", "share", "adsys")),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithPolicyKitSystemDir(filepath.Join(fakeRootDir, "usr", "share", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+				policies.WithApparmorParserCmd([]string{"/bin/true"}),
+				policies.WithCertAutoenrollCmd([]string{"/bin/true"}),
+				policies.WithGroupFile(filepath.Join("testdata", "localgroups", "group")),
+				policies.WithGpasswdCmd([]string{"/bin/true"}),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithEnvironmentDir(filepath.Join(fakeRootDir, "etc", "environment.d")),
+				policies.WithUserUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "user")),
+				policies.WithRuntimeSystemUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "system")),
+				policies.WithRuntimeUserUnitDir(filepath.Join(fakeRootDir, "run", "systemd", "user")),
+				policies.WithFilesRoot(fakeRootDir),
+				policies.WithProxyApplier(&mockProxyApplier{}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithHistorySize(0),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			pols, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", "all_entry_types"))
+			require.NoError(t, err, "Setup: can not load policies list")
+			defer pols.Close()
+			_, err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			require.NoError(t, err, "Setup: can not apply policies before verifying")
+
+			for p, content := range tc.tamper {
+				p = filepath.Join(fakeRootDir, p)
+				if content == "" {
+					require.NoError(t, os.Remove(p), "Setup: can not remove applied file")
+					continue
+				}
+				require.NoError(t, os.WriteFile(p, []byte(content), 0600), "Setup: can not change applied file")
+			}
+
+			// Files set up by rules only applied with Ubuntu Pro.
+			proOnlyPaths := []string{
+				filepath.Join("etc", "sudoers.d", "99-adsys-privilege-enforcement"),
+				filepath.Join("run", "adsys", "machine", "scripts"),
+				filepath.Join("etc", "apparmor.d", "adsys", "machine"),
+				filepath.Join("etc", "environment.d", environment.FileName),
+			}
+			if tc.detach {
+				for _, p := range proOnlyPaths {
+					_, err := os.Stat(filepath.Join(fakeRootDir, p))
+					require.NoError(t, err, "Setup: Ubuntu Pro-only rules should be applied")
+				}
+				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Setup: can not set subscription status to false")
+			}
+
+			if tc.repair {
+				_, err = m.RepairPolicies(context.Background(), tc.objectName, true)
+				require.NoError(t, err, "RepairPolicies should return no error but got one")
+			}
+
+			if tc.detach {
+				// Only the dconf and gdm managers are not restricted to Ubuntu Pro.
+				require.FileExists(t, filepath.Join(fakeRootDir, "etc", "dconf", "db", "machine.d", "adsys"), "Rules available without Ubuntu Pro should be kept")
+				for _, p := range proOnlyPaths {
+					_, err := os.Stat(filepath.Join(fakeRootDir, p))
+					require.ErrorIs(t, err, os.ErrNotExist, "Ubuntu Pro-only rules should be removed after detaching")
+				}
+			}
+
+			drifts, err := m.VerifyPolicies(context.Background(), tc.objectName, true)
+			if tc.wantErr {
+				require.Error(t, err, "VerifyPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "VerifyPolicies should return no error but got one")
+
+			got := strings.ReplaceAll(policies.FormatDrifts(drifts), fakeRootDir, "/FAKEROOT")
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "VerifyPolicies returned unexpected drifts")
+		})
+	}
+}
@@ -15,6 +15,13 @@ OUs = {}
 GPOs = {}
 accounts = {}
 
+# msWMI-Parm2 attribute of the WMI filters, by GUID. None is a filter without any query.
+WMIFilters = {
+    "{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A01}": "1;3;10;65;WQL;root\\CIMv2;SELECT * FROM Win32_OperatingSystem WHERE Caption LIKE '%Ubuntu%';",
+    "{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A02}": "2;3;10;79;WQL;root\\CIMv2;SELECT * FROM Win32_SystemEnclosure WHERE ChassisTypes = 9 OR ChassisTypes = 10;3;10;46;WQL;root\\CIMv2;SELECT * FROM Win32_Processor WHERE Name='a;b';",
+    "{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A03}": None,
+}
+
 # Group SIDs reported for an account by a tokenGroups query, split by the
 # directory service that answers it, because the two differ in real AD:
 #  * token_groups    -- the Global Catalog view: universal and global groups
@@ -84,6 +91,12 @@ def token_groups_for(name, is_global_catalog=True):
 #  /example/NogPOptions                 <- UserNogPOptions
 ##            -- NogPOptions GPO
 #  /example/InvalidGPOLink              <- UserInvalidLink
+#  /example/WMIFilter                   <- UserWMIFilter
+##            -- WMIFilter OS GPO                                     <- WMI filter with one query
+##            -- WMIFilter multiple queries GPO                       <- WMI filter with two queries
+##            -- WMIFilter without query GPO                          <- WMI filter without msWMI-Parm2
+##            -- WMIFilter missing GPO                                <- WMI filter object does not exist
+##            -- WMIFilter no filter GPO
 
 #  /example/IntegrationTests/
 #  /example/IntegrationTests/Dep1                          <-[CURRENT_HOSTNAME]
@@ -160,6 +173,16 @@ class GPO:
         if display_name:
             self.display_name = display_name
 
+        self.gPCWQLFilter = None
+        if name == "WMIFilter OS GPO":
+            self.gPCWQLFilter = [b'[gpoonly.com;{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A01};0]']
+        elif name == "WMIFilter multiple queries GPO":
+            self.gPCWQLFilter = [b'[gpoonly.com;{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A02};0]']
+        elif name == "WMIFilter without query GPO":
+            self.gPCWQLFilter = [b'[gpoonly.com;{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4A03};0]']
+        elif name == "WMIFilter missing GPO":
+            self.gPCWQLFilter = [b'[gpoonly.com;{6A5E1E7C-56A0-4A7E-9B3B-2B0F0C1E4AFF};0]']
+
         self.flags = [b'0']
         if name == "ITDep2 User only GPO":
             self.flags = [str.encode(str(dsdb.GPO_FLAG_MACHINE_DISABLE))]
@@ -384,6 +407,16 @@ o = OU("/example/PrimaryGroupFallback")
 o.addGPO(GPO("PrimaryGroupFallback GPO"))
 o.addAccount("UserPrimaryGroupFallback", token_groups_sids=[], dc_token_groups_sids=[], crash_user_session=True)
 
+# WMI filters: the GPOs are listed regardless of their filter, which the
+# script only prints for adsys to evaluate them on the client.
+o = OU("/example/WMIFilter")
+o.addGPO(GPO("WMIFilter OS GPO"))
+o.addGPO(GPO("WMIFilter multiple queries GPO"))
+o.addGPO(GPO("WMIFilter without query GPO"))
+o.addGPO(GPO("WMIFilter missing GPO"))
+o.addGPO(GPO("WMIFilter no filter GPO"))
+o.addAccount("UserWMIFilter")
+
 # Integration tests OU and GPO
 OU("/example/IntegrationTests")
 
@@ -13,13 +13,15 @@ class AccountSearch(dict):
         dict.__setitem__(self, "objectSid", objectSid)
 
 class GPOSearch(dict):
-    def __init__(self, name, displayName, flags, nTSecurityDescriptor, gPCFileSysPath):
+    def __init__(self, name, displayName, flags, nTSecurityDescriptor, gPCFileSysPath, gPCWQLFilter=None):
         self.dn = name
         dict.__setitem__(self, "name", name)
         dict.__setitem__(self, "displayName", [displayName])
         dict.__setitem__(self, "flags", flags)
         dict.__setitem__(self, "nTSecurityDescriptor", nTSecurityDescriptor)
         dict.__setitem__(self, "gPCFileSysPath", gPCFileSysPath)
+        if gPCWQLFilter is not None:
+            dict.__setitem__(self, "gPCWQLFilter", gPCWQLFilter)
 
 class SamDB:
     def __init__(self, url=None, session_info=None, credentials=None, lp=None):
@@ -77,6 +79,33 @@ class SamDB:
         elif "primaryGroupID" in attrs:
             return [{"primaryGroupID": [b"515"]}]
 
+        # Object attributes search, only returning the requested ones
+        elif "department" in attrs:
+            msg = {
+                "objectSid": [b"\x01\x05\x00\x00"],
+                "department": [b"R&D"],
+                "homeDirectory": ["\\\\server\\homes\\%s" % base],
+                "memberOf": [b"CN=group1", b"CN=group2"],
+                "thumbnailPhoto": [b"\xff\xd8\xff\xe0"],
+                "employeeNumber": [b"\xff\xd8\xff\xe0"],
+                "info": [b"Not requested"],
+                "streetAddress": [b"multi\nlines"],
+                "mail": [b"multi@example.com", b"valued@example.com"],
+            }
+            r = {name: value for name, value in msg.items() if name in attrs}
+            r["dn"] = [base.encode()]
+            return [r]
+
+        # WMI filter search, base is CN={GUID},CN=SOM,CN=WMIPolicy,CN=System,<basedn>
+        elif "msWMI-Parm2" in attrs:
+            guid = str(base).split(",")[0][len("CN="):]
+            if guid not in ldb.WMIFilters:
+                raise Exception("No such object")
+            parm = ldb.WMIFilters[guid]
+            if parm is None:
+                return [{}]
+            return [{"msWMI-Parm2": [parm.encode()]}]
+
         # OU search
         elif "gPLink" in attrs:
             ou = ldb.OUs[base.strdn]
@@ -90,7 +119,7 @@ class SamDB:
         gpo = ldb.GPOs[base]
         if gpo.nTSecurityDescriptor[0] == "MISSING":
             raise "nTSecurityDescriptor not available as requested"
-        return [GPOSearch(gpo.name, gpo.display_name, gpo.flags, gpo.nTSecurityDescriptor, gpo.gPCFileSysPath)]
+        return [GPOSearch(gpo.name, gpo.display_name, gpo.flags, gpo.nTSecurityDescriptor, gpo.gPCFileSysPath, gpo.gPCWQLFilter)]
 
 
     def get_default_basedn(self):